@@ -0,0 +1,46 @@
+// Command pqxdh-vectors prints the PQXDH and sealed sender reference vectors
+// as JSON. Sealed sender and delivery token vectors are listed under
+// "interop"; the handshake and KDF vectors only describe the Go client and
+// are listed under "go_only". It exits non-zero if the Go reference client no
+// longer reproduces the pinned outputs.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/kindlyrobotics/nochat/pkg/pqxdh"
+)
+
+func main() {
+	recompute := flag.Bool("recompute", false, "Print recomputed outputs instead of checking the pinned ones")
+	flag.Parse()
+
+	if !*recompute {
+		if err := pqxdh.CheckVectors(); err != nil {
+			log.Fatalf("Vector check failed: %v", err)
+		}
+	}
+
+	kdf, handshake, sealed, tokens, err := pqxdh.ComputedVectors()
+	if err != nil {
+		log.Fatalf("Failed to compute vectors: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]interface{}{
+		"interop": map[string]interface{}{
+			"sealed_sender":  sealed,
+			"delivery_token": tokens,
+		},
+		"go_only": map[string]interface{}{
+			"kdf":       kdf,
+			"handshake": handshake,
+		},
+	}); err != nil {
+		log.Fatalf("Failed to encode vectors: %v", err)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -99,6 +102,9 @@ func main() {
 		storageService = nil
 	}
 	cryptoService := crypto.NewService(database.Postgres)
+
+	// Reject high-S (malleable) P-256 prekey signatures when enabled
+	crypto.SetP256StrictMode(os.Getenv("CRYPTO_P256_STRICT") == "true")
 	contactsService := contacts.NewService(database.Postgres)
 	discoveryService := discovery.NewService(database.Postgres)
 
@@ -112,7 +118,30 @@ func main() {
 	// Wire up transparency service to crypto service
 	if transparencyService != nil {
 		cryptoService.SetTransparencyService(&transparencyQueuerAdapter{transparencyService})
+		// Push key-change events to monitoring subscribers after each epoch
+		transparencyService.SetKeyChangeNotifier(signalingService)
+	}
+
+	// Tell connected clients when a contact they verified changes identity key
+	cryptoService.SetKeyChangeNotifier(signalingService)
+
+	// Signed prekey / sealed sender key rotation policy
+	rotationPolicy, err := crypto.RotationPolicyFromEnv()
+	if err != nil {
+		log.Printf("[WARN] Invalid key rotation policy: %v (using defaults)", err)
+		rotationPolicy = crypto.DefaultRotationPolicy()
+	}
+	cryptoService.SetRotationPolicy(rotationPolicy)
+
+	// Cipher-suite registry with retirement deadlines
+	suiteRegistry, err := crypto.SuiteRegistryFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid cipher suite configuration: %v", err)
 	}
+	cryptoService.SetSuiteRegistry(suiteRegistry)
+	cryptoService.SetRotationNotifier(signalingService)
+	rotationCtx, stopRotationMonitor := context.WithCancel(context.Background())
+	go cryptoService.StartRotationMonitor(rotationCtx)
 
 	// Initialize rate limiter
 	rateLimiter := ratelimit.NewLimiter(database.Redis)
@@ -163,6 +192,7 @@ func main() {
 	<-quit
 
 	log.Println("[Server] Shutting down server...")
+	stopRotationMonitor()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -228,7 +258,18 @@ func (s *Server) setupRouter() *mux.Router {
 	// Storage routes (protected)
 	router.HandleFunc("/api/storage/upload", s.authMiddleware(s.handleRequestUpload)).Methods("POST")
 	router.HandleFunc("/api/storage/download", s.authMiddleware(s.handleRequestDownload)).Methods("POST")
+	router.HandleFunc("/api/storage/attachments", s.authMiddleware(s.handleCreateAttachment)).Methods("POST")
 	router.HandleFunc("/api/storage/attachments/{id}", s.authMiddleware(s.handleGetAttachment)).Methods("GET")
+	router.HandleFunc(storage.ThumbnailGrantPath, s.authMiddleware(s.handleGetThumbnailGrant)).Methods("GET")
+	router.HandleFunc("/api/messages/{id}/attachments", s.authMiddleware(s.handleGetMessageAttachments)).Methods("GET")
+	router.HandleFunc("/api/storage/capabilities", s.authMiddleware(s.handleGetStorageCapabilities)).Methods("GET")
+
+	// Streaming proxy for networks that can't reach the blob store directly
+	router.HandleFunc(storage.ProxyPath+"{key:.+}", s.authMiddleware(s.handleProxyUpload)).Methods("PUT")
+	router.HandleFunc(storage.ProxyPath+"{key:.+}", s.authMiddleware(s.handleProxyDownload)).Methods("GET")
+
+	// Signed blob URLs for local storage backends (authorized by URL signature)
+	router.HandleFunc(storage.LocalBlobPath+"{key:.+}", s.handleLocalBlob).Methods("GET", "PUT")
 
 	// Contacts routes (protected)
 	router.HandleFunc("/api/contacts", s.authMiddleware(s.handleGetContacts)).Methods("GET")
@@ -265,11 +306,31 @@ func (s *Server) setupRouter() *mux.Router {
 	// Crypto/E2EE routes (protected)
 	router.HandleFunc("/api/crypto/keys/identity", s.authMiddleware(s.handleUploadIdentityKey)).Methods("POST")
 	router.HandleFunc("/api/crypto/keys/identity", s.authMiddleware(s.handleGetMyIdentityKey)).Methods("GET")
+	router.HandleFunc("/api/crypto/keys/identity/companion", s.authMiddleware(s.handleUploadCompanionIdentityKey)).Methods("POST")
 	router.HandleFunc("/api/crypto/keys/prekey", s.authMiddleware(s.handleUploadSignedPreKey)).Methods("POST")
+	router.HandleFunc("/api/crypto/keys/prekey/companion", s.authMiddleware(s.handleUploadCompanionSignedPreKey)).Methods("POST")
 	router.HandleFunc("/api/crypto/keys/prekeys", s.authMiddleware(s.handleUploadOneTimePreKeys)).Methods("POST")
 	router.HandleFunc("/api/crypto/keys/prekeys/count", s.authMiddleware(s.handleGetPreKeyCount)).Methods("GET")
+	router.HandleFunc("/api/crypto/keys/prekeys/last-resort", s.authMiddleware(s.handleUploadLastResortPreKey)).Methods("POST")
+	router.HandleFunc("/api/crypto/keys/prekeys/claims", s.authMiddleware(s.handleGetPreKeyClaims)).Methods("GET")
 	router.HandleFunc("/api/crypto/bundles/{user_id}", s.authMiddleware(s.handleGetPreKeyBundle)).Methods("GET")
 	router.HandleFunc("/api/crypto/keys/status", s.authMiddleware(s.handleGetKeyStatus)).Methods("GET")
+	router.HandleFunc("/api/crypto/keys/suites", s.authMiddleware(s.handleGetMySuites)).Methods("GET")
+	router.HandleFunc("/api/crypto/keys/suites", s.authMiddleware(s.handleSetMySuites)).Methods("PUT")
+
+	// Cipher-suite registry (public, so clients can negotiate before logging in)
+	router.HandleFunc("/api/crypto/suites", s.handleListCipherSuites).Methods("GET")
+
+	// Ratchet session state backup routes (protected)
+	router.HandleFunc("/api/crypto/sessions", s.authMiddleware(s.handleListSessions)).Methods("GET")
+	router.HandleFunc("/api/crypto/sessions/{conversation_id}/{peer_user_id}", s.authMiddleware(s.handleGetSession)).Methods("GET")
+	router.HandleFunc("/api/crypto/sessions/{conversation_id}/{peer_user_id}", s.authMiddleware(s.handlePutSession)).Methods("PUT")
+	router.HandleFunc("/api/crypto/sessions/{conversation_id}/{peer_user_id}", s.authMiddleware(s.handleDeleteSession)).Methods("DELETE")
+
+	// Safety number / contact verification routes (protected)
+	router.HandleFunc("/api/crypto/safety-number/{user_id}", s.authMiddleware(s.handleGetSafetyNumber)).Methods("GET")
+	router.HandleFunc("/api/crypto/verifications", s.authMiddleware(s.handleListVerifications)).Methods("GET")
+	router.HandleFunc("/api/crypto/verifications/{user_id}", s.authMiddleware(s.handleSetVerification)).Methods("PUT")
 
 	// Sealed Sender routes (protected)
 	router.HandleFunc("/api/crypto/keys/sealed-sender", s.authMiddleware(s.handleUploadSealedSenderKey)).Methods("POST")
@@ -283,11 +344,28 @@ func (s *Server) setupRouter() *mux.Router {
 	router.HandleFunc("/api/transparency/consistency", s.handleGetConsistencyProof).Methods("GET")
 	router.HandleFunc("/api/transparency/audit-log", s.handleGetAuditLog).Methods("GET")
 	router.HandleFunc("/api/transparency/signing-keys", s.handleGetSigningKeys).Methods("GET")
+	router.HandleFunc("/api/transparency/vrf-key", s.handleGetVRFKey).Methods("GET")
+	router.HandleFunc("/api/transparency/witnesses", s.handleGetWitnesses).Methods("GET")
+	router.HandleFunc("/api/transparency/witness/cosign", s.handleSubmitCosignature).Methods("POST")
+	router.HandleFunc("/api/transparency/checkpoint", s.handleGetCheckpoint).Methods("GET")
+	router.HandleFunc("/api/transparency/forks", s.handleGetForks).Methods("GET")
+	router.HandleFunc("/api/transparency/storage", s.handleGetTreeStorage).Methods("GET")
+	router.HandleFunc("/api/transparency/epoch-salt", s.handleGetEpochSalt).Methods("GET")
+	router.HandleFunc("/api/transparency/log/sth", s.handleGetLogTreeHead).Methods("GET")
+	router.HandleFunc("/api/transparency/log/entries", s.handleGetLogEntries).Methods("GET")
+	router.HandleFunc("/api/transparency/log/proof/inclusion", s.handleGetLogInclusionProof).Methods("GET")
+	router.HandleFunc("/api/transparency/log/proof/consistency", s.handleGetLogConsistencyProof).Methods("GET")
 
 	// Key Transparency routes (protected)
 	router.HandleFunc("/api/transparency/inclusion", s.authMiddleware(s.handleGetInclusionProof)).Methods("GET")
+	router.HandleFunc("/api/transparency/absence", s.authMiddleware(s.handleGetNonExistenceProof)).Methods("GET")
+	router.HandleFunc("/api/transparency/history", s.authMiddleware(s.handleGetKeyHistory)).Methods("GET")
+	router.HandleFunc("/api/transparency/checkpoint", s.authMiddleware(s.handleSubmitObservedHeads)).Methods("POST")
 	router.HandleFunc("/api/transparency/client-state", s.authMiddleware(s.handleUpdateClientState)).Methods("POST")
 	router.HandleFunc("/api/transparency/client-state", s.authMiddleware(s.handleGetClientState)).Methods("GET")
+	router.HandleFunc("/api/transparency/monitor", s.authMiddleware(s.handleSetMonitoredUsers)).Methods("PUT")
+	router.HandleFunc("/api/transparency/monitor", s.authMiddleware(s.handleGetMonitoredUsers)).Methods("GET")
+	router.HandleFunc("/api/transparency/monitor/events", s.authMiddleware(s.handleGetKeyChangeEvents)).Methods("GET")
 
 	return router
 }
@@ -815,6 +893,14 @@ func (s *Server) handleRequestUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp, err := s.storageService.GenerateUploadURL(r.Context(), req)
+	if err == storage.ErrThumbnailTooLarge {
+		http.Error(w, fmt.Sprintf("Thumbnail must be at most %d bytes", storage.MaxThumbnailSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err == storage.ErrUnsupportedTransferMode {
+		http.Error(w, "Unsupported transfer mode", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to generate upload URL: %v", err), http.StatusInternalServerError)
 		return
@@ -824,15 +910,28 @@ func (s *Server) handleRequestUpload(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleRequestDownload(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
 	var req models.DownloadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	resp, err := s.storageService.GenerateDownloadURL(r.Context(), req.StorageKey)
+	// Only participants of the attachment's conversation get a (user-bound, logged) URL
+	resp, err := s.storageService.AuthorizeDownload(r.Context(), userID, req.StorageKey, req.TransferMode, storageAccessContext(r))
+	if err == storage.ErrUnsupportedTransferMode {
+		http.Error(w, "Unsupported transfer mode", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to generate download URL: %v", err), http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrAttachmentNotFound) || errors.Is(err, storage.ErrAccessDenied) {
+			// Don't reveal whether the key exists to non-participants
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Storage] Failed to authorize download: %v", err)
+		http.Error(w, "Failed to generate download URL", http.StatusInternalServerError)
 		return
 	}
 
@@ -840,6 +939,8 @@ func (s *Server) handleRequestDownload(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
 	vars := mux.Vars(r)
 	attID, err := uuid.Parse(vars["id"])
 	if err != nil {
@@ -847,7 +948,7 @@ func (s *Server) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	attachment, err := s.storageService.GetAttachment(r.Context(), attID)
+	attachment, err := s.storageService.GetAttachmentForUser(r.Context(), userID, attID)
 	if err != nil {
 		http.Error(w, "Attachment not found", http.StatusNotFound)
 		return
@@ -856,6 +957,324 @@ func (s *Server) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(attachment)
 }
 
+// storageAccessContext extracts the request metadata recorded with download grants
+func storageAccessContext(r *http.Request) storage.AccessContext {
+	clientIP := r.Header.Get("X-Forwarded-For")
+	if clientIP == "" {
+		clientIP = r.RemoteAddr
+	}
+	return storage.AccessContext{
+		IPAddress: clientIP,
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// handleCreateAttachment registers an uploaded encrypted file (and optional
+// encrypted thumbnail) against a message sent by the caller
+func (s *Server) handleCreateAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req models.CreateAttachmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := uuid.Parse(req.MessageID)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	if req.StorageKey == "" || req.FileName == "" {
+		http.Error(w, "storage_key and file_name are required", http.StatusBadRequest)
+		return
+	}
+
+	message, err := s.messagingService.GetMessage(r.Context(), messageID)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if message.SenderID != userID {
+		http.Error(w, "Only the sender can attach files to a message", http.StatusForbidden)
+		return
+	}
+
+	attachment, err := s.storageService.CreateEncryptedAttachment(r.Context(), message, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidStorageKey):
+			http.Error(w, "storage_key does not belong to the message's conversation", http.StatusBadRequest)
+		case errors.Is(err, storage.ErrStorageKeyInUse):
+			http.Error(w, "File is already attached", http.StatusConflict)
+		case errors.Is(err, storage.ErrThumbnailTooLarge):
+			http.Error(w, fmt.Sprintf("Thumbnail must be at most %d bytes", storage.MaxThumbnailSize), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, storage.ErrInvalidThumbnail), errors.Is(err, storage.ErrBlobNotFound):
+			http.Error(w, fmt.Sprintf("Invalid thumbnail: %v", err), http.StatusBadRequest)
+		default:
+			log.Printf("[Storage] Failed to create attachment: %v", err)
+			http.Error(w, "Failed to create attachment", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// handleGetMessageAttachments lists a message's attachments with the endpoint
+// to fetch a short-lived URL for each encrypted thumbnail
+func (s *Server) handleGetMessageAttachments(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	messageID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	previews, err := s.storageService.GetMessageAttachmentPreviews(r.Context(), userID, messageID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAttachmentNotFound) || errors.Is(err, storage.ErrAccessDenied) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Storage] Failed to get message attachments: %v", err)
+		http.Error(w, "Failed to get attachments", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"attachments": previews,
+	})
+}
+
+// handleGetThumbnailGrant issues a short-lived URL for an attachment's
+// encrypted thumbnail when the client renders its preview
+func (s *Server) handleGetThumbnailGrant(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	attachmentID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	mode := r.URL.Query().Get("transfer_mode")
+	resp, err := s.storageService.AuthorizeThumbnail(r.Context(), userID, attachmentID, mode, storageAccessContext(r))
+	if err == storage.ErrUnsupportedTransferMode {
+		http.Error(w, "Unsupported transfer mode", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrAttachmentNotFound) || errors.Is(err, storage.ErrAccessDenied) {
+			http.Error(w, "Thumbnail not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Storage] Failed to authorize thumbnail: %v", err)
+		http.Error(w, "Failed to generate thumbnail URL", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetStorageCapabilities lets clients negotiate presigned vs proxy transfers
+func (s *Server) handleGetStorageCapabilities(w http.ResponseWriter, r *http.Request) {
+	if s.storageService == nil {
+		http.Error(w, "Storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.storageService.Capabilities())
+}
+
+// handleProxyUpload streams an upload through the server to the blob store.
+// Content-Length is required so the body can be streamed without buffering.
+func (s *Server) handleProxyUpload(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	key := mux.Vars(r)["key"]
+
+	if r.ContentLength < 0 {
+		http.Error(w, "Content-Length required", http.StatusLengthRequired)
+		return
+	}
+	if r.ContentLength > storage.MaxBlobSize {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := s.storageService.AuthorizeProxyUpload(r.Context(), userID, key); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrAccessDenied), errors.Is(err, storage.ErrInvalidStorageKey):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case errors.Is(err, storage.ErrBlobExists):
+			http.Error(w, "File already exists", http.StatusConflict)
+		default:
+			log.Printf("[Storage] Failed to authorize proxy upload: %v", err)
+			http.Error(w, "Upload failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	release, err := s.storageService.AcquireTransfer()
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many concurrent transfers", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(storage.ProxyTransferTimeout))
+	rc.SetWriteDeadline(time.Now().Add(storage.ProxyTransferTimeout))
+
+	body := http.MaxBytesReader(w, r.Body, storage.MaxBlobSize)
+	if err := s.storageService.UploadFile(r.Context(), key, body, r.ContentLength, r.Header.Get("Content-Type")); err != nil {
+		log.Printf("[Storage] Proxy upload failed for %s: %v", key, err)
+		http.Error(w, "Upload failed", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"storage_key": key,
+		"size":        r.ContentLength,
+	})
+}
+
+// handleProxyDownload streams a blob through the server, honoring single
+// byte-range requests so clients can resume and seek
+func (s *Server) handleProxyDownload(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	key := mux.Vars(r)["key"]
+
+	info, err := s.storageService.AuthorizeProxyDownload(r.Context(), userID, key, r.Header.Get("Range"), storageAccessContext(r))
+	if err != nil {
+		if errors.Is(err, storage.ErrAttachmentNotFound) || errors.Is(err, storage.ErrAccessDenied) || errors.Is(err, storage.ErrBlobNotFound) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Storage] Failed to authorize proxy download: %v", err)
+		http.Error(w, "Download failed", http.StatusInternalServerError)
+		return
+	}
+
+	offset, length := int64(0), info.Size
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		offset, length, err = storage.ParseRange(rangeHeader, info.Size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+	}
+
+	release, err := s.storageService.AcquireTransfer()
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many concurrent transfers", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	reader, err := s.storageService.OpenRange(r.Context(), key, offset, length)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(storage.ProxyTransferTimeout))
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(status)
+
+	// Fixed-size buffer: a slow client blocks the copy, which in turn stops
+	// reads from the blob store instead of buffering the file in memory
+	if _, err := io.CopyBuffer(w, reader, make([]byte, 32*1024)); err != nil {
+		log.Printf("[Storage] Proxy download interrupted for %s: %v", key, err)
+	}
+}
+
+// handleLocalBlob serves signed upload and download URLs issued by the
+// filesystem and in-memory storage backends
+func (s *Server) handleLocalBlob(w http.ResponseWriter, r *http.Request) {
+	if s.storageService == nil {
+		http.Error(w, "Storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	key := mux.Vars(r)["key"]
+	op := storage.BlobOpGet
+	if r.Method == http.MethodPut {
+		op = storage.BlobOpPut
+	}
+
+	query := r.URL.Query()
+	if err := s.storageService.VerifySignedURL(op, key, query); err != nil {
+		if errors.Is(err, storage.ErrSignedURLsUnsupported) {
+			http.Error(w, "Not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Invalid or expired URL", http.StatusForbidden)
+		}
+		return
+	}
+
+	// Download grants are bound to the user they were issued to
+	if boundUser := query.Get(storage.GrantParamUser); boundUser != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		userID, err := s.authService.ValidateSessionToken(token)
+		if err != nil || userID.String() != boundUser {
+			http.Error(w, "Invalid or expired URL", http.StatusForbidden)
+			return
+		}
+	}
+
+	if op == storage.BlobOpPut {
+		if r.ContentLength > storage.MaxBlobSize {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, storage.MaxBlobSize)
+		if err := s.storageService.UploadSignedFile(r.Context(), key, body, r.ContentLength, r.Header.Get("Content-Type")); err != nil {
+			if errors.Is(err, storage.ErrBlobExists) {
+				http.Error(w, "Upload URL already used", http.StatusConflict)
+				return
+			}
+			log.Printf("[Storage] Local upload failed for %s: %v", key, err)
+			http.Error(w, "Upload failed", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	info, err := s.storageService.GetFileInfo(r.Context(), key)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	reader, err := s.storageService.DownloadFile(r.Context(), key)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, reader)
+}
+
 // Contacts Handlers
 
 func (s *Server) handleGetContacts(w http.ResponseWriter, r *http.Request) {
@@ -1428,12 +1847,13 @@ func (s *Server) handleMarkDiscoveryRead(w http.ResponseWriter, r *http.Request)
 // Crypto Handlers - PQC Key Management for E2EE
 
 // handleUploadIdentityKey uploads a user's identity public key
-// Accepts both P-256 (Web Crypto API) and Dilithium3 (PQC) keys
+// Accepts P-256 (Web Crypto API), Dilithium3 and ML-DSA-65 keys
 func (s *Server) handleUploadIdentityKey(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("userID").(uuid.UUID)
 
 	var req struct {
-		PublicKey string `json:"public_key"` // Base64 encoded public key (P-256 or Dilithium3)
+		PublicKey string `json:"public_key"`         // Base64 encoded public key
+		KeyType   string `json:"key_type,omitempty"` // Required for ML-DSA-65; inferred for P-256/Dilithium3
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1448,22 +1868,27 @@ func (s *Server) handleUploadIdentityKey(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Validate key size - accept both P-256 and Dilithium3
-	if !crypto.IsValidIdentityKeySize(publicKey) {
-		http.Error(w, fmt.Sprintf("Invalid public key size: got %d bytes, expected %d (P-256) or %d (Dilithium3)",
-			len(publicKey), crypto.P256PublicKeySize, crypto.Dilithium3PublicKeySize), http.StatusBadRequest)
+	// Validate key type and size
+	keyType, err := crypto.ResolveKeyType(req.KeyType, publicKey, true)
+	if err == nil {
+		err = keyType.ValidateIdentityKey(publicKey)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid identity key: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Store the identity key
-	key, err := s.cryptoService.StoreIdentityKey(r.Context(), userID, publicKey)
+	// Store the identity key (rotating any existing key and resetting contacts' verifications).
+	// A client-initiated upload is logged as a manual rotation.
+	key, err := s.cryptoService.RotateIdentityKey(r.Context(), userID, keyType, publicKey, crypto.RotationReasonManual)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to store identity key: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to store identity key: %v", err), keyUploadStatus(err))
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id":              key.ID,
+		"key_type":        key.KeyType,
 		"fingerprint":     key.KeyFingerprint,
 		"version":         key.KeyVersion,
 		"created_at":      key.CreatedAt,
@@ -1485,24 +1910,92 @@ func (s *Server) handleGetMyIdentityKey(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"id":              key.ID,
+		"key_type":        key.KeyType,
 		"public_key":      base64Encode(key.PublicKey),
 		"fingerprint":     key.KeyFingerprint,
 		"version":         key.KeyVersion,
 		"created_at":      key.CreatedAt,
-	})
+	}
+
+	companion, err := s.cryptoService.GetCompanionIdentityKey(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get companion identity key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if companion != nil {
+		response["companion"] = companionIdentityKeyResponse(companion)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleUploadCompanionIdentityKey publishes an identity key of the type the
+// user is migrating to (e.g. ML-DSA-65) alongside the primary one. The primary
+// identity key signs key_type || 0x00 || public_key.
+func (s *Server) handleUploadCompanionIdentityKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req struct {
+		KeyType   string `json:"key_type"`
+		PublicKey string `json:"public_key"` // Base64
+		Signature string `json:"signature"`  // Base64, by the primary identity key
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	keyType, err := crypto.ParseKeyType(req.KeyType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid key type: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	publicKey, err := base64Decode(req.PublicKey)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for public key", http.StatusBadRequest)
+		return
+	}
+
+	signature, err := base64Decode(req.Signature)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for signature", http.StatusBadRequest)
+		return
+	}
+
+	key, err := s.cryptoService.PublishCompanionIdentityKey(r.Context(), userID, keyType, publicKey, signature)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store companion identity key: %v", err), keyUploadStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(companionIdentityKeyResponse(key))
+}
+
+// companionIdentityKeyResponse serializes a companion identity key
+func companionIdentityKeyResponse(key *crypto.IdentityKey) map[string]interface{} {
+	return map[string]interface{}{
+		"key_type":    key.KeyType,
+		"public_key":  base64Encode(key.PublicKey),
+		"signature":   base64Encode(key.CompanionSignature),
+		"fingerprint": key.KeyFingerprint,
+		"version":     key.KeyVersion,
+	}
 }
 
 // handleUploadSignedPreKey uploads a user's signed prekey
-// Accepts both P-256 (Web Crypto API) and Kyber1024 (PQC) keys
+// Accepts P-256 (Web Crypto API), X25519, Kyber1024 and ML-KEM keys
 func (s *Server) handleUploadSignedPreKey(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("userID").(uuid.UUID)
 
 	var req struct {
 		KeyID          int    `json:"key_id"`
-		KyberPublicKey string `json:"kyber_public_key"` // Base64 encoded (P-256 or Kyber)
-		Signature      string `json:"signature"`        // Base64 encoded signature
+		KeyType        string `json:"key_type,omitempty"` // Required for ML-KEM; inferred otherwise
+		KyberPublicKey string `json:"kyber_public_key"`   // Base64 encoded public key
+		Signature      string `json:"signature"`          // Base64 encoded signature
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1522,10 +2015,13 @@ func (s *Server) handleUploadSignedPreKey(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Validate prekey size - accept both P-256 and Kyber1024
-	if !crypto.IsValidPreKeySize(preKeyPublicKey) {
-		http.Error(w, fmt.Sprintf("Invalid public key size: got %d bytes, expected %d (P-256) or %d (Kyber1024)",
-			len(preKeyPublicKey), crypto.P256PublicKeySize, crypto.Kyber1024PublicKeySize), http.StatusBadRequest)
+	// Validate prekey type and size
+	keyType, err := crypto.ResolveKeyType(req.KeyType, preKeyPublicKey, false)
+	if err == nil {
+		err = keyType.ValidatePreKey(preKeyPublicKey)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid signed prekey: %v", err), http.StatusBadRequest)
 		return
 	}
 
@@ -1542,39 +2038,45 @@ func (s *Server) handleUploadSignedPreKey(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Use the appropriate verification based on key type
-	valid, err := crypto.VerifyAnySignature(identityKey.PublicKey, preKeyPublicKey, signature)
+	// Verify with the identity key's algorithm; ML-KEM prekeys are signed with their type tag
+	valid, err := crypto.VerifyTypedSignature(identityKey.KeyType, identityKey.PublicKey, crypto.PreKeySignedData(keyType, nil, preKeyPublicKey), signature)
 	if err != nil || !valid {
+		log.Printf("[Crypto] Rejected signed prekey upload from %s: signature verification failed (strict=%v)", userID, crypto.P256StrictMode())
 		http.Error(w, "Invalid signature - prekey must be signed by identity key", http.StatusBadRequest)
 		return
 	}
 
 	// Store the signed prekey
-	prekey, err := s.cryptoService.StoreSignedPreKey(r.Context(), userID, req.KeyID, preKeyPublicKey, signature)
+	prekey, err := s.cryptoService.StoreSignedPreKey(r.Context(), userID, req.KeyID, keyType, preKeyPublicKey, signature)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to store signed prekey: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to store signed prekey: %v", err), keyUploadStatus(err))
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id":          prekey.ID,
 		"key_id":      prekey.KeyID,
+		"key_type":    prekey.KeyType,
 		"fingerprint": prekey.KeyFingerprint,
 		"expires_at":  prekey.ExpiresAt,
 		"created_at":  prekey.CreatedAt,
+		// Keep the previous signed prekey's private key until this long after now
+		"grace_period_seconds": int64(s.cryptoService.RotationPolicy().GracePeriod.Seconds()),
 	})
 }
 
-// handleUploadOneTimePreKeys uploads a batch of one-time prekeys
-// Accepts both P-256 (Web Crypto API) and Kyber1024 (PQC) keys
-func (s *Server) handleUploadOneTimePreKeys(w http.ResponseWriter, r *http.Request) {
+// handleUploadCompanionSignedPreKey uploads a signed prekey of the type the
+// user is migrating to, optionally paired with an X25519 key. It is signed by
+// the companion identity key if one is published, otherwise by the primary.
+func (s *Server) handleUploadCompanionSignedPreKey(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("userID").(uuid.UUID)
 
 	var req struct {
-		PreKeys []struct {
-			KeyID          int    `json:"key_id"`
-			KyberPublicKey string `json:"kyber_public_key"` // Base64 encoded (P-256 or Kyber)
-		} `json:"prekeys"`
+		KeyID       int    `json:"key_id"`
+		KeyType     string `json:"key_type"`                // PQ component type
+		ECPublicKey string `json:"ec_public_key,omitempty"` // Base64 X25519, hybrid only
+		PQPublicKey string `json:"pq_public_key"`           // Base64
+		Signature   string `json:"signature"`               // Base64, over key_type || 0x00 || [EC||]PQ for ML-KEM
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1582,40 +2084,126 @@ func (s *Server) handleUploadOneTimePreKeys(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if len(req.PreKeys) == 0 {
-		http.Error(w, "No prekeys provided", http.StatusBadRequest)
-		return
-	}
-
-	if len(req.PreKeys) > 100 {
-		http.Error(w, "Maximum 100 prekeys per upload", http.StatusBadRequest)
+	keyType, err := crypto.ParseKeyType(req.KeyType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid key type: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Convert to internal format
-	prekeys := make([]crypto.OneTimePreKeyInput, len(req.PreKeys))
-	for i, pk := range req.PreKeys {
-		publicKey, err := base64Decode(pk.KyberPublicKey)
+	var ecPublicKey []byte
+	if req.ECPublicKey != "" {
+		ecPublicKey, err = base64Decode(req.ECPublicKey)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid base64 encoding for prekey %d", pk.KeyID), http.StatusBadRequest)
-			return
-		}
-
-		// Accept both P-256 and Kyber1024 keys
-		if !crypto.IsValidPreKeySize(publicKey) {
-			http.Error(w, fmt.Sprintf("Invalid public key size for prekey %d: got %d bytes", pk.KeyID, len(publicKey)), http.StatusBadRequest)
+			http.Error(w, "Invalid base64 encoding for EC public key", http.StatusBadRequest)
 			return
 		}
+	}
 
-		prekeys[i] = crypto.OneTimePreKeyInput{
+	pqPublicKey, err := base64Decode(req.PQPublicKey)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for PQ public key", http.StatusBadRequest)
+		return
+	}
+	if err := keyType.ValidatePreKey(pqPublicKey); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid companion signed prekey: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	signature, err := base64Decode(req.Signature)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for signature", http.StatusBadRequest)
+		return
+	}
+
+	signer, err := s.cryptoService.GetCompanionIdentityKey(r.Context(), userID)
+	if err == nil && signer == nil {
+		signer, err = s.cryptoService.GetIdentityKey(r.Context(), userID)
+	}
+	if err != nil || signer == nil {
+		http.Error(w, "Must upload identity key before companion signed prekey", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := crypto.VerifyTypedSignature(signer.KeyType, signer.PublicKey, crypto.PreKeySignedData(keyType, ecPublicKey, pqPublicKey), signature)
+	if err != nil || !valid {
+		log.Printf("[Crypto] Rejected companion signed prekey upload from %s: signature verification failed", userID)
+		http.Error(w, "Invalid signature - prekey must be signed by identity key", http.StatusBadRequest)
+		return
+	}
+
+	prekey, err := s.cryptoService.StoreCompanionSignedPreKey(r.Context(), userID, req.KeyID, keyType, ecPublicKey, pqPublicKey, signature)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store companion signed prekey: %v", err), keyUploadStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":             prekey.ID,
+		"key_id":         prekey.KeyID,
+		"key_type":       prekey.KeyType,
+		"fingerprint":    prekey.KeyFingerprint,
+		"hybrid_version": prekey.HybridVersion,
+		"expires_at":     prekey.ExpiresAt,
+		"created_at":     prekey.CreatedAt,
+	})
+}
+
+// handleUploadOneTimePreKeys uploads a batch of one-time prekeys
+// Accepts P-256 (Web Crypto API), X25519, Kyber1024 and ML-KEM keys
+func (s *Server) handleUploadOneTimePreKeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req struct {
+		PreKeys []struct {
+			KeyID          int    `json:"key_id"`
+			KeyType        string `json:"key_type,omitempty"` // Required for ML-KEM; inferred otherwise
+			KyberPublicKey string `json:"kyber_public_key"`   // Base64 encoded public key
+		} `json:"prekeys"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.PreKeys) == 0 {
+		http.Error(w, "No prekeys provided", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.PreKeys) > 100 {
+		http.Error(w, "Maximum 100 prekeys per upload", http.StatusBadRequest)
+		return
+	}
+
+	// Convert to internal format
+	prekeys := make([]crypto.OneTimePreKeyInput, len(req.PreKeys))
+	for i, pk := range req.PreKeys {
+		publicKey, err := base64Decode(pk.KyberPublicKey)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid base64 encoding for prekey %d", pk.KeyID), http.StatusBadRequest)
+			return
+		}
+
+		keyType, err := crypto.ResolveKeyType(pk.KeyType, publicKey, false)
+		if err == nil {
+			err = keyType.ValidatePreKey(publicKey)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid prekey %d: %v", pk.KeyID, err), http.StatusBadRequest)
+			return
+		}
+
+		prekeys[i] = crypto.OneTimePreKeyInput{
 			KeyID:          pk.KeyID,
+			KeyType:        keyType,
 			KyberPublicKey: publicKey,
 		}
 	}
 
 	// Store the prekeys
 	if err := s.cryptoService.StoreOneTimePreKeys(r.Context(), userID, prekeys); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to store one-time prekeys: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to store one-time prekeys: %v", err), keyUploadStatus(err))
 		return
 	}
 
@@ -1639,6 +2227,279 @@ func (s *Server) handleGetPreKeyCount(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleUploadLastResortPreKey uploads the user's last-resort Kyber prekey,
+// optionally paired with an X25519 key for hybrid PQXDH bundles. It is handed
+// out whenever the one-time prekey pool is empty and is never consumed.
+func (s *Server) handleUploadLastResortPreKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req struct {
+		KeyID       int    `json:"key_id"`
+		KeyType     string `json:"key_type,omitempty"`      // kyber1024 (default) or ML-KEM
+		ECPublicKey string `json:"ec_public_key,omitempty"` // Base64 X25519, hybrid only
+		PQPublicKey string `json:"pq_public_key"`           // Base64
+		Signature   string `json:"signature"`               // Base64, over PQ or EC||PQ (tagged for ML-KEM)
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var ecPublicKey []byte
+	if req.ECPublicKey != "" {
+		decoded, err := base64Decode(req.ECPublicKey)
+		if err != nil {
+			http.Error(w, "Invalid base64 encoding for EC public key", http.StatusBadRequest)
+			return
+		}
+		if len(decoded) != crypto.X25519PublicKeySize {
+			http.Error(w, fmt.Sprintf("Invalid EC public key size: got %d bytes, expected %d", len(decoded), crypto.X25519PublicKeySize), http.StatusBadRequest)
+			return
+		}
+		ecPublicKey = decoded
+	}
+
+	keyType := crypto.KeyTypeKyber1024
+	if req.KeyType != "" {
+		parsed, err := crypto.ParseKeyType(req.KeyType)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid key type: %v", err), http.StatusBadRequest)
+			return
+		}
+		keyType = parsed
+	}
+
+	pqPublicKey, err := base64Decode(req.PQPublicKey)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for PQ public key", http.StatusBadRequest)
+		return
+	}
+	if err := keyType.ValidatePreKey(pqPublicKey); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid PQ public key: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	signature, err := base64Decode(req.Signature)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for signature", http.StatusBadRequest)
+		return
+	}
+	if !crypto.IsValidSignatureSize(signature) {
+		http.Error(w, fmt.Sprintf("Invalid signature size: got %d bytes", len(signature)), http.StatusBadRequest)
+		return
+	}
+
+	// Last-resort prekeys are reused across sessions, so they must be signed
+	identityKey, err := s.cryptoService.GetIdentityKey(r.Context(), userID)
+	if err != nil || identityKey == nil {
+		http.Error(w, "Must upload identity key before last-resort prekey", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := crypto.VerifyTypedSignature(identityKey.KeyType, identityKey.PublicKey, crypto.PreKeySignedData(keyType, ecPublicKey, pqPublicKey), signature)
+	if err != nil || !valid {
+		log.Printf("[Crypto] Rejected last-resort prekey upload from %s: signature verification failed (strict=%v)", userID, crypto.P256StrictMode())
+		http.Error(w, "Invalid signature - prekey must be signed by identity key", http.StatusBadRequest)
+		return
+	}
+
+	prekey, err := s.cryptoService.StoreLastResortPreKey(r.Context(), userID, req.KeyID, keyType, ecPublicKey, pqPublicKey, signature)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store last-resort prekey: %v", err), keyUploadStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":             prekey.ID,
+		"key_id":         prekey.KeyID,
+		"key_type":       prekey.KeyType,
+		"fingerprint":    prekey.KeyFingerprint,
+		"hybrid_version": prekey.HybridVersion,
+		"created_at":     prekey.CreatedAt,
+	})
+}
+
+// handleGetPreKeyClaims returns recent claims against the current user's
+// prekeys, grouped by requester, with an anomaly score for pool draining
+func (s *Server) handleGetPreKeyClaims(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	window := crypto.DefaultPreKeyClaimWindow
+	if hoursStr := r.URL.Query().Get("window_hours"); hoursStr != "" {
+		hours, err := strconv.Atoi(hoursStr)
+		if err != nil || hours <= 0 || time.Duration(hours)*time.Hour > crypto.PreKeyClaimRetention {
+			http.Error(w, fmt.Sprintf("window_hours must be between 1 and %d", int(crypto.PreKeyClaimRetention.Hours())), http.StatusBadRequest)
+			return
+		}
+		window = time.Duration(hours) * time.Hour
+	}
+
+	report, err := s.cryptoService.GetPreKeyClaimReport(r.Context(), userID, window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get prekey claims: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// keyUploadStatus maps a key storage error to an HTTP status: keys whose
+// algorithms are unknown or only used by retired cipher suites, and companion
+// keys that don't fit the primary keys, are client errors
+func keyUploadStatus(err error) int {
+	if errors.Is(err, crypto.ErrSuiteRetired) || errors.Is(err, crypto.ErrUnknownSuite) ||
+		errors.Is(err, crypto.ErrUnknownKeyType) || errors.Is(err, crypto.ErrInvalidCompanionKey) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// handleListCipherSuites returns the cipher-suite registry with each suite's
+// current status and retirement deadline
+func (s *Server) handleListCipherSuites(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	suites := []map[string]interface{}{}
+	for _, suite := range s.cryptoService.SuiteRegistry().List() {
+		suites = append(suites, map[string]interface{}{
+			"id":                 suite.ID,
+			"name":               suite.Name,
+			"kem":                suite.KEM,
+			"signature":          suite.Signature,
+			"aead":               suite.AEAD,
+			"kdf":                suite.KDF,
+			"post_quantum":       suite.PostQuantum,
+			"encryption_version": suite.EncryptionVersion,
+			"hybrid_version":     suite.HybridVersion,
+			"status":             suite.StatusAt(now),
+			"retire_at":          suite.RetireAt,
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"suites": suites, // In server preference order
+	})
+}
+
+// handleGetMySuites returns the cipher suites the current user advertises
+func (s *Server) handleGetMySuites(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	suites, err := s.cryptoService.GetSupportedSuites(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get cipher suites: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if suites == nil {
+		suites = []crypto.SuiteID{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"suites": suites,
+	})
+}
+
+// handleSetMySuites replaces the cipher suites the current user advertises in
+// their bundle, most preferred first
+func (s *Server) handleSetMySuites(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req struct {
+		Suites []crypto.SuiteID `json:"suites"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Suites) == 0 {
+		http.Error(w, "At least one cipher suite is required", http.StatusBadRequest)
+		return
+	}
+
+	suites, err := s.cryptoService.SetSupportedSuites(r.Context(), userID, req.Suites)
+	if errors.Is(err, crypto.ErrSuiteRetired) || errors.Is(err, crypto.ErrUnknownSuite) {
+		http.Error(w, fmt.Sprintf("Invalid cipher suites: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set cipher suites: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"suites": suites,
+	})
+}
+
+// addSuiteNegotiation adds the target's advertised suites to a bundle response,
+// plus the suite the requester should use if both sides advertise one in common
+func (s *Server) addSuiteNegotiation(ctx context.Context, response map[string]interface{}, requestingUserID uuid.UUID, targetSuites []crypto.SuiteID) {
+	if len(targetSuites) == 0 {
+		return
+	}
+	response["supported_suites"] = targetSuites
+
+	requesterSuites, err := s.cryptoService.GetSupportedSuites(ctx, requestingUserID)
+	if err != nil {
+		log.Printf("[Crypto] Failed to get cipher suites for %s: %v", requestingUserID, err)
+		return
+	}
+	if suite, ok := s.cryptoService.SuiteRegistry().Negotiate(requesterSuites, targetSuites); ok {
+		response["negotiated_suite"] = suite
+	}
+}
+
+// lastResortPreKeyResponse serializes a last-resort prekey for a bundle response
+func lastResortPreKeyResponse(prekey *crypto.LastResortPreKey) map[string]interface{} {
+	response := map[string]interface{}{
+		"key_id":         prekey.KeyID,
+		"key_type":       prekey.KeyType,
+		"pq_public_key":  base64Encode(prekey.PQPublicKey),
+		"signature":      base64Encode(prekey.Signature),
+		"fingerprint":    prekey.KeyFingerprint,
+		"hybrid_version": prekey.HybridVersion,
+	}
+	if prekey.ECPublicKey != nil {
+		response["ec_public_key"] = base64Encode(prekey.ECPublicKey)
+	}
+	return response
+}
+
+// addCompanionKeys adds the target's companion keys to a bundle response
+func addCompanionKeys(response map[string]interface{}, identityKey *crypto.IdentityKey, signedPreKey *crypto.HybridSignedPreKey, oneTimePreKey *crypto.HybridOneTimePreKey) {
+	if identityKey != nil {
+		response["companion_identity_key"] = companionIdentityKeyResponse(identityKey)
+	}
+	if signedPreKey != nil {
+		prekey := map[string]interface{}{
+			"key_id":         signedPreKey.KeyID,
+			"key_type":       signedPreKey.KeyType,
+			"pq_public_key":  base64Encode(signedPreKey.PQPublicKey),
+			"signature":      base64Encode(signedPreKey.Signature),
+			"fingerprint":    signedPreKey.KeyFingerprint,
+			"hybrid_version": signedPreKey.HybridVersion,
+		}
+		if signedPreKey.ECPublicKey != nil {
+			prekey["ec_public_key"] = base64Encode(signedPreKey.ECPublicKey)
+		}
+		response["companion_signed_prekey"] = prekey
+	}
+	if oneTimePreKey != nil {
+		prekey := map[string]interface{}{
+			"id":             oneTimePreKey.ID,
+			"key_id":         oneTimePreKey.KeyID,
+			"key_type":       oneTimePreKey.KeyType,
+			"pq_public_key":  base64Encode(oneTimePreKey.PQPublicKey),
+			"hybrid_version": oneTimePreKey.HybridVersion,
+		}
+		if oneTimePreKey.ECPublicKey != nil {
+			prekey["ec_public_key"] = base64Encode(oneTimePreKey.ECPublicKey)
+		}
+		response["companion_one_time_prekey"] = prekey
+	}
+}
+
 // handleGetPreKeyBundle retrieves a user's prekey bundle for key exchange
 func (s *Server) handleGetPreKeyBundle(w http.ResponseWriter, r *http.Request) {
 	requestingUserID := r.Context().Value("userID").(uuid.UUID)
@@ -1667,6 +2528,10 @@ func (s *Server) handleGetPreKeyBundle(w http.ResponseWriter, r *http.Request) {
 
 	// Get the prekey bundle (this will atomically claim a one-time prekey if available)
 	bundle, err := s.cryptoService.GetPreKeyBundle(r.Context(), targetUserID, requestingUserID)
+	if errors.Is(err, crypto.ErrStaleSignedPreKey) {
+		http.Error(w, "User's signed prekey has expired; try again after they rotate it", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get prekey bundle: %v", err), http.StatusNotFound)
 		return
@@ -1674,16 +2539,20 @@ func (s *Server) handleGetPreKeyBundle(w http.ResponseWriter, r *http.Request) {
 
 	// Serialize the bundle for response
 	response := map[string]interface{}{
-		"user_id":        bundle.UserID,
-		"bundle_version": bundle.BundleVersion,
-		"generated_at":   bundle.GeneratedAt,
+		"user_id":                    bundle.UserID,
+		"bundle_version":             bundle.BundleVersion,
+		"generated_at":               bundle.GeneratedAt,
+		"signed_prekey_rotation_due": bundle.SignedPreKeyRotationDue,
+		"signed_prekey_stale":        bundle.SignedPreKeyStale,
 		"identity_key": map[string]interface{}{
+			"key_type":    bundle.IdentityKey.KeyType,
 			"public_key":  base64Encode(bundle.IdentityKey.PublicKey),
 			"fingerprint": bundle.IdentityKey.KeyFingerprint,
 			"version":     bundle.IdentityKey.KeyVersion,
 		},
 		"signed_prekey": map[string]interface{}{
 			"key_id":           bundle.SignedPreKey.KeyID,
+			"key_type":         bundle.SignedPreKey.KeyType,
 			"kyber_public_key": base64Encode(bundle.SignedPreKey.KyberPublicKey),
 			"signature":        base64Encode(bundle.SignedPreKey.Signature),
 			"fingerprint":      bundle.SignedPreKey.KeyFingerprint,
@@ -1695,10 +2564,20 @@ func (s *Server) handleGetPreKeyBundle(w http.ResponseWriter, r *http.Request) {
 		response["one_time_prekey"] = map[string]interface{}{
 			"id":               bundle.OneTimePreKey.ID,
 			"key_id":           bundle.OneTimePreKey.KeyID,
+			"key_type":         bundle.OneTimePreKey.KeyType,
 			"kyber_public_key": base64Encode(bundle.OneTimePreKey.KyberPublicKey),
 		}
 	}
 
+	// Otherwise the last-resort prekey, if the user published one
+	response["last_resort_prekey_used"] = bundle.LastResortPreKeyUsed
+	if bundle.LastResortPreKey != nil {
+		response["last_resort_prekey"] = lastResortPreKeyResponse(bundle.LastResortPreKey)
+	}
+
+	s.addSuiteNegotiation(r.Context(), response, requestingUserID, bundle.SupportedSuites)
+	addCompanionKeys(response, bundle.CompanionIdentityKey, bundle.CompanionSignedPreKey, bundle.CompanionOneTimePreKey)
+
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -1709,86 +2588,382 @@ func (s *Server) handleGetKeyStatus(w http.ResponseWriter, r *http.Request) {
 	identityKey, _ := s.cryptoService.GetIdentityKey(r.Context(), userID)
 	signedPreKey, _ := s.cryptoService.GetSignedPreKey(r.Context(), userID)
 	otkCount, _ := s.cryptoService.GetAvailableOneTimePreKeyCount(r.Context(), userID)
+	lastResortType := crypto.KeyTypeKyber1024
+	if signedPreKey != nil {
+		lastResortType = crypto.LastResortKeyType(signedPreKey.KeyType)
+	}
+	lastResortPreKey, _ := s.cryptoService.GetLastResortPreKey(r.Context(), userID, 1, lastResortType)
+	hybridLastResortPreKey, _ := s.cryptoService.GetLastResortPreKey(r.Context(), userID, 2, lastResortType)
+	companionIdentityKey, _ := s.cryptoService.GetCompanionIdentityKey(r.Context(), userID)
+	companionSignedPreKey, _ := s.cryptoService.GetCompanionSignedPreKey(r.Context(), userID)
 
 	hasIdentityKey := identityKey != nil
 	hasSignedPreKey := signedPreKey != nil
 	hasOneTimePreKeys := otkCount > 0
+	hasLastResortPreKey := lastResortPreKey != nil || hybridLastResortPreKey != nil
 
-	// Determine if E2EE is fully set up
-	e2eeReady := hasIdentityKey && hasSignedPreKey && hasOneTimePreKeys
+	// Determine if E2EE is fully set up (a last-resort prekey stands in for an empty pool)
+	e2eeReady := hasIdentityKey && hasSignedPreKey && (hasOneTimePreKeys || hasLastResortPreKey)
 
 	response := map[string]interface{}{
 		"e2ee_ready":         e2eeReady,
 		"has_identity_key":   hasIdentityKey,
 		"has_signed_prekey":  hasSignedPreKey,
 		"one_time_prekey_count": otkCount,
+		"has_last_resort_prekey": hasLastResortPreKey,
 	}
 
 	if identityKey != nil {
 		response["identity_key_fingerprint"] = identityKey.KeyFingerprint
 		response["identity_key_version"] = identityKey.KeyVersion
+		response["identity_key_type"] = identityKey.KeyType
 	}
 
 	if signedPreKey != nil {
+		rotation := s.cryptoService.RotationPolicy().SignedPreKeyStatus(signedPreKey.CreatedAt, time.Now())
 		response["signed_prekey_id"] = signedPreKey.KeyID
+		response["signed_prekey_type"] = signedPreKey.KeyType
 		response["signed_prekey_expires_at"] = signedPreKey.ExpiresAt
+		response["signed_prekey_rotation"] = rotation
 	}
 
-	json.NewEncoder(w).Encode(response)
-}
-
-// Helper functions for base64 encoding/decoding
-func base64Encode(data []byte) string {
-	return base64.StdEncoding.EncodeToString(data)
-}
+	// Dual-publish state during an algorithm migration
+	if companionIdentityKey != nil {
+		response["companion_identity_key_type"] = companionIdentityKey.KeyType
+		response["companion_identity_key_fingerprint"] = companionIdentityKey.KeyFingerprint
+	}
+	if companionSignedPreKey != nil {
+		response["companion_signed_prekey_type"] = companionSignedPreKey.KeyType.Label(companionSignedPreKey.ECPublicKey != nil)
+		response["companion_signed_prekey_id"] = companionSignedPreKey.KeyID
+	}
 
-func base64Decode(s string) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(s)
+	json.NewEncoder(w).Encode(response)
 }
 
-// ============================================================================
-// Sealed Sender Handlers
-// ============================================================================
-
-// handleUploadSealedSenderKey stores a user's sealed sender public key
-func (s *Server) handleUploadSealedSenderKey(w http.ResponseWriter, r *http.Request) {
+// handleGetSafetyNumber returns the safety number between the current user and
+// another user, along with the current user's verification state for them
+func (s *Server) handleGetSafetyNumber(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("userID").(uuid.UUID)
 
-	var req models.SealedSenderKeyUpload
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	contactUserID, err := uuid.Parse(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
 
-	// Decode base64 public key
-	publicKey, err := base64Decode(req.KyberPublicKey)
+	number, err := s.cryptoService.GetSafetyNumber(r.Context(), userID, contactUserID)
 	if err != nil {
-		http.Error(w, "Invalid base64 encoding for public key", http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Failed to compute safety number: %v", err), http.StatusInternalServerError)
 		return
 	}
-
-	// Validate Kyber1024 key size
-	if len(publicKey) != crypto.Kyber1024PublicKeySize {
-		http.Error(w, fmt.Sprintf("Invalid public key size: got %d bytes, expected %d (Kyber1024)",
-			len(publicKey), crypto.Kyber1024PublicKeySize), http.StatusBadRequest)
+	if number == nil {
+		http.Error(w, "Both users must have an identity key", http.StatusNotFound)
 		return
 	}
 
-	// Store the sealed sender key
-	key, err := s.cryptoService.StoreSealedSenderKey(r.Context(), userID, publicKey)
+	verification, err := s.cryptoService.GetContactVerification(r.Context(), userID, contactUserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to store sealed sender key: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to get verification: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	response := models.SealedSenderKeyResponse{
-		KeyFingerprint: key.KeyFingerprint,
-		KeyVersion:     key.KeyVersion,
-		CreatedAt:      key.CreatedAt,
-		ExpiresAt:      *key.ExpiresAt,
-	}
-
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"contact_user_id":            contactUserID,
+		"version":                    number.Version,
+		"safety_number":              number.Digits,
+		"display":                    number.Display,
+		"qr_payload":                 base64Encode(number.QRPayload),
+		"local_fingerprint":          number.LocalFingerprint,
+		"local_fingerprint_display":  transparency.FormatFingerprint(number.LocalFingerprint),
+		"remote_fingerprint":         number.RemoteFingerprint,
+		"remote_fingerprint_display": transparency.FormatFingerprint(number.RemoteFingerprint),
+		"verification":               verification,
+	})
+}
+
+// handleListVerifications returns every contact verification the current user holds
+func (s *Server) handleListVerifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	verifications, err := s.cryptoService.ListContactVerifications(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list verifications: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"verifications": verifications,
+	})
+}
+
+// handleSetVerification marks a contact verified or unverified. Verification
+// requires either the contact's current key fingerprint or a scanned QR payload.
+func (s *Server) handleSetVerification(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	contactUserID, err := uuid.Parse(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Verified    bool   `json:"verified"`
+		Fingerprint string `json:"fingerprint,omitempty"` // Contact's identity key fingerprint as compared
+		QRPayload   string `json:"qr_payload,omitempty"`  // Base64 payload scanned from the contact's device
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	fingerprint := req.Fingerprint
+	if req.Verified && req.QRPayload != "" {
+		scanned, err := base64Decode(req.QRPayload)
+		if err != nil {
+			http.Error(w, "Invalid base64 encoding for QR payload", http.StatusBadRequest)
+			return
+		}
+
+		number, err := s.cryptoService.GetSafetyNumber(r.Context(), userID, contactUserID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to compute safety number: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !crypto.CompareSafetyNumberQR(scanned, number) {
+			http.Error(w, "Safety number does not match", http.StatusConflict)
+			return
+		}
+		fingerprint = number.RemoteFingerprint
+	}
+
+	if req.Verified && fingerprint == "" {
+		http.Error(w, "fingerprint or qr_payload required to verify", http.StatusBadRequest)
+		return
+	}
+
+	verification, err := s.cryptoService.SetContactVerification(r.Context(), userID, contactUserID, req.Verified, fingerprint)
+	if err == crypto.ErrCannotVerifySelf {
+		http.Error(w, "Cannot verify your own key", http.StatusBadRequest)
+		return
+	}
+	if err == crypto.ErrFingerprintMismatch {
+		http.Error(w, "Contact's identity key has changed; compare safety numbers again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set verification: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(verification)
+}
+
+// sessionRouteIDs parses the conversation and peer IDs from a session route
+func sessionRouteIDs(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+	vars := mux.Vars(r)
+	conversationID, err := uuid.Parse(vars["conversation_id"])
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	peerUserID, err := uuid.Parse(vars["peer_user_id"])
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	return conversationID, peerUserID, nil
+}
+
+// handleListSessions lists the current user's session backups
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var conversationID *uuid.UUID
+	if v := r.URL.Query().Get("conversation_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+			return
+		}
+		conversationID = &id
+	}
+
+	sessions, err := s.cryptoService.ListSessions(r.Context(), userID, conversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+// handleGetSession restores the current user's session backup with a peer
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	conversationID, peerUserID, err := sessionRouteIDs(r)
+	if err != nil {
+		http.Error(w, "Invalid conversation or peer ID", http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.cryptoService.GetSession(r.Context(), userID, conversationID, peerUserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if session == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(session)
+}
+
+// handlePutSession creates or updates a session backup. Updates must carry the
+// version the client last read; a stale write gets 409 with the current state.
+func (s *Server) handlePutSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	conversationID, peerUserID, err := sessionRouteIDs(r)
+	if err != nil {
+		http.Error(w, "Invalid conversation or peer ID", http.StatusBadRequest)
+		return
+	}
+	if peerUserID == userID {
+		http.Error(w, "Peer must be another user", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		EncryptedSessionState string `json:"encrypted_session_state"` // Base64, encrypted by the owner's device key
+		SendChainIndex        int    `json:"send_chain_index"`
+		ReceiveChainIndex     int    `json:"receive_chain_index"`
+		ExpectedVersion       *int64 `json:"expected_version,omitempty"` // Omit when creating
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 2*crypto.MaxSessionStateSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	state, err := base64Decode(req.EncryptedSessionState)
+	if err != nil || len(state) == 0 {
+		http.Error(w, "Invalid base64 encoding for session state", http.StatusBadRequest)
+		return
+	}
+
+	// Both the owner and the peer must be in the conversation
+	for _, id := range []uuid.UUID{userID, peerUserID} {
+		ok, err := s.messagingService.IsParticipant(r.Context(), conversationID, id)
+		if err != nil {
+			http.Error(w, "Failed to verify conversation membership", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	session, err := s.cryptoService.PutSession(r.Context(), userID, conversationID, peerUserID, state,
+		crypto.SessionChainIndexes{SendChainIndex: req.SendChainIndex, ReceiveChainIndex: req.ReceiveChainIndex},
+		req.ExpectedVersion)
+	switch err {
+	case nil:
+		json.NewEncoder(w).Encode(session)
+	case crypto.ErrSessionConflict:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   err.Error(),
+			"current": session, // null if the backup was deleted
+		})
+	case crypto.ErrChainIndexRollback:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case crypto.ErrSessionStateTooLarge:
+		http.Error(w, fmt.Sprintf("Session state must be at most %d bytes", crypto.MaxSessionStateSize), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to store session: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleDeleteSession removes a session backup
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	conversationID, peerUserID, err := sessionRouteIDs(r)
+	if err != nil {
+		http.Error(w, "Invalid conversation or peer ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.cryptoService.DeleteSession(r.Context(), userID, conversationID, peerUserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Helper functions for base64 encoding/decoding
+func base64Encode(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func base64Decode(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// ============================================================================
+// Sealed Sender Handlers
+// ============================================================================
+
+// handleUploadSealedSenderKey stores a user's sealed sender public key
+func (s *Server) handleUploadSealedSenderKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req models.SealedSenderKeyUpload
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Decode base64 public key
+	publicKey, err := base64Decode(req.KyberPublicKey)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for public key", http.StatusBadRequest)
+		return
+	}
+
+	// Validate Kyber1024 key size
+	if len(publicKey) != crypto.Kyber1024PublicKeySize {
+		http.Error(w, fmt.Sprintf("Invalid public key size: got %d bytes, expected %d (Kyber1024)",
+			len(publicKey), crypto.Kyber1024PublicKeySize), http.StatusBadRequest)
+		return
+	}
+
+	// Store the sealed sender key
+	key, err := s.cryptoService.StoreSealedSenderKey(r.Context(), userID, publicKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store sealed sender key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := models.SealedSenderKeyResponse{
+		KeyFingerprint: key.KeyFingerprint,
+		KeyVersion:     key.KeyVersion,
+		CreatedAt:      key.CreatedAt,
+		ExpiresAt:      *key.ExpiresAt,
+	}
+
+	json.NewEncoder(w).Encode(response)
 }
 
 // handleGetMySealedSenderKey returns the current user's sealed sender key
@@ -1887,16 +3062,23 @@ func (s *Server) handleGetPreKeyBundleWithSealedSender(w http.ResponseWriter, r
 
 	// Try hybrid bundle first, fall back to regular bundle
 	hybridBundle, err := s.cryptoService.GetHybridPreKeyBundleWithSealedSender(r.Context(), targetUserID, requestingUserID)
+	if errors.Is(err, crypto.ErrStaleSignedPreKey) {
+		http.Error(w, "User's signed prekey has expired; try again after they rotate it", http.StatusConflict)
+		return
+	}
 	if err == nil && hybridBundle != nil && hybridBundle.SignedPreKey != nil {
 		// Return hybrid bundle with sealed sender
 		response := map[string]interface{}{
-			"user_id":        hybridBundle.UserID,
-			"bundle_version": hybridBundle.BundleVersion,
-			"generated_at":   hybridBundle.GeneratedAt,
+			"user_id":                    hybridBundle.UserID,
+			"bundle_version":             hybridBundle.BundleVersion,
+			"generated_at":               hybridBundle.GeneratedAt,
+			"signed_prekey_rotation_due": hybridBundle.SignedPreKeyRotationDue,
+			"signed_prekey_stale":        hybridBundle.SignedPreKeyStale,
 		}
 
 		if hybridBundle.IdentityKey != nil {
 			response["identity_key"] = map[string]interface{}{
+				"key_type":    hybridBundle.IdentityKey.KeyType,
 				"public_key":  base64Encode(hybridBundle.IdentityKey.PublicKey),
 				"fingerprint": hybridBundle.IdentityKey.KeyFingerprint,
 				"version":     hybridBundle.IdentityKey.KeyVersion,
@@ -1906,6 +3088,7 @@ func (s *Server) handleGetPreKeyBundleWithSealedSender(w http.ResponseWriter, r
 		if hybridBundle.SignedPreKey != nil {
 			response["signed_prekey"] = map[string]interface{}{
 				"key_id":           hybridBundle.SignedPreKey.KeyID,
+				"key_type":         hybridBundle.SignedPreKey.KeyType,
 				"ec_public_key":    base64Encode(hybridBundle.SignedPreKey.ECPublicKey),
 				"pq_public_key":    base64Encode(hybridBundle.SignedPreKey.PQPublicKey),
 				"signature":        base64Encode(hybridBundle.SignedPreKey.Signature),
@@ -1918,12 +3101,21 @@ func (s *Server) handleGetPreKeyBundleWithSealedSender(w http.ResponseWriter, r
 			response["one_time_prekey"] = map[string]interface{}{
 				"id":              hybridBundle.OneTimePreKey.ID,
 				"key_id":          hybridBundle.OneTimePreKey.KeyID,
+				"key_type":        hybridBundle.OneTimePreKey.KeyType,
 				"ec_public_key":   base64Encode(hybridBundle.OneTimePreKey.ECPublicKey),
 				"pq_public_key":   base64Encode(hybridBundle.OneTimePreKey.PQPublicKey),
 				"hybrid_version":  hybridBundle.OneTimePreKey.HybridVersion,
 			}
 		}
 
+		response["last_resort_prekey_used"] = hybridBundle.LastResortPreKeyUsed
+		if hybridBundle.LastResortPreKey != nil {
+			response["last_resort_prekey"] = lastResortPreKeyResponse(hybridBundle.LastResortPreKey)
+		}
+
+		s.addSuiteNegotiation(r.Context(), response, requestingUserID, hybridBundle.SupportedSuites)
+		addCompanionKeys(response, hybridBundle.CompanionIdentityKey, hybridBundle.CompanionSignedPreKey, hybridBundle.CompanionOneTimePreKey)
+
 		// Add sealed sender key
 		if hybridBundle.SealedSenderKey != nil {
 			response["sealed_sender_key"] = map[string]interface{}{
@@ -1944,19 +3136,26 @@ func (s *Server) handleGetPreKeyBundleWithSealedSender(w http.ResponseWriter, r
 
 	// Fall back to regular bundle with sealed sender
 	bundle, err := s.cryptoService.GetPreKeyBundleWithSealedSender(r.Context(), targetUserID, requestingUserID)
+	if errors.Is(err, crypto.ErrStaleSignedPreKey) {
+		http.Error(w, "User's signed prekey has expired; try again after they rotate it", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get prekey bundle: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	response := map[string]interface{}{
-		"user_id":        bundle.UserID,
-		"bundle_version": bundle.BundleVersion,
-		"generated_at":   bundle.GeneratedAt,
+		"user_id":                    bundle.UserID,
+		"bundle_version":             bundle.BundleVersion,
+		"generated_at":               bundle.GeneratedAt,
+		"signed_prekey_rotation_due": bundle.SignedPreKeyRotationDue,
+		"signed_prekey_stale":        bundle.SignedPreKeyStale,
 	}
 
 	if bundle.IdentityKey != nil {
 		response["identity_key"] = map[string]interface{}{
+			"key_type":    bundle.IdentityKey.KeyType,
 			"public_key":  base64Encode(bundle.IdentityKey.PublicKey),
 			"fingerprint": bundle.IdentityKey.KeyFingerprint,
 			"version":     bundle.IdentityKey.KeyVersion,
@@ -1966,6 +3165,7 @@ func (s *Server) handleGetPreKeyBundleWithSealedSender(w http.ResponseWriter, r
 	if bundle.SignedPreKey != nil {
 		response["signed_prekey"] = map[string]interface{}{
 			"key_id":           bundle.SignedPreKey.KeyID,
+			"key_type":         bundle.SignedPreKey.KeyType,
 			"kyber_public_key": base64Encode(bundle.SignedPreKey.KyberPublicKey),
 			"signature":        base64Encode(bundle.SignedPreKey.Signature),
 			"fingerprint":      bundle.SignedPreKey.KeyFingerprint,
@@ -1976,10 +3176,19 @@ func (s *Server) handleGetPreKeyBundleWithSealedSender(w http.ResponseWriter, r
 		response["one_time_prekey"] = map[string]interface{}{
 			"id":               bundle.OneTimePreKey.ID,
 			"key_id":           bundle.OneTimePreKey.KeyID,
+			"key_type":         bundle.OneTimePreKey.KeyType,
 			"kyber_public_key": base64Encode(bundle.OneTimePreKey.KyberPublicKey),
 		}
 	}
 
+	response["last_resort_prekey_used"] = bundle.LastResortPreKeyUsed
+	if bundle.LastResortPreKey != nil {
+		response["last_resort_prekey"] = lastResortPreKeyResponse(bundle.LastResortPreKey)
+	}
+
+	s.addSuiteNegotiation(r.Context(), response, requestingUserID, bundle.SupportedSuites)
+	addCompanionKeys(response, bundle.CompanionIdentityKey, bundle.CompanionSignedPreKey, bundle.CompanionOneTimePreKey)
+
 	// Add sealed sender key
 	if bundle.SealedSenderKey != nil {
 		response["sealed_sender_key"] = map[string]interface{}{
@@ -1989,142 +3198,664 @@ func (s *Server) handleGetPreKeyBundleWithSealedSender(w http.ResponseWriter, r
 		}
 	}
 
-	// Add delivery verifier
-	if bundle.DeliveryVerifier != nil {
-		response["delivery_verifier"] = base64Encode(bundle.DeliveryVerifier)
+	// Add delivery verifier
+	if bundle.DeliveryVerifier != nil {
+		response["delivery_verifier"] = base64Encode(bundle.DeliveryVerifier)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// ============================================================================
+// Key Transparency Handlers
+// ============================================================================
+
+// handleGetTransparencyRoot returns the current signed tree head, or that of
+// the epoch given by ?epoch= (public endpoint)
+func (s *Server) handleGetTransparencyRoot(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	if epochStr := r.URL.Query().Get("epoch"); epochStr != "" {
+		epoch, err := strconv.ParseInt(epochStr, 10, 64)
+		if err != nil || epoch <= 0 {
+			http.Error(w, "Invalid epoch", http.StatusBadRequest)
+			return
+		}
+		sth, err := s.transparencyService.GetSignedTreeHeadAtEpoch(r.Context(), epoch)
+		if errors.Is(err, transparency.ErrUnknownEpoch) {
+			http.Error(w, "Epoch not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get tree head: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeTransparencyObject(w, r, sth, sth.ToResponse())
+		return
+	}
+
+	sth, err := s.transparencyService.GetSignedTreeHead(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get tree head: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if sth == nil {
+		http.Error(w, "No transparency data available yet", http.StatusNotFound)
+		return
+	}
+
+	writeTransparencyObject(w, r, sth, sth.ToResponse())
+}
+
+// handleGetConsistencyProof returns a consistency proof between two epochs (public endpoint)
+func (s *Server) handleGetConsistencyProof(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	fromEpochStr := r.URL.Query().Get("from")
+	toEpochStr := r.URL.Query().Get("to")
+
+	if fromEpochStr == "" || toEpochStr == "" {
+		http.Error(w, "Both 'from' and 'to' epoch parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	fromEpoch, err := strconv.ParseInt(fromEpochStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid 'from' epoch", http.StatusBadRequest)
+		return
+	}
+
+	toEpoch, err := strconv.ParseInt(toEpochStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid 'to' epoch", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := s.transparencyService.GetConsistencyProof(r.Context(), fromEpoch, toEpoch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get consistency proof: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeTransparencyObject(w, r, proof, proof.ToResponse())
+}
+
+// handleGetAuditLog returns the public audit log. Tree indexes are included
+// only for requests with a valid X-Auditor-Token header (public endpoint)
+func (s *Server) handleGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	withIndexes := false
+	if token := r.Header.Get("X-Auditor-Token"); token != "" {
+		if !s.transparencyService.IsAuditor(token) {
+			http.Error(w, "Invalid auditor token", http.StatusUnauthorized)
+			return
+		}
+		withIndexes = true
+	}
+
+	fromEpochStr := r.URL.Query().Get("from_epoch")
+	limitStr := r.URL.Query().Get("limit")
+
+	var fromEpoch int64 = 0
+	if fromEpochStr != "" {
+		parsed, err := strconv.ParseInt(fromEpochStr, 10, 64)
+		if err == nil {
+			fromEpoch = parsed
+		}
+	}
+
+	// Resume within from_epoch after the last seq already read
+	var afterSeq int64 = 0
+	if afterSeqStr := r.URL.Query().Get("after_seq"); afterSeqStr != "" {
+		parsed, err := strconv.ParseInt(afterSeqStr, 10, 64)
+		if err == nil {
+			afterSeq = parsed
+		}
+	}
+
+	limit := 100
+	if limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+
+	entries, err := s.transparencyService.GetAuditLog(r.Context(), fromEpoch, afterSeq, limit, withIndexes)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Convert to response format
+	responseEntries := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		responseEntries[i] = entry.ToResponse()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": responseEntries,
+	})
+}
+
+// handleGetSigningKeys returns the transparency signing public keys (public endpoint)
+func (s *Server) handleGetSigningKeys(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	keys, err := s.transparencyService.GetSigningKeys(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get signing keys: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Convert to response format
+	responseKeys := make([]interface{}, len(keys))
+	for i, key := range keys {
+		responseKeys[i] = key.ToResponse()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"signing_keys": responseKeys,
+	})
+}
+
+// handleGetVRFKey returns the VRF public key that derives tree indexes (public endpoint)
+func (s *Server) handleGetVRFKey(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	key, err := s.transparencyService.GetVRFKey(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get VRF key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		http.Error(w, "No VRF key registered", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(key.ToResponse())
+}
+
+// handleGetWitnesses returns the witnesses that cosign tree heads (public endpoint)
+func (s *Server) handleGetWitnesses(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	witnesses, err := s.transparencyService.GetWitnesses(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get witnesses: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	responseWitnesses := make([]interface{}, len(witnesses))
+	for i, witness := range witnesses {
+		responseWitnesses[i] = witness.ToResponse()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"witnesses": responseWitnesses,
+	})
+}
+
+// handleSubmitCosignature stores a witness cosignature over a tree head
+// (public endpoint; the cosignature authenticates the witness)
+func (s *Server) handleSubmitCosignature(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		TreeHead           transparency.TreeHeadResponse `json:"tree_head"`
+		WitnessFingerprint string                        `json:"witness_fingerprint"`
+		CosignedAt         string                        `json:"cosigned_at"`
+		Signature          string                        `json:"signature"` // Base64 encoded
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sth, err := transparency.TreeHeadFromResponse(&req.TreeHead)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tree_head: %v", err), http.StatusBadRequest)
+		return
+	}
+	cosignedAt, err := time.Parse(time.RFC3339, req.CosignedAt)
+	if err != nil {
+		http.Error(w, "Invalid cosigned_at", http.StatusBadRequest)
+		return
+	}
+	signature, err := base64Decode(req.Signature)
+	if err != nil {
+		http.Error(w, "Invalid base64 encoding for signature", http.StatusBadRequest)
+		return
+	}
+
+	fork, err := s.transparencyService.AddCosignature(r.Context(), sth, &transparency.Cosignature{
+		EpochNumber:        sth.EpochNumber,
+		WitnessFingerprint: req.WitnessFingerprint,
+		CosignedAt:         cosignedAt,
+		Signature:          signature,
+	})
+	switch {
+	case err == nil:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"epoch":   sth.EpochNumber,
+		})
+	case errors.Is(err, transparency.ErrForkDetected):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+			"fork":  fork.ToResponse(),
+		})
+	case errors.Is(err, transparency.ErrUnknownWitness):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, transparency.ErrUnknownEpoch):
+		http.Error(w, "Epoch not found", http.StatusNotFound)
+	case isTreeHeadRejection(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to store cosignature: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleGetCheckpoint returns a tree head with its witness cosignatures (public endpoint)
+// Query: epoch (optional, defaults to the latest cosigned epoch)
+func (s *Server) handleGetCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var epoch int64 = 0
+	if epochStr := r.URL.Query().Get("epoch"); epochStr != "" {
+		parsed, err := strconv.ParseInt(epochStr, 10, 64)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid epoch", http.StatusBadRequest)
+			return
+		}
+		epoch = parsed
+	}
+
+	checkpoint, err := s.transparencyService.GetCheckpoint(r.Context(), epoch)
+	if errors.Is(err, transparency.ErrUnknownEpoch) {
+		http.Error(w, "Epoch not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get checkpoint: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(checkpoint.ToResponse())
+}
+
+// handleSubmitObservedHeads checks the tree heads a client observed against
+// the published ones and returns the latest cosigned checkpoint (protected endpoint)
+func (s *Server) handleSubmitObservedHeads(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req struct {
+		TreeHeads []transparency.TreeHeadResponse `json:"tree_heads"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.TreeHeads) > 100 {
+		http.Error(w, "At most 100 tree heads per request", http.StatusBadRequest)
+		return
+	}
+
+	heads := make([]*transparency.SignedTreeHead, len(req.TreeHeads))
+	for i := range req.TreeHeads {
+		sth, err := transparency.TreeHeadFromResponse(&req.TreeHeads[i])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid tree head %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		heads[i] = sth
+	}
+
+	checkpoint, forks, err := s.transparencyService.SubmitObservedHeads(r.Context(), userID, heads)
+	switch {
+	case err == nil:
+		json.NewEncoder(w).Encode(checkpoint.ToResponse())
+	case errors.Is(err, transparency.ErrForkDetected):
+		responseForks := make([]interface{}, len(forks))
+		for i, fork := range forks {
+			responseForks[i] = fork.ToResponse()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":      err.Error(),
+			"forks":      responseForks,
+			"checkpoint": checkpoint.ToResponse(),
+		})
+	case errors.Is(err, transparency.ErrUnknownEpoch):
+		http.Error(w, "Epoch not found", http.StatusNotFound)
+	case isTreeHeadRejection(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to check tree heads: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleGetForks returns recorded fork evidence (public endpoint)
+func (s *Server) handleGetForks(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	forks, err := s.transparencyService.GetForks(r.Context(), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get forks: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	responseForks := make([]interface{}, len(forks))
+	for i, fork := range forks {
+		responseForks[i] = fork.ToResponse()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"forks": responseForks,
+	})
+}
+
+// handleGetTreeStorage returns node storage metrics for the key directory tree
+// and the last compaction result (public endpoint)
+func (s *Server) handleGetTreeStorage(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	stats, err := s.transparencyService.GetStorageStats(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get storage stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleGetEpochSalt reveals an epoch's salt once the reveal delay has passed,
+// so auditors can check user-ID commitments (public endpoint)
+// Query: epoch (required)
+func (s *Server) handleGetEpochSalt(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	epoch, err := strconv.ParseInt(r.URL.Query().Get("epoch"), 10, 64)
+	if err != nil || epoch <= 0 {
+		http.Error(w, "Invalid or missing 'epoch'", http.StatusBadRequest)
+		return
+	}
+
+	salt, err := s.transparencyService.GetEpochSalt(r.Context(), epoch)
+	switch {
+	case err == nil:
+		json.NewEncoder(w).Encode(salt.ToResponse())
+	case errors.Is(err, transparency.ErrUnknownEpoch):
+		http.Error(w, "Epoch not found", http.StatusNotFound)
+	case errors.Is(err, transparency.ErrNoSaltCommitment):
+		http.Error(w, "Epoch has no committed salt", http.StatusNotFound)
+	case errors.Is(err, transparency.ErrSaltNotRevealed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, transparency.ErrSaltUnavailable):
+		http.Error(w, "Epoch salts are not available on this server", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to get epoch salt: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// writeTransparencyObject writes a tree head or proof as JSON, or in its
+// canonical binary encoding if the request accepts it
+func writeTransparencyObject(w http.ResponseWriter, r *http.Request, object, response interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if !acceptsCanonicalEncoding(r) {
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	data, err := transparency.EncodeWire(object)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", transparency.WireContentType)
+	w.Write(data)
+}
 
-	json.NewEncoder(w).Encode(response)
+// acceptsCanonicalEncoding reports whether the Accept header lists the
+// canonical transparency encoding
+func acceptsCanonicalEncoding(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == transparency.WireContentType && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
 }
 
-// ============================================================================
-// Key Transparency Handlers
-// ============================================================================
+// isTreeHeadRejection reports whether err rejects a submitted tree head or cosignature
+func isTreeHeadRejection(err error) bool {
+	return errors.Is(err, transparency.ErrUnknownSigningKey) ||
+		errors.Is(err, transparency.ErrSigningKeyRevoked) ||
+		errors.Is(err, transparency.ErrOutsideKeyValidity) ||
+		errors.Is(err, transparency.ErrInvalidTreeHeadSignature) ||
+		errors.Is(err, transparency.ErrInvalidCosignature) ||
+		errors.Is(err, transparency.ErrCosignatureTooStale)
+}
 
-// handleGetTransparencyRoot returns the current signed tree head (public endpoint)
-func (s *Server) handleGetTransparencyRoot(w http.ResponseWriter, r *http.Request) {
+// handleGetLogTreeHead returns a signed head of the append-only tree head log (public endpoint)
+// Query: tree_size (optional, defaults to the latest head)
+func (s *Server) handleGetLogTreeHead(w http.ResponseWriter, r *http.Request) {
 	if s.transparencyService == nil {
 		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
 		return
 	}
 
-	sth, err := s.transparencyService.GetSignedTreeHead(r.Context())
+	var treeSize int64
+	if treeSizeStr := r.URL.Query().Get("tree_size"); treeSizeStr != "" {
+		parsed, err := strconv.ParseInt(treeSizeStr, 10, 64)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid 'tree_size'", http.StatusBadRequest)
+			return
+		}
+		treeSize = parsed
+	}
+
+	head, err := s.transparencyService.GetLogTreeHead(r.Context(), treeSize)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get tree head: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to get log head: %v", err), http.StatusInternalServerError)
 		return
 	}
-
-	if sth == nil {
-		http.Error(w, "No transparency data available yet", http.StatusNotFound)
+	if head == nil {
+		http.Error(w, "No signed log head for that size", http.StatusNotFound)
 		return
 	}
 
-	json.NewEncoder(w).Encode(sth.ToResponse())
+	json.NewEncoder(w).Encode(head.ToResponse())
 }
 
-// handleGetConsistencyProof returns a consistency proof between two epochs (public endpoint)
-func (s *Server) handleGetConsistencyProof(w http.ResponseWriter, r *http.Request) {
+// handleGetLogEntries returns leaves of the append-only tree head log (public endpoint)
+// Query: start (inclusive), end (exclusive); at most 1000 entries are returned
+func (s *Server) handleGetLogEntries(w http.ResponseWriter, r *http.Request) {
 	if s.transparencyService == nil {
 		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
 		return
 	}
 
-	fromEpochStr := r.URL.Query().Get("from")
-	toEpochStr := r.URL.Query().Get("to")
-
-	if fromEpochStr == "" || toEpochStr == "" {
-		http.Error(w, "Both 'from' and 'to' epoch parameters are required", http.StatusBadRequest)
+	start, err := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid 'start'", http.StatusBadRequest)
 		return
 	}
-
-	fromEpoch, err := strconv.ParseInt(fromEpochStr, 10, 64)
+	end, err := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid 'from' epoch", http.StatusBadRequest)
+		http.Error(w, "Invalid 'end'", http.StatusBadRequest)
 		return
 	}
 
-	toEpoch, err := strconv.ParseInt(toEpochStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid 'to' epoch", http.StatusBadRequest)
+	entries, err := s.transparencyService.GetLogEntries(r.Context(), start, end)
+	if errors.Is(err, transparency.ErrLogRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	proof, err := s.transparencyService.GetConsistencyProof(r.Context(), fromEpoch, toEpoch)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get consistency proof: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to get log entries: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(proof.ToResponse())
+	responseEntries := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		responseEntries[i] = entry.ToResponse()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": responseEntries,
+	})
 }
 
-// handleGetAuditLog returns the public audit log (public endpoint)
-func (s *Server) handleGetAuditLog(w http.ResponseWriter, r *http.Request) {
+// handleGetLogInclusionProof returns an RFC 6962 audit path (public endpoint)
+// Query: leaf_index or epoch, and tree_size (optional, defaults to the current size)
+func (s *Server) handleGetLogInclusionProof(w http.ResponseWriter, r *http.Request) {
 	if s.transparencyService == nil {
 		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
 		return
 	}
 
-	fromEpochStr := r.URL.Query().Get("from_epoch")
-	limitStr := r.URL.Query().Get("limit")
-
-	var fromEpoch int64 = 0
-	if fromEpochStr != "" {
-		parsed, err := strconv.ParseInt(fromEpochStr, 10, 64)
-		if err == nil {
-			fromEpoch = parsed
+	query := r.URL.Query()
+	var leafIndex int64
+	switch {
+	case query.Get("leaf_index") != "":
+		parsed, err := strconv.ParseInt(query.Get("leaf_index"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'leaf_index'", http.StatusBadRequest)
+			return
+		}
+		leafIndex = parsed
+	case query.Get("epoch") != "":
+		epoch, err := strconv.ParseInt(query.Get("epoch"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'epoch'", http.StatusBadRequest)
+			return
 		}
+		leafIndex, err = s.transparencyService.GetLogLeafIndex(r.Context(), epoch)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to find epoch in log: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if leafIndex < 0 {
+			http.Error(w, "Epoch not in log", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Either 'leaf_index' or 'epoch' is required", http.StatusBadRequest)
+		return
 	}
 
-	limit := 100
-	if limitStr != "" {
-		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 1000 {
-			limit = parsed
+	var treeSize int64
+	if treeSizeStr := query.Get("tree_size"); treeSizeStr != "" {
+		parsed, err := strconv.ParseInt(treeSizeStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'tree_size'", http.StatusBadRequest)
+			return
 		}
+		treeSize = parsed
 	}
 
-	entries, err := s.transparencyService.GetAuditLog(r.Context(), fromEpoch, limit)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get audit log: %v", err), http.StatusInternalServerError)
+	proof, err := s.transparencyService.GetLogInclusionProof(r.Context(), leafIndex, treeSize)
+	if errors.Is(err, transparency.ErrLogRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// Convert to response format
-	responseEntries := make([]interface{}, len(entries))
-	for i, entry := range entries {
-		responseEntries[i] = entry.ToResponse()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get inclusion proof: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"entries": responseEntries,
-	})
+	json.NewEncoder(w).Encode(proof.ToResponse())
 }
 
-// handleGetSigningKeys returns the transparency signing public keys (public endpoint)
-func (s *Server) handleGetSigningKeys(w http.ResponseWriter, r *http.Request) {
+// handleGetLogConsistencyProof returns an RFC 6962 consistency proof (public endpoint)
+// Query: first, second (optional, defaults to the current size)
+func (s *Server) handleGetLogConsistencyProof(w http.ResponseWriter, r *http.Request) {
 	if s.transparencyService == nil {
 		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
 		return
 	}
 
-	keys, err := s.transparencyService.GetSigningKeys(r.Context())
+	first, err := strconv.ParseInt(r.URL.Query().Get("first"), 10, 64)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get signing keys: %v", err), http.StatusInternalServerError)
+		http.Error(w, "Invalid 'first'", http.StatusBadRequest)
 		return
 	}
 
-	// Convert to response format
-	responseKeys := make([]interface{}, len(keys))
-	for i, key := range keys {
-		responseKeys[i] = key.ToResponse()
+	var second int64
+	if secondStr := r.URL.Query().Get("second"); secondStr != "" {
+		second, err = strconv.ParseInt(secondStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'second'", http.StatusBadRequest)
+			return
+		}
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"signing_keys": responseKeys,
-	})
+	proof, err := s.transparencyService.GetLogConsistencyProof(r.Context(), first, second)
+	if errors.Is(err, transparency.ErrLogRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get consistency proof: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(proof.ToResponse())
 }
 
 // handleGetInclusionProof returns an inclusion proof for a user's key (protected endpoint)
+// Query: user_id, epoch (optional), compressed (optional, "true" for the compressed sibling path)
 func (s *Server) handleGetInclusionProof(w http.ResponseWriter, r *http.Request) {
 	if s.transparencyService == nil {
 		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
@@ -2153,12 +3884,138 @@ func (s *Server) handleGetInclusionProof(w http.ResponseWriter, r *http.Request)
 	}
 
 	proof, err := s.transparencyService.GetInclusionProof(r.Context(), userID, epoch)
+	if errors.Is(err, transparency.ErrEpochPruned) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get inclusion proof: %v", err), http.StatusNotFound)
 		return
 	}
 
-	json.NewEncoder(w).Encode(proof.ToResponse())
+	// compressed=true returns a sibling bitmap plus only the non-default siblings
+	if r.URL.Query().Get("compressed") == "true" {
+		proof.Compress()
+	}
+
+	writeTransparencyObject(w, r, proof, proof.ToResponse())
+}
+
+// handleGetNonExistenceProof proves a user had no directory entry at an epoch (protected endpoint)
+// Query: user_id, epoch (optional, defaults to current), compressed (optional)
+func (s *Server) handleGetNonExistenceProof(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID, err := uuid.Parse(r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		return
+	}
+
+	var epoch int64
+	if epochStr := r.URL.Query().Get("epoch"); epochStr != "" {
+		epoch, err = strconv.ParseInt(epochStr, 10, 64)
+		if err != nil || epoch < 0 {
+			http.Error(w, "Invalid 'epoch'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	proof, err := s.transparencyService.GetNonExistenceProof(r.Context(), userID, epoch)
+	switch {
+	case errors.Is(err, transparency.ErrUserPresent):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, transparency.ErrUnknownEpoch):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, transparency.ErrLegacyEpoch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, transparency.ErrEpochPruned):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case errors.Is(err, transparency.ErrVRFUnavailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to get non-existence proof: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("compressed") == "true" {
+		proof.Compress()
+	}
+
+	writeTransparencyObject(w, r, proof, proof.ToResponse())
+}
+
+// handleGetKeyHistory returns every key version published for a user, each
+// with an inclusion proof at the epoch that introduced it (protected endpoint).
+// The full history shows when a user changed keys, so callers only get their own.
+// Query: user_id (optional, must be the caller), from_epoch, limit, compressed (all optional)
+func (s *Server) handleGetKeyHistory(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID := r.Context().Value("userID").(uuid.UUID)
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		parsed, err := uuid.Parse(userIDStr)
+		if err != nil {
+			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			return
+		}
+		if parsed != userID {
+			http.Error(w, "Key history is only available for your own account", http.StatusForbidden)
+			return
+		}
+	}
+
+	var fromEpoch int64
+	if fromStr := r.URL.Query().Get("from_epoch"); fromStr != "" {
+		parsed, err := strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'from_epoch'", http.StatusBadRequest)
+			return
+		}
+		fromEpoch = parsed
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil {
+			limit = parsed
+		}
+	}
+
+	history, err := s.transparencyService.GetKeyHistory(r.Context(), userID, fromEpoch, limit)
+	if errors.Is(err, transparency.ErrEpochPruned) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get key history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	compressed := r.URL.Query().Get("compressed") == "true"
+	versions := make([]interface{}, len(history))
+	for i := range history {
+		if compressed {
+			history[i].Proof.Compress()
+		}
+		versions[i] = history[i].ToResponse()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":  userID.String(),
+		"versions": versions,
+	})
 }
 
 // handleUpdateClientState updates a client's verified epoch state (protected endpoint)
@@ -2239,6 +4096,115 @@ func (s *Server) handleGetClientState(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleSetMonitoredUsers replaces the users the caller monitors for key
+// changes. Users the caller has no conversation or contact with are dropped
+// silently (protected endpoint)
+func (s *Server) handleSetMonitoredUsers(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var req struct {
+		UserIDs []string `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userIDs := make([]uuid.UUID, len(req.UserIDs))
+	for i, idStr := range req.UserIDs {
+		parsed, err := uuid.Parse(idStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid user ID: %s", idStr), http.StatusBadRequest)
+			return
+		}
+		userIDs[i] = parsed
+	}
+
+	err := s.transparencyService.SetMonitoredUsers(r.Context(), userID, userIDs)
+	if errors.Is(err, transparency.ErrTooManyMonitoredUsers) {
+		http.Error(w, fmt.Sprintf("At most %d users can be monitored", transparency.MaxMonitoredUsers), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update monitored users: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.handleGetMonitoredUsers(w, r)
+}
+
+// handleGetMonitoredUsers returns the users the caller monitors (protected endpoint)
+func (s *Server) handleGetMonitoredUsers(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	userIDs, err := s.transparencyService.GetMonitoredUsers(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get monitored users: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	responseList := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		responseList[i] = id.String()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_ids": responseList,
+	})
+}
+
+// handleGetKeyChangeEvents returns key changes of the caller and the users it
+// monitors, for catching up on pushes missed while offline (protected endpoint)
+func (s *Server) handleGetKeyChangeEvents(w http.ResponseWriter, r *http.Request) {
+	if s.transparencyService == nil {
+		http.Error(w, "Key transparency not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	var fromEpoch int64
+	if fromStr := r.URL.Query().Get("from_epoch"); fromStr != "" {
+		parsed, err := strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'from_epoch'", http.StatusBadRequest)
+			return
+		}
+		fromEpoch = parsed
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil {
+			limit = parsed
+		}
+	}
+
+	events, err := s.transparencyService.GetKeyChangeEvents(r.Context(), userID, fromEpoch, limit)
+	if errors.Is(err, transparency.ErrEpochPruned) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get key changes: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+	})
+}
+
 // ============================================================================
 // Transparency Adapter for Crypto Service
 // ============================================================================
@@ -2256,9 +4222,24 @@ func (a *transparencyQueuerAdapter) QueueKeyUpdate(update crypto.TransparencyKey
 		SignedPreKeyFingerprint: update.SignedPreKeyFingerprint,
 		KeyVersion:              update.KeyVersion,
 		UpdateType:              update.UpdateType,
+		IdentityKeyType:         update.IdentityKeyType,
+		SignedPreKeyType:        update.SignedPreKeyType,
+		CompanionKeys:           companionLeafKeys(update.CompanionKeys),
 	})
 }
 
+// companionLeafKeys converts crypto companion keys to transparency leaf keys
+func companionLeafKeys(keys []crypto.TransparencyLeafKey) []transparency.LeafKey {
+	if len(keys) == 0 {
+		return nil
+	}
+	leafKeys := make([]transparency.LeafKey, len(keys))
+	for i, key := range keys {
+		leafKeys[i] = transparency.LeafKey{Role: key.Role, KeyType: key.KeyType, Fingerprint: key.Fingerprint}
+	}
+	return leafKeys
+}
+
 // ============================================================================
 // OAuth Handlers
 // ============================================================================
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kindlyrobotics/nochat/internal/transparency"
+)
+
+// Alert kinds
+const (
+	alertEquivocation = "equivocation"  // two different signed roots for one epoch
+	alertRollback     = "rollback"      // the server's latest epoch went backwards
+	alertBadSignature = "bad_signature" // a tree head that does not verify
+	alertInconsistent = "inconsistent"  // consecutive epochs are not consistent
+	alertRootMismatch = "root_mismatch" // the audit log does not rebuild the signed root
+	alertMissingEpoch = "missing_epoch" // an epoch below the latest is gone
+	alertSaltMismatch = "salt_mismatch" // a revealed salt does not match its signed commitment
+)
+
+// alert is evidence of server misbehaviour. It stops the audit: the state is
+// not advanced past the last epoch that verified.
+type alert struct {
+	Kind       string    `json:"kind"`
+	Server     string    `json:"server"`
+	Epoch      int64     `json:"epoch"`
+	Detail     string    `json:"detail"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+func (a *alert) Error() string {
+	return fmt.Sprintf("%s at epoch %d: %s", a.Kind, a.Epoch, a.Detail)
+}
+
+// auditorState is what the auditor has verified so far, saved after every epoch
+type auditorState struct {
+	Server   string `json:"server"`
+	Epoch    int64  `json:"epoch"` // last verified epoch, 0 before the first
+	RootHash []byte `json:"root_hash"`
+	// Cumulative is set once an epoch's root covered the whole directory.
+	// Epochs signed before trees were cumulative commit only to their own
+	// updates; none may follow a cumulative one.
+	Cumulative bool `json:"cumulative"`
+	// Leaves is the rebuilt directory: leaf hash by hex tree index
+	Leaves map[string][]byte `json:"leaves"`
+	// SaltEpoch is the last epoch whose salt was checked or had none to check
+	SaltEpoch int64     `json:"salt_epoch"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// loadState reads the state file, or starts fresh if there is none
+func loadState(path, server string) (*auditorState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &auditorState{Server: server, Leaves: map[string][]byte{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	var state auditorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+	if state.Server != server {
+		return nil, fmt.Errorf("state file %s belongs to %s, not %s", path, state.Server, server)
+	}
+	if state.Leaves == nil {
+		state.Leaves = map[string][]byte{}
+	}
+	return &state, nil
+}
+
+// save writes the state file atomically
+func (st *auditorState) save(path string) error {
+	st.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace state: %w", err)
+	}
+	return nil
+}
+
+// auditor checks a server's epochs against its saved state
+type auditor struct {
+	client    *client
+	server    string
+	statePath string
+	state     *auditorState
+
+	// If set, the auditor also acts as a witness and cosigns each head it verifies
+	witnessKey ed25519.PrivateKey
+	verified   *transparency.SignedTreeHead
+}
+
+// newAlert builds an alert for the audited server
+func (a *auditor) newAlert(kind string, epoch int64, format string, args ...interface{}) *alert {
+	return &alert{
+		Kind:       kind,
+		Server:     a.server,
+		Epoch:      epoch,
+		Detail:     fmt.Sprintf(format, args...),
+		DetectedAt: time.Now().UTC(),
+	}
+}
+
+// run audits every epoch published since the last run. It returns an *alert
+// on misbehaviour and a plain error if the server could not be audited.
+func (a *auditor) run(ctx context.Context) error {
+	latest, err := a.client.treeHead(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if latest == nil {
+		log.Printf("[Auditor] No epochs published yet")
+		return nil
+	}
+
+	if latest.EpochNumber < a.state.Epoch {
+		return a.newAlert(alertRollback, latest.EpochNumber,
+			"latest epoch is %d but epoch %d was already verified", latest.EpochNumber, a.state.Epoch)
+	}
+
+	// The last verified epoch must still have the root we verified
+	if a.state.Epoch > 0 {
+		sth, err := a.client.treeHead(ctx, a.state.Epoch)
+		if err != nil {
+			return err
+		}
+		if sth == nil {
+			return a.newAlert(alertMissingEpoch, a.state.Epoch, "verified epoch is no longer served")
+		}
+		if !bytes.Equal(sth.RootHash, a.state.RootHash) {
+			return a.newAlert(alertEquivocation, a.state.Epoch, "root is now %x, verified %x", sth.RootHash, a.state.RootHash)
+		}
+	}
+
+	if latest.EpochNumber == a.state.Epoch {
+		return a.checkSalts(ctx)
+	}
+
+	keys, err := a.client.signingKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for epoch := a.state.Epoch + 1; epoch <= latest.EpochNumber; epoch++ {
+		if err := a.auditEpoch(ctx, epoch, latest, keys); err != nil {
+			return err
+		}
+		if err := a.state.save(a.statePath); err != nil {
+			return err
+		}
+	}
+	log.Printf("[Auditor] Verified through epoch %d (%d leaves), root: %s",
+		a.state.Epoch, len(a.state.Leaves), hex.EncodeToString(a.state.RootHash[:8]))
+
+	if err := a.checkSalts(ctx); err != nil {
+		return err
+	}
+
+	if a.witnessKey != nil {
+		err := a.client.cosign(ctx, a.witnessKey, a.verified)
+		if errors.Is(err, errForkRejected) {
+			return a.newAlert(alertEquivocation, a.verified.EpochNumber, "%v", err)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("[Auditor] Cosigned epoch %d", a.verified.EpochNumber)
+	}
+	return nil
+}
+
+// auditEpoch verifies one epoch and advances the state to it
+func (a *auditor) auditEpoch(ctx context.Context, epoch int64, latest *transparency.SignedTreeHead, keys map[string]*transparency.SigningKey) error {
+	sth, err := a.client.treeHead(ctx, epoch)
+	if err != nil {
+		return err
+	}
+	if sth == nil {
+		return a.newAlert(alertMissingEpoch, epoch, "epoch missing below latest epoch %d", latest.EpochNumber)
+	}
+	if sth.EpochNumber != epoch {
+		return a.newAlert(alertEquivocation, epoch, "server returned epoch %d", sth.EpochNumber)
+	}
+
+	// Signature, through the key that claims to have signed it
+	key := keys[sth.SigningKeyFingerprint]
+	if key == nil {
+		return a.newAlert(alertBadSignature, epoch, "unknown signing key %s", sth.SigningKeyFingerprint)
+	}
+	if err := transparency.VerifyTreeHeadWithKey(key, sth); err != nil {
+		return a.newAlert(alertBadSignature, epoch, "%v", err)
+	}
+
+	if epoch == latest.EpochNumber && !bytes.Equal(sth.RootHash, latest.RootHash) {
+		return a.newAlert(alertEquivocation, epoch, "served roots %x and %x", latest.RootHash, sth.RootHash)
+	}
+
+	// Consistency with the epoch before
+	if a.state.Epoch > 0 {
+		proof, err := a.client.consistencyProof(ctx, a.state.Epoch, epoch)
+		if err != nil {
+			return err
+		}
+		if !transparency.VerifyConsistencyProof(proof) {
+			return a.newAlert(alertInconsistent, epoch, "consistency proof from epoch %d does not verify", a.state.Epoch)
+		}
+		if !bytes.Equal(proof.FromRoot, a.state.RootHash) {
+			return a.newAlert(alertEquivocation, a.state.Epoch, "consistency proof starts from root %x, verified %x", proof.FromRoot, a.state.RootHash)
+		}
+		if !bytes.Equal(proof.ToRoot, sth.RootHash) {
+			return a.newAlert(alertEquivocation, epoch, "consistency proof ends at root %x, signed %x", proof.ToRoot, sth.RootHash)
+		}
+	}
+
+	// Rebuild the tree from the audit log
+	entries, err := a.client.auditLog(ctx, epoch)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if len(entry.LeafIndex) != transparency.HashSize {
+			return fmt.Errorf("audit log entry %d of epoch %d has no tree index; set -auditor-token, or the server predates rebuildable audit logs", entry.Seq, epoch)
+		}
+	}
+	epochLeaves := map[string][]byte{}
+	for _, entry := range entries {
+		applyEntry(a.state.Leaves, entry)
+		applyEntry(epochLeaves, entry)
+	}
+
+	root := treeRoot(a.state.Leaves)
+	epochRoot := treeRoot(epochLeaves)
+	switch {
+	case bytes.Equal(root, sth.RootHash):
+		if !bytes.Equal(epochRoot, root) {
+			a.state.Cumulative = true
+		}
+	case !a.state.Cumulative && bytes.Equal(epochRoot, sth.RootHash):
+		// Pre-cumulative epoch: its tree holds only its own updates
+	default:
+		return a.newAlert(alertRootMismatch, epoch, "audit log rebuilds root %x, signed %x", root, sth.RootHash)
+	}
+
+	a.state.Epoch = epoch
+	a.state.RootHash = sth.RootHash
+	a.verified = sth
+	return nil
+}
+
+// checkSalts checks the salts revealed since the last run against the
+// commitments signed in their verified tree heads. It stops at the first
+// epoch whose salt is not revealed yet.
+func (a *auditor) checkSalts(ctx context.Context) error {
+	checked := a.state.SaltEpoch
+	var keys map[string]*transparency.SigningKey
+	for epoch := a.state.SaltEpoch + 1; epoch <= a.state.Epoch; epoch++ {
+		salt, err := a.client.epochSalt(ctx, epoch)
+		if errors.Is(err, errForbidden) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if salt != nil {
+			sth, err := a.client.treeHead(ctx, epoch)
+			if err != nil {
+				return err
+			}
+			if sth == nil {
+				return a.newAlert(alertMissingEpoch, epoch, "verified epoch is no longer served")
+			}
+			if len(sth.SaltCommitment) == 0 {
+				return a.newAlert(alertSaltMismatch, epoch, "salt revealed for a tree head without a salt commitment")
+			}
+			// The commitment must be the one signed in the head verified earlier
+			if keys == nil {
+				if keys, err = a.client.signingKeys(ctx); err != nil {
+					return err
+				}
+			}
+			key := keys[sth.SigningKeyFingerprint]
+			if key == nil {
+				return a.newAlert(alertBadSignature, epoch, "unknown signing key %s", sth.SigningKeyFingerprint)
+			}
+			if err := transparency.VerifyTreeHeadWithKey(key, sth); err != nil {
+				return a.newAlert(alertBadSignature, epoch, "%v", err)
+			}
+			if err := transparency.VerifyEpochSalt(sth, salt); err != nil {
+				return a.newAlert(alertSaltMismatch, epoch, "%v", err)
+			}
+		}
+		a.state.SaltEpoch = epoch
+	}
+
+	if a.state.SaltEpoch == checked {
+		return nil
+	}
+	log.Printf("[Auditor] Checked epoch salts through epoch %d", a.state.SaltEpoch)
+	return a.state.save(a.statePath)
+}
+
+// applyEntry applies an audit log entry to a set of leaves
+func applyEntry(leaves map[string][]byte, entry auditLogEntryResponse) {
+	if len(entry.OldLeafIndex) > 0 {
+		delete(leaves, hex.EncodeToString(entry.OldLeafIndex))
+	}
+	index := hex.EncodeToString(entry.LeafIndex)
+	if len(entry.NewLeafHash) == 0 {
+		delete(leaves, index)
+		return
+	}
+	leaves[index] = entry.NewLeafHash
+}
+
+// treeRoot computes the sparse tree root of a set of leaves
+func treeRoot(leaves map[string][]byte) []byte {
+	byIndex := make(map[[transparency.HashSize]byte][]byte, len(leaves))
+	for indexHex, hash := range leaves {
+		var index [transparency.HashSize]byte
+		decoded, err := hex.DecodeString(indexHex)
+		if err != nil || len(decoded) != transparency.HashSize {
+			continue
+		}
+		copy(index[:], decoded)
+		byIndex[index] = hash
+	}
+	return transparency.ComputeTreeRoot(byIndex)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kindlyrobotics/nochat/internal/transparency"
+)
+
+// errNotFound is returned for a 404 from the server
+var errNotFound = errors.New("not found")
+
+// errForbidden is returned for a 403 from the server
+var errForbidden = errors.New("forbidden")
+
+// auditLogPageSize is the largest page /api/transparency/audit-log serves
+const auditLogPageSize = 1000
+
+// client reads the public transparency endpoints of a nochat server
+type client struct {
+	baseURL    string
+	httpClient *http.Client
+	// auditorToken is sent as X-Auditor-Token so the audit log includes tree indexes
+	auditorToken string
+}
+
+func newClient(baseURL, auditorToken string, timeout time.Duration) *client {
+	return &client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		httpClient:   &http.Client{Timeout: timeout},
+		auditorToken: auditorToken,
+	}
+}
+
+// Response bodies (byte fields are base64)
+
+type treeHeadResponse struct {
+	EpochNumber           int64     `json:"epoch_number"`
+	RootHash              []byte    `json:"root_hash"`
+	TreeSize              int64     `json:"tree_size"`
+	Signature             []byte    `json:"signature"`
+	SigningKeyFingerprint string    `json:"signing_key_fingerprint"`
+	Timestamp             time.Time `json:"timestamp"`
+	SaltCommitment        []byte    `json:"salt_commitment"`
+	Version               int       `json:"version"`
+}
+
+type signingKeyResponse struct {
+	Fingerprint string     `json:"fingerprint"`
+	PublicKey   []byte     `json:"public_key"`
+	Algorithm   string     `json:"algorithm"`
+	Status      string     `json:"status"`
+	ValidFrom   time.Time  `json:"valid_from"`
+	ValidUntil  *time.Time `json:"valid_until"`
+}
+
+type consistencyProofResponse struct {
+	FromEpoch   int64    `json:"from_epoch"`
+	ToEpoch     int64    `json:"to_epoch"`
+	FromRoot    []byte   `json:"from_root"`
+	ToRoot      []byte   `json:"to_root"`
+	ProofHashes [][]byte `json:"proof_hashes"`
+}
+
+type auditLogEntryResponse struct {
+	EpochNumber  int64  `json:"epoch_number"`
+	ChangeType   string `json:"change_type"`
+	OldLeafHash  []byte `json:"old_leaf_hash"`
+	NewLeafHash  []byte `json:"new_leaf_hash"`
+	LeafIndex    []byte `json:"leaf_index"`
+	OldLeafIndex []byte `json:"old_leaf_index"`
+	Seq          int64  `json:"seq"`
+}
+
+// get fetches a JSON endpoint into out
+func (c *client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.auditorToken != "" {
+		req.Header.Set("X-Auditor-Token", c.auditorToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return errForbidden
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to fetch %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// treeHead fetches the signed tree head of an epoch, or the latest if epoch is 0.
+// It returns nil if the server has no such epoch.
+func (c *client) treeHead(ctx context.Context, epoch int64) (*transparency.SignedTreeHead, error) {
+	query := url.Values{}
+	if epoch > 0 {
+		query.Set("epoch", strconv.FormatInt(epoch, 10))
+	}
+
+	var body treeHeadResponse
+	if err := c.get(ctx, "/api/transparency/root", query, &body); errors.Is(err, errNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &transparency.SignedTreeHead{
+		EpochNumber:           body.EpochNumber,
+		RootHash:              body.RootHash,
+		TreeSize:              body.TreeSize,
+		Signature:             body.Signature,
+		SigningKeyFingerprint: body.SigningKeyFingerprint,
+		Timestamp:             body.Timestamp,
+		SaltCommitment:        body.SaltCommitment,
+		Version:               body.Version,
+	}, nil
+}
+
+// signingKeys fetches every published signing key, by fingerprint
+func (c *client) signingKeys(ctx context.Context) (map[string]*transparency.SigningKey, error) {
+	var body struct {
+		SigningKeys []signingKeyResponse `json:"signing_keys"`
+	}
+	if err := c.get(ctx, "/api/transparency/signing-keys", nil, &body); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*transparency.SigningKey, len(body.SigningKeys))
+	for _, k := range body.SigningKeys {
+		keys[k.Fingerprint] = &transparency.SigningKey{
+			Fingerprint: k.Fingerprint,
+			PublicKey:   k.PublicKey,
+			Algorithm:   k.Algorithm,
+			Status:      k.Status,
+			ValidFrom:   k.ValidFrom,
+			ValidUntil:  k.ValidUntil,
+		}
+	}
+	return keys, nil
+}
+
+// consistencyProof fetches the consistency proof between two epochs
+func (c *client) consistencyProof(ctx context.Context, fromEpoch, toEpoch int64) (*transparency.ConsistencyProof, error) {
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(fromEpoch, 10))
+	query.Set("to", strconv.FormatInt(toEpoch, 10))
+
+	var body consistencyProofResponse
+	if err := c.get(ctx, "/api/transparency/consistency", query, &body); err != nil {
+		return nil, err
+	}
+
+	return &transparency.ConsistencyProof{
+		FromEpoch:   body.FromEpoch,
+		ToEpoch:     body.ToEpoch,
+		FromRoot:    body.FromRoot,
+		ToRoot:      body.ToRoot,
+		ProofHashes: body.ProofHashes,
+	}, nil
+}
+
+// auditLog fetches every audit log entry of an epoch, in the order applied
+func (c *client) auditLog(ctx context.Context, epoch int64) ([]auditLogEntryResponse, error) {
+	var entries []auditLogEntryResponse
+	var afterSeq int64
+	for {
+		query := url.Values{}
+		query.Set("from_epoch", strconv.FormatInt(epoch, 10))
+		query.Set("after_seq", strconv.FormatInt(afterSeq, 10))
+		query.Set("limit", strconv.Itoa(auditLogPageSize))
+
+		var body struct {
+			Entries []auditLogEntryResponse `json:"entries"`
+		}
+		if err := c.get(ctx, "/api/transparency/audit-log", query, &body); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range body.Entries {
+			if entry.EpochNumber != epoch {
+				return entries, nil
+			}
+			entries = append(entries, entry)
+			afterSeq = entry.Seq
+		}
+		if len(body.Entries) < auditLogPageSize {
+			return entries, nil
+		}
+	}
+}
+
+// epochSalt fetches an epoch's revealed salt. It returns nil if the epoch
+// commits to no salt and errForbidden if the salt is not revealed yet.
+func (c *client) epochSalt(ctx context.Context, epoch int64) ([]byte, error) {
+	query := url.Values{}
+	query.Set("epoch", strconv.FormatInt(epoch, 10))
+
+	var body struct {
+		Salt []byte `json:"salt"`
+	}
+	if err := c.get(ctx, "/api/transparency/epoch-salt", query, &body); errors.Is(err, errNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return body.Salt, nil
+}
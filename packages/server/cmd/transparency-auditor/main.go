@@ -0,0 +1,158 @@
+// Command transparency-auditor independently audits a nochat key transparency
+// server. It follows the signed tree heads at /api/transparency/root, checks
+// each epoch's signature and its consistency with the epoch before, and
+// replays /api/transparency/audit-log to rebuild the sparse tree and compare
+// its root with the signed one. The audit log only carries the tree indexes
+// needed for this with -auditor-token set. Once an epoch's salt is revealed at
+// /api/transparency/epoch-salt it is checked against the signed commitment.
+//
+// Misbehaviour (equivocation, a root the audit log does not rebuild, a bad
+// signature) raises an alert: it is logged, optionally POSTed as JSON to a
+// webhook and appended to a file, and the auditor exits with status 2.
+// Progress is kept in a local state file so a restart resumes where it left
+// off. With -interval 0 the auditor checks once and exits, for use from cron.
+//
+// With -witness-key the auditor also acts as a witness: after verifying new
+// epochs it cosigns the latest one at /api/transparency/witness/cosign.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Exit statuses
+const (
+	exitOK    = 0
+	exitError = 1 // the server could not be audited
+	exitAlert = 2 // the server misbehaved
+)
+
+func main() {
+	server := flag.String("server", os.Getenv("NOCHAT_SERVER_URL"), "Base URL of the nochat server")
+	statePath := flag.String("state", "transparency-auditor.json", "File holding the auditor's verified state")
+	interval := flag.Duration("interval", 0, "Time between checks; 0 checks once and exits")
+	webhook := flag.String("webhook", "", "URL to POST alerts to as JSON")
+	alertFile := flag.String("alert-file", "", "File to append alerts to, one JSON object per line")
+	timeout := flag.Duration("timeout", 30*time.Second, "HTTP request timeout")
+	witnessKeyPath := flag.String("witness-key", "", "PEM Ed25519 key to cosign verified tree heads with, as a registered witness")
+	auditorToken := flag.String("auditor-token", os.Getenv("NOCHAT_AUDITOR_TOKEN"), "Token from the server's TRANSPARENCY_AUDITOR_TOKENS, needed to read the audit log's tree indexes")
+	flag.Parse()
+
+	if *server == "" {
+		log.Fatalf("-server (or NOCHAT_SERVER_URL) is required")
+	}
+
+	state, err := loadState(*statePath, *server)
+	if err != nil {
+		log.Fatalf("Failed to load state: %v", err)
+	}
+	a := &auditor{
+		client:    newClient(*server, *auditorToken, *timeout),
+		server:    *server,
+		statePath: *statePath,
+		state:     state,
+	}
+	if *witnessKeyPath != "" {
+		if a.witnessKey, err = loadWitnessKey(*witnessKeyPath); err != nil {
+			log.Fatalf("Failed to load witness key: %v", err)
+		}
+	}
+	notifier := &notifier{webhook: *webhook, file: *alertFile, httpClient: &http.Client{Timeout: *timeout}}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("[Auditor] Auditing %s from epoch %d", *server, state.Epoch)
+	for {
+		err := a.run(ctx)
+
+		var misbehaviour *alert
+		switch {
+		case errors.As(err, &misbehaviour):
+			notifier.notify(misbehaviour)
+			os.Exit(exitAlert)
+		case err != nil && *interval == 0:
+			log.Printf("[Auditor] Audit failed: %v", err)
+			os.Exit(exitError)
+		case err != nil:
+			log.Printf("[Auditor] Audit failed, retrying in %s: %v", *interval, err)
+		}
+
+		if *interval == 0 {
+			os.Exit(exitOK)
+		}
+		select {
+		case <-ctx.Done():
+			os.Exit(exitOK)
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// notifier delivers alerts
+type notifier struct {
+	webhook    string
+	file       string
+	httpClient *http.Client
+}
+
+// notify logs an alert and delivers it to the webhook and alert file. Delivery
+// failures are logged; the exit status still reports the alert.
+func (n *notifier) notify(a *alert) {
+	log.Printf("[Auditor] ALERT %s", a.Error())
+
+	body, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("[Auditor] Failed to encode alert: %v", err)
+		return
+	}
+
+	if n.file != "" {
+		if err := appendLine(n.file, body); err != nil {
+			log.Printf("[Auditor] Failed to write alert file: %v", err)
+		}
+	}
+
+	if n.webhook != "" {
+		if err := n.post(body); err != nil {
+			log.Printf("[Auditor] Failed to deliver alert webhook: %v", err)
+		}
+	}
+}
+
+// post sends an alert to the webhook
+func (n *notifier) post(body []byte) error {
+	resp, err := n.httpClient.Post(n.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// appendLine appends one line to a file, creating it if needed
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kindlyrobotics/nochat/internal/transparency"
+)
+
+// errForkRejected is returned when the server reports a verified head as a fork
+var errForkRejected = errors.New("server reports the verified head conflicts with its published head")
+
+// loadWitnessKey reads a PEM-encoded Ed25519 private key
+func loadWitnessKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read witness key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("witness key is not PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse witness key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("witness key must be Ed25519")
+	}
+	return edKey, nil
+}
+
+// cosign submits a cosignature over a tree head the auditor has verified.
+// The server answers 409 if the head conflicts with the one it publishes.
+func (c *client) cosign(ctx context.Context, key ed25519.PrivateKey, sth *transparency.SignedTreeHead) error {
+	cosignedAt := time.Unix(time.Now().Unix(), 0)
+	body, err := json.Marshal(map[string]interface{}{
+		"tree_head":           sth.ToResponse(),
+		"witness_fingerprint": transparency.WitnessFingerprint(key.Public().(ed25519.PublicKey)),
+		"cosigned_at":         cosignedAt.UTC().Format(time.RFC3339),
+		"signature":           base64.StdEncoding.EncodeToString(transparency.Cosign(key, sth, cosignedAt)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode cosignature: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/transparency/witness/cosign", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to submit cosignature: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %s", errForkRejected, strings.TrimSpace(string(respBody)))
+	default:
+		return fmt.Errorf("failed to submit cosignature: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+}
@@ -1,9 +1,10 @@
 module github.com/kindlyrobotics/nochat
 
-go 1.20
+go 1.22.0
 
 require (
-	github.com/cloudflare/circl v1.3.7
+	filippo.io/edwards25519 v1.1.0
+	github.com/cloudflare/circl v1.6.1
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.1
@@ -0,0 +1,174 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/cloudflare/circl/kem"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem1024"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem768"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+)
+
+/*
+FIPS 203 / FIPS 204:
+The Kyber1024 and Dilithium3 primitives in pqc.go are the round-3 submissions.
+The final standards, ML-KEM (FIPS 203) and ML-DSA (FIPS 204), changed key
+derivation, hashing and encodings, so they are not wire-compatible even where
+sizes match: Kyber1024 and ML-KEM-1024 public keys are both 1568 bytes, and
+Dilithium3 and ML-DSA-65 public keys are both 1952 bytes.
+
+Keys are therefore never identified by length alone once ML-KEM/ML-DSA are in
+play; see keytypes.go for the explicit key type tags.
+
+ML-DSA signatures use an empty context string.
+*/
+
+// ML-KEM / ML-DSA sizes
+const (
+	MLKEM768PublicKeySize   = mlkem768.PublicKeySize   // 1184 bytes
+	MLKEM768PrivateKeySize  = mlkem768.PrivateKeySize  // 2400 bytes
+	MLKEM768CiphertextSize  = mlkem768.CiphertextSize  // 1088 bytes
+	MLKEM1024PublicKeySize  = mlkem1024.PublicKeySize  // 1568 bytes
+	MLKEM1024PrivateKeySize = mlkem1024.PrivateKeySize // 3168 bytes
+	MLKEM1024CiphertextSize = mlkem1024.CiphertextSize // 1568 bytes
+	MLKEMSharedKeySize      = 32
+
+	MLDSA65PublicKeySize  = mldsa65.PublicKeySize  // 1952 bytes
+	MLDSA65PrivateKeySize = mldsa65.PrivateKeySize // 4032 bytes
+	MLDSA65SignatureSize  = mldsa65.SignatureSize  // 3309 bytes
+)
+
+// mlkemScheme returns the circl scheme for an ML-KEM key type
+func mlkemScheme(keyType KeyType) (kem.Scheme, error) {
+	switch keyType {
+	case KeyTypeMLKEM768:
+		return mlkem768.Scheme(), nil
+	case KeyTypeMLKEM1024:
+		return mlkem1024.Scheme(), nil
+	default:
+		return nil, fmt.Errorf("not an ML-KEM key type: %s", keyType)
+	}
+}
+
+// GenerateMLKEMKeyPair generates an ML-KEM-768 or ML-KEM-1024 key pair
+func GenerateMLKEMKeyPair(keyType KeyType) (*KyberKeyPair, error) {
+	scheme, err := mlkemScheme(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, privateKey, err := scheme.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key pair: %w", keyType, err)
+	}
+
+	pubBytes, err := publicKey.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s public key: %w", keyType, err)
+	}
+	privBytes, err := privateKey.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s private key: %w", keyType, err)
+	}
+
+	return &KyberKeyPair{
+		PublicKey:  pubBytes,
+		PrivateKey: privBytes,
+	}, nil
+}
+
+// EncapsulateMLKEM performs ML-KEM encapsulation to a public key
+func EncapsulateMLKEM(keyType KeyType, publicKeyBytes []byte) (*EncapsulationResult, error) {
+	scheme, err := mlkemScheme(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := scheme.UnmarshalBinaryPublicKey(publicKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s public key: %w", keyType, err)
+	}
+
+	ciphertext, sharedKey, err := scheme.Encapsulate(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encapsulate: %w", err)
+	}
+
+	return &EncapsulationResult{
+		Ciphertext: ciphertext,
+		SharedKey:  sharedKey,
+	}, nil
+}
+
+// DecapsulateMLKEM recovers the shared secret from an ML-KEM ciphertext
+func DecapsulateMLKEM(keyType KeyType, privateKeyBytes, ciphertext []byte) ([]byte, error) {
+	scheme, err := mlkemScheme(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := scheme.UnmarshalBinaryPrivateKey(privateKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s private key: %w", keyType, err)
+	}
+	if len(ciphertext) != scheme.CiphertextSize() {
+		return nil, fmt.Errorf("invalid ciphertext size: expected %d, got %d", scheme.CiphertextSize(), len(ciphertext))
+	}
+
+	sharedKey, err := scheme.Decapsulate(privateKey, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decapsulate: %w", err)
+	}
+
+	return sharedKey, nil
+}
+
+// GenerateMLDSA65KeyPair generates an ML-DSA-65 key pair
+func GenerateMLDSA65KeyPair() (*DilithiumKeyPair, error) {
+	publicKey, privateKey, err := mldsa65.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ML-DSA-65 key pair: %w", err)
+	}
+
+	return &DilithiumKeyPair{
+		PublicKey:  publicKey.Bytes(),
+		PrivateKey: privateKey.Bytes(),
+	}, nil
+}
+
+// SignMLDSA65 creates a hedged ML-DSA-65 signature over a message
+func SignMLDSA65(privateKeyBytes, message []byte) ([]byte, error) {
+	if len(privateKeyBytes) != MLDSA65PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", MLDSA65PrivateKeySize, len(privateKeyBytes))
+	}
+
+	var privateKey mldsa65.PrivateKey
+	if err := privateKey.UnmarshalBinary(privateKeyBytes); err != nil {
+		return nil, fmt.Errorf("invalid ML-DSA-65 private key: %w", err)
+	}
+
+	signature := make([]byte, MLDSA65SignatureSize)
+	if err := mldsa65.SignTo(&privateKey, message, nil, true, signature); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return signature, nil
+}
+
+// VerifyMLDSA65 verifies an ML-DSA-65 signature over a message
+func VerifyMLDSA65(publicKeyBytes, message, signature []byte) (bool, error) {
+	if len(publicKeyBytes) != MLDSA65PublicKeySize {
+		return false, fmt.Errorf("invalid public key size: expected %d, got %d", MLDSA65PublicKeySize, len(publicKeyBytes))
+	}
+	if len(signature) != MLDSA65SignatureSize {
+		return false, fmt.Errorf("invalid signature size: expected %d, got %d", MLDSA65SignatureSize, len(signature))
+	}
+
+	var publicKey mldsa65.PublicKey
+	if err := publicKey.UnmarshalBinary(publicKeyBytes); err != nil {
+		return false, fmt.Errorf("invalid ML-DSA-65 public key: %w", err)
+	}
+
+	return mldsa65.Verify(&publicKey, message, nil, signature), nil
+}
@@ -7,14 +7,16 @@ The server operates in a zero-trust model - it stores only public keys and encry
 blobs, never private keys or plaintext content.
 
 KEY TYPES SUPPORTED:
-  - Identity Keys: Long-term keys for user identity (P-256 or Dilithium3)
-  - Signed PreKeys: Medium-term keys for session establishment (P-256, X25519, or Kyber)
+  - Identity Keys: Long-term keys for user identity (P-256, Dilithium3 or ML-DSA-65)
+  - Signed PreKeys: Medium-term keys for session establishment (P-256, X25519, Kyber or ML-KEM)
   - One-Time PreKeys: Single-use keys for forward secrecy
   - Sealed Sender Keys: Keys for metadata protection
 
 BACKWARDS COMPATIBILITY:
 The server accepts both classical (P-256) and post-quantum (Kyber/Dilithium) keys.
-This allows clients to upgrade to PQC at their own pace.
+This allows clients to upgrade to PQC at their own pace. Every key is tagged
+with a KeyType, and companion keys let a user publish ML-KEM/ML-DSA keys next
+to their existing ones while contacts migrate (see keytypes.go).
 
 ZERO-TRUST PROPERTIES:
   - Server stores only public keys
@@ -30,6 +32,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -48,17 +51,41 @@ type TransparencyKeyUpdate struct {
 	SignedPreKeyFingerprint string
 	KeyVersion              int
 	UpdateType              string // "key_added", "key_updated", "key_revoked"
+	IdentityKeyType         string
+	SignedPreKeyType        string // KeyType.Label, e.g. "x25519+kyber1024" for hybrid prekeys
+	CompanionKeys           []TransparencyLeafKey
 }
 
+// TransparencyLeafKey is a companion key committed in the transparency leaf
+type TransparencyLeafKey struct {
+	Role        string // "companion_identity" or "companion_signed_prekey"
+	KeyType     string
+	Fingerprint string
+}
+
+// Companion key roles in transparency leaves
+const (
+	LeafRoleCompanionIdentity     = "companion_identity"
+	LeafRoleCompanionSignedPreKey = "companion_signed_prekey"
+)
+
 // Service provides cryptographic key management operations
 type Service struct {
 	db                  *sql.DB
 	transparencyService TransparencyQueuer
+	keyChangeNotifier   KeyChangeNotifier
+	rotationNotifier    RotationNotifier
+	rotationPolicy      RotationPolicy
+	suites              *SuiteRegistry
 }
 
+// ErrInvalidCompanionKey is returned when a companion key doesn't fit the
+// user's primary keys (missing primary, same type, or bad signature)
+var ErrInvalidCompanionKey = errors.New("invalid companion key")
+
 // NewService creates a new crypto service
 func NewService(db *sql.DB) *Service {
-	return &Service{db: db}
+	return &Service{db: db, rotationPolicy: DefaultRotationPolicy(), suites: NewSuiteRegistry()}
 }
 
 // SetTransparencyService sets the transparency service for queueing key updates
@@ -70,11 +97,15 @@ func (s *Service) SetTransparencyService(ts TransparencyQueuer) {
 type IdentityKey struct {
 	ID             uuid.UUID `json:"id"`
 	UserID         uuid.UUID `json:"user_id"`
+	KeyType        KeyType   `json:"key_type"`
 	PublicKey      []byte    `json:"public_key"`
 	KeyFingerprint string    `json:"key_fingerprint"`
 	KeyVersion     int       `json:"key_version"`
 	Status         string    `json:"status"`
 	CreatedAt      time.Time `json:"created_at"`
+	// Companion keys only: the primary identity key's signature over TaggedPublicKey
+	IsCompanion        bool   `json:"is_companion,omitempty"`
+	CompanionSignature []byte `json:"companion_signature,omitempty"`
 }
 
 // SignedPreKey represents a medium-term signed prekey
@@ -82,6 +113,7 @@ type SignedPreKey struct {
 	ID             uuid.UUID  `json:"id"`
 	UserID         uuid.UUID  `json:"user_id"`
 	KeyID          int        `json:"key_id"`
+	KeyType        KeyType    `json:"key_type"`
 	KyberPublicKey []byte     `json:"kyber_public_key"`
 	Signature      []byte     `json:"signature"`
 	KeyFingerprint string     `json:"key_fingerprint"`
@@ -95,6 +127,7 @@ type OneTimePreKey struct {
 	ID             uuid.UUID  `json:"id"`
 	UserID         uuid.UUID  `json:"user_id"`
 	KeyID          int        `json:"key_id"`
+	KeyType        KeyType    `json:"key_type"`
 	KyberPublicKey []byte     `json:"kyber_public_key"`
 	Status         string     `json:"status"`
 	UsedBy         *uuid.UUID `json:"used_by,omitempty"`
@@ -110,6 +143,18 @@ type PreKeyBundle struct {
 	OneTimePreKey        *OneTimePreKey `json:"one_time_prekey,omitempty"`
 	BundleVersion        int            `json:"bundle_version"`
 	GeneratedAt          time.Time      `json:"generated_at"`
+	// Set when the signed prekey is past the rotation policy's max age / hard limit
+	SignedPreKeyRotationDue bool `json:"signed_prekey_rotation_due,omitempty"`
+	SignedPreKeyStale       bool `json:"signed_prekey_stale,omitempty"`
+	// Set in place of OneTimePreKey when the one-time pool is empty
+	LastResortPreKey     *LastResortPreKey `json:"last_resort_prekey,omitempty"`
+	LastResortPreKeyUsed bool              `json:"last_resort_prekey_used"`
+	// Cipher suites the user advertises, most preferred first
+	SupportedSuites []SuiteID `json:"supported_suites,omitempty"`
+	// Keys of the type the user is migrating to, published alongside the primary keys
+	CompanionIdentityKey   *IdentityKey         `json:"companion_identity_key,omitempty"`
+	CompanionSignedPreKey  *HybridSignedPreKey  `json:"companion_signed_prekey,omitempty"`
+	CompanionOneTimePreKey *HybridOneTimePreKey `json:"companion_one_time_prekey,omitempty"`
 }
 
 // HybridSignedPreKey represents a hybrid signed prekey (X25519 + Kyber) for PQXDH
@@ -117,14 +162,16 @@ type HybridSignedPreKey struct {
 	ID             uuid.UUID  `json:"id"`
 	UserID         uuid.UUID  `json:"user_id"`
 	KeyID          int        `json:"key_id"`
+	KeyType        KeyType    `json:"key_type"`         // PQ component type
 	ECPublicKey    []byte     `json:"ec_public_key"`    // X25519 (32 bytes)
 	PQPublicKey    []byte     `json:"pq_public_key"`    // Kyber1024 (1568 bytes)
-	Signature      []byte     `json:"signature"`        // Signs EC||PQ concatenation
+	Signature      []byte     `json:"signature"`        // Signs PreKeySignedData (EC||PQ for Kyber)
 	KeyFingerprint string     `json:"key_fingerprint"`
 	HybridVersion  int        `json:"hybrid_version"`   // 2 for PQXDH
 	Status         string     `json:"status"`
 	CreatedAt      time.Time  `json:"created_at"`
 	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	IsCompanion    bool       `json:"is_companion,omitempty"`
 }
 
 // HybridOneTimePreKey represents a hybrid one-time prekey (X25519 + Kyber) for PQXDH
@@ -132,6 +179,7 @@ type HybridOneTimePreKey struct {
 	ID           uuid.UUID  `json:"id"`
 	UserID       uuid.UUID  `json:"user_id"`
 	KeyID        int        `json:"key_id"`
+	KeyType      KeyType    `json:"key_type"`        // PQ component type
 	ECPublicKey  []byte     `json:"ec_public_key"`   // X25519 (32 bytes)
 	PQPublicKey  []byte     `json:"pq_public_key"`   // Kyber1024 (1568 bytes)
 	HybridVersion int       `json:"hybrid_version"`  // 2 for PQXDH
@@ -149,32 +197,69 @@ type HybridPreKeyBundle struct {
 	OneTimePreKey      *HybridOneTimePreKey `json:"one_time_prekey,omitempty"`
 	BundleVersion      int                  `json:"bundle_version"`  // 2 for PQXDH
 	GeneratedAt        time.Time            `json:"generated_at"`
+	// Set when the signed prekey is past the rotation policy's max age / hard limit
+	SignedPreKeyRotationDue bool `json:"signed_prekey_rotation_due,omitempty"`
+	SignedPreKeyStale       bool `json:"signed_prekey_stale,omitempty"`
+	// Set in place of OneTimePreKey when the one-time pool is empty
+	LastResortPreKey     *LastResortPreKey `json:"last_resort_prekey,omitempty"`
+	LastResortPreKeyUsed bool              `json:"last_resort_prekey_used"`
+	// Cipher suites the user advertises, most preferred first
+	SupportedSuites []SuiteID `json:"supported_suites,omitempty"`
+	// Keys of the type the user is migrating to, published alongside the primary keys
+	CompanionIdentityKey   *IdentityKey         `json:"companion_identity_key,omitempty"`
+	CompanionSignedPreKey  *HybridSignedPreKey  `json:"companion_signed_prekey,omitempty"`
+	CompanionOneTimePreKey *HybridOneTimePreKey `json:"companion_one_time_prekey,omitempty"`
 }
 
-// StoreIdentityKey stores a new identity key for a user
-// Accepts both P-256 (Web Crypto API) and Dilithium3 (PQC) keys
-func (s *Service) StoreIdentityKey(ctx context.Context, userID uuid.UUID, publicKey []byte) (*IdentityKey, error) {
-	if !IsValidIdentityKeySize(publicKey) {
-		return nil, fmt.Errorf("invalid public key size: got %d, expected %d (P-256) or %d (Dilithium3)",
-			len(publicKey), P256PublicKeySize, Dilithium3PublicKeySize)
+// StoreIdentityKey stores a new primary identity key for a user.
+// Accepts P-256 (Web Crypto API), Dilithium3 and ML-DSA-65 keys; an empty
+// keyType infers a legacy type from the key length.
+func (s *Service) StoreIdentityKey(ctx context.Context, userID uuid.UUID, keyType KeyType, publicKey []byte) (*IdentityKey, error) {
+	if keyType == "" {
+		inferred, err := InferLegacyKeyType(publicKey, true)
+		if err != nil {
+			return nil, err
+		}
+		keyType = inferred
+	}
+	if err := keyType.ValidateIdentityKey(publicKey); err != nil {
+		return nil, err
+	}
+	if err := s.suites.CheckSignatureKey(keyType); err != nil {
+		return nil, err
 	}
 
-	fingerprint := KeyFingerprint(publicKey)
+	fingerprint := TypedKeyFingerprint(keyType, publicKey, false)
 
-	// First, mark any existing active keys as rotated
-	_, err := s.db.ExecContext(ctx, `
+	// First, mark any existing active primary key as rotated
+	var oldFingerprint string
+	err := s.db.QueryRowContext(ctx, `
 		UPDATE identity_keys
 		SET status = 'rotated', rotated_at = NOW()
-		WHERE user_id = $1 AND status = 'active'
-	`, userID)
-	if err != nil {
+		WHERE user_id = $1 AND status = 'active' AND is_companion = FALSE
+		RETURNING key_fingerprint
+	`, userID).Scan(&oldFingerprint)
+	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to rotate existing identity keys: %w", err)
 	}
 
+	// Companions are signed by the primary, so a new primary retires them.
+	// This also ends a transition when the companion is promoted.
+	if oldFingerprint != fingerprint {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE identity_keys
+			SET status = 'rotated', rotated_at = NOW()
+			WHERE user_id = $1 AND status = 'active' AND is_companion = TRUE
+		`, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rotate companion identity key: %w", err)
+		}
+	}
+
 	// Get the next version number
 	var version int
 	err = s.db.QueryRowContext(ctx, `
-		SELECT COALESCE(MAX(key_version), 0) + 1 FROM identity_keys WHERE user_id = $1
+		SELECT COALESCE(MAX(key_version), 0) + 1 FROM identity_keys WHERE user_id = $1 AND is_companion = FALSE
 	`, userID).Scan(&version)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get next version: %w", err)
@@ -184,6 +269,7 @@ func (s *Service) StoreIdentityKey(ctx context.Context, userID uuid.UUID, public
 	key := &IdentityKey{
 		ID:             uuid.New(),
 		UserID:         userID,
+		KeyType:        keyType,
 		PublicKey:      publicKey,
 		KeyFingerprint: fingerprint,
 		KeyVersion:     version,
@@ -192,42 +278,53 @@ func (s *Service) StoreIdentityKey(ctx context.Context, userID uuid.UUID, public
 	}
 
 	_, err = s.db.ExecContext(ctx, `
-		INSERT INTO identity_keys (id, user_id, dilithium_public_key, key_fingerprint, key_version, status, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, key.ID, key.UserID, key.PublicKey, key.KeyFingerprint, key.KeyVersion, key.Status, key.CreatedAt)
+		INSERT INTO identity_keys (id, user_id, key_type, dilithium_public_key, key_fingerprint, key_version, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, key.ID, key.UserID, string(key.KeyType), key.PublicKey, key.KeyFingerprint, key.KeyVersion, key.Status, key.CreatedAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to store identity key: %w", err)
 	}
 
 	// Log the key creation
-	s.logKeyRotation(ctx, userID, "identity", "", fingerprint, "initial")
+	if err := s.logKeyRotation(ctx, userID, "identity", "", fingerprint, RotationReasonInitial); err != nil {
+		return nil, err
+	}
 
 	// Queue update to transparency service
-	if s.transparencyService != nil {
-		updateType := "key_added"
-		if version > 1 {
-			updateType = "key_updated"
-		}
-		s.transparencyService.QueueKeyUpdate(TransparencyKeyUpdate{
-			UserID:                 userID,
-			IdentityKeyFingerprint: fingerprint,
-			KeyVersion:             version,
-			UpdateType:             updateType,
-		})
+	updateType := "key_added"
+	if version > 1 {
+		updateType = "key_updated"
 	}
+	s.queueTransparencyUpdate(ctx, userID, updateType)
 
 	return key, nil
 }
 
-// GetIdentityKey retrieves a user's active identity key
-func (s *Service) GetIdentityKey(ctx context.Context, userID uuid.UUID) (*IdentityKey, error) {
+// identityKeyColumns is the column list scanned by scanIdentityKey
+const identityKeyColumns = `id, user_id, key_type, dilithium_public_key, key_fingerprint, key_version,
+	status, created_at, is_companion, companion_signature`
+
+// scanIdentityKey scans a row selected with identityKeyColumns
+func scanIdentityKey(row interface{ Scan(...interface{}) error }) (*IdentityKey, error) {
 	key := &IdentityKey{}
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, user_id, dilithium_public_key, key_fingerprint, key_version, status, created_at
+	var keyType string
+	err := row.Scan(&key.ID, &key.UserID, &keyType, &key.PublicKey, &key.KeyFingerprint, &key.KeyVersion,
+		&key.Status, &key.CreatedAt, &key.IsCompanion, &key.CompanionSignature)
+	if err != nil {
+		return nil, err
+	}
+	key.KeyType = KeyType(keyType)
+	return key, nil
+}
+
+// GetIdentityKey retrieves a user's active primary identity key
+func (s *Service) GetIdentityKey(ctx context.Context, userID uuid.UUID) (*IdentityKey, error) {
+	key, err := scanIdentityKey(s.db.QueryRowContext(ctx, `
+		SELECT `+identityKeyColumns+`
 		FROM identity_keys
-		WHERE user_id = $1 AND status = 'active'
-	`, userID).Scan(&key.ID, &key.UserID, &key.PublicKey, &key.KeyFingerprint, &key.KeyVersion, &key.Status, &key.CreatedAt)
+		WHERE user_id = $1 AND status = 'active' AND is_companion = FALSE
+	`, userID))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -239,28 +336,136 @@ func (s *Service) GetIdentityKey(ctx context.Context, userID uuid.UUID) (*Identi
 	return key, nil
 }
 
-// StoreSignedPreKey stores a signed prekey for a user
-// Accepts both P-256 (Web Crypto API) and Kyber1024 (PQC) keys
-func (s *Service) StoreSignedPreKey(ctx context.Context, userID uuid.UUID, keyID int, publicKey, signature []byte) (*SignedPreKey, error) {
-	if !IsValidPreKeySize(publicKey) {
-		return nil, fmt.Errorf("invalid public key size: got %d, expected %d (P-256) or %d (Kyber1024)",
-			len(publicKey), P256PublicKeySize, Kyber1024PublicKeySize)
+// GetCompanionIdentityKey retrieves a user's active companion identity key
+func (s *Service) GetCompanionIdentityKey(ctx context.Context, userID uuid.UUID) (*IdentityKey, error) {
+	key, err := scanIdentityKey(s.db.QueryRowContext(ctx, `
+		SELECT `+identityKeyColumns+`
+		FROM identity_keys
+		WHERE user_id = $1 AND status = 'active' AND is_companion = TRUE
+	`, userID))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get companion identity key: %w", err)
+	}
+
+	return key, nil
+}
+
+// PublishCompanionIdentityKey publishes an identity key of a new type next to
+// the primary one. The signature is the primary key's signature over
+// TaggedPublicKey(keyType, publicKey); it is verified here, against the
+// primary key current at the time of the call.
+func (s *Service) PublishCompanionIdentityKey(ctx context.Context, userID uuid.UUID, keyType KeyType, publicKey, signature []byte) (*IdentityKey, error) {
+	if err := keyType.ValidateIdentityKey(publicKey); err != nil {
+		return nil, err
+	}
+	if err := s.suites.CheckSignatureKey(keyType); err != nil {
+		return nil, err
+	}
+
+	primary, err := s.GetIdentityKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("%w: no primary identity key", ErrInvalidCompanionKey)
+	}
+	if primary.KeyType == keyType {
+		return nil, fmt.Errorf("%w: companion must differ from the primary key type %s", ErrInvalidCompanionKey, keyType)
+	}
+	if err := primary.KeyType.ValidateSignature(signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCompanionKey, err)
+	}
+	valid, err := VerifyTypedSignature(primary.KeyType, primary.PublicKey, TaggedPublicKey(keyType, publicKey), signature)
+	if err != nil || !valid {
+		return nil, fmt.Errorf("%w: signature by primary identity key does not verify", ErrInvalidCompanionKey)
+	}
+
+	var oldFingerprint string
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE identity_keys
+		SET status = 'rotated', rotated_at = NOW()
+		WHERE user_id = $1 AND status = 'active' AND is_companion = TRUE
+		RETURNING key_fingerprint
+	`, userID).Scan(&oldFingerprint)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to rotate companion identity key: %w", err)
+	}
+
+	var version int
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(key_version), 0) + 1 FROM identity_keys WHERE user_id = $1 AND is_companion = TRUE
+	`, userID).Scan(&version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next version: %w", err)
+	}
+
+	key := &IdentityKey{
+		ID:                 uuid.New(),
+		UserID:             userID,
+		KeyType:            keyType,
+		PublicKey:          publicKey,
+		KeyFingerprint:     TypedKeyFingerprint(keyType, publicKey, false),
+		KeyVersion:         version,
+		Status:             "active",
+		CreatedAt:          time.Now(),
+		IsCompanion:        true,
+		CompanionSignature: signature,
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO identity_keys (id, user_id, key_type, dilithium_public_key, key_fingerprint, key_version,
+			status, created_at, is_companion, companion_signature)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, TRUE, $9)
+	`, key.ID, key.UserID, string(key.KeyType), key.PublicKey, key.KeyFingerprint, key.KeyVersion,
+		key.Status, key.CreatedAt, key.CompanionSignature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store companion identity key: %w", err)
+	}
+
+	reason := RotationReasonInitial
+	if oldFingerprint != "" {
+		reason = RotationReasonManual
+	}
+	if err := s.logKeyRotation(ctx, userID, "identity", oldFingerprint, key.KeyFingerprint, reason); err != nil {
+		return nil, err
+	}
+	s.queueTransparencyUpdate(ctx, userID, "key_updated")
+
+	return key, nil
+}
+
+// StoreSignedPreKey stores a primary signed prekey for a user.
+// Accepts P-256 (Web Crypto API), X25519, Kyber1024 and ML-KEM keys; an empty
+// keyType infers a legacy type from the key length.
+func (s *Service) StoreSignedPreKey(ctx context.Context, userID uuid.UUID, keyID int, keyType KeyType, publicKey, signature []byte) (*SignedPreKey, error) {
+	if keyType == "" {
+		inferred, err := InferLegacyKeyType(publicKey, false)
+		if err != nil {
+			return nil, err
+		}
+		keyType = inferred
+	}
+	if err := keyType.ValidatePreKey(publicKey); err != nil {
+		return nil, err
 	}
 	if !IsValidSignatureSize(signature) {
 		return nil, fmt.Errorf("invalid signature size: got %d", len(signature))
 	}
+	if err := s.suites.CheckPreKey(keyType, false); err != nil {
+		return nil, err
+	}
 
-	fingerprint := KeyFingerprint(publicKey)
-	expiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
+	fingerprint := PreKeyFingerprint(keyType, nil, publicKey)
+	expiresAt := time.Now().Add(s.rotationPolicy.SignedPreKeyMaxAge)
 
-	// Mark existing signed prekeys as rotated
-	_, err := s.db.ExecContext(ctx, `
-		UPDATE signed_prekeys
-		SET status = 'rotated', rotated_at = NOW()
-		WHERE user_id = $1 AND status = 'active'
-	`, userID)
+	// Mark existing signed prekeys as rotated (kept for the grace window)
+	oldFingerprint, err := s.rotateActiveSignedPreKeys(ctx, userID, false, keyType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to rotate existing signed prekeys: %w", err)
+		return nil, err
 	}
 
 	// Insert new signed prekey
@@ -268,6 +473,7 @@ func (s *Service) StoreSignedPreKey(ctx context.Context, userID uuid.UUID, keyID
 		ID:             uuid.New(),
 		UserID:         userID,
 		KeyID:          keyID,
+		KeyType:        keyType,
 		KyberPublicKey: publicKey,
 		Signature:      signature,
 		KeyFingerprint: fingerprint,
@@ -278,48 +484,50 @@ func (s *Service) StoreSignedPreKey(ctx context.Context, userID uuid.UUID, keyID
 
 	// Use UPSERT to handle re-uploading the same key_id (common during development/testing)
 	_, err = s.db.ExecContext(ctx, `
-		INSERT INTO signed_prekeys (id, user_id, key_id, kyber_public_key, signature, key_fingerprint, status, created_at, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO signed_prekeys (id, user_id, key_id, key_type, kyber_public_key, signature, key_fingerprint, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		ON CONFLICT (user_id, key_id) DO UPDATE SET
+			key_type = EXCLUDED.key_type,
 			kyber_public_key = EXCLUDED.kyber_public_key,
+			ec_public_key = NULL,
+			hybrid_version = 1,
+			is_companion = FALSE,
 			signature = EXCLUDED.signature,
 			key_fingerprint = EXCLUDED.key_fingerprint,
 			status = EXCLUDED.status,
-			expires_at = EXCLUDED.expires_at
-	`, prekey.ID, prekey.UserID, prekey.KeyID, prekey.KyberPublicKey, prekey.Signature, prekey.KeyFingerprint, prekey.Status, prekey.CreatedAt, prekey.ExpiresAt)
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at,
+			rotated_at = NULL,
+			rotation_notified_at = NULL
+	`, prekey.ID, prekey.UserID, prekey.KeyID, string(prekey.KeyType), prekey.KyberPublicKey, prekey.Signature, prekey.KeyFingerprint, prekey.Status, prekey.CreatedAt, prekey.ExpiresAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to store signed prekey: %w", err)
 	}
 
-	// Queue update to transparency service (with signed prekey fingerprint)
-	if s.transparencyService != nil {
-		// Get identity key fingerprint for the update
-		identityKey, _ := s.GetIdentityKey(ctx, userID)
-		if identityKey != nil {
-			s.transparencyService.QueueKeyUpdate(TransparencyKeyUpdate{
-				UserID:                  userID,
-				IdentityKeyFingerprint:  identityKey.KeyFingerprint,
-				SignedPreKeyFingerprint: fingerprint,
-				KeyVersion:              identityKey.KeyVersion,
-				UpdateType:              "key_updated",
-			})
+	if oldFingerprint != "" && oldFingerprint != fingerprint {
+		if err := s.logKeyRotation(ctx, userID, "signed_prekey", oldFingerprint, fingerprint, RotationReasonScheduled); err != nil {
+			return nil, err
 		}
 	}
 
+	// Queue update to transparency service (with signed prekey fingerprint)
+	s.queueTransparencyUpdate(ctx, userID, "key_updated")
+
 	return prekey, nil
 }
 
-// GetSignedPreKey retrieves a user's active signed prekey
+// GetSignedPreKey retrieves a user's active primary signed prekey
 func (s *Service) GetSignedPreKey(ctx context.Context, userID uuid.UUID) (*SignedPreKey, error) {
 	prekey := &SignedPreKey{}
+	var keyType string
 	err := s.db.QueryRowContext(ctx, `
-		SELECT id, user_id, key_id, kyber_public_key, signature, key_fingerprint, status, created_at, expires_at
+		SELECT id, user_id, key_id, key_type, kyber_public_key, signature, key_fingerprint, status, created_at, expires_at
 		FROM signed_prekeys
-		WHERE user_id = $1 AND status = 'active'
+		WHERE user_id = $1 AND status = 'active' AND is_companion = FALSE
 		ORDER BY created_at DESC
 		LIMIT 1
-	`, userID).Scan(&prekey.ID, &prekey.UserID, &prekey.KeyID, &prekey.KyberPublicKey, &prekey.Signature, &prekey.KeyFingerprint, &prekey.Status, &prekey.CreatedAt, &prekey.ExpiresAt)
+	`, userID).Scan(&prekey.ID, &prekey.UserID, &prekey.KeyID, &keyType, &prekey.KyberPublicKey, &prekey.Signature, &prekey.KeyFingerprint, &prekey.Status, &prekey.CreatedAt, &prekey.ExpiresAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -327,6 +535,7 @@ func (s *Service) GetSignedPreKey(ctx context.Context, userID uuid.UUID) (*Signe
 	if err != nil {
 		return nil, fmt.Errorf("failed to get signed prekey: %w", err)
 	}
+	prekey.KeyType = KeyType(keyType)
 
 	return prekey, nil
 }
@@ -340,8 +549,8 @@ func (s *Service) StoreOneTimePreKeys(ctx context.Context, userID uuid.UUID, pre
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO one_time_prekeys (id, user_id, key_id, kyber_public_key, status, created_at, expires_at)
-		VALUES ($1, $2, $3, $4, 'available', $5, $6)
+		INSERT INTO one_time_prekeys (id, user_id, key_id, key_type, kyber_public_key, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, 'available', $6, $7)
 		ON CONFLICT (user_id, key_id) DO NOTHING
 	`)
 	if err != nil {
@@ -353,12 +562,22 @@ func (s *Service) StoreOneTimePreKeys(ctx context.Context, userID uuid.UUID, pre
 	expiresAt := now.Add(30 * 24 * time.Hour) // 30 days
 
 	for _, prekey := range prekeys {
-		// Accept both P-256 and Kyber1024 keys
-		if !IsValidPreKeySize(prekey.KyberPublicKey) {
-			return fmt.Errorf("invalid public key size for key %d: got %d bytes", prekey.KeyID, len(prekey.KyberPublicKey))
+		// Untagged keys are P-256, X25519 or Kyber1024 by length
+		keyType := prekey.KeyType
+		if keyType == "" {
+			keyType, err = InferLegacyKeyType(prekey.KyberPublicKey, false)
+			if err != nil {
+				return fmt.Errorf("one-time prekey %d: %w", prekey.KeyID, err)
+			}
+		}
+		if err := keyType.ValidatePreKey(prekey.KyberPublicKey); err != nil {
+			return fmt.Errorf("one-time prekey %d: %w", prekey.KeyID, err)
+		}
+		if err := s.suites.CheckPreKey(keyType, false); err != nil {
+			return fmt.Errorf("one-time prekey %d: %w", prekey.KeyID, err)
 		}
 
-		_, err = stmt.ExecContext(ctx, uuid.New(), userID, prekey.KeyID, prekey.KyberPublicKey, now, expiresAt)
+		_, err = stmt.ExecContext(ctx, uuid.New(), userID, prekey.KeyID, string(keyType), prekey.KyberPublicKey, now, expiresAt)
 		if err != nil {
 			return fmt.Errorf("failed to store one-time prekey %d: %w", prekey.KeyID, err)
 		}
@@ -369,11 +588,13 @@ func (s *Service) StoreOneTimePreKeys(ctx context.Context, userID uuid.UUID, pre
 
 // OneTimePreKeyInput represents input for storing a one-time prekey
 type OneTimePreKeyInput struct {
-	KeyID          int    `json:"key_id"`
-	KyberPublicKey []byte `json:"kyber_public_key"`
+	KeyID          int     `json:"key_id"`
+	KeyType        KeyType `json:"key_type,omitempty"` // Empty infers a legacy type
+	KyberPublicKey []byte  `json:"kyber_public_key"`
 }
 
-// ClaimOneTimePreKey atomically claims an available one-time prekey
+// ClaimOneTimePreKey atomically claims an available legacy (P-256, X25519 or
+// Kyber1024) one-time prekey. ML-KEM prekeys are claimed by type.
 func (s *Service) ClaimOneTimePreKey(ctx context.Context, targetUserID, claimingUserID uuid.UUID) (*OneTimePreKey, error) {
 	prekey := &OneTimePreKey{}
 	err := s.db.QueryRowContext(ctx, `
@@ -388,6 +609,7 @@ func (s *Service) ClaimOneTimePreKey(ctx context.Context, targetUserID, claiming
 		return nil, fmt.Errorf("failed to claim one-time prekey: %w", err)
 	}
 
+	prekey.KeyType, _ = InferLegacyKeyType(prekey.KyberPublicKey, false)
 	prekey.UserID = targetUserID
 	prekey.Status = "used"
 	prekey.UsedBy = &claimingUserID
@@ -397,6 +619,38 @@ func (s *Service) ClaimOneTimePreKey(ctx context.Context, targetUserID, claiming
 	return prekey, nil
 }
 
+// claimPreKeyForSignedPreKey claims a one-time prekey matching a
+// single-algorithm signed prekey's type
+func (s *Service) claimPreKeyForSignedPreKey(ctx context.Context, targetUserID, claimingUserID uuid.UUID, keyType KeyType) (*OneTimePreKey, error) {
+	if keyType.IsLegacy() {
+		return s.ClaimOneTimePreKey(ctx, targetUserID, claimingUserID)
+	}
+
+	claimed, err := s.claimTypedOneTimePreKey(ctx, targetUserID, claimingUserID, keyType, false)
+	if err != nil || claimed == nil {
+		return nil, err
+	}
+	return &OneTimePreKey{
+		ID:             claimed.ID,
+		UserID:         claimed.UserID,
+		KeyID:          claimed.KeyID,
+		KeyType:        claimed.KeyType,
+		KyberPublicKey: claimed.PQPublicKey,
+		Status:         claimed.Status,
+		UsedBy:         claimed.UsedBy,
+		UsedAt:         claimed.UsedAt,
+	}, nil
+}
+
+// LastResortKeyType returns the last-resort prekey type served with a signed
+// prekey: legacy bundles have always fallen back to Kyber1024
+func LastResortKeyType(signedPreKeyType KeyType) KeyType {
+	if signedPreKeyType.IsLegacy() {
+		return KeyTypeKyber1024
+	}
+	return signedPreKeyType
+}
+
 // GetAvailableOneTimePreKeyCount returns the count of available one-time prekeys for a user
 func (s *Service) GetAvailableOneTimePreKeyCount(ctx context.Context, userID uuid.UUID) (int, error) {
 	var count int
@@ -440,13 +694,45 @@ func (s *Service) GetPreKeyBundle(ctx context.Context, targetUserID, requestingU
 	}
 	bundle.SignedPreKey = signedPreKey
 
-	// Try to claim a one-time prekey
-	oneTimePreKey, err := s.ClaimOneTimePreKey(ctx, targetUserID, requestingUserID)
+	// Check before claiming so a refused bundle doesn't burn a one-time prekey
+	bundle.SignedPreKeyRotationDue, bundle.SignedPreKeyStale, err = s.checkSignedPreKeyAge(signedPreKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try to claim a one-time prekey of the signed prekey's type
+	oneTimePreKey, err := s.claimPreKeyForSignedPreKey(ctx, targetUserID, requestingUserID, signedPreKey.KeyType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to claim one-time prekey: %w", err)
 	}
 	bundle.OneTimePreKey = oneTimePreKey // May be nil if none available
 
+	// Pool exhausted: fall back to the last-resort prekey
+	kind := PreKeyKindOneTime
+	if oneTimePreKey == nil {
+		bundle.LastResortPreKey, err = s.useLastResortPreKey(ctx, targetUserID, 1, LastResortKeyType(signedPreKey.KeyType))
+		if err != nil {
+			return nil, err
+		}
+		bundle.LastResortPreKeyUsed = bundle.LastResortPreKey != nil
+		kind = PreKeyKindNone
+		if bundle.LastResortPreKeyUsed {
+			kind = PreKeyKindLastResort
+		}
+	}
+	s.recordPreKeyClaim(ctx, targetUserID, requestingUserID, kind)
+
+	bundle.SupportedSuites, err = s.GetSupportedSuites(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle.CompanionIdentityKey, bundle.CompanionSignedPreKey, bundle.CompanionOneTimePreKey, err =
+		s.companionKeys(ctx, targetUserID, requestingUserID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get bundle version
 	var version int
 	err = s.db.QueryRowContext(ctx, `
@@ -480,6 +766,11 @@ func (s *Service) GetPreKeyBundleWithoutClaim(ctx context.Context, targetUserID
 	}
 	bundle.SignedPreKey = signedPreKey
 
+	bundle.CompanionIdentityKey, err = s.GetCompanionIdentityKey(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+
 	return bundle, nil
 }
 
@@ -506,16 +797,58 @@ func (s *Service) CacheKeyBundle(ctx context.Context, userID uuid.UUID, bundle *
 	return nil
 }
 
-// logKeyRotation logs a key rotation event
-func (s *Service) logKeyRotation(ctx context.Context, userID uuid.UUID, keyType, oldFingerprint, newFingerprint, reason string) {
-	_, _ = s.db.ExecContext(ctx, `
+// rotateActiveSignedPreKeys marks a user's active primary (or companion)
+// signed prekeys as rotated and returns the fingerprint of the most recent one
+// ("" if there was none). A new primary also rotates companions of its type,
+// which promotes the companion and ends the transition.
+func (s *Service) rotateActiveSignedPreKeys(ctx context.Context, userID uuid.UUID, companion bool, keyType KeyType) (string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE signed_prekeys
+		SET status = 'rotated', rotated_at = NOW()
+		WHERE user_id = $1 AND status = 'active'
+		AND (is_companion = $2 OR ($2 = FALSE AND key_type = $3))
+		RETURNING key_fingerprint, created_at, is_companion
+	`, userID, companion, string(keyType))
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate existing signed prekeys: %w", err)
+	}
+	defer rows.Close()
+
+	var fingerprint string
+	var newest time.Time
+	for rows.Next() {
+		var fp string
+		var createdAt time.Time
+		var isCompanion bool
+		if err := rows.Scan(&fp, &createdAt, &isCompanion); err != nil {
+			return "", fmt.Errorf("failed to rotate existing signed prekeys: %w", err)
+		}
+		if isCompanion != companion {
+			continue
+		}
+		if fingerprint == "" || createdAt.After(newest) {
+			fingerprint, newest = fp, createdAt
+		}
+	}
+
+	return fingerprint, rows.Err()
+}
+
+// logKeyRotation logs a key rotation event. reason is one of the RotationReason constants.
+func (s *Service) logKeyRotation(ctx context.Context, userID uuid.UUID, keyType, oldFingerprint, newFingerprint, reason string) error {
+	_, err := s.db.ExecContext(ctx, `
 		INSERT INTO key_rotation_log (id, user_id, key_type, old_key_fingerprint, new_key_fingerprint, reason, created_at)
 		VALUES ($1, $2, $3, $4, $5, $6, NOW())
 	`, uuid.New(), userID, keyType, sql.NullString{String: oldFingerprint, Valid: oldFingerprint != ""}, newFingerprint, reason)
+	if err != nil {
+		return fmt.Errorf("failed to log key rotation: %w", err)
+	}
+	return nil
 }
 
-// RotateIdentityKey rotates a user's identity key
-func (s *Service) RotateIdentityKey(ctx context.Context, userID uuid.UUID, newPublicKey []byte, reason string) (*IdentityKey, error) {
+// RotateIdentityKey rotates a user's primary identity key. An empty keyType
+// infers a legacy type from the key length.
+func (s *Service) RotateIdentityKey(ctx context.Context, userID uuid.UUID, keyType KeyType, newPublicKey []byte, reason string) (*IdentityKey, error) {
 	// Get current identity key for logging
 	currentKey, _ := s.GetIdentityKey(ctx, userID)
 	oldFingerprint := ""
@@ -524,13 +857,23 @@ func (s *Service) RotateIdentityKey(ctx context.Context, userID uuid.UUID, newPu
 	}
 
 	// Store new identity key (this marks old one as rotated)
-	newKey, err := s.StoreIdentityKey(ctx, userID, newPublicKey)
+	newKey, err := s.StoreIdentityKey(ctx, userID, keyType, newPublicKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// Log the rotation
-	s.logKeyRotation(ctx, userID, "identity", oldFingerprint, newKey.KeyFingerprint, reason)
+	// Log the rotation (skipped for a first key or a re-upload of the same key)
+	if oldFingerprint != "" && oldFingerprint != newKey.KeyFingerprint {
+		if err := s.logKeyRotation(ctx, userID, "identity", oldFingerprint, newKey.KeyFingerprint, reason); err != nil {
+			return nil, err
+		}
+	}
+
+	// Contacts who verified any other key must re-compare safety numbers.
+	// Runs even when nothing rotated so a retried upload finishes a failed reset.
+	if err := s.resetVerifications(ctx, userID, newKey.KeyFingerprint); err != nil {
+		return nil, err
+	}
 
 	return newKey, nil
 }
@@ -560,33 +903,35 @@ func (s *Service) HasKeys(ctx context.Context, userID uuid.UUID) (bool, error) {
 // Hybrid PQXDH Key Management (X25519 + Kyber-1024)
 // ============================================================================
 
-// StoreHybridSignedPreKey stores a hybrid signed prekey for PQXDH
-func (s *Service) StoreHybridSignedPreKey(ctx context.Context, userID uuid.UUID, keyID int, ecPublicKey, pqPublicKey, signature []byte) (*HybridSignedPreKey, error) {
+// StoreHybridSignedPreKey stores a primary hybrid signed prekey for PQXDH.
+// An empty keyType means Kyber1024.
+func (s *Service) StoreHybridSignedPreKey(ctx context.Context, userID uuid.UUID, keyID int, keyType KeyType, ecPublicKey, pqPublicKey, signature []byte) (*HybridSignedPreKey, error) {
+	if keyType == "" {
+		keyType = KeyTypeKyber1024
+	}
 	// Validate X25519 key size
 	if len(ecPublicKey) != X25519PublicKeySize {
 		return nil, fmt.Errorf("invalid EC public key size: expected %d, got %d", X25519PublicKeySize, len(ecPublicKey))
 	}
-	// Validate Kyber key size
-	if len(pqPublicKey) != Kyber1024PublicKeySize {
-		return nil, fmt.Errorf("invalid PQ public key size: expected %d, got %d", Kyber1024PublicKeySize, len(pqPublicKey))
+	// Validate PQ key size
+	if err := keyType.ValidatePreKey(pqPublicKey); err != nil {
+		return nil, err
 	}
 	if !IsValidSignatureSize(signature) {
 		return nil, fmt.Errorf("invalid signature size: got %d", len(signature))
 	}
+	if err := s.suites.CheckPreKey(keyType, true); err != nil {
+		return nil, err
+	}
 
 	// Fingerprint of concatenated keys
-	combinedKey := append(ecPublicKey, pqPublicKey...)
-	fingerprint := KeyFingerprint(combinedKey)
-	expiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
+	fingerprint := PreKeyFingerprint(keyType, ecPublicKey, pqPublicKey)
+	expiresAt := time.Now().Add(s.rotationPolicy.SignedPreKeyMaxAge)
 
-	// Mark existing signed prekeys as rotated
-	_, err := s.db.ExecContext(ctx, `
-		UPDATE signed_prekeys
-		SET status = 'rotated', rotated_at = NOW()
-		WHERE user_id = $1 AND status = 'active'
-	`, userID)
+	// Mark existing signed prekeys as rotated (kept for the grace window)
+	oldFingerprint, err := s.rotateActiveSignedPreKeys(ctx, userID, false, keyType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to rotate existing signed prekeys: %w", err)
+		return nil, err
 	}
 
 	// Insert new hybrid signed prekey
@@ -594,6 +939,7 @@ func (s *Service) StoreHybridSignedPreKey(ctx context.Context, userID uuid.UUID,
 		ID:             uuid.New(),
 		UserID:         userID,
 		KeyID:          keyID,
+		KeyType:        keyType,
 		ECPublicKey:    ecPublicKey,
 		PQPublicKey:    pqPublicKey,
 		Signature:      signature,
@@ -605,29 +951,52 @@ func (s *Service) StoreHybridSignedPreKey(ctx context.Context, userID uuid.UUID,
 	}
 
 	_, err = s.db.ExecContext(ctx, `
-		INSERT INTO signed_prekeys (id, user_id, key_id, ec_public_key, kyber_public_key, signature, key_fingerprint, hybrid_version, status, created_at, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-	`, prekey.ID, prekey.UserID, prekey.KeyID, prekey.ECPublicKey, prekey.PQPublicKey, prekey.Signature, prekey.KeyFingerprint, prekey.HybridVersion, prekey.Status, prekey.CreatedAt, prekey.ExpiresAt)
+		INSERT INTO signed_prekeys (id, user_id, key_id, key_type, ec_public_key, kyber_public_key, signature, key_fingerprint, hybrid_version, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, prekey.ID, prekey.UserID, prekey.KeyID, string(prekey.KeyType), prekey.ECPublicKey, prekey.PQPublicKey, prekey.Signature, prekey.KeyFingerprint, prekey.HybridVersion, prekey.Status, prekey.CreatedAt, prekey.ExpiresAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to store hybrid signed prekey: %w", err)
 	}
 
+	if oldFingerprint != "" && oldFingerprint != fingerprint {
+		if err := s.logKeyRotation(ctx, userID, "signed_prekey", oldFingerprint, fingerprint, RotationReasonScheduled); err != nil {
+			return nil, err
+		}
+	}
+
+	s.queueTransparencyUpdate(ctx, userID, "key_updated")
+
 	return prekey, nil
 }
 
-// GetHybridSignedPreKey retrieves a user's active hybrid signed prekey
-func (s *Service) GetHybridSignedPreKey(ctx context.Context, userID uuid.UUID) (*HybridSignedPreKey, error) {
+// hybridSignedPreKeyColumns is the column list scanned by scanHybridSignedPreKey
+const hybridSignedPreKeyColumns = `id, user_id, key_id, key_type, ec_public_key, kyber_public_key, signature,
+	key_fingerprint, COALESCE(hybrid_version, 1), status, created_at, expires_at, is_companion`
+
+// scanHybridSignedPreKey scans a row selected with hybridSignedPreKeyColumns
+func scanHybridSignedPreKey(row interface{ Scan(...interface{}) error }) (*HybridSignedPreKey, error) {
 	prekey := &HybridSignedPreKey{}
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, user_id, key_id, ec_public_key, kyber_public_key, signature, key_fingerprint,
-		       COALESCE(hybrid_version, 1), status, created_at, expires_at
+	var keyType string
+	err := row.Scan(&prekey.ID, &prekey.UserID, &prekey.KeyID, &keyType, &prekey.ECPublicKey, &prekey.PQPublicKey,
+		&prekey.Signature, &prekey.KeyFingerprint, &prekey.HybridVersion, &prekey.Status, &prekey.CreatedAt,
+		&prekey.ExpiresAt, &prekey.IsCompanion)
+	if err != nil {
+		return nil, err
+	}
+	prekey.KeyType = KeyType(keyType)
+	return prekey, nil
+}
+
+// GetHybridSignedPreKey retrieves a user's active primary hybrid signed prekey
+func (s *Service) GetHybridSignedPreKey(ctx context.Context, userID uuid.UUID) (*HybridSignedPreKey, error) {
+	prekey, err := scanHybridSignedPreKey(s.db.QueryRowContext(ctx, `
+		SELECT `+hybridSignedPreKeyColumns+`
 		FROM signed_prekeys
-		WHERE user_id = $1 AND status = 'active' AND ec_public_key IS NOT NULL
+		WHERE user_id = $1 AND status = 'active' AND ec_public_key IS NOT NULL AND is_companion = FALSE
 		ORDER BY created_at DESC
 		LIMIT 1
-	`, userID).Scan(&prekey.ID, &prekey.UserID, &prekey.KeyID, &prekey.ECPublicKey, &prekey.PQPublicKey,
-		&prekey.Signature, &prekey.KeyFingerprint, &prekey.HybridVersion, &prekey.Status, &prekey.CreatedAt, &prekey.ExpiresAt)
+	`, userID))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -639,11 +1008,118 @@ func (s *Service) GetHybridSignedPreKey(ctx context.Context, userID uuid.UUID) (
 	return prekey, nil
 }
 
+// StoreCompanionSignedPreKey stores a signed prekey of the type the user is
+// migrating to, next to the primary one. ecPublicKey is nil for a
+// single-algorithm prekey. The caller is responsible for verifying the
+// signature (by the companion identity key if one is published, otherwise
+// by the primary) over PreKeySignedData.
+func (s *Service) StoreCompanionSignedPreKey(ctx context.Context, userID uuid.UUID, keyID int, keyType KeyType, ecPublicKey, pqPublicKey, signature []byte) (*HybridSignedPreKey, error) {
+	hybridVersion := 1
+	if ecPublicKey != nil {
+		if len(ecPublicKey) != X25519PublicKeySize {
+			return nil, fmt.Errorf("invalid EC public key size: expected %d, got %d", X25519PublicKeySize, len(ecPublicKey))
+		}
+		hybridVersion = 2
+	}
+	if err := keyType.ValidatePreKey(pqPublicKey); err != nil {
+		return nil, err
+	}
+	if !IsValidSignatureSize(signature) {
+		return nil, fmt.Errorf("invalid signature size: got %d", len(signature))
+	}
+	if err := s.suites.CheckPreKey(keyType, ecPublicKey != nil); err != nil {
+		return nil, err
+	}
+
+	primary, err := s.GetHybridSignedPreKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if primary == nil {
+		if legacy, err := s.GetSignedPreKey(ctx, userID); err != nil {
+			return nil, err
+		} else if legacy != nil {
+			primary = &HybridSignedPreKey{KeyType: legacy.KeyType, HybridVersion: 1}
+		}
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("%w: no primary signed prekey", ErrInvalidCompanionKey)
+	}
+	if primary.KeyType == keyType && primary.HybridVersion == hybridVersion {
+		return nil, fmt.Errorf("%w: companion must differ from the primary prekey type %s", ErrInvalidCompanionKey, keyType.Label(ecPublicKey != nil))
+	}
+
+	fingerprint := PreKeyFingerprint(keyType, ecPublicKey, pqPublicKey)
+	expiresAt := time.Now().Add(s.rotationPolicy.SignedPreKeyMaxAge)
+
+	oldFingerprint, err := s.rotateActiveSignedPreKeys(ctx, userID, true, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	prekey := &HybridSignedPreKey{
+		ID:             uuid.New(),
+		UserID:         userID,
+		KeyID:          keyID,
+		KeyType:        keyType,
+		ECPublicKey:    ecPublicKey,
+		PQPublicKey:    pqPublicKey,
+		Signature:      signature,
+		KeyFingerprint: fingerprint,
+		HybridVersion:  hybridVersion,
+		Status:         "active",
+		CreatedAt:      time.Now(),
+		ExpiresAt:      &expiresAt,
+		IsCompanion:    true,
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO signed_prekeys (id, user_id, key_id, key_type, ec_public_key, kyber_public_key, signature,
+			key_fingerprint, hybrid_version, status, created_at, expires_at, is_companion)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, TRUE)
+	`, prekey.ID, prekey.UserID, prekey.KeyID, string(prekey.KeyType), prekey.ECPublicKey, prekey.PQPublicKey,
+		prekey.Signature, prekey.KeyFingerprint, prekey.HybridVersion, prekey.Status, prekey.CreatedAt, prekey.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store companion signed prekey: %w", err)
+	}
+
+	if oldFingerprint != "" && oldFingerprint != fingerprint {
+		if err := s.logKeyRotation(ctx, userID, "signed_prekey", oldFingerprint, fingerprint, RotationReasonScheduled); err != nil {
+			return nil, err
+		}
+	}
+
+	s.queueTransparencyUpdate(ctx, userID, "key_updated")
+
+	return prekey, nil
+}
+
+// GetCompanionSignedPreKey retrieves a user's active companion signed prekey
+func (s *Service) GetCompanionSignedPreKey(ctx context.Context, userID uuid.UUID) (*HybridSignedPreKey, error) {
+	prekey, err := scanHybridSignedPreKey(s.db.QueryRowContext(ctx, `
+		SELECT `+hybridSignedPreKeyColumns+`
+		FROM signed_prekeys
+		WHERE user_id = $1 AND status = 'active' AND is_companion = TRUE
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, userID))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get companion signed prekey: %w", err)
+	}
+
+	return prekey, nil
+}
+
 // HybridOneTimePreKeyInput represents input for storing a hybrid one-time prekey
 type HybridOneTimePreKeyInput struct {
-	KeyID       int    `json:"key_id"`
-	ECPublicKey []byte `json:"ec_public_key"`  // X25519 (32 bytes)
-	PQPublicKey []byte `json:"pq_public_key"`  // Kyber1024 (1568 bytes)
+	KeyID       int     `json:"key_id"`
+	KeyType     KeyType `json:"key_type,omitempty"` // PQ component type; empty means Kyber1024
+	ECPublicKey []byte  `json:"ec_public_key"`      // X25519 (32 bytes)
+	PQPublicKey []byte  `json:"pq_public_key"`      // Kyber1024 (1568 bytes)
 }
 
 // StoreHybridOneTimePreKeys stores a batch of hybrid one-time prekeys
@@ -655,8 +1131,8 @@ func (s *Service) StoreHybridOneTimePreKeys(ctx context.Context, userID uuid.UUI
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO one_time_prekeys (id, user_id, key_id, ec_public_key, kyber_public_key, hybrid_version, status, created_at, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, 'available', $7, $8)
+		INSERT INTO one_time_prekeys (id, user_id, key_id, key_type, ec_public_key, kyber_public_key, hybrid_version, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'available', $8, $9)
 		ON CONFLICT (user_id, key_id) DO NOTHING
 	`)
 	if err != nil {
@@ -668,16 +1144,23 @@ func (s *Service) StoreHybridOneTimePreKeys(ctx context.Context, userID uuid.UUI
 	expiresAt := now.Add(30 * 24 * time.Hour) // 30 days
 
 	for _, prekey := range prekeys {
+		keyType := prekey.KeyType
+		if keyType == "" {
+			keyType = KeyTypeKyber1024
+		}
 		// Validate X25519 key size
 		if len(prekey.ECPublicKey) != X25519PublicKeySize {
 			return fmt.Errorf("invalid EC public key size for key %d: expected %d, got %d", prekey.KeyID, X25519PublicKeySize, len(prekey.ECPublicKey))
 		}
-		// Validate Kyber key size
-		if len(prekey.PQPublicKey) != Kyber1024PublicKeySize {
-			return fmt.Errorf("invalid PQ public key size for key %d: expected %d, got %d", prekey.KeyID, Kyber1024PublicKeySize, len(prekey.PQPublicKey))
+		// Validate PQ key size
+		if err := keyType.ValidatePreKey(prekey.PQPublicKey); err != nil {
+			return fmt.Errorf("hybrid one-time prekey %d: %w", prekey.KeyID, err)
+		}
+		if err := s.suites.CheckPreKey(keyType, true); err != nil {
+			return fmt.Errorf("hybrid one-time prekey %d: %w", prekey.KeyID, err)
 		}
 
-		_, err = stmt.ExecContext(ctx, uuid.New(), userID, prekey.KeyID, prekey.ECPublicKey, prekey.PQPublicKey, 2, now, expiresAt)
+		_, err = stmt.ExecContext(ctx, uuid.New(), userID, prekey.KeyID, string(keyType), prekey.ECPublicKey, prekey.PQPublicKey, 2, now, expiresAt)
 		if err != nil {
 			return fmt.Errorf("failed to store hybrid one-time prekey %d: %w", prekey.KeyID, err)
 		}
@@ -686,18 +1169,24 @@ func (s *Service) StoreHybridOneTimePreKeys(ctx context.Context, userID uuid.UUI
 	return tx.Commit()
 }
 
-// ClaimHybridOneTimePreKey atomically claims an available hybrid one-time prekey
+// ClaimHybridOneTimePreKey atomically claims an available X25519+Kyber1024 one-time prekey
 func (s *Service) ClaimHybridOneTimePreKey(ctx context.Context, targetUserID, claimingUserID uuid.UUID) (*HybridOneTimePreKey, error) {
+	return s.claimTypedOneTimePreKey(ctx, targetUserID, claimingUserID, KeyTypeKyber1024, true)
+}
+
+// claimTypedOneTimePreKey atomically claims an available one-time prekey of
+// the given type, paired with an X25519 key when hybrid
+func (s *Service) claimTypedOneTimePreKey(ctx context.Context, targetUserID, claimingUserID uuid.UUID, keyType KeyType, hybrid bool) (*HybridOneTimePreKey, error) {
 	prekey := &HybridOneTimePreKey{}
 
-	// First try to claim a hybrid prekey
 	err := s.db.QueryRowContext(ctx, `
 		WITH claimed AS (
 			SELECT id, key_id, ec_public_key, kyber_public_key, COALESCE(hybrid_version, 1) as hybrid_version
 			FROM one_time_prekeys
 			WHERE user_id = $1
 			AND status = 'available'
-			AND ec_public_key IS NOT NULL
+			AND key_type = $3
+			AND (ec_public_key IS NOT NULL) = $4
 			AND (expires_at IS NULL OR expires_at > NOW())
 			ORDER BY created_at ASC
 			LIMIT 1
@@ -707,15 +1196,16 @@ func (s *Service) ClaimHybridOneTimePreKey(ctx context.Context, targetUserID, cl
 		SET status = 'used', used_by = $2, used_at = NOW()
 		WHERE id = (SELECT id FROM claimed)
 		RETURNING id, key_id, ec_public_key, kyber_public_key, (SELECT hybrid_version FROM claimed)
-	`, targetUserID, claimingUserID).Scan(&prekey.ID, &prekey.KeyID, &prekey.ECPublicKey, &prekey.PQPublicKey, &prekey.HybridVersion)
+	`, targetUserID, claimingUserID, string(keyType), hybrid).Scan(&prekey.ID, &prekey.KeyID, &prekey.ECPublicKey, &prekey.PQPublicKey, &prekey.HybridVersion)
 
 	if err == sql.ErrNoRows {
-		return nil, nil // No available hybrid prekeys
+		return nil, nil // No available prekeys of this type
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to claim hybrid one-time prekey: %w", err)
+		return nil, fmt.Errorf("failed to claim %s one-time prekey: %w", keyType.Label(hybrid), err)
 	}
 
+	prekey.KeyType = keyType
 	prekey.UserID = targetUserID
 	prekey.Status = "used"
 	prekey.UsedBy = &claimingUserID
@@ -725,6 +1215,87 @@ func (s *Service) ClaimHybridOneTimePreKey(ctx context.Context, targetUserID, cl
 	return prekey, nil
 }
 
+// companionKeys returns the target's companion identity key and signed
+// prekey. A companion one-time prekey is claimed only when the requester
+// advertises a suite using the companion prekey's KEM, so legacy clients
+// don't drain a pool they can't use.
+func (s *Service) companionKeys(ctx context.Context, targetUserID, requestingUserID uuid.UUID) (*IdentityKey, *HybridSignedPreKey, *HybridOneTimePreKey, error) {
+	identityKey, err := s.GetCompanionIdentityKey(ctx, targetUserID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signedPreKey, err := s.GetCompanionSignedPreKey(ctx, targetUserID)
+	if err != nil || signedPreKey == nil {
+		return identityKey, nil, nil, err
+	}
+
+	requesterSuites, err := s.GetSupportedSuites(ctx, requestingUserID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hybrid := signedPreKey.ECPublicKey != nil
+	if !s.suites.UsesKEM(requesterSuites, signedPreKey.KeyType.KEMAlgorithm(hybrid)) {
+		return identityKey, signedPreKey, nil, nil
+	}
+
+	oneTimePreKey, err := s.claimTypedOneTimePreKey(ctx, targetUserID, requestingUserID, signedPreKey.KeyType, hybrid)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return identityKey, signedPreKey, oneTimePreKey, nil
+}
+
+// queueTransparencyUpdate queues the user's current primary and companion
+// keys to the transparency service
+func (s *Service) queueTransparencyUpdate(ctx context.Context, userID uuid.UUID, updateType string) {
+	if s.transparencyService == nil {
+		return
+	}
+
+	identityKey, err := s.GetIdentityKey(ctx, userID)
+	if err != nil || identityKey == nil {
+		return
+	}
+
+	update := TransparencyKeyUpdate{
+		UserID:                 userID,
+		IdentityKeyFingerprint: identityKey.KeyFingerprint,
+		IdentityKeyType:        string(identityKey.KeyType),
+		KeyVersion:             identityKey.KeyVersion,
+		UpdateType:             updateType,
+	}
+
+	var keyType string
+	var hybrid bool
+	err = s.db.QueryRowContext(ctx, `
+		SELECT key_fingerprint, key_type, ec_public_key IS NOT NULL
+		FROM signed_prekeys
+		WHERE user_id = $1 AND status = 'active' AND is_companion = FALSE
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, userID).Scan(&update.SignedPreKeyFingerprint, &keyType, &hybrid)
+	if err == nil {
+		update.SignedPreKeyType = KeyType(keyType).Label(hybrid)
+	}
+
+	if companion, _ := s.GetCompanionIdentityKey(ctx, userID); companion != nil {
+		update.CompanionKeys = append(update.CompanionKeys, TransparencyLeafKey{
+			Role:        LeafRoleCompanionIdentity,
+			KeyType:     string(companion.KeyType),
+			Fingerprint: companion.KeyFingerprint,
+		})
+	}
+	if companion, _ := s.GetCompanionSignedPreKey(ctx, userID); companion != nil {
+		update.CompanionKeys = append(update.CompanionKeys, TransparencyLeafKey{
+			Role:        LeafRoleCompanionSignedPreKey,
+			KeyType:     companion.KeyType.Label(companion.ECPublicKey != nil),
+			Fingerprint: companion.KeyFingerprint,
+		})
+	}
+
+	s.transparencyService.QueueKeyUpdate(update)
+}
+
 // GetHybridPreKeyBundle retrieves a complete hybrid prekey bundle for PQXDH
 func (s *Service) GetHybridPreKeyBundle(ctx context.Context, targetUserID, requestingUserID uuid.UUID) (*HybridPreKeyBundle, error) {
 	bundle := &HybridPreKeyBundle{
@@ -753,13 +1324,45 @@ func (s *Service) GetHybridPreKeyBundle(ctx context.Context, targetUserID, reque
 	}
 	bundle.SignedPreKey = signedPreKey
 
-	// Try to claim a hybrid one-time prekey
-	oneTimePreKey, err := s.ClaimHybridOneTimePreKey(ctx, targetUserID, requestingUserID)
+	// Check before claiming so a refused bundle doesn't burn a one-time prekey
+	bundle.SignedPreKeyRotationDue, bundle.SignedPreKeyStale, err = s.checkSignedPreKeyAge(signedPreKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try to claim a hybrid one-time prekey of the signed prekey's type
+	oneTimePreKey, err := s.claimTypedOneTimePreKey(ctx, targetUserID, requestingUserID, signedPreKey.KeyType, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to claim hybrid one-time prekey: %w", err)
 	}
 	bundle.OneTimePreKey = oneTimePreKey // May be nil if none available
 
+	// Pool exhausted: fall back to the hybrid last-resort prekey
+	kind := PreKeyKindOneTime
+	if oneTimePreKey == nil {
+		bundle.LastResortPreKey, err = s.useLastResortPreKey(ctx, targetUserID, 2, LastResortKeyType(signedPreKey.KeyType))
+		if err != nil {
+			return nil, err
+		}
+		bundle.LastResortPreKeyUsed = bundle.LastResortPreKey != nil
+		kind = PreKeyKindNone
+		if bundle.LastResortPreKeyUsed {
+			kind = PreKeyKindLastResort
+		}
+	}
+	s.recordPreKeyClaim(ctx, targetUserID, requestingUserID, kind)
+
+	bundle.SupportedSuites, err = s.GetSupportedSuites(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle.CompanionIdentityKey, bundle.CompanionSignedPreKey, bundle.CompanionOneTimePreKey, err =
+		s.companionKeys(ctx, targetUserID, requestingUserID)
+	if err != nil {
+		return nil, err
+	}
+
 	return bundle, nil
 }
 
@@ -843,8 +1446,7 @@ func (s *Service) StoreSealedSenderKey(ctx context.Context, userID uuid.UUID, pu
 		return nil, fmt.Errorf("failed to get next version: %w", err)
 	}
 
-	// Default expiration: 30 days
-	expiresAt := time.Now().Add(30 * 24 * time.Hour)
+	expiresAt := time.Now().Add(s.rotationPolicy.SealedSenderKeyMaxAge)
 
 	key := &SealedSenderKey{
 		ID:             uuid.New(),
@@ -867,7 +1469,9 @@ func (s *Service) StoreSealedSenderKey(ctx context.Context, userID uuid.UUID, pu
 	}
 
 	// Log the key creation/rotation
-	s.logKeyRotation(ctx, userID, "sealed_sender", "", fingerprint, "initial")
+	if err := s.logKeyRotation(ctx, userID, "sealed_sender", "", fingerprint, RotationReasonInitial); err != nil {
+		return nil, err
+	}
 
 	return key, nil
 }
@@ -908,7 +1512,9 @@ func (s *Service) RotateSealedSenderKey(ctx context.Context, userID uuid.UUID, n
 	}
 
 	// Log the rotation with reason
-	s.logKeyRotation(ctx, userID, "sealed_sender", oldFingerprint, newKey.KeyFingerprint, reason)
+	if err := s.logKeyRotation(ctx, userID, "sealed_sender", oldFingerprint, newKey.KeyFingerprint, reason); err != nil {
+		return nil, err
+	}
 
 	return newKey, nil
 }
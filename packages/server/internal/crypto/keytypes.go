@@ -0,0 +1,267 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+/*
+KEY TYPE TAGS:
+Every stored key carries an explicit key_type. Uploads from older clients that
+omit it are tagged by length, which only ever yields the legacy types
+(p256, x25519, kyber1024, dilithium3); ML-KEM and ML-DSA keys must always be
+tagged because their sizes collide with the round-3 algorithms.
+
+FINGERPRINTS:
+Legacy keys keep the plain SHA-256(public key) fingerprint so existing
+fingerprints, safety numbers and transparency leaves are unchanged. ML-KEM and
+ML-DSA keys use a domain-separated fingerprint over the tagged encoding
+(see TaggedPublicKey), so the same bytes published as Dilithium3 and as
+ML-DSA-65 can never share a fingerprint.
+
+DUAL PUBLISH:
+During a migration a user keeps their primary identity key and signed prekey
+and publishes a companion of the new type alongside each. Companion identity
+keys are signed by the primary identity key over TaggedPublicKey, which binds
+the key type as well as the bytes. Uploading a primary key of the companion's
+type promotes it and ends the transition.
+*/
+
+// KeyType identifies the algorithm of a stored public key
+type KeyType string
+
+// Key types
+const (
+	KeyTypeP256       KeyType = "p256"        // ECDSA/ECDH P-256, uncompressed (Web Crypto)
+	KeyTypeX25519     KeyType = "x25519"      // X25519
+	KeyTypeKyber1024  KeyType = "kyber1024"   // CRYSTALS-Kyber round 3
+	KeyTypeDilithium3 KeyType = "dilithium3"  // CRYSTALS-Dilithium round 3
+	KeyTypeMLKEM768   KeyType = "ml-kem-768"  // FIPS 203
+	KeyTypeMLKEM1024  KeyType = "ml-kem-1024" // FIPS 203
+	KeyTypeMLDSA65    KeyType = "ml-dsa-65"   // FIPS 204
+)
+
+// ErrUnknownKeyType is returned for key type tags that aren't registered
+var ErrUnknownKeyType = errors.New("unknown key type")
+
+// keyTypeInfo describes a key type's encoding and role
+type keyTypeInfo struct {
+	publicKeySize int
+	signatureSize int  // Fixed signature size; 0 for KEM-only or variable-size (P-256)
+	signing       bool // Usable as an identity key
+	kem           bool // Usable as a prekey
+	legacy        bool // Fingerprinted without a tag
+}
+
+var keyTypes = map[KeyType]keyTypeInfo{
+	KeyTypeP256:       {publicKeySize: P256PublicKeySize, signing: true, kem: true, legacy: true},
+	KeyTypeX25519:     {publicKeySize: X25519PublicKeySize, kem: true, legacy: true},
+	KeyTypeKyber1024:  {publicKeySize: Kyber1024PublicKeySize, kem: true, legacy: true},
+	KeyTypeDilithium3: {publicKeySize: Dilithium3PublicKeySize, signatureSize: Dilithium3SignatureSize, signing: true, legacy: true},
+	KeyTypeMLKEM768:   {publicKeySize: MLKEM768PublicKeySize, kem: true},
+	KeyTypeMLKEM1024:  {publicKeySize: MLKEM1024PublicKeySize, kem: true},
+	KeyTypeMLDSA65:    {publicKeySize: MLDSA65PublicKeySize, signatureSize: MLDSA65SignatureSize, signing: true},
+}
+
+// ParseKeyType validates a key type tag
+func ParseKeyType(s string) (KeyType, error) {
+	kt := KeyType(s)
+	if _, ok := keyTypes[kt]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKeyType, s)
+	}
+	return kt, nil
+}
+
+// IsLegacy reports whether the key type predates explicit tagging
+func (kt KeyType) IsLegacy() bool {
+	return keyTypes[kt].legacy
+}
+
+// PublicKeySize returns the encoded public key size, or 0 if unknown
+func (kt KeyType) PublicKeySize() int {
+	return keyTypes[kt].publicKeySize
+}
+
+// ValidateIdentityKey checks that kt is a signing type and publicKey has its size
+func (kt KeyType) ValidateIdentityKey(publicKey []byte) error {
+	info, ok := keyTypes[kt]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKeyType, kt)
+	}
+	if !info.signing {
+		return fmt.Errorf("%s cannot be used as an identity key", kt)
+	}
+	if len(publicKey) != info.publicKeySize {
+		return fmt.Errorf("invalid %s public key size: expected %d, got %d", kt, info.publicKeySize, len(publicKey))
+	}
+	return nil
+}
+
+// ValidatePreKey checks that kt is a KEM type and publicKey has its size
+func (kt KeyType) ValidatePreKey(publicKey []byte) error {
+	info, ok := keyTypes[kt]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKeyType, kt)
+	}
+	if !info.kem {
+		return fmt.Errorf("%s cannot be used as a prekey", kt)
+	}
+	if len(publicKey) != info.publicKeySize {
+		return fmt.Errorf("invalid %s public key size: expected %d, got %d", kt, info.publicKeySize, len(publicKey))
+	}
+	return nil
+}
+
+// ValidateSignature checks a signature's size for a signing key type
+func (kt KeyType) ValidateSignature(signature []byte) error {
+	info := keyTypes[kt]
+	switch {
+	case kt == KeyTypeP256:
+		if len(signature) < P256SignatureMinSize || len(signature) > P256SignatureMaxSize {
+			return fmt.Errorf("invalid %s signature size: got %d", kt, len(signature))
+		}
+	case info.signatureSize > 0:
+		if len(signature) != info.signatureSize {
+			return fmt.Errorf("invalid %s signature size: expected %d, got %d", kt, info.signatureSize, len(signature))
+		}
+	default:
+		return fmt.Errorf("%s is not a signature key type", kt)
+	}
+	return nil
+}
+
+// InferLegacyKeyType tags an untagged upload by length. It never returns an
+// ML-KEM/ML-DSA type; signing selects between identity and prekey types.
+func InferLegacyKeyType(publicKey []byte, signing bool) (KeyType, error) {
+	switch {
+	case len(publicKey) == P256PublicKeySize:
+		return KeyTypeP256, nil
+	case signing && len(publicKey) == Dilithium3PublicKeySize:
+		return KeyTypeDilithium3, nil
+	case !signing && len(publicKey) == X25519PublicKeySize:
+		return KeyTypeX25519, nil
+	case !signing && len(publicKey) == Kyber1024PublicKeySize:
+		return KeyTypeKyber1024, nil
+	default:
+		return "", fmt.Errorf("%w: cannot infer type of %d-byte key", ErrUnknownKeyType, len(publicKey))
+	}
+}
+
+// ResolveKeyType returns the tagged type, or infers a legacy type when tag is empty
+func ResolveKeyType(tag string, publicKey []byte, signing bool) (KeyType, error) {
+	if tag == "" {
+		return InferLegacyKeyType(publicKey, signing)
+	}
+	return ParseKeyType(tag)
+}
+
+// TaggedPublicKey encodes a key with its type: tag || 0x00 || public key.
+// Hybrid prekeys pass the X25519 key and PQ key concatenated.
+func TaggedPublicKey(kt KeyType, publicKey []byte) []byte {
+	out := make([]byte, 0, len(kt)+1+len(publicKey))
+	out = append(out, kt...)
+	out = append(out, 0x00)
+	return append(out, publicKey...)
+}
+
+// typedFingerprintDomain separates tagged fingerprints from plain SHA-256 ones
+const typedFingerprintDomain = "nochat-key-fingerprint-v1\x00"
+
+// TypedKeyFingerprint returns the fingerprint of a key of the given type.
+// Legacy types keep the untagged KeyFingerprint; hybrid marks an X25519||PQ key.
+func TypedKeyFingerprint(kt KeyType, publicKey []byte, hybrid bool) string {
+	if kt.IsLegacy() {
+		return KeyFingerprint(publicKey)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(typedFingerprintDomain))
+	h.Write(TaggedPublicKey(KeyType(kt.Label(hybrid)), publicKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Label names the key type as published, e.g. "x25519+ml-kem-768" for a hybrid prekey
+func (kt KeyType) Label(hybrid bool) string {
+	if hybrid {
+		return string(KeyTypeX25519) + "+" + string(kt)
+	}
+	return string(kt)
+}
+
+// VerifyTypedSignature verifies a signature made by a key of the given type
+func VerifyTypedSignature(kt KeyType, publicKey, message, signature []byte) (bool, error) {
+	switch kt {
+	case KeyTypeP256:
+		return VerifyP256Signature(publicKey, message, signature), nil
+	case KeyTypeDilithium3:
+		return Verify(publicKey, message, signature)
+	case KeyTypeMLDSA65:
+		return VerifyMLDSA65(publicKey, message, signature)
+	default:
+		return false, fmt.Errorf("%s is not a signature key type", kt)
+	}
+}
+
+// SignatureAlgorithm returns the cipher-suite signature algorithm for a signing key type
+func (kt KeyType) SignatureAlgorithm() string {
+	switch kt {
+	case KeyTypeP256:
+		return SigECDSAP256
+	case KeyTypeDilithium3:
+		return SigDilithium3
+	case KeyTypeMLDSA65:
+		return SigMLDSA65
+	default:
+		return ""
+	}
+}
+
+// KEMAlgorithm returns the cipher-suite KEM for a prekey type, paired with
+// X25519 when hybrid
+func (kt KeyType) KEMAlgorithm(hybrid bool) string {
+	if hybrid {
+		switch kt {
+		case KeyTypeKyber1024:
+			return KEMX25519Kyber1024
+		case KeyTypeMLKEM768:
+			return KEMX25519MLKEM768
+		case KeyTypeMLKEM1024:
+			return KEMX25519MLKEM1024
+		default:
+			return ""
+		}
+	}
+
+	switch kt {
+	case KeyTypeP256:
+		return KEMP256ECDH
+	case KeyTypeX25519:
+		return KEMX25519
+	case KeyTypeKyber1024:
+		return KEMKyber1024
+	case KeyTypeMLKEM768:
+		return KEMMLKEM768
+	case KeyTypeMLKEM1024:
+		return KEMMLKEM1024
+	default:
+		return ""
+	}
+}
+
+// PreKeySignedData returns the message an identity key signs for a prekey.
+// Legacy prekeys sign the raw key (EC||PQ for hybrid); ML-KEM prekeys sign
+// TaggedPublicKey so a signature can't be replayed under another key type.
+func PreKeySignedData(kt KeyType, ecPublicKey, publicKey []byte) []byte {
+	data := LastResortPreKeySignedData(ecPublicKey, publicKey)
+	if kt.IsLegacy() {
+		return data
+	}
+	return TaggedPublicKey(kt, data)
+}
+
+// PreKeyFingerprint returns the fingerprint of a prekey, hybrid when ecPublicKey is set
+func PreKeyFingerprint(kt KeyType, ecPublicKey, publicKey []byte) string {
+	return TypedKeyFingerprint(kt, LastResortPreKeySignedData(ecPublicKey, publicKey), len(ecPublicKey) > 0)
+}
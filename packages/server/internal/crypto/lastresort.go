@@ -0,0 +1,380 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+LAST-RESORT PREKEYS:
+One-time prekeys give each new session forward secrecy, but the pool is finite
+and anyone allowed to fetch a bundle can drain it. Rate limits only slow that
+down. Following PQXDH, each user also publishes a signed last-resort Kyber or
+ML-KEM prekey (hybrid users pair it with an X25519 key). It is never consumed: once
+the one-time pool is empty, bundles carry the last-resort key instead and set
+LastResortPreKeyUsed so the initiator knows the KEM key may be shared with
+other sessions.
+
+Uploading a new last-resort key marks the previous one replaced rather than
+deleting it, so initiators that fetched the old key can still complete the
+handshake.
+
+CLAIM ANOMALY SCORE:
+Every bundle claim is logged with its requester. A legitimate contact claims a
+handful of prekeys (one per new device or session reset); a requester that
+claims far more is draining the pool. The score sums each requester's claims
+beyond that allowance and maps the excess onto [0, 1).
+*/
+
+const (
+	// PreKeyClaimRetention is how long claim records are kept
+	PreKeyClaimRetention = 30 * 24 * time.Hour
+
+	// DefaultPreKeyClaimWindow is the window used when computing the anomaly score
+	DefaultPreKeyClaimWindow = 24 * time.Hour
+
+	// expectedClaimsPerRequester is the per-day claim allowance for one requester
+	expectedClaimsPerRequester = 3
+
+	// anomalyScoreScale is the excess claim count that yields a score of ~0.63
+	anomalyScoreScale = 20.0
+
+	// maxReportedRequesters bounds the requester list in a claim report
+	maxReportedRequesters = 20
+)
+
+// Prekey kinds recorded in prekey_claims
+const (
+	PreKeyKindOneTime    = "one_time"
+	PreKeyKindLastResort = "last_resort"
+	PreKeyKindNone       = "none"
+)
+
+// Anomaly levels
+const (
+	AnomalyLevelNormal   = "normal"
+	AnomalyLevelElevated = "elevated"
+	AnomalyLevelHigh     = "high"
+)
+
+// LastResortPreKey is a signed prekey handed out when no one-time prekeys remain
+type LastResortPreKey struct {
+	ID             uuid.UUID  `json:"id"`
+	UserID         uuid.UUID  `json:"user_id"`
+	KeyID          int        `json:"key_id"`
+	KeyType        KeyType    `json:"key_type"`                // PQ component type
+	ECPublicKey    []byte     `json:"ec_public_key,omitempty"` // X25519 (32 bytes), hybrid only
+	PQPublicKey    []byte     `json:"pq_public_key"`           // Kyber1024 (1568 bytes)
+	Signature      []byte     `json:"signature"`               // Signs PreKeySignedData
+	KeyFingerprint string     `json:"key_fingerprint"`
+	HybridVersion  int        `json:"hybrid_version"` // 1 = Kyber-only, 2 = PQXDH hybrid
+	UseCount       int        `json:"use_count"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// SignedData returns the bytes the identity key signs for this prekey
+func (k *LastResortPreKey) SignedData() []byte {
+	return PreKeySignedData(k.KeyType, k.ECPublicKey, k.PQPublicKey)
+}
+
+// LastResortPreKeySignedData returns the message signed for a last-resort
+// prekey: the Kyber key, or EC||PQ for hybrid keys (matching hybrid signed prekeys)
+func LastResortPreKeySignedData(ecPublicKey, pqPublicKey []byte) []byte {
+	if len(ecPublicKey) == 0 {
+		return pqPublicKey
+	}
+	data := make([]byte, 0, len(ecPublicKey)+len(pqPublicKey))
+	data = append(data, ecPublicKey...)
+	return append(data, pqPublicKey...)
+}
+
+// RequesterClaimStats summarizes one requester's claims against a user's bundle
+type RequesterClaimStats struct {
+	RequesterUserID  uuid.UUID `json:"requester_user_id"`
+	Claims           int       `json:"claims"`
+	LastResortClaims int       `json:"last_resort_claims"`
+	FirstClaimAt     time.Time `json:"first_claim_at"`
+	LastClaimAt      time.Time `json:"last_claim_at"`
+	// Set when the requester claimed more than the per-requester allowance
+	Suspicious bool `json:"suspicious"`
+}
+
+// PreKeyClaimReport describes recent claims against a user's prekeys
+type PreKeyClaimReport struct {
+	WindowStart             time.Time              `json:"window_start"`
+	WindowEnd               time.Time              `json:"window_end"`
+	TotalClaims             int                    `json:"total_claims"`
+	OneTimeClaims           int                    `json:"one_time_claims"`
+	LastResortClaims        int                    `json:"last_resort_claims"`
+	EmptyClaims             int                    `json:"empty_claims"`
+	DistinctRequesters      int                    `json:"distinct_requesters"`
+	AvailableOneTimePreKeys int                    `json:"available_one_time_prekeys"`
+	AnomalyScore            float64                `json:"anomaly_score"`
+	AnomalyLevel            string                 `json:"anomaly_level"`
+	Requesters              []*RequesterClaimStats `json:"requesters"`
+}
+
+// StoreLastResortPreKey stores a user's last-resort prekey, replacing the
+// active one of the same kind and type. ecPublicKey is nil for a PQ-only key;
+// an empty keyType means Kyber1024. The caller is responsible for verifying
+// the signature.
+func (s *Service) StoreLastResortPreKey(ctx context.Context, userID uuid.UUID, keyID int, keyType KeyType, ecPublicKey, pqPublicKey, signature []byte) (*LastResortPreKey, error) {
+	if keyType == "" {
+		keyType = KeyTypeKyber1024
+	}
+	if keyType != KeyTypeKyber1024 && keyType.IsLegacy() {
+		return nil, fmt.Errorf("last-resort prekeys must be Kyber1024 or ML-KEM, got %s", keyType)
+	}
+	hybridVersion := 1
+	if ecPublicKey != nil {
+		if len(ecPublicKey) != X25519PublicKeySize {
+			return nil, fmt.Errorf("invalid EC public key size: expected %d, got %d", X25519PublicKeySize, len(ecPublicKey))
+		}
+		hybridVersion = 2
+	}
+	if err := keyType.ValidatePreKey(pqPublicKey); err != nil {
+		return nil, err
+	}
+	if !IsValidSignatureSize(signature) {
+		return nil, fmt.Errorf("invalid signature size: got %d", len(signature))
+	}
+	if err := s.suites.CheckPreKey(keyType, ecPublicKey != nil); err != nil {
+		return nil, err
+	}
+
+	prekey := &LastResortPreKey{
+		ID:             uuid.New(),
+		UserID:         userID,
+		KeyID:          keyID,
+		KeyType:        keyType,
+		ECPublicKey:    ecPublicKey,
+		PQPublicKey:    pqPublicKey,
+		Signature:      signature,
+		KeyFingerprint: PreKeyFingerprint(keyType, ecPublicKey, pqPublicKey),
+		HybridVersion:  hybridVersion,
+		CreatedAt:      time.Now(),
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE last_resort_prekeys
+		SET status = 'replaced', replaced_at = NOW()
+		WHERE user_id = $1 AND hybrid_version = $2 AND key_type = $3 AND status = 'active'
+	`, userID, hybridVersion, string(keyType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace last-resort prekey: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO last_resort_prekeys (id, user_id, key_id, key_type, ec_public_key, kyber_public_key, signature,
+			key_fingerprint, hybrid_version, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'active', $10)
+	`, prekey.ID, userID, keyID, string(keyType), ecPublicKey, pqPublicKey, signature, prekey.KeyFingerprint, hybridVersion, prekey.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store last-resort prekey: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit last-resort prekey: %w", err)
+	}
+
+	return prekey, nil
+}
+
+// lastResortColumns is the column list scanned by scanLastResortPreKey
+const lastResortColumns = `id, user_id, key_id, key_type, ec_public_key, kyber_public_key, signature,
+	key_fingerprint, hybrid_version, use_count, last_used_at, created_at`
+
+// scanLastResortPreKey scans a row selected with lastResortColumns
+func scanLastResortPreKey(row interface{ Scan(...interface{}) error }) (*LastResortPreKey, error) {
+	prekey := &LastResortPreKey{}
+	var keyType string
+	err := row.Scan(&prekey.ID, &prekey.UserID, &prekey.KeyID, &keyType, &prekey.ECPublicKey, &prekey.PQPublicKey,
+		&prekey.Signature, &prekey.KeyFingerprint, &prekey.HybridVersion, &prekey.UseCount,
+		&prekey.LastUsedAt, &prekey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	prekey.KeyType = KeyType(keyType)
+	return prekey, nil
+}
+
+// GetLastResortPreKey returns a user's active last-resort prekey of the given
+// kind (1 = PQ-only, 2 = hybrid) and type. Returns nil if none is published.
+func (s *Service) GetLastResortPreKey(ctx context.Context, userID uuid.UUID, hybridVersion int, keyType KeyType) (*LastResortPreKey, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+lastResortColumns+`
+		FROM last_resort_prekeys
+		WHERE user_id = $1 AND hybrid_version = $2 AND key_type = $3 AND status = 'active'
+	`, userID, hybridVersion, string(keyType))
+
+	prekey, err := scanLastResortPreKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last-resort prekey: %w", err)
+	}
+
+	return prekey, nil
+}
+
+// useLastResortPreKey returns the active last-resort prekey and counts the use.
+// Returns nil if none is published.
+func (s *Service) useLastResortPreKey(ctx context.Context, userID uuid.UUID, hybridVersion int, keyType KeyType) (*LastResortPreKey, error) {
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE last_resort_prekeys
+		SET use_count = use_count + 1, last_used_at = NOW()
+		WHERE user_id = $1 AND hybrid_version = $2 AND key_type = $3 AND status = 'active'
+		RETURNING `+lastResortColumns,
+		userID, hybridVersion, string(keyType))
+
+	prekey, err := scanLastResortPreKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to use last-resort prekey: %w", err)
+	}
+
+	return prekey, nil
+}
+
+// recordPreKeyClaim logs a bundle claim. Failures are logged rather than
+// returned so a claim log outage never blocks session setup.
+func (s *Service) recordPreKeyClaim(ctx context.Context, targetUserID, requestingUserID uuid.UUID, kind string) {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO prekey_claims (target_user_id, requester_user_id, prekey_kind, claimed_at)
+		VALUES ($1, $2, $3, NOW())
+	`, targetUserID, requestingUserID, kind)
+	if err != nil {
+		log.Printf("[Crypto] Failed to record prekey claim on %s by %s: %v", targetUserID, requestingUserID, err)
+	}
+}
+
+// GetPreKeyClaimReport summarizes claims against a user's prekeys over the
+// given window, including the anomaly score
+func (s *Service) GetPreKeyClaimReport(ctx context.Context, userID uuid.UUID, window time.Duration) (*PreKeyClaimReport, error) {
+	if window <= 0 {
+		window = DefaultPreKeyClaimWindow
+	}
+	if window > PreKeyClaimRetention {
+		window = PreKeyClaimRetention
+	}
+
+	now := time.Now()
+	report := &PreKeyClaimReport{
+		WindowStart: now.Add(-window),
+		WindowEnd:   now,
+		Requesters:  []*RequesterClaimStats{},
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT requester_user_id,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE prekey_kind = 'one_time'),
+		       COUNT(*) FILTER (WHERE prekey_kind = 'last_resort'),
+		       MIN(claimed_at),
+		       MAX(claimed_at)
+		FROM prekey_claims
+		WHERE target_user_id = $1 AND claimed_at >= $2
+		GROUP BY requester_user_id
+		ORDER BY COUNT(*) DESC, MAX(claimed_at) DESC
+	`, userID, report.WindowStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prekey claims: %w", err)
+	}
+	defer rows.Close()
+
+	allowance := claimAllowance(window)
+	excess := 0
+	for rows.Next() {
+		stats := &RequesterClaimStats{}
+		var oneTime int
+		if err := rows.Scan(&stats.RequesterUserID, &stats.Claims, &oneTime, &stats.LastResortClaims,
+			&stats.FirstClaimAt, &stats.LastClaimAt); err != nil {
+			return nil, fmt.Errorf("failed to scan prekey claims: %w", err)
+		}
+
+		report.TotalClaims += stats.Claims
+		report.OneTimeClaims += oneTime
+		report.LastResortClaims += stats.LastResortClaims
+		report.EmptyClaims += stats.Claims - oneTime - stats.LastResortClaims
+		report.DistinctRequesters++
+
+		if stats.Claims > allowance {
+			stats.Suspicious = true
+			excess += stats.Claims - allowance
+		}
+		if len(report.Requesters) < maxReportedRequesters {
+			report.Requesters = append(report.Requesters, stats)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read prekey claims: %w", err)
+	}
+
+	report.AvailableOneTimePreKeys, err = s.GetAvailableOneTimePreKeyCount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	report.AnomalyScore = PreKeyClaimAnomalyScore(excess)
+	report.AnomalyLevel = anomalyLevel(report.AnomalyScore)
+
+	return report, nil
+}
+
+// claimAllowance scales the per-requester allowance to the window, rounding up to whole days
+func claimAllowance(window time.Duration) int {
+	days := int(math.Ceil(window.Hours() / 24))
+	if days < 1 {
+		days = 1
+	}
+	return expectedClaimsPerRequester * days
+}
+
+// PreKeyClaimAnomalyScore maps the number of claims beyond per-requester
+// allowances onto [0, 1): 0 for none, ~0.63 at anomalyScoreScale
+func PreKeyClaimAnomalyScore(excessClaims int) float64 {
+	if excessClaims <= 0 {
+		return 0
+	}
+	return 1 - math.Exp(-float64(excessClaims)/anomalyScoreScale)
+}
+
+// anomalyLevel buckets an anomaly score for display
+func anomalyLevel(score float64) string {
+	switch {
+	case score >= 0.6:
+		return AnomalyLevelHigh
+	case score >= 0.25:
+		return AnomalyLevelElevated
+	default:
+		return AnomalyLevelNormal
+	}
+}
+
+// PrunePreKeyClaims deletes claim records older than PreKeyClaimRetention
+func (s *Service) PrunePreKeyClaims(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM prekey_claims WHERE claimed_at < $1
+	`, time.Now().Add(-PreKeyClaimRetention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune prekey claims: %w", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+	"sync/atomic"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+/*
+P-256 ECDSA VERIFICATION:
+Web clients sign their prekeys with Web Crypto's ECDSA (P-256, SHA-256), which
+produces raw R||S signatures (64 bytes). Other clients may send ASN.1 DER.
+Both forms are accepted; DER must be strictly encoded (minimal integers, no
+trailing data), matching Wycheproof's expectations.
+
+STRICT MODE:
+ECDSA signatures are malleable: (r, s) and (r, n-s) both verify. Strict mode
+additionally rejects high-S signatures, which removes that malleability. It
+does not make signatures unique: the same (r, s) is still accepted both as raw
+R||S and as DER, and a signer can always produce a fresh signature, so callers
+must not use signature bytes as an identifier. Web Crypto does not normalize S,
+so strict mode should only be enabled once all clients produce low-S signatures.
+*/
+
+// p256RawSignatureSize is the size of a raw R||S signature
+const p256RawSignatureSize = 64
+
+var (
+	p256Strict atomic.Bool
+
+	p256Curve     = elliptic.P256()
+	p256HalfOrder = new(big.Int).Rsh(p256Curve.Params().N, 1)
+)
+
+// SetP256StrictMode enables or disables rejection of high-S P-256 signatures
+func SetP256StrictMode(strict bool) {
+	p256Strict.Store(strict)
+}
+
+// P256StrictMode reports whether strict P-256 verification is enabled
+func P256StrictMode() bool {
+	return p256Strict.Load()
+}
+
+// VerifyP256Signature verifies an ECDSA P-256/SHA-256 signature over message.
+// publicKey is an uncompressed point; signature is DER or raw R||S.
+func VerifyP256Signature(publicKey, message, signature []byte) bool {
+	return verifyP256(publicKey, message, signature, P256StrictMode())
+}
+
+// verifyP256 verifies a signature with an explicit strictness setting
+func verifyP256(publicKey, message, signature []byte, strict bool) bool {
+	pub := parseP256PublicKey(publicKey)
+	if pub == nil {
+		return false
+	}
+
+	hash := sha256.Sum256(message)
+
+	// A 64-byte signature is usually raw R||S, but can also be a short DER
+	// encoding, so try every interpretation the bytes admit
+	for _, parse := range []func([]byte) (*big.Int, *big.Int, bool){parseP256DER, parseP256Raw} {
+		r, sig, ok := parse(signature)
+		if !ok {
+			continue
+		}
+		if !validP256Scalars(r, sig, strict) {
+			continue
+		}
+		if ecdsa.Verify(pub, hash[:], r, sig) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseP256PublicKey decodes an uncompressed P-256 point, rejecting points
+// that are not on the curve
+func parseP256PublicKey(publicKey []byte) *ecdsa.PublicKey {
+	if len(publicKey) != P256PublicKeySize || publicKey[0] != 0x04 {
+		return nil
+	}
+
+	x := new(big.Int).SetBytes(publicKey[1:33])
+	y := new(big.Int).SetBytes(publicKey[33:])
+	p := p256Curve.Params().P
+	if x.Cmp(p) >= 0 || y.Cmp(p) >= 0 {
+		return nil
+	}
+	if !p256Curve.IsOnCurve(x, y) {
+		return nil
+	}
+
+	return &ecdsa.PublicKey{Curve: p256Curve, X: x, Y: y}
+}
+
+// parseP256Raw splits a 64-byte R||S signature
+func parseP256Raw(signature []byte) (*big.Int, *big.Int, bool) {
+	if len(signature) != p256RawSignatureSize {
+		return nil, nil, false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return r, s, true
+}
+
+// parseP256DER parses a strict DER ECDSA-Sig-Value: SEQUENCE { r INTEGER, s INTEGER }
+func parseP256DER(signature []byte) (*big.Int, *big.Int, bool) {
+	if len(signature) > P256SignatureMaxSize {
+		return nil, nil, false
+	}
+
+	var inner cryptobyte.String
+	input := cryptobyte.String(signature)
+	r, s := new(big.Int), new(big.Int)
+	if !input.ReadASN1(&inner, asn1.SEQUENCE) ||
+		!input.Empty() ||
+		!inner.ReadASN1Integer(r) ||
+		!inner.ReadASN1Integer(s) ||
+		!inner.Empty() {
+		return nil, nil, false
+	}
+
+	return r, s, true
+}
+
+// validP256Scalars checks 0 < r, s < n, and s <= n/2 in strict mode
+func validP256Scalars(r, s *big.Int, strict bool) bool {
+	n := p256Curve.Params().N
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+	if strict && s.Cmp(p256HalfOrder) > 0 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,280 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// Wycheproof-style result for a signature vector
+const (
+	vectorResultValid      = "valid"
+	vectorResultInvalid    = "invalid"
+	vectorResultAcceptable = "acceptable" // Valid, but rejected in strict mode
+)
+
+// p256SignatureVector is a known-answer test for VerifyP256Signature.
+// Fields are hex encoded, following the layout of Wycheproof's ecdsa_*_test files.
+type p256SignatureVector struct {
+	TcID      int
+	Comment   string
+	Flags     []string
+	PublicKey string
+	Message   string
+	Signature string
+	Result    string
+}
+
+// p256SignatureVectors covers raw and DER encodings, malleability, scalar
+// range checks, BER encodings that strict DER must reject, and invalid keys
+var p256SignatureVectors = []p256SignatureVector{
+	{
+		TcID:      1,
+		Comment:   "valid raw r||s",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultValid,
+	},
+	{
+		TcID:      2,
+		Comment:   "valid DER",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "3045022100fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac502202aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultValid,
+	},
+	{
+		TcID:      3,
+		Comment:   "valid raw r||s over empty message",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "",
+		Signature: "48f9979f669725a04feba7468a0ea35b011c2aaef68a07120ae4a04fd60f8336131691862211a4377db8eea109f06b5c3269b080fa17323ca52c5b285329c5cc",
+		Result:    vectorResultValid,
+	},
+	{
+		TcID:      4,
+		Comment:   "high-S raw r||s",
+		Flags:     []string{"HighS"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac5d5581f17bd715b921326789b06d26f00587fff8aaa5bef0e48703baf8b16b61b",
+		Result:    vectorResultAcceptable,
+	},
+	{
+		TcID:      5,
+		Comment:   "high-S DER",
+		Flags:     []string{"HighS"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "3046022100fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac5022100d5581f17bd715b921326789b06d26f00587fff8aaa5bef0e48703baf8b16b61b",
+		Result:    vectorResultAcceptable,
+	},
+	{
+		TcID:      6,
+		Comment:   "modified message",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b657920627974657a",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      7,
+		Comment:   "r with flipped bit",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac42aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      8,
+		Comment:   "s with flipped bit",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f37",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      9,
+		Comment:   "r = 0",
+		Flags:     []string{"ZeroScalar"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "00000000000000000000000000000000000000000000000000000000000000002aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      10,
+		Comment:   "s = 0",
+		Flags:     []string{"ZeroScalar"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac50000000000000000000000000000000000000000000000000000000000000000",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      11,
+		Comment:   "r = n",
+		Flags:     []string{"RangeCheck"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "3045022100ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc63255102202aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      12,
+		Comment:   "s = n",
+		Flags:     []string{"RangeCheck"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "3046022100fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac5022100ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      13,
+		Comment:   "r + n",
+		Flags:     []string{"RangeCheck"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "3045022101fa8c1e4dc68b658bb87ebce084f3de27e2f933f15f7ec2df17bbbfc46f88101602202aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      14,
+		Comment:   "DER with trailing garbage",
+		Flags:     []string{"BerEncoding"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "3045022100fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac502202aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f3600",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      15,
+		Comment:   "DER integer with unnecessary leading zero",
+		Flags:     []string{"BerEncoding"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "3046022100fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac50221002aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      16,
+		Comment:   "DER long-form length",
+		Flags:     []string{"BerEncoding"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "308145022100fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac502202aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      17,
+		Comment:   "DER negative r",
+		Flags:     []string{"BerEncoding"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "30440220fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac502202aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      18,
+		Comment:   "DER r and s swapped",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "304502202aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36022100fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac5",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      19,
+		Comment:   "raw signature with extra byte",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f3600",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      20,
+		Comment:   "raw signature truncated",
+		Flags:     nil,
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      21,
+		Comment:   "signature from another key",
+		Flags:     nil,
+		PublicKey: "044bcd3c5eaf74056ab4749a03fce9eb48ac2778c1b967407d5880f3017239d46ac33ca7a09a2c03ce55d021607fec36bec18df433f251908b8e08214840cf198a",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      22,
+		Comment:   "public key not on curve",
+		Flags:     []string{"InvalidPublicKey"},
+		PublicKey: "040013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9a",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      23,
+		Comment:   "compressed public key",
+		Flags:     []string{"InvalidPublicKey"},
+		PublicKey: "030013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+	{
+		TcID:      24,
+		Comment:   "public key with invalid prefix",
+		Flags:     []string{"InvalidPublicKey"},
+		PublicKey: "050013212eb623c5e4fce6dac2edfc3733a518b0eb8378feb1ae550bd5ffb9e313abb08c5993ea366d7eede34ef267242e0d861768b4ffb94b315eef12699bfe9b",
+		Message:   "7369676e6564207072656b6579206279746573",
+		Signature: "fa8c1e4ec68b658ab87ebce084f3de2826123943b867245a2401f5017324eac52aa7e0e7428ea46eecd98764f92d90ff6466fb22fcbbaf76ab498f13714c6f36",
+		Result:    vectorResultInvalid,
+	},
+}
+
+// checkP256SignatureVectors runs every vector through the verifier with the
+// given strictness and returns an error for the first mismatch
+func checkP256SignatureVectors(strict bool) error {
+	for _, v := range p256SignatureVectors {
+		publicKey, err := hex.DecodeString(v.PublicKey)
+		if err != nil {
+			return fmt.Errorf("vector %d: invalid public key hex: %w", v.TcID, err)
+		}
+		message, err := hex.DecodeString(v.Message)
+		if err != nil {
+			return fmt.Errorf("vector %d: invalid message hex: %w", v.TcID, err)
+		}
+		signature, err := hex.DecodeString(v.Signature)
+		if err != nil {
+			return fmt.Errorf("vector %d: invalid signature hex: %w", v.TcID, err)
+		}
+
+		expected := v.Result == vectorResultValid || (v.Result == vectorResultAcceptable && !strict)
+		if got := verifyP256(publicKey, message, signature, strict); got != expected {
+			return fmt.Errorf("vector %d (%s): expected valid=%v, got %v", v.TcID, v.Comment, expected, got)
+		}
+	}
+	return nil
+}
+
+func TestP256SignatureVectors(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		if err := checkP256SignatureVectors(strict); err != nil {
+			t.Errorf("strict=%v: %v", strict, err)
+		}
+	}
+}
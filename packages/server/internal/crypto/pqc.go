@@ -2,10 +2,13 @@
 Package crypto provides Post-Quantum Cryptography (PQC) primitives.
 
 ALGORITHMS IMPLEMENTED:
-  - CRYSTALS-Kyber-1024: ML-KEM key encapsulation (NIST standardized)
-  - CRYSTALS-Dilithium3: ML-DSA digital signatures (NIST standardized)
+  - CRYSTALS-Kyber-1024: round-3 key encapsulation (pre-FIPS 203)
+  - CRYSTALS-Dilithium3: round-3 digital signatures (pre-FIPS 204)
   - X25519: Classical ECDH for hybrid key exchange
 
+The final ML-KEM (FIPS 203) and ML-DSA (FIPS 204) standards are implemented in
+fips.go. They are not wire-compatible with the round-3 algorithms above.
+
 LIBRARY: cloudflare/circl
 All PQC operations use Cloudflare's CIRCL library which provides
 well-audited implementations of NIST PQC standards.
@@ -391,38 +394,21 @@ func IsValidPreKeySize(publicKey []byte) bool {
 }
 
 // IsValidSignatureSize checks if a signature has a valid size
-// Accepts both P-256 ECDSA and Dilithium3 signatures
+// Accepts P-256 ECDSA, Dilithium3 and ML-DSA-65 signatures
 func IsValidSignatureSize(signature []byte) bool {
 	sigLen := len(signature)
 	// P-256 ECDSA: 64 bytes (raw R||S) or up to 72 bytes (DER encoded)
 	// Dilithium3: exactly 3293 bytes
+	// ML-DSA-65: exactly 3309 bytes
 	return (sigLen >= P256SignatureMinSize && sigLen <= P256SignatureMaxSize) ||
-		sigLen == Dilithium3SignatureSize
-}
-
-// VerifyP256Signature verifies a P-256 ECDSA signature
-// This is a placeholder - actual verification requires crypto/ecdsa
-// For now, we skip signature verification on P-256 keys since
-// the frontend generates both keys, making server-side verification unnecessary
-// (the server is already zero-trust and doesn't need to verify client signatures)
-func VerifyP256Signature(publicKey, message, signature []byte) bool {
-	// In a zero-trust model, the server doesn't need to verify signatures
-	// because it never sees plaintext data anyway. The signature is for
-	// peer-to-peer verification between clients.
-	// We just validate the sizes are reasonable.
-	if len(publicKey) != P256PublicKeySize {
-		return false
-	}
-	if len(signature) < P256SignatureMinSize || len(signature) > P256SignatureMaxSize {
-		return false
-	}
-	return true
+		sigLen == Dilithium3SignatureSize ||
+		sigLen == MLDSA65SignatureSize
 }
 
 // VerifyAnySignature verifies a signature using either P-256 or Dilithium3
 func VerifyAnySignature(publicKey, message, signature []byte) (bool, error) {
 	if IsP256Key(publicKey) {
-		// P-256 signature verification (size check only in zero-trust model)
+		// P-256 ECDSA-SHA256 (Web Crypto), DER or raw R||S
 		return VerifyP256Signature(publicKey, message, signature), nil
 	}
 	// PQC Dilithium signature verification
@@ -467,9 +453,10 @@ type SealedEnvelope struct {
 // SealedSenderEncrypt encrypts an inner envelope to a recipient's sealed sender public key
 // Returns the sealed envelope containing the encrypted inner envelope
 //
-// Note: This function is primarily for testing/reference. In production,
-// the actual sealed sender encryption happens on the client side.
-// The server never sees the inner envelope or performs this encryption.
+// Note: This function is primarily for testing. In production, the actual
+// sealed sender encryption happens on the client side and the server never
+// sees the inner envelope. It is not wire-compatible with the clients; the
+// reference implementation of their format is pkg/pqxdh.
 func SealedSenderEncrypt(innerEnvelope []byte, recipientPublicKey []byte) (*SealedEnvelope, error) {
 	if len(recipientPublicKey) != Kyber1024PublicKeySize {
 		return nil, fmt.Errorf("invalid recipient public key size: expected %d, got %d", Kyber1024PublicKeySize, len(recipientPublicKey))
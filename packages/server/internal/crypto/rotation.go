@@ -0,0 +1,351 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+KEY ROTATION POLICY:
+Signed prekeys and sealed sender keys are medium-term keys; rotating them
+limits how much traffic a compromised key exposes. The server cannot rotate
+them itself (private keys live on the client), so it enforces the policy by:
+
+  - stamping expires_at = created_at + max age on upload
+  - notifying connected clients when a key enters the warning window
+  - flagging (or refusing) bundles whose signed prekey is past the hard limit
+  - keeping rotated keys for a grace window, then marking them expired
+
+The grace window exists for in-flight sessions: a peer may have fetched the old
+bundle just before rotation, and the owner's client needs to know how long to
+keep the matching private key.
+*/
+
+// Stale bundle handling
+const (
+	StaleBundleFlag   = "flag"   // Serve the bundle with signed_prekey_stale set
+	StaleBundleRefuse = "refuse" // Refuse to serve the bundle
+)
+
+// Rotation policy defaults
+const (
+	DefaultSignedPreKeyMaxAge    = 7 * 24 * time.Hour
+	DefaultSignedPreKeyHardLimit = 14 * 24 * time.Hour
+	DefaultSealedSenderKeyMaxAge = 30 * 24 * time.Hour
+	DefaultRotationWarningWindow = 24 * time.Hour
+	DefaultRotationGracePeriod   = 7 * 24 * time.Hour
+	DefaultRotationCheckInterval = time.Hour
+	// rotationRenotifyInterval limits how often the same key triggers a notice
+	rotationRenotifyInterval = 6 * time.Hour
+)
+
+// ErrStaleSignedPreKey is returned when a bundle's signed prekey is past the
+// hard limit and the policy refuses stale bundles
+var ErrStaleSignedPreKey = errors.New("signed prekey is past its hard expiry")
+
+// Key types carried in rotation notices
+const (
+	RotationKeySignedPreKey = "signed_prekey"
+	RotationKeySealedSender = "sealed_sender"
+)
+
+// Reasons recorded in key_rotation_log; must match the table's CHECK constraint
+const (
+	RotationReasonScheduled   = "scheduled"
+	RotationReasonCompromised = "compromised"
+	RotationReasonManual      = "manual"
+	RotationReasonInitial     = "initial"
+)
+
+// RotationPolicy configures signed prekey and sealed sender key lifetimes
+type RotationPolicy struct {
+	SignedPreKeyMaxAge    time.Duration // Rotation is due after this age
+	SignedPreKeyHardLimit time.Duration // Bundles are flagged/refused after this age
+	SealedSenderKeyMaxAge time.Duration // Sealed sender keys expire after this age
+	WarningWindow         time.Duration // Clients are notified this long before expiry
+	GracePeriod           time.Duration // Rotated keys are kept this long
+	CheckInterval         time.Duration // How often the rotation monitor runs
+	StaleBundleAction     string        // StaleBundleFlag or StaleBundleRefuse
+}
+
+// DefaultRotationPolicy returns the built-in rotation policy
+func DefaultRotationPolicy() RotationPolicy {
+	return RotationPolicy{
+		SignedPreKeyMaxAge:    DefaultSignedPreKeyMaxAge,
+		SignedPreKeyHardLimit: DefaultSignedPreKeyHardLimit,
+		SealedSenderKeyMaxAge: DefaultSealedSenderKeyMaxAge,
+		WarningWindow:         DefaultRotationWarningWindow,
+		GracePeriod:           DefaultRotationGracePeriod,
+		CheckInterval:         DefaultRotationCheckInterval,
+		StaleBundleAction:     StaleBundleFlag,
+	}
+}
+
+// RotationPolicyFromEnv reads the rotation policy from KEY_ROTATION_* variables,
+// falling back to defaults for anything unset
+func RotationPolicyFromEnv() (RotationPolicy, error) {
+	policy := DefaultRotationPolicy()
+
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"KEY_ROTATION_PREKEY_MAX_AGE", &policy.SignedPreKeyMaxAge},
+		{"KEY_ROTATION_PREKEY_HARD_LIMIT", &policy.SignedPreKeyHardLimit},
+		{"KEY_ROTATION_SEALED_SENDER_MAX_AGE", &policy.SealedSenderKeyMaxAge},
+		{"KEY_ROTATION_WARNING_WINDOW", &policy.WarningWindow},
+		{"KEY_ROTATION_GRACE_PERIOD", &policy.GracePeriod},
+		{"KEY_ROTATION_CHECK_INTERVAL", &policy.CheckInterval},
+	}
+	for _, d := range durations {
+		v := os.Getenv(d.env)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s: %w", d.env, err)
+		}
+		*d.dst = parsed
+	}
+
+	if v := os.Getenv("KEY_ROTATION_STALE_BUNDLES"); v != "" {
+		policy.StaleBundleAction = v
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate checks the policy for inconsistent values
+func (p RotationPolicy) Validate() error {
+	if p.SignedPreKeyMaxAge <= 0 || p.SealedSenderKeyMaxAge <= 0 || p.CheckInterval <= 0 {
+		return fmt.Errorf("key max ages and check interval must be positive")
+	}
+	if p.SignedPreKeyHardLimit < p.SignedPreKeyMaxAge {
+		return fmt.Errorf("signed prekey hard limit (%s) must not be shorter than max age (%s)",
+			p.SignedPreKeyHardLimit, p.SignedPreKeyMaxAge)
+	}
+	if p.WarningWindow < 0 || p.GracePeriod < 0 {
+		return fmt.Errorf("warning window and grace period must not be negative")
+	}
+	if p.StaleBundleAction != StaleBundleFlag && p.StaleBundleAction != StaleBundleRefuse {
+		return fmt.Errorf("stale bundle action must be %q or %q", StaleBundleFlag, StaleBundleRefuse)
+	}
+	return nil
+}
+
+// SignedPreKeyAgeStatus describes where a signed prekey is in its lifetime
+type SignedPreKeyAgeStatus struct {
+	RotateBy      time.Time `json:"rotate_by"`       // created_at + max age
+	HardExpiresAt time.Time `json:"hard_expires_at"` // created_at + hard limit
+	RotationDue   bool      `json:"rotation_due"`
+	Stale         bool      `json:"stale"`
+}
+
+// SignedPreKeyStatus evaluates a signed prekey's age against the policy
+func (p RotationPolicy) SignedPreKeyStatus(createdAt time.Time, now time.Time) SignedPreKeyAgeStatus {
+	status := SignedPreKeyAgeStatus{
+		RotateBy:      createdAt.Add(p.SignedPreKeyMaxAge),
+		HardExpiresAt: createdAt.Add(p.SignedPreKeyHardLimit),
+	}
+	status.RotationDue = !now.Before(status.RotateBy)
+	status.Stale = !now.Before(status.HardExpiresAt)
+	return status
+}
+
+// RotationNotifier is told when a user's key is close to (or past) expiry.
+// NotifyKeyRotationDue reports whether the notice reached any of the user's clients.
+type RotationNotifier interface {
+	NotifyKeyRotationDue(userID uuid.UUID, keyType string, keyID int, expiresAt, hardExpiresAt, graceUntil time.Time) bool
+}
+
+// SetRotationPolicy replaces the service's rotation policy
+func (s *Service) SetRotationPolicy(policy RotationPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	s.rotationPolicy = policy
+	return nil
+}
+
+// RotationPolicy returns the service's rotation policy
+func (s *Service) RotationPolicy() RotationPolicy {
+	return s.rotationPolicy
+}
+
+// SetRotationNotifier sets the notifier used by the rotation monitor
+func (s *Service) SetRotationNotifier(n RotationNotifier) {
+	s.rotationNotifier = n
+}
+
+// checkSignedPreKeyAge applies the stale-bundle policy to a signed prekey.
+// Returns whether the key is past max age and past the hard limit.
+func (s *Service) checkSignedPreKeyAge(createdAt time.Time) (due bool, stale bool, err error) {
+	status := s.rotationPolicy.SignedPreKeyStatus(createdAt, time.Now())
+	if status.Stale && s.rotationPolicy.StaleBundleAction == StaleBundleRefuse {
+		return status.RotationDue, status.Stale, ErrStaleSignedPreKey
+	}
+	return status.RotationDue, status.Stale, nil
+}
+
+// StartRotationMonitor runs the rotation monitor until ctx is cancelled
+func (s *Service) StartRotationMonitor(ctx context.Context) {
+	ticker := time.NewTicker(s.rotationPolicy.CheckInterval)
+	defer ticker.Stop()
+
+	s.runRotationCheck(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			s.runRotationCheck(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runRotationCheck performs one pass of the rotation monitor, logging failures
+func (s *Service) runRotationCheck(ctx context.Context) {
+	notified, err := s.notifyExpiringKeys(ctx)
+	if err != nil {
+		log.Printf("[Crypto] Rotation check failed: %v", err)
+	}
+
+	expired, err := s.ExpireRotatedKeys(ctx)
+	if err != nil {
+		log.Printf("[Crypto] Failed to expire rotated keys: %v", err)
+	}
+
+	if notified > 0 || expired > 0 {
+		log.Printf("[Crypto] Rotation check: %d rotation notices sent, %d rotated keys expired", notified, expired)
+	}
+
+	if _, err := s.PrunePreKeyClaims(ctx); err != nil {
+		log.Printf("[Crypto] Failed to prune prekey claims: %v", err)
+	}
+}
+
+// notifyExpiringKeys notifies owners of active keys inside the warning window.
+// A key is marked notified only once a notice was delivered, so users who are
+// offline (or a server without a notifier) get the warning on a later pass.
+// Each key is re-notified at most every rotationRenotifyInterval.
+func (s *Service) notifyExpiringKeys(ctx context.Context) (int, error) {
+	if s.rotationNotifier == nil {
+		return 0, nil
+	}
+
+	policy := s.rotationPolicy
+	warnAt := time.Now().Add(policy.WarningWindow)
+
+	type notice struct {
+		userID        uuid.UUID
+		keyType       string
+		keyID         int
+		expiresAt     time.Time
+		hardExpiresAt time.Time
+	}
+	var notices []notice
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, key_id, created_at
+		FROM signed_prekeys
+		WHERE status = 'active'
+		  AND created_at + make_interval(secs => $1) <= $2
+		  AND (rotation_notified_at IS NULL OR rotation_notified_at < NOW() - make_interval(secs => $3))
+	`, policy.SignedPreKeyMaxAge.Seconds(), warnAt, rotationRenotifyInterval.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expiring signed prekeys: %w", err)
+	}
+	for rows.Next() {
+		var n notice
+		var createdAt time.Time
+		if err := rows.Scan(&n.userID, &n.keyID, &createdAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan expiring signed prekey: %w", err)
+		}
+		status := policy.SignedPreKeyStatus(createdAt, time.Now())
+		n.keyType = RotationKeySignedPreKey
+		n.expiresAt = status.RotateBy
+		n.hardExpiresAt = status.HardExpiresAt
+		notices = append(notices, n)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT user_id, key_version, created_at
+		FROM sealed_sender_keys
+		WHERE status = 'active'
+		  AND created_at + make_interval(secs => $1) <= $2
+		  AND (rotation_notified_at IS NULL OR rotation_notified_at < NOW() - make_interval(secs => $3))
+	`, policy.SealedSenderKeyMaxAge.Seconds(), warnAt, rotationRenotifyInterval.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expiring sealed sender keys: %w", err)
+	}
+	for rows.Next() {
+		var n notice
+		var createdAt time.Time
+		if err := rows.Scan(&n.userID, &n.keyID, &createdAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan expiring sealed sender key: %w", err)
+		}
+		n.keyType = RotationKeySealedSender
+		n.expiresAt = createdAt.Add(policy.SealedSenderKeyMaxAge)
+		n.hardExpiresAt = n.expiresAt // Sealed sender keys are not served past expiry
+		notices = append(notices, n)
+	}
+	rows.Close()
+
+	delivered := 0
+	for _, n := range notices {
+		// Rotating now keeps the old key valid for in-flight sessions until graceUntil
+		graceUntil := time.Now().Add(policy.GracePeriod)
+		if !s.rotationNotifier.NotifyKeyRotationDue(n.userID, n.keyType, n.keyID, n.expiresAt, n.hardExpiresAt, graceUntil) {
+			continue
+		}
+
+		query := `UPDATE signed_prekeys SET rotation_notified_at = NOW() WHERE user_id = $1 AND key_id = $2`
+		if n.keyType == RotationKeySealedSender {
+			query = `UPDATE sealed_sender_keys SET rotation_notified_at = NOW() WHERE user_id = $1 AND key_version = $2`
+		}
+		if _, err := s.db.ExecContext(ctx, query, n.userID, n.keyID); err != nil {
+			return delivered, fmt.Errorf("failed to mark rotation notice sent: %w", err)
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// ExpireRotatedKeys marks rotated signed prekeys and sealed sender keys as
+// expired once their grace window has passed
+func (s *Service) ExpireRotatedKeys(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-s.rotationPolicy.GracePeriod)
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE signed_prekeys
+		SET status = 'expired'
+		WHERE status = 'rotated' AND rotated_at < $1
+	`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire rotated signed prekeys: %w", err)
+	}
+	prekeys, _ := result.RowsAffected()
+
+	result, err = s.db.ExecContext(ctx, `
+		UPDATE sealed_sender_keys
+		SET status = 'expired'
+		WHERE status = 'rotated' AND rotated_at < $1
+	`, cutoff)
+	if err != nil {
+		return prekeys, fmt.Errorf("failed to expire rotated sealed sender keys: %w", err)
+	}
+	sealed, _ := result.RowsAffected()
+
+	return prekeys + sealed, nil
+}
@@ -0,0 +1,203 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/kindlyrobotics/nochat/internal/models"
+)
+
+/*
+SESSION STATE BACKUP:
+Devices back up their Double Ratchet state to e2ee_sessions so it can be
+restored on another device or after a reinstall. The state is encrypted by the
+owner's device key; the server only sees an opaque blob plus the send/receive
+chain indexes.
+
+Writes are compare-and-swap on a version the server bumps on every write: the
+client sends the version it last read (expected) along with the new state. The
+write only succeeds if the stored version still matches, and the new chain
+indexes may never be lower than the stored ones, so two devices racing on the
+same session cannot overwrite each other or roll a ratchet back, even when a
+write leaves both indexes unchanged. A losing writer receives
+ErrSessionConflict with the current state and must merge or re-derive before
+retrying.
+
+Deleting a backup leaves a tombstone row that keeps the version, so the
+version never repeats and a device still holding a pre-delete version gets
+ErrSessionConflict instead of resurrecting the old state.
+*/
+
+// MaxSessionStateSize bounds a single encrypted ratchet state blob
+const MaxSessionStateSize = 64 * 1024
+
+var (
+	// ErrSessionConflict is returned when the stored version no longer
+	// matches the version the client based its write on
+	ErrSessionConflict = errors.New("session state was modified by another device")
+
+	// ErrChainIndexRollback is returned when a write would lower a chain index
+	ErrChainIndexRollback = errors.New("chain indexes cannot move backwards")
+
+	// ErrSessionStateTooLarge is returned for session blobs over MaxSessionStateSize
+	ErrSessionStateTooLarge = errors.New("session state too large")
+)
+
+// SessionChainIndexes are the ratchet chain indexes carried by a session write
+type SessionChainIndexes struct {
+	SendChainIndex    int `json:"send_chain_index"`
+	ReceiveChainIndex int `json:"receive_chain_index"`
+}
+
+// sessionColumns is the column list scanned by scanSession
+const sessionColumns = `id, conversation_id, owner_user_id, peer_user_id, encrypted_session_state,
+	send_chain_index, receive_chain_index, version, created_at, updated_at`
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row interface{ Scan(...interface{}) error }) (*models.E2EESession, error) {
+	session := &models.E2EESession{}
+	err := row.Scan(&session.ID, &session.ConversationID, &session.OwnerUserID, &session.PeerUserID,
+		&session.EncryptedSessionState, &session.SendChainIndex, &session.ReceiveChainIndex,
+		&session.Version, &session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSession retrieves the owner's backed-up session with a peer in a conversation.
+// Returns nil if no backup exists.
+func (s *Service) GetSession(ctx context.Context, ownerUserID, conversationID, peerUserID uuid.UUID) (*models.E2EESession, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM e2ee_sessions
+		WHERE conversation_id = $1 AND owner_user_id = $2 AND peer_user_id = $3 AND deleted_at IS NULL
+	`, conversationID, ownerUserID, peerUserID)
+
+	session, err := scanSession(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return session, nil
+}
+
+// ListSessions returns the owner's backed-up sessions, optionally limited to one conversation
+func (s *Service) ListSessions(ctx context.Context, ownerUserID uuid.UUID, conversationID *uuid.UUID) ([]*models.E2EESession, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM e2ee_sessions
+		WHERE owner_user_id = $1 AND ($2::uuid IS NULL OR conversation_id = $2) AND deleted_at IS NULL
+		ORDER BY updated_at DESC
+	`, ownerUserID, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*models.E2EESession{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// PutSession creates or updates a session backup. expectedVersion is nil when
+// the client believes no backup exists yet; otherwise it must match the stored
+// version. On ErrSessionConflict the current session is returned so the
+// caller can hand it back to the client.
+func (s *Service) PutSession(ctx context.Context, ownerUserID, conversationID, peerUserID uuid.UUID,
+	encryptedState []byte, next SessionChainIndexes, expectedVersion *int64) (*models.E2EESession, error) {
+	if len(encryptedState) == 0 {
+		return nil, fmt.Errorf("encrypted session state is required")
+	}
+	if len(encryptedState) > MaxSessionStateSize {
+		return nil, ErrSessionStateTooLarge
+	}
+	if next.SendChainIndex < 0 || next.ReceiveChainIndex < 0 {
+		return nil, ErrChainIndexRollback
+	}
+
+	var row *sql.Row
+	if expectedVersion == nil {
+		// Creating: only succeeds if no backup exists, or over a tombstone
+		// (continuing its version)
+		row = s.db.QueryRowContext(ctx, `
+			INSERT INTO e2ee_sessions (id, conversation_id, owner_user_id, peer_user_id, encrypted_session_state,
+				send_chain_index, receive_chain_index, version, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, 1, NOW(), NOW())
+			ON CONFLICT (conversation_id, owner_user_id, peer_user_id) DO UPDATE
+			SET encrypted_session_state = EXCLUDED.encrypted_session_state,
+				send_chain_index = EXCLUDED.send_chain_index,
+				receive_chain_index = EXCLUDED.receive_chain_index,
+				version = e2ee_sessions.version + 1,
+				deleted_at = NULL,
+				created_at = NOW(),
+				updated_at = NOW()
+			WHERE e2ee_sessions.deleted_at IS NOT NULL
+			RETURNING `+sessionColumns,
+			uuid.New(), conversationID, ownerUserID, peerUserID, encryptedState,
+			next.SendChainIndex, next.ReceiveChainIndex)
+	} else {
+		row = s.db.QueryRowContext(ctx, `
+			UPDATE e2ee_sessions
+			SET encrypted_session_state = $4, send_chain_index = $5, receive_chain_index = $6,
+				version = version + 1, updated_at = NOW()
+			WHERE conversation_id = $1 AND owner_user_id = $2 AND peer_user_id = $3
+			  AND deleted_at IS NULL AND version = $7
+			  AND send_chain_index <= $5 AND receive_chain_index <= $6
+			RETURNING `+sessionColumns,
+			conversationID, ownerUserID, peerUserID, encryptedState,
+			next.SendChainIndex, next.ReceiveChainIndex, *expectedVersion)
+	}
+
+	session, err := scanSession(row)
+	if err == sql.ErrNoRows {
+		// Lost the race, the backup was created/deleted elsewhere, or the
+		// version matched but the write would lower a chain index
+		current, getErr := s.GetSession(ctx, ownerUserID, conversationID, peerUserID)
+		if getErr != nil {
+			return nil, getErr
+		}
+		if current != nil && expectedVersion != nil && current.Version == *expectedVersion {
+			return nil, ErrChainIndexRollback
+		}
+		return current, ErrSessionConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return session, nil
+}
+
+// DeleteSession removes a session backup, leaving a tombstone that keeps its
+// version. Returns false if none existed.
+func (s *Service) DeleteSession(ctx context.Context, ownerUserID, conversationID, peerUserID uuid.UUID) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE e2ee_sessions
+		SET encrypted_session_state = '', version = version + 1, deleted_at = NOW(), updated_at = NOW()
+		WHERE conversation_id = $1 AND owner_user_id = $2 AND peer_user_id = $3 AND deleted_at IS NULL
+	`, conversationID, ownerUserID, peerUserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,432 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+CIPHER SUITES:
+A cipher suite fixes the KEM, signature, AEAD and KDF used together for a
+session. Suites are identified by a stable 16-bit ID that clients advertise in
+their bundles and negotiate on; the registry below is the single source of
+truth for which combinations exist and which are still accepted.
+
+RETIREMENT:
+Each suite may carry a retirement deadline. Before the deadline the suite is
+"deprecated" (still accepted, clients should migrate); after it, uploads of
+keys that only a retired suite uses are rejected, retired suites are dropped
+from advertised lists, and negotiation skips them.
+
+Deadlines are configured with CRYPTO_SUITE_RETIREMENTS, a comma-separated list
+of name-or-ID=date entries, e.g. "p256=2027-06-30,0x0005=2027-06-30T00:00:00Z".
+*/
+
+// SuiteID identifies a cipher suite
+type SuiteID uint16
+
+// Registered cipher suites
+const (
+	SuiteP256           SuiteID = 0x0001 // P-256 ECDH, ECDSA P-256, AES-256-GCM, HKDF-SHA256
+	SuiteKyberDilithium SuiteID = 0x0002 // Kyber1024, Dilithium3, AES-256-GCM, HKDF-SHA256
+	SuitePQXDHP256      SuiteID = 0x0003 // X25519+Kyber1024, ECDSA P-256, XChaCha20-Poly1305, HKDF-SHA256
+	SuitePQXDHDilithium SuiteID = 0x0004 // X25519+Kyber1024, Dilithium3, XChaCha20-Poly1305, HKDF-SHA256
+	SuiteX25519P256     SuiteID = 0x0005 // X25519, ECDSA P-256, AES-256-GCM, HKDF-SHA256
+
+	// FIPS 203/204 suites
+	SuitePQXDHMLKEM768MLDSA65  SuiteID = 0x0006 // X25519+ML-KEM-768, ML-DSA-65, XChaCha20-Poly1305, HKDF-SHA256
+	SuitePQXDHMLKEM1024MLDSA65 SuiteID = 0x0007 // X25519+ML-KEM-1024, ML-DSA-65, XChaCha20-Poly1305, HKDF-SHA256
+	SuitePQXDHMLKEM768P256     SuiteID = 0x0008 // X25519+ML-KEM-768, ECDSA P-256, XChaCha20-Poly1305, HKDF-SHA256
+	SuiteMLKEM1024MLDSA65      SuiteID = 0x0009 // ML-KEM-1024, ML-DSA-65, AES-256-GCM, HKDF-SHA256
+)
+
+// KEM algorithms
+const (
+	KEMP256ECDH        = "p256-ecdh"
+	KEMX25519          = "x25519"
+	KEMKyber1024       = "kyber1024"
+	KEMX25519Kyber1024 = "x25519-kyber1024"
+	KEMMLKEM768        = "ml-kem-768"
+	KEMMLKEM1024       = "ml-kem-1024"
+	KEMX25519MLKEM768  = "x25519-ml-kem-768"
+	KEMX25519MLKEM1024 = "x25519-ml-kem-1024"
+)
+
+// Signature algorithms
+const (
+	SigECDSAP256  = "ecdsa-p256-sha256"
+	SigDilithium3 = "dilithium3"
+	SigMLDSA65    = "ml-dsa-65"
+)
+
+// AEAD algorithms (names match EncryptedMessage.Algorithm)
+const (
+	AEADAES256GCM         = "aes-256-gcm"
+	AEADXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+// KDF algorithms
+const (
+	KDFHKDFSHA256 = "hkdf-sha256"
+)
+
+// Suite statuses
+const (
+	SuiteStatusActive     = "active"
+	SuiteStatusDeprecated = "deprecated"
+	SuiteStatusRetired    = "retired"
+)
+
+var (
+	// ErrUnknownSuite is returned for suite IDs or algorithms not in the registry
+	ErrUnknownSuite = errors.New("unknown cipher suite")
+
+	// ErrSuiteRetired is returned when every suite that could use a key or ID is retired
+	ErrSuiteRetired = errors.New("cipher suite retired")
+)
+
+// CipherSuite describes one registered combination of algorithms
+type CipherSuite struct {
+	ID          SuiteID `json:"id"`
+	Name        string  `json:"name"`
+	KEM         string  `json:"kem"`
+	Signature   string  `json:"signature"`
+	AEAD        string  `json:"aead"`
+	KDF         string  `json:"kdf"`
+	PostQuantum bool    `json:"post_quantum"`
+	// Message.EncryptionVersion for this suite's AEAD (1 = AES-GCM, 2 = XChaCha20)
+	EncryptionVersion int `json:"encryption_version"`
+	// Prekey hybrid_version for this suite's KEM (1 = single algorithm, 2 = PQXDH hybrid)
+	HybridVersion int        `json:"hybrid_version"`
+	RetireAt      *time.Time `json:"retire_at,omitempty"`
+}
+
+// StatusAt returns the suite's status at the given time
+func (c *CipherSuite) StatusAt(now time.Time) string {
+	switch {
+	case c.RetireAt == nil:
+		return SuiteStatusActive
+	case now.Before(*c.RetireAt):
+		return SuiteStatusDeprecated
+	default:
+		return SuiteStatusRetired
+	}
+}
+
+// builtinSuites lists the suites in default preference order (most preferred first)
+var builtinSuites = []CipherSuite{
+	{ID: SuitePQXDHMLKEM1024MLDSA65, Name: "pqxdh-mlkem1024-mldsa65", KEM: KEMX25519MLKEM1024, Signature: SigMLDSA65,
+		AEAD: AEADXChaCha20Poly1305, KDF: KDFHKDFSHA256, PostQuantum: true, EncryptionVersion: 2, HybridVersion: 2},
+	{ID: SuitePQXDHMLKEM768MLDSA65, Name: "pqxdh-mlkem768-mldsa65", KEM: KEMX25519MLKEM768, Signature: SigMLDSA65,
+		AEAD: AEADXChaCha20Poly1305, KDF: KDFHKDFSHA256, PostQuantum: true, EncryptionVersion: 2, HybridVersion: 2},
+	{ID: SuitePQXDHMLKEM768P256, Name: "pqxdh-mlkem768-p256", KEM: KEMX25519MLKEM768, Signature: SigECDSAP256,
+		AEAD: AEADXChaCha20Poly1305, KDF: KDFHKDFSHA256, PostQuantum: true, EncryptionVersion: 2, HybridVersion: 2},
+	{ID: SuiteMLKEM1024MLDSA65, Name: "mlkem1024-mldsa65", KEM: KEMMLKEM1024, Signature: SigMLDSA65,
+		AEAD: AEADAES256GCM, KDF: KDFHKDFSHA256, PostQuantum: true, EncryptionVersion: 1, HybridVersion: 1},
+	{ID: SuitePQXDHDilithium, Name: "pqxdh-dilithium", KEM: KEMX25519Kyber1024, Signature: SigDilithium3,
+		AEAD: AEADXChaCha20Poly1305, KDF: KDFHKDFSHA256, PostQuantum: true, EncryptionVersion: 2, HybridVersion: 2},
+	{ID: SuitePQXDHP256, Name: "pqxdh-p256", KEM: KEMX25519Kyber1024, Signature: SigECDSAP256,
+		AEAD: AEADXChaCha20Poly1305, KDF: KDFHKDFSHA256, PostQuantum: true, EncryptionVersion: 2, HybridVersion: 2},
+	{ID: SuiteKyberDilithium, Name: "kyber-dilithium", KEM: KEMKyber1024, Signature: SigDilithium3,
+		AEAD: AEADAES256GCM, KDF: KDFHKDFSHA256, PostQuantum: true, EncryptionVersion: 1, HybridVersion: 1},
+	{ID: SuiteX25519P256, Name: "x25519-p256", KEM: KEMX25519, Signature: SigECDSAP256,
+		AEAD: AEADAES256GCM, KDF: KDFHKDFSHA256, EncryptionVersion: 1, HybridVersion: 1},
+	{ID: SuiteP256, Name: "p256", KEM: KEMP256ECDH, Signature: SigECDSAP256,
+		AEAD: AEADAES256GCM, KDF: KDFHKDFSHA256, EncryptionVersion: 1, HybridVersion: 1},
+}
+
+// SuiteRegistry holds the registered cipher suites and their retirement deadlines
+type SuiteRegistry struct {
+	mu     sync.RWMutex
+	suites []*CipherSuite // in preference order
+	byID   map[SuiteID]*CipherSuite
+	now    func() time.Time
+}
+
+// NewSuiteRegistry returns a registry containing the built-in suites
+func NewSuiteRegistry() *SuiteRegistry {
+	r := &SuiteRegistry{
+		byID: make(map[SuiteID]*CipherSuite),
+		now:  time.Now,
+	}
+	for i := range builtinSuites {
+		suite := builtinSuites[i]
+		r.suites = append(r.suites, &suite)
+		r.byID[suite.ID] = &suite
+	}
+	return r
+}
+
+// SuiteRegistryFromEnv returns the built-in registry with retirement
+// deadlines from CRYPTO_SUITE_RETIREMENTS
+func SuiteRegistryFromEnv() (*SuiteRegistry, error) {
+	r := NewSuiteRegistry()
+
+	spec := strings.TrimSpace(os.Getenv("CRYPTO_SUITE_RETIREMENTS"))
+	if spec == "" {
+		return r, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, date, ok := strings.Cut(entry, "=")
+		if !ok {
+			return r, fmt.Errorf("invalid CRYPTO_SUITE_RETIREMENTS entry %q: expected suite=date", entry)
+		}
+		suite, err := r.Lookup(strings.TrimSpace(name))
+		if err != nil {
+			return r, fmt.Errorf("invalid CRYPTO_SUITE_RETIREMENTS entry %q: %w", entry, err)
+		}
+		at, err := parseRetirementDate(strings.TrimSpace(date))
+		if err != nil {
+			return r, fmt.Errorf("invalid CRYPTO_SUITE_RETIREMENTS entry %q: %w", entry, err)
+		}
+		r.Retire(suite.ID, at)
+	}
+
+	return r, nil
+}
+
+// parseRetirementDate accepts RFC 3339 timestamps or YYYY-MM-DD dates (midnight UTC)
+func parseRetirementDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Lookup finds a suite by name or numeric ID ("3" or "0x0003")
+func (r *SuiteRegistry) Lookup(nameOrID string) (*CipherSuite, error) {
+	if id, err := strconv.ParseUint(nameOrID, 0, 16); err == nil {
+		if suite := r.Get(SuiteID(id)); suite != nil {
+			return suite, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSuite, nameOrID)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, suite := range r.suites {
+		if strings.EqualFold(suite.Name, nameOrID) {
+			copied := *suite
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSuite, nameOrID)
+}
+
+// Get returns a copy of the suite with the given ID, or nil if unregistered
+func (r *SuiteRegistry) Get(id SuiteID) *CipherSuite {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	suite, ok := r.byID[id]
+	if !ok {
+		return nil
+	}
+	copied := *suite
+	return &copied
+}
+
+// List returns copies of all registered suites in preference order
+func (r *SuiteRegistry) List() []*CipherSuite {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	suites := make([]*CipherSuite, 0, len(r.suites))
+	for _, suite := range r.suites {
+		copied := *suite
+		suites = append(suites, &copied)
+	}
+	return suites
+}
+
+// Retire sets a suite's retirement deadline
+func (r *SuiteRegistry) Retire(id SuiteID, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if suite, ok := r.byID[id]; ok {
+		retireAt := at
+		suite.RetireAt = &retireAt
+	}
+}
+
+// Check returns nil if the suite is registered and not retired
+func (r *SuiteRegistry) Check(id SuiteID) error {
+	suite := r.Get(id)
+	if suite == nil {
+		return fmt.Errorf("%w: 0x%04x", ErrUnknownSuite, uint16(id))
+	}
+	if suite.StatusAt(r.now()) == SuiteStatusRetired {
+		return fmt.Errorf("%w: %s", ErrSuiteRetired, suite.Name)
+	}
+	return nil
+}
+
+// Allowed filters ids down to registered, unretired suites, preserving order
+// and dropping duplicates
+func (r *SuiteRegistry) Allowed(ids []SuiteID) []SuiteID {
+	allowed := make([]SuiteID, 0, len(ids))
+	seen := make(map[SuiteID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] || r.Check(id) != nil {
+			continue
+		}
+		seen[id] = true
+		allowed = append(allowed, id)
+	}
+	return allowed
+}
+
+// Negotiate picks the first suite in the initiator's preference list that the
+// responder also supports and that is not retired
+func (r *SuiteRegistry) Negotiate(initiator, responder []SuiteID) (SuiteID, bool) {
+	supported := make(map[SuiteID]bool, len(responder))
+	for _, id := range responder {
+		supported[id] = true
+	}
+	for _, id := range r.Allowed(initiator) {
+		if supported[id] {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// UsesKEM reports whether any unretired suite in ids uses the given KEM
+func (r *SuiteRegistry) UsesKEM(ids []SuiteID, kem string) bool {
+	for _, id := range r.Allowed(ids) {
+		if suite := r.Get(id); suite != nil && suite.KEM == kem {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSignatureKey validates that an identity key type's signature algorithm
+// is used by at least one unretired suite
+func (r *SuiteRegistry) CheckSignatureKey(keyType KeyType) error {
+	alg := keyType.SignatureAlgorithm()
+	if alg == "" {
+		return fmt.Errorf("%w: no signature algorithm for key type %s", ErrUnknownSuite, keyType)
+	}
+	return r.checkAlgorithm(func(c *CipherSuite) bool { return c.Signature == alg }, alg)
+}
+
+// CheckPreKey validates that a prekey type's KEM is used by at least one
+// unretired suite. hybrid is set when the key is paired with an X25519 key.
+func (r *SuiteRegistry) CheckPreKey(keyType KeyType, hybrid bool) error {
+	alg := keyType.KEMAlgorithm(hybrid)
+	if alg == "" {
+		return fmt.Errorf("%w: no KEM for key type %s", ErrUnknownSuite, keyType)
+	}
+	return r.checkAlgorithm(func(c *CipherSuite) bool { return c.KEM == alg }, alg)
+}
+
+// checkAlgorithm reports ErrUnknownSuite if no suite matches, or
+// ErrSuiteRetired if every matching suite is retired
+func (r *SuiteRegistry) checkAlgorithm(match func(*CipherSuite) bool, alg string) error {
+	now := r.now()
+	found := false
+	for _, suite := range r.List() {
+		if !match(suite) {
+			continue
+		}
+		found = true
+		if suite.StatusAt(now) != SuiteStatusRetired {
+			return nil
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrUnknownSuite, alg)
+	}
+	return fmt.Errorf("%w: every suite using %s is retired", ErrSuiteRetired, alg)
+}
+
+// SetSuiteRegistry replaces the cipher-suite registry
+func (s *Service) SetSuiteRegistry(r *SuiteRegistry) {
+	s.suites = r
+}
+
+// SuiteRegistry returns the cipher-suite registry
+func (s *Service) SuiteRegistry() *SuiteRegistry {
+	return s.suites
+}
+
+// SetSupportedSuites replaces the suites a user advertises, in preference order
+func (s *Service) SetSupportedSuites(ctx context.Context, userID uuid.UUID, ids []SuiteID) ([]SuiteID, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("at least one cipher suite is required")
+	}
+	for _, id := range ids {
+		if err := s.suites.Check(id); err != nil {
+			return nil, err
+		}
+	}
+	ids = s.suites.Allowed(ids)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_cipher_suites WHERE user_id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("failed to clear cipher suites: %w", err)
+	}
+	for priority, id := range ids {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO user_cipher_suites (user_id, suite_id, priority, created_at)
+			VALUES ($1, $2, $3, NOW())
+		`, userID, int(id), priority)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store cipher suite: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit cipher suites: %w", err)
+	}
+
+	return ids, nil
+}
+
+// GetSupportedSuites returns the suites a user advertises, in preference
+// order, with retired or unregistered suites removed
+func (s *Service) GetSupportedSuites(ctx context.Context, userID uuid.UUID) ([]SuiteID, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT suite_id FROM user_cipher_suites
+		WHERE user_id = $1
+		ORDER BY priority ASC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cipher suites: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []SuiteID
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan cipher suite: %w", err)
+		}
+		ids = append(ids, SuiteID(id))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cipher suites: %w", err)
+	}
+
+	return s.suites.Allowed(ids), nil
+}
@@ -0,0 +1,336 @@
+package crypto
+
+import (
+	"context"
+	"crypto/sha512"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+SAFETY NUMBERS:
+A safety number lets two users confirm out-of-band that the server handed each
+of them the other's real identity key. It follows the construction used by
+Signal's numeric fingerprints:
+
+	hash_0 = SHA-512(version || identity_key || user_id)
+	hash_i = SHA-512(hash_{i-1} || identity_key)       for i in 1..SafetyNumberIterations
+
+The first 30 bytes of the final hash are split into six 5-byte chunks, each
+reduced mod 100000 to give 30 decimal digits per party. The two halves are
+ordered lexicographically so both parties see the same 60-digit number.
+
+The QR payload carries the version and both 32-byte fingerprint prefixes in the
+same canonical order, so a scan compares the full hashes rather than digits.
+
+For ML-DSA and other non-legacy identity keys, identity_key above is the
+TaggedPublicKey encoding, so the key type is part of the hash; legacy keys hash
+the raw bytes and keep their existing safety numbers. The fingerprints reported
+alongside are TypedKeyFingerprint, matching the stored key_fingerprint.
+*/
+
+// Safety number parameters
+const (
+	SafetyNumberVersion    = 1
+	SafetyNumberIterations = 5200
+	SafetyNumberDigits     = 60
+	safetyNumberHashSize   = 32 // Per-party bytes carried in the QR payload
+)
+
+var (
+	// ErrFingerprintMismatch is returned when a client marks a contact verified
+	// against a key that is no longer the contact's active identity key
+	ErrFingerprintMismatch = errors.New("identity key fingerprint does not match current key")
+
+	// ErrCannotVerifySelf is returned when a user tries to verify their own key
+	ErrCannotVerifySelf = errors.New("cannot verify own identity key")
+)
+
+// KeyChangeNotifier is notified when a contact's verified identity key changes
+type KeyChangeNotifier interface {
+	NotifyVerificationReset(userID, contactUserID uuid.UUID, oldFingerprint, newFingerprint string)
+}
+
+// SafetyNumber is the comparable value for a pair of identity keys
+type SafetyNumber struct {
+	Version           int    `json:"version"`
+	Digits            string `json:"digits"`             // 60 decimal digits
+	Display           string `json:"display"`            // Digits in groups of 5
+	QRPayload         []byte `json:"qr_payload"`         // version || fingerprint_a || fingerprint_b
+	LocalFingerprint  string `json:"local_fingerprint"`  // TypedKeyFingerprint of the caller's identity key
+	RemoteFingerprint string `json:"remote_fingerprint"` // TypedKeyFingerprint of the contact's identity key
+}
+
+// ContactVerification records whether a user has verified a contact's identity key
+type ContactVerification struct {
+	UserID              uuid.UUID  `json:"user_id"`
+	ContactUserID       uuid.UUID  `json:"contact_user_id"`
+	Verified            bool       `json:"verified"`
+	VerifiedFingerprint *string    `json:"verified_fingerprint,omitempty"`
+	VerifiedAt          *time.Time `json:"verified_at,omitempty"`
+	ResetAt             *time.Time `json:"reset_at,omitempty"`
+	ResetReason         *string    `json:"reset_reason,omitempty"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// SetKeyChangeNotifier sets the notifier used when verifications are reset
+func (s *Service) SetKeyChangeNotifier(n KeyChangeNotifier) {
+	s.keyChangeNotifier = n
+}
+
+// safetyNumberHash computes the iterated per-party hash
+func safetyNumberHash(userID uuid.UUID, keyType KeyType, publicKey []byte) []byte {
+	identityKey := publicKey
+	if !keyType.IsLegacy() {
+		identityKey = TaggedPublicKey(keyType, publicKey)
+	}
+
+	h := sha512.New()
+	var version [2]byte
+	binary.BigEndian.PutUint16(version[:], SafetyNumberVersion)
+	h.Write(version[:])
+	h.Write(identityKey)
+	h.Write(userID[:])
+	hash := h.Sum(nil)
+
+	for i := 0; i < SafetyNumberIterations; i++ {
+		h.Reset()
+		h.Write(hash)
+		h.Write(identityKey)
+		hash = h.Sum(hash[:0])
+	}
+
+	return hash
+}
+
+// safetyNumberDigits encodes the first 30 bytes of hash as 30 digits
+func safetyNumberDigits(hash []byte) string {
+	var sb strings.Builder
+	for i := 0; i < 30; i += 5 {
+		chunk := uint64(hash[i])<<32 | uint64(hash[i+1])<<24 | uint64(hash[i+2])<<16 |
+			uint64(hash[i+3])<<8 | uint64(hash[i+4])
+		fmt.Fprintf(&sb, "%05d", chunk%100000)
+	}
+	return sb.String()
+}
+
+// ComputeSafetyNumber derives the safety number for two users' identity keys.
+// The result is the same regardless of which party computes it.
+func ComputeSafetyNumber(localUserID uuid.UUID, localType KeyType, localKey []byte, remoteUserID uuid.UUID, remoteType KeyType, remoteKey []byte) *SafetyNumber {
+	localHash := safetyNumberHash(localUserID, localType, localKey)
+	remoteHash := safetyNumberHash(remoteUserID, remoteType, remoteKey)
+
+	first, second := localHash, remoteHash
+	firstDigits, secondDigits := safetyNumberDigits(localHash), safetyNumberDigits(remoteHash)
+	if secondDigits < firstDigits {
+		first, second = second, first
+		firstDigits, secondDigits = secondDigits, firstDigits
+	}
+
+	digits := firstDigits + secondDigits
+
+	groups := make([]string, 0, SafetyNumberDigits/5)
+	for i := 0; i < len(digits); i += 5 {
+		groups = append(groups, digits[i:i+5])
+	}
+
+	qr := make([]byte, 0, 1+2*safetyNumberHashSize)
+	qr = append(qr, SafetyNumberVersion)
+	qr = append(qr, first[:safetyNumberHashSize]...)
+	qr = append(qr, second[:safetyNumberHashSize]...)
+
+	return &SafetyNumber{
+		Version:           SafetyNumberVersion,
+		Digits:            digits,
+		Display:           strings.Join(groups, " "),
+		QRPayload:         qr,
+		LocalFingerprint:  TypedKeyFingerprint(localType, localKey, false),
+		RemoteFingerprint: TypedKeyFingerprint(remoteType, remoteKey, false),
+	}
+}
+
+// CompareSafetyNumberQR checks a scanned QR payload against an expected safety number
+func CompareSafetyNumberQR(scanned []byte, expected *SafetyNumber) bool {
+	if expected == nil || len(scanned) != len(expected.QRPayload) {
+		return false
+	}
+	return subtle.ConstantTimeCompare(scanned, expected.QRPayload) == 1
+}
+
+// GetSafetyNumber computes the safety number between a user and a contact
+// from their active identity keys. Returns nil if either key is missing.
+func (s *Service) GetSafetyNumber(ctx context.Context, userID, contactUserID uuid.UUID) (*SafetyNumber, error) {
+	localKey, err := s.GetIdentityKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	remoteKey, err := s.GetIdentityKey(ctx, contactUserID)
+	if err != nil {
+		return nil, err
+	}
+	if localKey == nil || remoteKey == nil {
+		return nil, nil
+	}
+
+	return ComputeSafetyNumber(userID, localKey.KeyType, localKey.PublicKey, contactUserID, remoteKey.KeyType, remoteKey.PublicKey), nil
+}
+
+// scanContactVerification scans a contact_key_verifications row
+func scanContactVerification(row interface{ Scan(...interface{}) error }) (*ContactVerification, error) {
+	v := &ContactVerification{}
+	var verifiedFingerprint, resetReason sql.NullString
+	var verifiedAt, resetAt sql.NullTime
+
+	err := row.Scan(&v.UserID, &v.ContactUserID, &v.Verified, &verifiedFingerprint,
+		&verifiedAt, &resetAt, &resetReason, &v.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if verifiedFingerprint.Valid {
+		v.VerifiedFingerprint = &verifiedFingerprint.String
+	}
+	if verifiedAt.Valid {
+		v.VerifiedAt = &verifiedAt.Time
+	}
+	if resetAt.Valid {
+		v.ResetAt = &resetAt.Time
+	}
+	if resetReason.Valid {
+		v.ResetReason = &resetReason.String
+	}
+
+	return v, nil
+}
+
+// SetContactVerification marks a contact verified or unverified. When
+// verifying, fingerprint must match the contact's current identity key so a
+// key that changed while the user was comparing numbers is never trusted.
+func (s *Service) SetContactVerification(ctx context.Context, userID, contactUserID uuid.UUID, verified bool, fingerprint string) (*ContactVerification, error) {
+	if userID == contactUserID {
+		return nil, ErrCannotVerifySelf
+	}
+
+	var verifiedFingerprint sql.NullString
+	if verified {
+		contactKey, err := s.GetIdentityKey(ctx, contactUserID)
+		if err != nil {
+			return nil, err
+		}
+		if contactKey == nil || subtle.ConstantTimeCompare([]byte(contactKey.KeyFingerprint), []byte(fingerprint)) != 1 {
+			return nil, ErrFingerprintMismatch
+		}
+		verifiedFingerprint = sql.NullString{String: contactKey.KeyFingerprint, Valid: true}
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		INSERT INTO contact_key_verifications (id, user_id, contact_user_id, verified, verified_fingerprint, verified_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $4 THEN NOW() END, NOW())
+		ON CONFLICT (user_id, contact_user_id) DO UPDATE SET
+			verified = EXCLUDED.verified,
+			verified_fingerprint = EXCLUDED.verified_fingerprint,
+			verified_at = EXCLUDED.verified_at,
+			reset_at = NULL,
+			reset_reason = NULL,
+			updated_at = NOW()
+		RETURNING user_id, contact_user_id, verified, verified_fingerprint, verified_at, reset_at, reset_reason, updated_at
+	`, uuid.New(), userID, contactUserID, verified, verifiedFingerprint)
+
+	v, err := scanContactVerification(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store contact verification: %w", err)
+	}
+
+	return v, nil
+}
+
+// GetContactVerification returns a user's verification state for a contact.
+// A contact that was never verified returns an unverified record.
+func (s *Service) GetContactVerification(ctx context.Context, userID, contactUserID uuid.UUID) (*ContactVerification, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT user_id, contact_user_id, verified, verified_fingerprint, verified_at, reset_at, reset_reason, updated_at
+		FROM contact_key_verifications
+		WHERE user_id = $1 AND contact_user_id = $2
+	`, userID, contactUserID)
+
+	v, err := scanContactVerification(row)
+	if err == sql.ErrNoRows {
+		return &ContactVerification{UserID: userID, ContactUserID: contactUserID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contact verification: %w", err)
+	}
+
+	return v, nil
+}
+
+// ListContactVerifications returns all verification records owned by a user
+func (s *Service) ListContactVerifications(ctx context.Context, userID uuid.UUID) ([]*ContactVerification, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, contact_user_id, verified, verified_fingerprint, verified_at, reset_at, reset_reason, updated_at
+		FROM contact_key_verifications
+		WHERE user_id = $1
+		ORDER BY updated_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contact verifications: %w", err)
+	}
+	defer rows.Close()
+
+	verifications := []*ContactVerification{}
+	for rows.Next() {
+		v, err := scanContactVerification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan contact verification: %w", err)
+		}
+		verifications = append(verifications, v)
+	}
+
+	return verifications, rows.Err()
+}
+
+// resetVerifications clears the verified flag for everyone who had verified
+// a key other than contactUserID's current identity key and notifies them
+func (s *Service) resetVerifications(ctx context.Context, contactUserID uuid.UUID, newFingerprint string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE contact_key_verifications
+		SET verified = false, reset_at = NOW(), reset_reason = 'identity_key_changed', updated_at = NOW()
+		WHERE contact_user_id = $1 AND verified = true AND verified_fingerprint IS DISTINCT FROM $2
+		RETURNING user_id, COALESCE(verified_fingerprint, '')
+	`, contactUserID, newFingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to reset contact verifications: %w", err)
+	}
+	defer rows.Close()
+
+	type reset struct {
+		userID         uuid.UUID
+		oldFingerprint string
+	}
+	var affected []reset
+	for rows.Next() {
+		var r reset
+		if err := rows.Scan(&r.userID, &r.oldFingerprint); err != nil {
+			return fmt.Errorf("failed to scan reset verification: %w", err)
+		}
+		affected = append(affected, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if s.keyChangeNotifier != nil {
+		for _, r := range affected {
+			s.keyChangeNotifier.NotifyVerificationReset(r.userID, contactUserID, r.oldFingerprint, newFingerprint)
+		}
+	}
+
+	return nil
+}
@@ -157,6 +157,41 @@ func (s *Service) GetParticipants(ctx context.Context, conversationID uuid.UUID)
 	return participants, nil
 }
 
+// IsParticipant reports whether a user is a participant of a conversation
+func (s *Service) IsParticipant(ctx context.Context, conversationID, userID uuid.UUID) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM participants WHERE conversation_id = $1 AND user_id = $2)`,
+		conversationID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check participation: %w", err)
+	}
+	return exists, nil
+}
+
+// GetMessage retrieves a single message by ID
+func (s *Service) GetMessage(ctx context.Context, messageID uuid.UUID) (*models.Message, error) {
+	query := `
+		SELECT id, conversation_id, COALESCE(sender_id, '00000000-0000-0000-0000-000000000000'), encrypted_content, message_type,
+		       reply_to_id, created_at, updated_at, deleted_at, is_edited
+		FROM messages
+		WHERE id = $1
+	`
+
+	var msg models.Message
+	err := s.db.QueryRowContext(ctx, query, messageID).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.EncryptedContent,
+		&msg.MessageType, &msg.ReplyToID, &msg.CreatedAt, &msg.UpdatedAt, &msg.DeletedAt, &msg.IsEdited)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("message not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message: %w", err)
+	}
+
+	return &msg, nil
+}
+
 // CreateMessage creates a new message in a conversation
 func (s *Service) CreateMessage(ctx context.Context, conversationID, senderID uuid.UUID, encryptedContent []byte, messageType string, replyToID *uuid.UUID) (*models.Message, error) {
 	msg := &models.Message{
@@ -123,6 +123,45 @@ type Attachment struct {
 	FileKeyNonce      []byte    `json:"file_key_nonce,omitempty"`      // Nonce used for file key encryption
 	FileKeyAlgorithm  string    `json:"file_key_algorithm,omitempty"`  // Algorithm used (aes-256-gcm/xchacha20)
 	ChecksumSHA256    string    `json:"checksum_sha256,omitempty"`     // SHA-256 of encrypted file for integrity
+	// Encrypted thumbnail - separate blob with its own key, wrapped with the message key
+	ThumbnailSize           *int64  `json:"thumbnail_size,omitempty"`
+	ThumbnailEncryptedKey   []byte  `json:"thumbnail_encrypted_key,omitempty"`
+	ThumbnailKeyNonce       []byte  `json:"thumbnail_key_nonce,omitempty"`
+	ThumbnailKeyAlgorithm   *string `json:"thumbnail_key_algorithm,omitempty"`
+	ThumbnailChecksumSHA256 *string `json:"thumbnail_checksum_sha256,omitempty"`
+}
+
+// AttachmentThumbnail describes an encrypted thumbnail paired with an attachment
+type AttachmentThumbnail struct {
+	StorageKey     string `json:"storage_key"`
+	Size           int64  `json:"size"`
+	EncryptedKey   []byte `json:"encrypted_key"`   // Thumbnail key, encrypted with message key
+	KeyNonce       []byte `json:"key_nonce"`       // Nonce used for thumbnail key encryption
+	KeyAlgorithm   string `json:"key_algorithm"`   // aes-256-gcm or xchacha20-poly1305
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
+}
+
+// AttachmentPreview is an attachment plus the endpoint that grants a
+// short-lived URL for its encrypted thumbnail. Grants are issued only when a
+// client actually renders the preview, not for every listing.
+type AttachmentPreview struct {
+	Attachment
+	ThumbnailGrantURL string `json:"thumbnail_grant_url,omitempty"`
+}
+
+// CreateAttachmentRequest registers an uploaded encrypted file against a message
+type CreateAttachmentRequest struct {
+	MessageID         string               `json:"message_id"`
+	StorageKey        string               `json:"storage_key"`
+	FileName          string               `json:"file_name"`
+	FileSize          int64                `json:"file_size"`
+	MimeType          string               `json:"mime_type"`
+	EncryptedMetadata *string              `json:"encrypted_metadata,omitempty"`
+	EncryptedFileKey  []byte               `json:"encrypted_file_key,omitempty"`
+	FileKeyNonce      []byte               `json:"file_key_nonce,omitempty"`
+	FileKeyAlgorithm  string               `json:"file_key_algorithm,omitempty"`
+	ChecksumSHA256    string               `json:"checksum_sha256,omitempty"`
+	Thumbnail         *AttachmentThumbnail `json:"thumbnail,omitempty"`
 }
 
 // Contact represents a friend/contact relationship
@@ -204,21 +243,30 @@ type UploadRequest struct {
 	FileSize        int64  `json:"file_size"`
 	MimeType        string `json:"mime_type"`
 	ConversationID  string `json:"conversation_id"`
+	ThumbnailSize   int64  `json:"thumbnail_size,omitempty"` // Encrypted thumbnail size, if one will be uploaded
+	TransferMode    string `json:"transfer_mode,omitempty"`  // presigned, proxy or auto (default)
 }
 
 type UploadResponse struct {
 	UploadURL   string    `json:"upload_url"`   // Pre-signed S3 URL
 	StorageKey  string    `json:"storage_key"`  // S3 object key for later reference
 	ExpiresAt   time.Time `json:"expires_at"`   // URL expiration
+	// Paired thumbnail upload, present when thumbnail_size was requested
+	ThumbnailUploadURL  string `json:"thumbnail_upload_url,omitempty"`
+	ThumbnailStorageKey string `json:"thumbnail_storage_key,omitempty"`
+	TransferMode        string `json:"transfer_mode"` // Mode the URLs were issued for
 }
 
 type DownloadRequest struct {
-	StorageKey string `json:"storage_key"`
+	StorageKey   string `json:"storage_key"`
+	TransferMode string `json:"transfer_mode,omitempty"` // presigned, proxy or auto (default)
 }
 
 type DownloadResponse struct {
-	DownloadURL string    `json:"download_url"` // Pre-signed S3 URL
-	ExpiresAt   time.Time `json:"expires_at"`
+	DownloadURL  string    `json:"download_url"` // Pre-signed S3 URL or proxy URL
+	ExpiresAt    time.Time `json:"expires_at"`
+	GrantID      string    `json:"grant_id,omitempty"` // Access log entry this URL was issued under
+	TransferMode string    `json:"transfer_mode"`      // presigned or proxy
 }
 
 // E2EE Types for Zero-Trust Messaging
@@ -275,6 +323,7 @@ type E2EESession struct {
 	EncryptedSessionState  []byte    `json:"encrypted_session_state"` // Encrypted by owner's device key
 	SendChainIndex         int       `json:"send_chain_index"`
 	ReceiveChainIndex      int       `json:"receive_chain_index"`
+	Version                int64     `json:"version"` // Bumped on every write; sent back as expected_version
 	CreatedAt              time.Time `json:"created_at"`
 	UpdatedAt              time.Time `json:"updated_at"`
 }
@@ -778,3 +778,62 @@ func (s *Service) NotifyLowPreKeys(client *Client, count int) {
 		},
 	})
 }
+
+// NotifyUser sends a message to every connected client of a user, across all
+// rooms. Returns the number of clients the message was queued for.
+func (s *Service) NotifyUser(userID uuid.UUID, msgType string, content interface{}) int {
+	s.roomsMu.RLock()
+	var clients []*Client
+	for _, room := range s.rooms {
+		room.mu.RLock()
+		for _, c := range room.Clients {
+			if c.UserID == userID {
+				clients = append(clients, c)
+			}
+		}
+		room.mu.RUnlock()
+	}
+	s.roomsMu.RUnlock()
+
+	for _, c := range clients {
+		s.sendMessage(c, models.WSMessage{
+			Type:    msgType,
+			RoomID:  c.Room.ID,
+			Content: content,
+		})
+	}
+
+	return len(clients)
+}
+
+// NotifyVerificationReset tells a user that a contact they had verified has a
+// new identity key, so the contact is no longer marked as verified
+func (s *Service) NotifyVerificationReset(userID, contactUserID uuid.UUID, oldFingerprint, newFingerprint string) {
+	s.NotifyUser(userID, "verificationReset", map[string]interface{}{
+		"contact_user_id": contactUserID.String(),
+		"old_fingerprint": oldFingerprint,
+		"new_fingerprint": newFingerprint,
+		"reason":          "identity_key_changed",
+		"timestamp":       time.Now().UnixMilli(),
+	})
+}
+
+// NotifyKeyChange pushes a key transparency key-change event to a user: a
+// monitored contact, or the user themselves, has a new directory entry
+func (s *Service) NotifyKeyChange(userID uuid.UUID, event interface{}) {
+	s.NotifyUser(userID, "keyDirectoryChanged", event)
+}
+
+// NotifyKeyRotationDue tells a user's clients that a medium-term key is near
+// or past its rotation deadline. graceUntil is how long the old private key
+// should be kept after rotating, for sessions started against it. Reports
+// whether any of the user's clients were connected to receive it.
+func (s *Service) NotifyKeyRotationDue(userID uuid.UUID, keyType string, keyID int, expiresAt, hardExpiresAt, graceUntil time.Time) bool {
+	return s.NotifyUser(userID, "keyRotationDue", map[string]interface{}{
+		"key_type":        keyType,
+		"key_id":          keyID,
+		"expires_at":      expiresAt.UnixMilli(),
+		"hard_expires_at": hardExpiresAt.UnixMilli(),
+		"grace_until":     graceUntil.UnixMilli(),
+	}) > 0
+}
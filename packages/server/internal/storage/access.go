@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kindlyrobotics/nochat/internal/models"
+)
+
+// DownloadGrantTTL is how long an authorized download URL stays valid
+const DownloadGrantTTL = 5 * time.Minute
+
+// UnboundGrantTTL is the lifetime of presigned URLs from external stores (S3),
+// which ignore the grant parameters and so act as bearer URLs until they expire
+const UnboundGrantTTL = 1 * time.Minute
+
+// ThumbnailGrantPath is the router path of the lazy thumbnail grant endpoint
+const ThumbnailGrantPath = "/api/storage/attachments/{id}/thumbnail"
+
+// Access types recorded in attachment_access_log
+const (
+	AccessTypeFile      = "file"
+	AccessTypeThumbnail = "thumbnail"
+)
+
+// Query parameters binding a signed download URL to its grant
+const (
+	GrantParamUser  = "uid"
+	GrantParamGrant = "grant"
+)
+
+var (
+	// ErrAttachmentNotFound is returned when a storage key or ID does not resolve to an attachment
+	ErrAttachmentNotFound = errors.New("attachment not found")
+
+	// ErrAccessDenied is returned when the caller is not a participant of the attachment's conversation
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// AccessContext carries request metadata recorded with each grant
+type AccessContext struct {
+	IPAddress string
+	UserAgent string
+}
+
+// resolvedAttachment is an attachment together with the conversation it belongs to
+type resolvedAttachment struct {
+	attachment     *models.Attachment
+	conversationID uuid.UUID
+}
+
+// resolveByStorageKey finds the attachment that owns storageKey, either as its
+// file or its thumbnail
+func (s *Service) resolveByStorageKey(ctx context.Context, storageKey string) (*resolvedAttachment, string, error) {
+	query := `
+		SELECT ` + attachmentColumns + `, m.conversation_id
+		FROM attachments
+		JOIN messages m ON m.id = attachments.message_id
+		WHERE attachments.storage_key = $1 OR attachments.thumbnail_key = $1
+		LIMIT 1
+	`
+
+	res, err := s.scanResolved(s.db.QueryRowContext(ctx, query, storageKey))
+	if err != nil {
+		return nil, "", err
+	}
+
+	accessType := AccessTypeFile
+	if res.attachment.StorageKey != storageKey {
+		accessType = AccessTypeThumbnail
+	}
+	return res, accessType, nil
+}
+
+// resolveByID finds an attachment by ID
+func (s *Service) resolveByID(ctx context.Context, attachmentID uuid.UUID) (*resolvedAttachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `, m.conversation_id
+		FROM attachments
+		JOIN messages m ON m.id = attachments.message_id
+		WHERE attachments.id = $1
+	`
+
+	return s.scanResolved(s.db.QueryRowContext(ctx, query, attachmentID))
+}
+
+// scanResolved scans attachmentColumns followed by the message's conversation ID
+func (s *Service) scanResolved(row *sql.Row) (*resolvedAttachment, error) {
+	var conversationID uuid.UUID
+	att, err := scanAttachment(row, &conversationID)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve attachment: %w", err)
+	}
+	return &resolvedAttachment{attachment: att, conversationID: conversationID}, nil
+}
+
+// checkParticipant returns ErrAccessDenied unless userID participates in conversationID
+func (s *Service) checkParticipant(ctx context.Context, conversationID, userID uuid.UUID) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM participants WHERE conversation_id = $1 AND user_id = $2)`,
+		conversationID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check participation: %w", err)
+	}
+	if !exists {
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+// recordGrant writes a download grant to attachment_access_log
+func (s *Service) recordGrant(ctx context.Context, grantID uuid.UUID, res *resolvedAttachment, userID uuid.UUID, storageKey, accessType, mode string, ac AccessContext, expiresAt time.Time) error {
+	// INET rejects malformed addresses, so only record parseable IPs
+	var ip *string
+	if host := hostOnly(ac.IPAddress); net.ParseIP(host) != nil {
+		ip = &host
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO attachment_access_log
+			(grant_id, attachment_id, conversation_id, user_id, storage_key, access_type, delivery_mode, ip_address, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)
+	`, grantID, res.attachment.ID, res.conversationID, userID, storageKey, accessType, mode, ip, ac.UserAgent, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to record access grant: %w", err)
+	}
+	return nil
+}
+
+// issueGrant returns a download URL for storageKey in the given transfer mode.
+// Presigned URLs are short-lived and recorded in the access log before being
+// returned. Only local backends enforce the user binding in the URL; S3 URLs
+// would be bearer URLs, so S3 downloads go through the proxy unless
+// STORAGE_ALLOW_UNBOUND_GRANTS is set, in which case they get UnboundGrantTTL.
+// Proxy URLs require the caller's session and are recorded when the proxied
+// download is actually served.
+func (s *Service) issueGrant(ctx context.Context, res *resolvedAttachment, userID uuid.UUID, storageKey, accessType, mode string, ac AccessContext) (*models.DownloadResponse, error) {
+	ttl := DownloadGrantTTL
+	if _, bound := s.store.(SignedURLVerifier); !bound {
+		if !s.transfer.allowUnboundGrants {
+			mode = TransferModeProxy
+		}
+		ttl = UnboundGrantTTL
+	}
+
+	if mode == TransferModeProxy {
+		return &models.DownloadResponse{
+			DownloadURL:  s.ProxyURL(storageKey),
+			ExpiresAt:    time.Now().Add(DownloadGrantTTL),
+			TransferMode: TransferModeProxy,
+		}, nil
+	}
+
+	grantID := uuid.New()
+	expiresAt := time.Now().Add(ttl)
+
+	params := url.Values{}
+	params.Set(GrantParamUser, userID.String())
+	params.Set(GrantParamGrant, grantID.String())
+
+	downloadURL, err := s.store.PresignGet(ctx, storageKey, ttl, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate download URL: %w", err)
+	}
+
+	// Never hand out a URL that isn't in the access log
+	if err := s.recordGrant(ctx, grantID, res, userID, storageKey, accessType, TransferModePresigned, ac, expiresAt); err != nil {
+		return nil, err
+	}
+
+	return &models.DownloadResponse{
+		DownloadURL:  downloadURL,
+		ExpiresAt:    expiresAt,
+		GrantID:      grantID.String(),
+		TransferMode: TransferModePresigned,
+	}, nil
+}
+
+// AuthorizeDownload resolves a storage key to its attachment, message and
+// conversation, checks that userID is a participant, and issues a short-lived
+// download URL bound to that user. Every grant is written to attachment_access_log.
+// mode selects between a presigned URL and the streaming proxy.
+func (s *Service) AuthorizeDownload(ctx context.Context, userID uuid.UUID, storageKey, mode string, ac AccessContext) (*models.DownloadResponse, error) {
+	mode, err := s.ResolveTransferMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	res, accessType, err := s.resolveByStorageKey(ctx, storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkParticipant(ctx, res.conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.issueGrant(ctx, res, userID, storageKey, accessType, mode, ac)
+}
+
+// GetAttachmentForUser returns an attachment if userID participates in its conversation
+func (s *Service) GetAttachmentForUser(ctx context.Context, userID, attachmentID uuid.UUID) (*models.Attachment, error) {
+	res, err := s.resolveByID(ctx, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkParticipant(ctx, res.conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	return res.attachment, nil
+}
+
+// GetMessageAttachmentPreviews returns a message's attachments after checking
+// userID participates in the conversation. Thumbnails are not granted here;
+// each preview carries the ThumbnailGrantPath URL to request one when rendered.
+func (s *Service) GetMessageAttachmentPreviews(ctx context.Context, userID, messageID uuid.UUID) ([]models.AttachmentPreview, error) {
+	var conversationID uuid.UUID
+	err := s.db.QueryRowContext(ctx, `SELECT conversation_id FROM messages WHERE id = $1`, messageID).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message: %w", err)
+	}
+
+	if err := s.checkParticipant(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	attachments, err := s.GetMessageAttachments(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make([]models.AttachmentPreview, 0, len(attachments))
+	for _, att := range attachments {
+		preview := models.AttachmentPreview{Attachment: *att}
+		if att.ThumbnailKey != nil {
+			preview.ThumbnailGrantURL = s.transfer.publicURL + strings.Replace(ThumbnailGrantPath, "{id}", att.ID.String(), 1)
+		}
+		previews = append(previews, preview)
+	}
+
+	return previews, nil
+}
+
+// AuthorizeThumbnail issues a download grant for an attachment's encrypted
+// thumbnail, after checking userID participates in its conversation
+func (s *Service) AuthorizeThumbnail(ctx context.Context, userID, attachmentID uuid.UUID, mode string, ac AccessContext) (*models.DownloadResponse, error) {
+	mode, err := s.ResolveTransferMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.resolveByID(ctx, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if res.attachment.ThumbnailKey == nil {
+		return nil, ErrAttachmentNotFound
+	}
+
+	if err := s.checkParticipant(ctx, res.conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.issueGrant(ctx, res, userID, *res.attachment.ThumbnailKey, AccessTypeThumbnail, mode, ac)
+}
+
+// hostOnly strips a port from an address and keeps the first X-Forwarded-For hop
+func hostOnly(addr string) string {
+	addr, _, _ = strings.Cut(addr, ",")
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
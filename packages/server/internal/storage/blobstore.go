@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrBlobNotFound is returned when a storage key does not exist in the backend
+	ErrBlobNotFound = errors.New("blob not found")
+
+	// ErrInvalidStorageKey is returned for keys that are empty or escape the store root
+	ErrInvalidStorageKey = errors.New("invalid storage key")
+
+	// ErrInvalidSignedURL is returned when a locally signed URL is malformed, tampered with or expired
+	ErrInvalidSignedURL = errors.New("invalid or expired signed URL")
+
+	// ErrSignedURLsUnsupported is returned when the backend does not serve its own signed URLs
+	ErrSignedURLsUnsupported = errors.New("backend does not serve local signed URLs")
+)
+
+// Signed URL operations for backends that serve their own blobs
+const (
+	BlobOpGet = "get"
+	BlobOpPut = "put"
+)
+
+// LocalBlobPath is the router path prefix under which local backends serve signed URLs
+const LocalBlobPath = "/api/storage/blobs/"
+
+// BlobInfo describes a stored object
+type BlobInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore is the object storage backend behind Service.
+// Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores the contents of reader under key. size may be -1 if unknown.
+	Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key. Returns ErrBlobNotFound if missing.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange opens length bytes of the object starting at offset. A negative
+	// length reads to the end of the object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat returns metadata for the object stored under key
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	// Delete removes the object stored under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// PresignPut returns a URL that accepts a PUT of the object until expiry.
+	// Local backends accept a single upload per key (see SignedURLVerifier);
+	// S3 presigned URLs can be replayed until they expire.
+	PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// PresignGet returns a URL that serves the object until expiry. params are
+	// carried (and covered by the signature) in the URL, e.g. to bind it to a grant.
+	PresignGet(ctx context.Context, key string, expiry time.Duration, params url.Values) (string, error)
+}
+
+// SignedURLVerifier is implemented by backends whose presigned URLs are served
+// by this server (rather than by an external object store)
+type SignedURLVerifier interface {
+	VerifySignedURL(op, key string, query url.Values) error
+	// PutSigned stores an upload received on a signed PUT URL. It never
+	// replaces an existing object and returns ErrBlobExists instead, so a
+	// replayed URL cannot overwrite the original upload.
+	PutSigned(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+}
+
+// validateKey rejects keys that are empty, absolute or contain parent references
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidStorageKey
+	}
+	if path.Clean(key) != key {
+		return ErrInvalidStorageKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." {
+			return ErrInvalidStorageKey
+		}
+	}
+	return nil
+}
+
+// urlSigner issues and checks HMAC-signed, expiring URLs for local backends
+type urlSigner struct {
+	secret  []byte
+	baseURL string
+}
+
+// newURLSigner creates a signer. An empty secret generates a random
+// per-process secret, which invalidates outstanding URLs on restart.
+func newURLSigner(secret []byte, baseURL string) (*urlSigner, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate URL signing secret: %w", err)
+		}
+		fmt.Printf("[Storage] STORAGE_SIGNING_SECRET not set, using ephemeral secret\n")
+	}
+	return &urlSigner{
+		secret:  secret,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+// reservedURLParams are set by the signer itself and cannot be passed as extra params
+var reservedURLParams = []string{"op", "expires", "sig"}
+
+// mac computes HMAC-SHA256(secret, op || 0x00 || key || 0x00 || expires || 0x00 || params)
+// where params is the canonical (sorted) encoding of any extra query parameters
+func (u *urlSigner) mac(op, key string, expires int64, params url.Values) []byte {
+	h := hmac.New(sha256.New, u.secret)
+	h.Write([]byte(op))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(expires, 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(params.Encode()))
+	return h.Sum(nil)
+}
+
+// sign returns a URL for op on key that expires after expiry
+func (u *urlSigner) sign(op, key string, expiry time.Duration, params url.Values) string {
+	expires := time.Now().Add(expiry).Unix()
+
+	extra := url.Values{}
+	for k, v := range params {
+		extra[k] = v
+	}
+	for _, k := range reservedURLParams {
+		extra.Del(k)
+	}
+
+	q := url.Values{}
+	for k, v := range extra {
+		q[k] = v
+	}
+	q.Set("op", op)
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", hex.EncodeToString(u.mac(op, key, expires, extra)))
+
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+
+	return u.baseURL + LocalBlobPath + strings.Join(parts, "/") + "?" + q.Encode()
+}
+
+// verify checks the op, expiry and signature carried in query
+func (u *urlSigner) verify(op, key string, query url.Values) error {
+	if query.Get("op") != op {
+		return ErrInvalidSignedURL
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrInvalidSignedURL
+	}
+
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil {
+		return ErrInvalidSignedURL
+	}
+
+	extra := url.Values{}
+	for k, v := range query {
+		extra[k] = v
+	}
+	for _, k := range reservedURLParams {
+		extra.Del(k)
+	}
+
+	if !hmac.Equal(sig, u.mac(op, key, expires, extra)) {
+		return ErrInvalidSignedURL
+	}
+
+	return nil
+}
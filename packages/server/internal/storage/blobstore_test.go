@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigningSecret is a fixed secret so signed URLs are reproducible
+var testSigningSecret = []byte("blobstore-conformance-test-secret")
+
+// localBlobStore is a backend that serves its own signed URLs
+type localBlobStore interface {
+	BlobStore
+	SignedURLVerifier
+}
+
+// testBlobStore runs the BlobStore conformance checks against store
+func testBlobStore(t *testing.T, store localBlobStore) {
+	ctx := context.Background()
+	data := []byte("0123456789abcdef")
+
+	t.Run("PutGet", func(t *testing.T) {
+		if err := store.Put(ctx, "attachments/a/blob.bin", bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		got, err := readBlob(store.Get(ctx, "attachments/a/blob.bin"))
+		if err != nil {
+			t.Fatalf("read blob: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Get = %q, want %q", got, data)
+		}
+
+		// Put replaces existing objects
+		replacement := []byte("replaced")
+		if err := store.Put(ctx, "attachments/a/blob.bin", bytes.NewReader(replacement), -1, ""); err != nil {
+			t.Fatalf("Put (replace): %v", err)
+		}
+		got, err = readBlob(store.Get(ctx, "attachments/a/blob.bin"))
+		if err != nil {
+			t.Fatalf("read blob: %v", err)
+		}
+		if !bytes.Equal(got, replacement) {
+			t.Fatalf("Get after replace = %q, want %q", got, replacement)
+		}
+	})
+
+	t.Run("PutSizeMismatch", func(t *testing.T) {
+		if err := store.Put(ctx, "attachments/a/short.bin", bytes.NewReader(data), int64(len(data))+1, ""); err == nil {
+			t.Fatal("Put accepted a body shorter than size")
+		}
+		if _, err := store.Stat(ctx, "attachments/a/short.bin"); !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("Stat after failed Put = %v, want ErrBlobNotFound", err)
+		}
+	})
+
+	t.Run("GetRange", func(t *testing.T) {
+		if err := store.Put(ctx, "attachments/a/range.bin", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		tests := []struct {
+			offset, length int64
+			want            string
+		}{
+			{0, -1, "0123456789abcdef"},
+			{0, 4, "0123"},
+			{10, -1, "abcdef"},
+			{10, 100, "abcdef"},
+			{15, 1, "f"},
+		}
+		for _, tt := range tests {
+			got, err := readBlob(store.GetRange(ctx, "attachments/a/range.bin", tt.offset, tt.length))
+			if err != nil {
+				t.Fatalf("read blob: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("GetRange(%d, %d) = %q, want %q", tt.offset, tt.length, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("StatDelete", func(t *testing.T) {
+		if err := store.Put(ctx, "attachments/a/stat.bin", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		info, err := store.Stat(ctx, "attachments/a/stat.bin")
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if info.Key != "attachments/a/stat.bin" || info.Size != int64(len(data)) {
+			t.Fatalf("Stat = %+v, want key and size %d", info, len(data))
+		}
+
+		if err := store.Delete(ctx, "attachments/a/stat.bin"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Stat(ctx, "attachments/a/stat.bin"); !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("Stat after Delete = %v, want ErrBlobNotFound", err)
+		}
+		if _, err := store.Get(ctx, "attachments/a/stat.bin"); !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("Get after Delete = %v, want ErrBlobNotFound", err)
+		}
+		if err := store.Delete(ctx, "attachments/a/stat.bin"); err != nil {
+			t.Fatalf("Delete of missing key: %v", err)
+		}
+	})
+
+	t.Run("InvalidKeys", func(t *testing.T) {
+		for _, key := range []string{"", "/abs", "../escape", "a/../b", "a/./b", "a//b", "a\\b", "a/"} {
+			if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), ""); !errors.Is(err, ErrInvalidStorageKey) {
+				t.Errorf("Put(%q) = %v, want ErrInvalidStorageKey", key, err)
+			}
+			if _, err := store.PresignPut(ctx, key, time.Minute); !errors.Is(err, ErrInvalidStorageKey) {
+				t.Errorf("PresignPut(%q) = %v, want ErrInvalidStorageKey", key, err)
+			}
+		}
+	})
+
+	t.Run("PutSigned", func(t *testing.T) {
+		if err := store.PutSigned(ctx, "attachments/a/signed.bin", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+			t.Fatalf("PutSigned: %v", err)
+		}
+		err := store.PutSigned(ctx, "attachments/a/signed.bin", strings.NewReader("replay"), 6, "")
+		if !errors.Is(err, ErrBlobExists) {
+			t.Fatalf("second PutSigned = %v, want ErrBlobExists", err)
+		}
+		got, err := readBlob(store.Get(ctx, "attachments/a/signed.bin"))
+		if err != nil {
+			t.Fatalf("read blob: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("replayed PutSigned overwrote the object: %q", got)
+		}
+	})
+
+	t.Run("SignedURLs", func(t *testing.T) {
+		const key = "attachments/b/file name.bin"
+
+		putURL, err := store.PresignPut(ctx, key, time.Minute)
+		if err != nil {
+			t.Fatalf("PresignPut: %v", err)
+		}
+		putKey, putQuery := parseSignedURL(t, putURL)
+		if putKey != key {
+			t.Fatalf("signed URL path decodes to %q, want %q", putKey, key)
+		}
+		if err := store.VerifySignedURL(BlobOpPut, key, putQuery); err != nil {
+			t.Fatalf("VerifySignedURL(put): %v", err)
+		}
+		if err := store.VerifySignedURL(BlobOpGet, key, putQuery); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("put URL verified for get: %v", err)
+		}
+		if err := store.VerifySignedURL(BlobOpPut, "attachments/b/other.bin", putQuery); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("put URL verified for another key: %v", err)
+		}
+
+		params := url.Values{GrantParamUser: {"user-1"}}
+		getURL, err := store.PresignGet(ctx, key, time.Minute, params)
+		if err != nil {
+			t.Fatalf("PresignGet: %v", err)
+		}
+		_, getQuery := parseSignedURL(t, getURL)
+		if err := store.VerifySignedURL(BlobOpGet, key, getQuery); err != nil {
+			t.Fatalf("VerifySignedURL(get): %v", err)
+		}
+
+		tampered := cloneValues(getQuery)
+		tampered.Set(GrantParamUser, "user-2")
+		if err := store.VerifySignedURL(BlobOpGet, key, tampered); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("tampered bound param verified: %v", err)
+		}
+
+		added := cloneValues(getQuery)
+		added.Set("extra", "1")
+		if err := store.VerifySignedURL(BlobOpGet, key, added); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("URL with an added param verified: %v", err)
+		}
+
+		dropped := cloneValues(getQuery)
+		dropped.Del(GrantParamUser)
+		if err := store.VerifySignedURL(BlobOpGet, key, dropped); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("URL with a dropped param verified: %v", err)
+		}
+
+		extended := cloneValues(getQuery)
+		expires, _ := strconv.ParseInt(extended.Get("expires"), 10, 64)
+		extended.Set("expires", strconv.FormatInt(expires+3600, 10))
+		if err := store.VerifySignedURL(BlobOpGet, key, extended); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("URL with an extended expiry verified: %v", err)
+		}
+
+		badSig := cloneValues(getQuery)
+		badSig.Set("sig", "zz")
+		if err := store.VerifySignedURL(BlobOpGet, key, badSig); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("URL with a malformed signature verified: %v", err)
+		}
+
+		expiredURL, err := store.PresignGet(ctx, key, -time.Minute, nil)
+		if err != nil {
+			t.Fatalf("PresignGet (expired): %v", err)
+		}
+		_, expiredQuery := parseSignedURL(t, expiredURL)
+		if err := store.VerifySignedURL(BlobOpGet, key, expiredQuery); !errors.Is(err, ErrInvalidSignedURL) {
+			t.Errorf("expired URL verified: %v", err)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	store, err := NewMemoryStore("http://localhost:8080", testSigningSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+}
+
+func TestFilesystemStore(t *testing.T) {
+	store, err := NewFilesystemStore(t.TempDir(), "http://localhost:8080", testSigningSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+}
+
+// TestSignedURLSecret checks that URLs signed with one secret do not verify under another
+func TestSignedURLSecret(t *testing.T) {
+	a, err := NewMemoryStore("http://localhost:8080", testSigningSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMemoryStore("http://localhost:8080", []byte("another-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := a.PresignGet(context.Background(), "attachments/c/blob.bin", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, query := parseSignedURL(t, signed)
+	if err := b.VerifySignedURL(BlobOpGet, "attachments/c/blob.bin", query); !errors.Is(err, ErrInvalidSignedURL) {
+		t.Fatalf("URL verified under a different secret: %v", err)
+	}
+}
+
+// readBlob reads and closes the reader returned by a Get/GetRange call
+func readBlob(rc io.ReadCloser, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// parseSignedURL splits a local signed URL into its storage key and query
+func parseSignedURL(t *testing.T, raw string) (string, url.Values) {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse signed URL: %v", err)
+	}
+	if !strings.HasPrefix(u.Path, LocalBlobPath) {
+		t.Fatalf("signed URL path %q is not under %s", u.Path, LocalBlobPath)
+	}
+	return strings.TrimPrefix(u.Path, LocalBlobPath), u.Query()
+}
+
+func cloneValues(v url.Values) url.Values {
+	out := url.Values{}
+	for k, vs := range v {
+		out[k] = append([]string(nil), vs...)
+	}
+	return out
+}
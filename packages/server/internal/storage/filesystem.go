@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FilesystemStore stores blobs as files under a root directory and serves
+// its own signed upload/download URLs through the main router
+type FilesystemStore struct {
+	root   string
+	signer *urlSigner
+}
+
+// NewFilesystemStore creates a store rooted at dir. Signed URLs are issued
+// against baseURL (the public origin of this server) and authenticated with secret.
+func NewFilesystemStore(dir string, baseURL string, secret []byte) (*FilesystemStore, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve storage directory: %w", err)
+	}
+
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	signer, err := newURLSigner(secret, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilesystemStore{
+		root:   root,
+		signer: signer,
+	}, nil
+}
+
+// path maps a storage key to a file path inside the root
+func (f *FilesystemStore) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(f.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temp file and renames it into place so
+// readers never observe a partial upload
+func (f *FilesystemStore) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	return f.write(key, reader, size, true)
+}
+
+// PutSigned writes the object like Put but refuses to replace an existing file
+func (f *FilesystemStore) PutSigned(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	return f.write(key, reader, size, false)
+}
+
+// write stores reader under key via a temp file. Without replace the temp
+// file is hard-linked into place, which fails atomically if key exists.
+func (f *FilesystemStore) write(key string, reader io.Reader, size int64, replace bool) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	written, err := io.Copy(tmp, reader)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if size >= 0 && written != size {
+		tmp.Close()
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, written)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if replace {
+		return os.Rename(tmp.Name(), p)
+	}
+	if err := os.Link(tmp.Name(), p); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrBlobExists
+		}
+		return err
+	}
+	return nil
+}
+
+// Get opens the file for key
+func (f *FilesystemStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return file, err
+}
+
+// GetRange opens the file for key and seeks to offset
+func (f *FilesystemStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := f.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	file := rc.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return limitedReadCloser{io.LimitReader(file, length), file}, nil
+}
+
+// Stat returns file metadata. Content type is derived from the key's extension
+// since blobs are opaque encrypted data.
+func (f *FilesystemStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlobInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  contentTypeForKey(key),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+// Delete removes the file for key
+func (f *FilesystemStore) Delete(ctx context.Context, key string) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PresignPut returns a signed URL served by this server
+func (f *FilesystemStore) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return f.signer.sign(BlobOpPut, key, expiry, nil), nil
+}
+
+// PresignGet returns a signed URL served by this server
+func (f *FilesystemStore) PresignGet(ctx context.Context, key string, expiry time.Duration, params url.Values) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return f.signer.sign(BlobOpGet, key, expiry, params), nil
+}
+
+// VerifySignedURL checks a URL previously issued by PresignPut/PresignGet
+func (f *FilesystemStore) VerifySignedURL(op, key string, query url.Values) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	return f.signer.verify(op, key, query)
+}
+
+// contentTypeForKey guesses a content type from the key's extension
+func contentTypeForKey(key string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(key)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// limitedReadCloser closes the underlying file of a limited reader
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
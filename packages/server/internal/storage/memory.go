@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps blobs in process memory. Intended for tests and
+// ephemeral development servers; contents are lost on restart.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	signer  *urlSigner
+}
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store. Signed URLs are issued
+// against baseURL and served through the main router like FilesystemStore.
+func NewMemoryStore(baseURL string, secret []byte) (*MemoryStore, error) {
+	signer, err := newURLSigner(secret, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryStore{
+		objects: make(map[string]memoryObject),
+		signer:  signer,
+	}, nil
+}
+
+// Put stores a copy of the reader's contents
+func (m *MemoryStore) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	return m.put(key, reader, size, contentType, true)
+}
+
+// PutSigned stores the object like Put but refuses to replace an existing one
+func (m *MemoryStore) PutSigned(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	return m.put(key, reader, size, contentType, false)
+}
+
+func (m *MemoryStore) put(key string, reader io.Reader, size int64, contentType string, replace bool) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return io.ErrUnexpectedEOF
+	}
+	if contentType == "" {
+		contentType = contentTypeForKey(key)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.objects[key]; exists && !replace {
+		return ErrBlobExists
+	}
+	m.objects[key] = memoryObject{
+		data:         data,
+		contentType:  contentType,
+		lastModified: time.Now(),
+	}
+	return nil
+}
+
+// Get returns a reader over the stored object
+func (m *MemoryStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// GetRange returns a reader over part of the stored object
+func (m *MemoryStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	if offset < 0 || offset > int64(len(obj.data)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	end := int64(len(obj.data))
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(obj.data[offset:end])), nil
+}
+
+// Stat returns object metadata
+func (m *MemoryStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+
+	return &BlobInfo{
+		Key:          key,
+		Size:         int64(len(obj.data)),
+		ContentType:  obj.contentType,
+		LastModified: obj.lastModified,
+	}, nil
+}
+
+// Delete removes an object
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.objects, key)
+	m.mu.Unlock()
+	return nil
+}
+
+// PresignPut returns a signed URL served by this server
+func (m *MemoryStore) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return m.signer.sign(BlobOpPut, key, expiry, nil), nil
+}
+
+// PresignGet returns a signed URL served by this server
+func (m *MemoryStore) PresignGet(ctx context.Context, key string, expiry time.Duration, params url.Values) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return m.signer.sign(BlobOpGet, key, expiry, params), nil
+}
+
+// VerifySignedURL checks a URL previously issued by PresignPut/PresignGet
+func (m *MemoryStore) VerifySignedURL(op, key string, query url.Values) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	return m.signer.verify(op, key, query)
+}
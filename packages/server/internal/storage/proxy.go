@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transfer modes a client can negotiate for uploads and downloads
+const (
+	// TransferModePresigned sends the client straight to the blob store
+	TransferModePresigned = "presigned"
+	// TransferModeProxy streams the blob through this server's authenticated endpoints
+	TransferModeProxy = "proxy"
+	// TransferModeAuto lets the server pick its configured default
+	TransferModeAuto = "auto"
+)
+
+// ProxyPath is the router path prefix of the streaming proxy endpoints
+const ProxyPath = "/api/storage/proxy/"
+
+// DefaultMaxProxyTransfers bounds concurrent proxied transfers per server
+const DefaultMaxProxyTransfers = 64
+
+// ProxyTransferTimeout replaces the HTTP server's short read/write timeouts
+// for a single proxied transfer
+const ProxyTransferTimeout = 30 * time.Minute
+
+var (
+	// ErrUnsupportedTransferMode is returned for unknown or disabled transfer modes
+	ErrUnsupportedTransferMode = errors.New("unsupported transfer mode")
+
+	// ErrTooManyTransfers is returned when all proxy transfer slots are in use
+	ErrTooManyTransfers = errors.New("too many concurrent transfers")
+
+	// ErrBlobExists is returned when a proxied upload targets an existing object
+	ErrBlobExists = errors.New("blob already exists")
+
+	// ErrInvalidRange is returned for unsatisfiable or malformed Range headers
+	ErrInvalidRange = errors.New("invalid range")
+)
+
+// TransferCapabilities describes the transfer modes a client may negotiate
+type TransferCapabilities struct {
+	Modes            []string `json:"modes"`
+	DefaultMode      string   `json:"default_mode"`
+	MaxBlobSize      int64    `json:"max_blob_size"`
+	MaxThumbnailSize int64    `json:"max_thumbnail_size"`
+	RangeRequests    bool     `json:"range_requests"`
+}
+
+// transferConfig holds proxy settings read from the environment
+type transferConfig struct {
+	publicURL          string
+	defaultMode        string
+	allowUnboundGrants bool
+	slots              chan struct{}
+}
+
+// transferConfigFromEnv reads STORAGE_TRANSFER_MODE (presigned or proxy),
+// STORAGE_ALLOW_UNBOUND_GRANTS and STORAGE_PROXY_MAX_TRANSFERS
+func transferConfigFromEnv() transferConfig {
+	mode := os.Getenv("STORAGE_TRANSFER_MODE")
+	if mode != TransferModeProxy {
+		mode = TransferModePresigned
+	}
+
+	maxTransfers := DefaultMaxProxyTransfers
+	if v, err := strconv.Atoi(os.Getenv("STORAGE_PROXY_MAX_TRANSFERS")); err == nil && v > 0 {
+		maxTransfers = v
+	}
+
+	return transferConfig{
+		publicURL:          strings.TrimRight(os.Getenv("STORAGE_PUBLIC_URL"), "/"),
+		defaultMode:        mode,
+		allowUnboundGrants: os.Getenv("STORAGE_ALLOW_UNBOUND_GRANTS") == "true",
+		slots:              make(chan struct{}, maxTransfers),
+	}
+}
+
+// Capabilities returns the transfer modes this server supports
+func (s *Service) Capabilities() TransferCapabilities {
+	return TransferCapabilities{
+		Modes:            []string{TransferModePresigned, TransferModeProxy},
+		DefaultMode:      s.transfer.defaultMode,
+		MaxBlobSize:      MaxBlobSize,
+		MaxThumbnailSize: MaxThumbnailSize,
+		RangeRequests:    true,
+	}
+}
+
+// ResolveTransferMode maps a client-requested mode to the mode the server will use
+func (s *Service) ResolveTransferMode(requested string) (string, error) {
+	switch requested {
+	case "", TransferModeAuto:
+		return s.transfer.defaultMode, nil
+	case TransferModePresigned, TransferModeProxy:
+		return requested, nil
+	default:
+		return "", ErrUnsupportedTransferMode
+	}
+}
+
+// ProxyURL returns the streaming proxy URL for a storage key. The URL is
+// relative unless STORAGE_PUBLIC_URL is set.
+func (s *Service) ProxyURL(storageKey string) string {
+	parts := strings.Split(storageKey, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return s.transfer.publicURL + ProxyPath + strings.Join(parts, "/")
+}
+
+// AcquireTransfer reserves a proxy transfer slot. The returned release func
+// must be called when the transfer ends. Rejecting excess transfers (rather
+// than queueing them) keeps slow clients from tying up server memory.
+func (s *Service) AcquireTransfer() (func(), error) {
+	select {
+	case s.transfer.slots <- struct{}{}:
+		return func() { <-s.transfer.slots }, nil
+	default:
+		return nil, ErrTooManyTransfers
+	}
+}
+
+// AuthorizeProxyUpload checks that userID may upload storageKey through the
+// proxy. Keys are issued as "<conversation_id>/<uuid>.<ext>" by
+// GenerateUploadURL; the caller must participate in that conversation and the
+// object must not exist yet, so proxied uploads can't overwrite other blobs.
+func (s *Service) AuthorizeProxyUpload(ctx context.Context, userID uuid.UUID, storageKey string) error {
+	if err := validateKey(storageKey); err != nil {
+		return err
+	}
+
+	prefix, _, ok := strings.Cut(storageKey, "/")
+	if !ok {
+		return ErrInvalidStorageKey
+	}
+	conversationID, err := uuid.Parse(prefix)
+	if err != nil {
+		return ErrInvalidStorageKey
+	}
+
+	if err := s.checkParticipant(ctx, conversationID, userID); err != nil {
+		return err
+	}
+
+	if _, err := s.store.Stat(ctx, storageKey); err == nil {
+		return ErrBlobExists
+	} else if !errors.Is(err, ErrBlobNotFound) {
+		return fmt.Errorf("failed to check existing blob: %w", err)
+	}
+
+	return nil
+}
+
+// AuthorizeProxyDownload checks that userID participates in the conversation
+// owning storageKey and returns the blob's metadata. The access is recorded
+// once per transfer: seeking and resuming send many Range requests, so only a
+// request whose resolved range starts at offset 0 is logged. Any request that
+// reads the first byte, however its range is written, is therefore recorded.
+func (s *Service) AuthorizeProxyDownload(ctx context.Context, userID uuid.UUID, storageKey, rangeHeader string, ac AccessContext) (*BlobInfo, error) {
+	res, accessType, err := s.resolveByStorageKey(ctx, storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkParticipant(ctx, res.conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	info, err := s.store.Stat(ctx, storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if startsTransfer(rangeHeader, info.Size) {
+		if err := s.recordGrant(ctx, uuid.New(), res, userID, storageKey, accessType, TransferModeProxy, ac, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// startsTransfer reports whether a Range header (possibly empty) requests the
+// blob from its first byte once resolved against size. Suffix ranges that
+// cover the whole blob resolve to offset 0 too. Unsatisfiable ranges are
+// rejected before anything is served, so they don't start a transfer.
+func startsTransfer(rangeHeader string, size int64) bool {
+	if rangeHeader == "" {
+		return true
+	}
+	offset, _, err := ParseRange(rangeHeader, size)
+	return err == nil && offset == 0
+}
+
+// OpenRange opens part of a blob for streaming
+func (s *Service) OpenRange(ctx context.Context, storageKey string, offset, length int64) (io.ReadCloser, error) {
+	return s.store.GetRange(ctx, storageKey, offset, length)
+}
+
+// ParseRange parses a single-range "bytes=" Range header against an object of
+// the given size and returns the offset and length to serve. Multi-range
+// requests are not supported.
+func ParseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, ErrInvalidRange
+	}
+
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, ErrInvalidRange
+	}
+
+	if startStr == "" {
+		// Suffix range: last N bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, ErrInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, ErrInvalidRange
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, ErrInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, nil
+}
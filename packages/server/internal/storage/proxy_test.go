@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+
+	tests := []struct {
+		header         string
+		offset, length int64
+		err            bool
+	}{
+		{header: "bytes=0-", offset: 0, length: 100},
+		{header: "bytes=0-0", offset: 0, length: 1},
+		{header: "bytes=0-99", offset: 0, length: 100},
+		{header: "bytes=0-500", offset: 0, length: 100},
+		{header: "bytes=10-19", offset: 10, length: 10},
+		{header: "bytes=99-", offset: 99, length: 1},
+		{header: "bytes= 5-9", offset: 5, length: 5},
+		{header: "bytes=-1", offset: 99, length: 1},
+		{header: "bytes=-10", offset: 90, length: 10},
+		{header: "bytes=-100", offset: 0, length: 100},
+		{header: "bytes=-1000", offset: 0, length: 100},
+		{header: "bytes=100-", err: true},
+		{header: "bytes=20-10", err: true},
+		{header: "bytes=-0", err: true},
+		{header: "bytes=-", err: true},
+		{header: "bytes=0-1,5-6", err: true},
+		{header: "bytes=a-b", err: true},
+		{header: "bytes=5", err: true},
+		{header: "items=0-1", err: true},
+		{header: "", err: true},
+	}
+
+	for _, tt := range tests {
+		offset, length, err := ParseRange(tt.header, size)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidRange) {
+				t.Errorf("ParseRange(%q) error = %v, want ErrInvalidRange", tt.header, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRange(%q) error = %v", tt.header, err)
+			continue
+		}
+		if offset != tt.offset || length != tt.length {
+			t.Errorf("ParseRange(%q) = (%d, %d), want (%d, %d)", tt.header, offset, length, tt.offset, tt.length)
+		}
+	}
+}
+
+func TestStartsTransfer(t *testing.T) {
+	const size = 100
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", true},
+		{"bytes=0-", true},
+		{"bytes=0-0", true},
+		{"bytes= 0-10", true},
+		{"bytes=-100", true},
+		{"bytes=-5000", true},
+		{"bytes=1-", false},
+		{"bytes=50-99", false},
+		{"bytes=-99", false},
+		{"bytes=-1", false},
+		{"bytes=100-", false},
+		{"bytes=0-1,5-6", false},
+	}
+
+	for _, tt := range tests {
+		if got := startsTransfer(tt.header, size); got != tt.want {
+			t.Errorf("startsTransfer(%q, %d) = %v, want %v", tt.header, size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config configures an S3-compatible backend (AWS S3, Cloudflare R2, MinIO)
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+// S3ConfigFromEnv reads S3_* environment variables.
+// Credentials have no defaults and must be set explicitly.
+func S3ConfigFromEnv() S3Config {
+	cfg := S3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		Region:    os.Getenv("S3_REGION"),
+		UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+	}
+
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "localhost:9000" // Default MinIO local
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = "nochat-files"
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	return cfg
+}
+
+// S3Store stores blobs in an S3-compatible bucket
+type S3Store struct {
+	client       *minio.Client
+	bucketName   string
+	bucketRegion string
+}
+
+// NewS3Store connects to the bucket described by cfg, creating it if needed
+func NewS3Store(ctx context.Context, cfg S3Config) (*S3Store, error) {
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("S3_ACCESS_KEY and S3_SECRET_KEY are required for the s3 storage backend")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	store := &S3Store{
+		client:       client,
+		bucketName:   cfg.Bucket,
+		bucketRegion: cfg.Region,
+	}
+
+	if err := store.ensureBucket(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ensure bucket: %w", err)
+	}
+
+	return store, nil
+}
+
+// ensureBucket creates the bucket if it doesn't exist
+func (s *S3Store) ensureBucket(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucketName)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		err = s.client.MakeBucket(ctx, s.bucketName, minio.MakeBucketOptions{
+			Region: s.bucketRegion,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[Storage] Created bucket: %s\n", s.bucketName)
+	}
+
+	return nil
+}
+
+// Put uploads an object
+func (s *S3Store) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucketName, key, reader, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+// Get opens an object for reading
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	// GetObject is lazy, so stat first to surface missing keys
+	if _, err := s.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.bucketName, key, minio.GetObjectOptions{})
+}
+
+// GetRange opens part of an object using an HTTP Range request to S3
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+
+	opts := minio.GetObjectOptions{}
+	end := int64(0) // SetRange treats end=0 as "to the end of the object"
+	if length > 0 {
+		end = offset + length - 1
+	}
+	if err := opts.SetRange(offset, end); err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.bucketName, key, opts)
+}
+
+// Stat returns object metadata
+func (s *S3Store) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+
+	return &BlobInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// Delete removes an object
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucketName, key, minio.RemoveObjectOptions{})
+}
+
+// PresignPut generates a pre-signed PUT URL served directly by S3
+func (s *S3Store) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedPutObject(ctx, s.bucketName, key, expiry)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// PresignGet generates a pre-signed GET URL served directly by S3. params are
+// included in the signed query string; S3 ignores parameters it doesn't know.
+func (s *S3Store) PresignGet(ctx context.Context, key string, expiry time.Duration, params url.Values) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucketName, key, expiry, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -3,102 +3,161 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/kindlyrobotics/nochat/internal/models"
+	"github.com/lib/pq"
+)
 
-	// Using minio-go for S3-compatible storage
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+// Storage backends selectable via STORAGE_BACKEND
+const (
+	BackendS3         = "s3"
+	BackendFilesystem = "filesystem"
+	BackendMemory     = "memory"
 )
 
-type Service struct {
-	db           *sql.DB
-	client       *minio.Client
-	bucketName   string
-	bucketRegion string
-}
+// MaxBlobSize is the largest object accepted through server-handled uploads
+const MaxBlobSize int64 = 100 << 20 // 100 MB
 
-// NewService creates a new storage service
-func NewService(db *sql.DB) (*Service, error) {
-	endpoint := os.Getenv("S3_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "localhost:9000" // Default MinIO local
-	}
+// MaxThumbnailSize is the largest encrypted thumbnail accepted for an attachment
+// (matches attachments_thumbnail_size_check)
+const MaxThumbnailSize int64 = 256 << 10 // 256 KiB
 
-	accessKey := os.Getenv("S3_ACCESS_KEY")
-	if accessKey == "" {
-		accessKey = "minioadmin" // Default MinIO credentials
-	}
+var (
+	// ErrThumbnailTooLarge is returned when a thumbnail exceeds MaxThumbnailSize
+	ErrThumbnailTooLarge = errors.New("thumbnail exceeds maximum size")
 
-	secretKey := os.Getenv("S3_SECRET_KEY")
-	if secretKey == "" {
-		secretKey = "minioadmin" // Default MinIO credentials
-	}
+	// ErrInvalidThumbnail is returned when a thumbnail is not paired with its attachment
+	// or is missing its wrapped key
+	ErrInvalidThumbnail = errors.New("invalid thumbnail")
 
-	bucketName := os.Getenv("S3_BUCKET")
-	if bucketName == "" {
-		bucketName = "nochat-files"
-	}
+	// ErrStorageKeyInUse is returned when a blob is already attached to another attachment
+	ErrStorageKeyInUse = errors.New("storage key already attached")
+)
 
-	bucketRegion := os.Getenv("S3_REGION")
-	if bucketRegion == "" {
-		bucketRegion = "us-east-1"
+// attachmentColumns is the column list scanned by scanAttachment. Columns are
+// qualified so the list can be used in joins.
+const attachmentColumns = `attachments.id, attachments.message_id, attachments.storage_key,
+		       attachments.file_name, attachments.file_size, attachments.mime_type,
+		       attachments.thumbnail_key, attachments.encrypted_metadata, attachments.created_at,
+		       attachments.encrypted_file_key, attachments.file_key_nonce,
+		       COALESCE(attachments.file_key_algorithm, ''), COALESCE(attachments.checksum_sha256, ''),
+		       attachments.thumbnail_size, attachments.thumbnail_encrypted_key, attachments.thumbnail_key_nonce,
+		       attachments.thumbnail_key_algorithm, attachments.thumbnail_checksum_sha256`
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAttachment scans a row selected with attachmentColumns, followed by any
+// extra columns into extra
+func scanAttachment(row rowScanner, extra ...interface{}) (*models.Attachment, error) {
+	var att models.Attachment
+	dest := []interface{}{&att.ID, &att.MessageID, &att.StorageKey, &att.FileName,
+		&att.FileSize, &att.MimeType, &att.ThumbnailKey,
+		&att.EncryptedMetadata, &att.CreatedAt,
+		&att.EncryptedFileKey, &att.FileKeyNonce, &att.FileKeyAlgorithm, &att.ChecksumSHA256,
+		&att.ThumbnailSize, &att.ThumbnailEncryptedKey, &att.ThumbnailKeyNonce,
+		&att.ThumbnailKeyAlgorithm, &att.ThumbnailChecksumSHA256}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
 	}
+	return &att, nil
+}
 
-	useSSL := os.Getenv("S3_USE_SSL") == "true"
+// ThumbnailKeyFor returns the storage key of the thumbnail paired with an attachment
+func ThumbnailKeyFor(storageKey string) string {
+	return strings.TrimSuffix(storageKey, filepath.Ext(storageKey)) + ".thumb"
+}
+
+type Service struct {
+	db       *sql.DB
+	store    BlobStore
+	transfer transferConfig
+}
 
-	// Initialize minio client
-	client, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: useSSL,
-	})
+// NewService creates a new storage service using the backend named by
+// STORAGE_BACKEND (s3, filesystem or memory; default s3)
+func NewService(db *sql.DB) (*Service, error) {
+	store, err := NewBlobStoreFromEnv(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+		return nil, err
 	}
+	return NewServiceWithStore(db, store), nil
+}
 
-	service := &Service{
-		db:           db,
-		client:       client,
-		bucketName:   bucketName,
-		bucketRegion: bucketRegion,
+// NewServiceWithStore creates a storage service on top of an existing backend
+func NewServiceWithStore(db *sql.DB, store BlobStore) *Service {
+	return &Service{
+		db:       db,
+		store:    store,
+		transfer: transferConfigFromEnv(),
 	}
+}
 
-	// Ensure bucket exists
-	if err := service.ensureBucket(context.Background()); err != nil {
-		return nil, fmt.Errorf("failed to ensure bucket: %w", err)
+// NewBlobStoreFromEnv builds the backend selected by STORAGE_BACKEND.
+// Local backends sign their URLs with STORAGE_SIGNING_SECRET and issue them
+// against STORAGE_PUBLIC_URL (the externally reachable origin of this server).
+func NewBlobStoreFromEnv(ctx context.Context) (BlobStore, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		backend = BackendS3
 	}
 
-	return service, nil
-}
-
-// ensureBucket creates the bucket if it doesn't exist
-func (s *Service) ensureBucket(ctx context.Context) error {
-	exists, err := s.client.BucketExists(ctx, s.bucketName)
-	if err != nil {
-		return err
+	publicURL := os.Getenv("STORAGE_PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
 	}
+	secret := []byte(os.Getenv("STORAGE_SIGNING_SECRET"))
 
-	if !exists {
-		err = s.client.MakeBucket(ctx, s.bucketName, minio.MakeBucketOptions{
-			Region: s.bucketRegion,
-		})
-		if err != nil {
-			return err
+	switch backend {
+	case BackendS3:
+		return NewS3Store(ctx, S3ConfigFromEnv())
+
+	case BackendFilesystem:
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "data/blobs"
 		}
-		fmt.Printf("[Storage] Created bucket: %s\n", s.bucketName)
+		fmt.Printf("[Storage] Using filesystem backend at %s\n", dir)
+		return NewFilesystemStore(dir, publicURL, secret)
+
+	case BackendMemory:
+		fmt.Printf("[Storage] Using in-memory backend (contents are not persisted)\n")
+		return NewMemoryStore(publicURL, secret)
+
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
 	}
+}
 
-	return nil
+// Store returns the underlying blob backend
+func (s *Service) Store() BlobStore {
+	return s.store
 }
 
-// GenerateUploadURL generates a pre-signed URL for uploading a file
+// GenerateUploadURL generates a pre-signed URL for uploading a file, plus a
+// paired thumbnail upload URL when req.ThumbnailSize is set. In proxy mode the
+// URLs point at the authenticated streaming endpoints instead.
 func (s *Service) GenerateUploadURL(ctx context.Context, req models.UploadRequest) (*models.UploadResponse, error) {
+	if req.ThumbnailSize < 0 || req.ThumbnailSize > MaxThumbnailSize {
+		return nil, ErrThumbnailTooLarge
+	}
+
+	mode, err := s.ResolveTransferMode(req.TransferMode)
+	if err != nil {
+		return nil, err
+	}
+
 	// Generate unique storage key
 	ext := filepath.Ext(req.FileName)
 	storageKey := fmt.Sprintf("%s/%s%s",
@@ -107,31 +166,38 @@ func (s *Service) GenerateUploadURL(ctx context.Context, req models.UploadReques
 		ext,
 	)
 
-	// Generate pre-signed PUT URL (valid for 15 minutes)
-	presignedURL, err := s.client.PresignedPutObject(ctx, s.bucketName, storageKey, 15*time.Minute)
+	// Generate upload URL (pre-signed PUT URLs are valid for 15 minutes)
+	uploadURL, err := s.uploadURL(ctx, storageKey, mode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate upload URL: %w", err)
 	}
 
-	return &models.UploadResponse{
-		UploadURL:  presignedURL.String(),
-		StorageKey: storageKey,
-		ExpiresAt:  time.Now().Add(15 * time.Minute),
-	}, nil
-}
+	resp := &models.UploadResponse{
+		UploadURL:    uploadURL,
+		StorageKey:   storageKey,
+		ExpiresAt:    time.Now().Add(15 * time.Minute),
+		TransferMode: mode,
+	}
 
-// GenerateDownloadURL generates a pre-signed URL for downloading a file
-func (s *Service) GenerateDownloadURL(ctx context.Context, storageKey string) (*models.DownloadResponse, error) {
-	// Generate pre-signed GET URL (valid for 1 hour)
-	presignedURL, err := s.client.PresignedGetObject(ctx, s.bucketName, storageKey, 1*time.Hour, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate download URL: %w", err)
+	if req.ThumbnailSize > 0 {
+		thumbnailKey := ThumbnailKeyFor(storageKey)
+		thumbnailURL, err := s.uploadURL(ctx, thumbnailKey, mode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate thumbnail upload URL: %w", err)
+		}
+		resp.ThumbnailUploadURL = thumbnailURL
+		resp.ThumbnailStorageKey = thumbnailKey
 	}
 
-	return &models.DownloadResponse{
-		DownloadURL: presignedURL.String(),
-		ExpiresAt:   time.Now().Add(1 * time.Hour),
-	}, nil
+	return resp, nil
+}
+
+// uploadURL returns where the client should PUT storageKey in the given mode
+func (s *Service) uploadURL(ctx context.Context, storageKey, mode string) (string, error) {
+	if mode == TransferModeProxy {
+		return s.ProxyURL(storageKey), nil
+	}
+	return s.store.PresignPut(ctx, storageKey, 15*time.Minute)
 }
 
 // CreateAttachment creates an attachment record in the database
@@ -165,23 +231,130 @@ func (s *Service) CreateAttachment(ctx context.Context, messageID uuid.UUID, sto
 	return attachment, nil
 }
 
+// CreateEncryptedAttachment records an uploaded E2EE file and its optional
+// encrypted thumbnail. The thumbnail blob must already be uploaded under
+// ThumbnailKeyFor(StorageKey) and is size-checked against the stored object,
+// since pre-signed uploads cannot enforce a size limit themselves. Storage keys
+// must live under the message's conversation and may only be attached once, so
+// a sender can't claim another conversation's blob.
+func (s *Service) CreateEncryptedAttachment(ctx context.Context, message *models.Message, req models.CreateAttachmentRequest) (*models.Attachment, error) {
+	if err := validateKey(req.StorageKey); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(req.StorageKey, message.ConversationID.String()+"/") {
+		return nil, ErrInvalidStorageKey
+	}
+
+	keys := []string{req.StorageKey}
+	if req.Thumbnail != nil {
+		keys = append(keys, req.Thumbnail.StorageKey)
+	}
+	if err := s.checkKeysUnattached(ctx, keys...); err != nil {
+		return nil, err
+	}
+	attachment := &models.Attachment{
+		ID:                uuid.New(),
+		MessageID:         message.ID,
+		StorageKey:        req.StorageKey,
+		FileName:          req.FileName,
+		FileSize:          req.FileSize,
+		MimeType:          req.MimeType,
+		EncryptedMetadata: req.EncryptedMetadata,
+		EncryptedFileKey:  req.EncryptedFileKey,
+		FileKeyNonce:      req.FileKeyNonce,
+		FileKeyAlgorithm:  req.FileKeyAlgorithm,
+		ChecksumSHA256:    req.ChecksumSHA256,
+		CreatedAt:         time.Now(),
+	}
+
+	if t := req.Thumbnail; t != nil {
+		if t.StorageKey != ThumbnailKeyFor(req.StorageKey) || len(t.EncryptedKey) == 0 || len(t.KeyNonce) == 0 {
+			return nil, ErrInvalidThumbnail
+		}
+
+		info, err := s.store.Stat(ctx, t.StorageKey)
+		if err != nil {
+			return nil, fmt.Errorf("thumbnail not uploaded: %w", err)
+		}
+		if info.Size > MaxThumbnailSize {
+			s.DeleteFile(ctx, t.StorageKey) // Ignore error
+			return nil, ErrThumbnailTooLarge
+		}
+		if info.Size != t.Size {
+			return nil, ErrInvalidThumbnail
+		}
+
+		attachment.ThumbnailKey = &t.StorageKey
+		attachment.ThumbnailSize = &info.Size
+		attachment.ThumbnailEncryptedKey = t.EncryptedKey
+		attachment.ThumbnailKeyNonce = t.KeyNonce
+		attachment.ThumbnailKeyAlgorithm = &t.KeyAlgorithm
+		if t.ChecksumSHA256 != "" {
+			attachment.ThumbnailChecksumSHA256 = &t.ChecksumSHA256
+		}
+	}
+
+	query := `
+		INSERT INTO attachments (id, message_id, storage_key, file_name, file_size, mime_type,
+		                         encrypted_metadata, created_at,
+		                         encrypted_file_key, file_key_nonce, file_key_algorithm, checksum_sha256,
+		                         thumbnail_key, thumbnail_size, thumbnail_encrypted_key, thumbnail_key_nonce,
+		                         thumbnail_key_algorithm, thumbnail_checksum_sha256)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), NULLIF($12, ''), $13, $14, $15, $16, $17, $18)
+	`
+
+	_, err := s.db.ExecContext(ctx, query,
+		attachment.ID, attachment.MessageID, attachment.StorageKey, attachment.FileName,
+		attachment.FileSize, attachment.MimeType, attachment.EncryptedMetadata, attachment.CreatedAt,
+		attachment.EncryptedFileKey, attachment.FileKeyNonce, attachment.FileKeyAlgorithm, attachment.ChecksumSHA256,
+		attachment.ThumbnailKey, attachment.ThumbnailSize, attachment.ThumbnailEncryptedKey, attachment.ThumbnailKeyNonce,
+		attachment.ThumbnailKeyAlgorithm, attachment.ThumbnailChecksumSHA256,
+	)
+	if isUniqueViolation(err) {
+		return nil, ErrStorageKeyInUse
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment: %w", err)
+	}
+
+	return attachment, nil
+}
+
+// checkKeysUnattached returns ErrStorageKeyInUse if any key is already an
+// attachment's file or thumbnail. The unique indexes on attachments catch
+// concurrent inserts; this also covers a file key reused as a thumbnail key.
+func (s *Service) checkKeysUnattached(ctx context.Context, keys ...string) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM attachments
+			WHERE storage_key = ANY($1) OR thumbnail_key = ANY($1)
+		)
+	`, pq.Array(keys)).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check storage keys: %w", err)
+	}
+	if exists {
+		return ErrStorageKeyInUse
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 // GetAttachment retrieves an attachment by ID
 func (s *Service) GetAttachment(ctx context.Context, attachmentID uuid.UUID) (*models.Attachment, error) {
-	var attachment models.Attachment
-
 	query := `
-		SELECT id, message_id, storage_key, file_name, file_size, mime_type,
-		       thumbnail_key, encrypted_metadata, created_at
+		SELECT ` + attachmentColumns + `
 		FROM attachments
 		WHERE id = $1
 	`
 
-	err := s.db.QueryRowContext(ctx, query, attachmentID).Scan(
-		&attachment.ID, &attachment.MessageID, &attachment.StorageKey, &attachment.FileName,
-		&attachment.FileSize, &attachment.MimeType, &attachment.ThumbnailKey,
-		&attachment.EncryptedMetadata, &attachment.CreatedAt,
-	)
-
+	attachment, err := scanAttachment(s.db.QueryRowContext(ctx, query, attachmentID))
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("attachment not found")
 	}
@@ -189,14 +362,14 @@ func (s *Service) GetAttachment(ctx context.Context, attachmentID uuid.UUID) (*m
 		return nil, fmt.Errorf("failed to query attachment: %w", err)
 	}
 
-	return &attachment, nil
+	return attachment, nil
 }
 
-// GetMessageAttachments retrieves all attachments for a message
+// GetMessageAttachments retrieves all attachments for a message, including
+// the wrapped keys for both the file and its encrypted thumbnail
 func (s *Service) GetMessageAttachments(ctx context.Context, messageID uuid.UUID) ([]*models.Attachment, error) {
 	query := `
-		SELECT id, message_id, storage_key, file_name, file_size, mime_type,
-		       thumbnail_key, encrypted_metadata, created_at
+		SELECT ` + attachmentColumns + `
 		FROM attachments
 		WHERE message_id = $1
 		ORDER BY created_at ASC
@@ -210,22 +383,19 @@ func (s *Service) GetMessageAttachments(ctx context.Context, messageID uuid.UUID
 
 	var attachments []*models.Attachment
 	for rows.Next() {
-		var att models.Attachment
-		err := rows.Scan(&att.ID, &att.MessageID, &att.StorageKey, &att.FileName,
-			&att.FileSize, &att.MimeType, &att.ThumbnailKey,
-			&att.EncryptedMetadata, &att.CreatedAt)
+		att, err := scanAttachment(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan attachment: %w", err)
 		}
-		attachments = append(attachments, &att)
+		attachments = append(attachments, att)
 	}
 
 	return attachments, nil
 }
 
-// DeleteFile deletes a file from S3
+// DeleteFile deletes a file from the blob store
 func (s *Service) DeleteFile(ctx context.Context, storageKey string) error {
-	err := s.client.RemoveObject(ctx, s.bucketName, storageKey, minio.RemoveObjectOptions{})
+	err := s.store.Delete(ctx, storageKey)
 	if err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
@@ -240,7 +410,7 @@ func (s *Service) DeleteAttachment(ctx context.Context, attachmentID uuid.UUID)
 		return err
 	}
 
-	// Delete from S3
+	// Delete from blob store
 	if err := s.DeleteFile(ctx, attachment.StorageKey); err != nil {
 		return err
 	}
@@ -262,10 +432,21 @@ func (s *Service) DeleteAttachment(ctx context.Context, attachmentID uuid.UUID)
 
 // UploadFile directly uploads a file (alternative to pre-signed URL)
 func (s *Service) UploadFile(ctx context.Context, storageKey string, reader io.Reader, size int64, contentType string) error {
-	_, err := s.client.PutObject(ctx, s.bucketName, storageKey, reader, size, minio.PutObjectOptions{
-		ContentType: contentType,
-	})
-	if err != nil {
+	if err := s.store.Put(ctx, storageKey, reader, size, contentType); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// UploadSignedFile stores an upload received on a local signed PUT URL.
+// Returns ErrBlobExists if the key was already uploaded, so each URL is
+// good for a single upload even though its signature stays valid until expiry.
+func (s *Service) UploadSignedFile(ctx context.Context, storageKey string, reader io.Reader, size int64, contentType string) error {
+	verifier, ok := s.store.(SignedURLVerifier)
+	if !ok {
+		return ErrSignedURLsUnsupported
+	}
+	if err := verifier.PutSigned(ctx, storageKey, reader, size, contentType); err != nil {
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
 	return nil
@@ -273,7 +454,7 @@ func (s *Service) UploadFile(ctx context.Context, storageKey string, reader io.R
 
 // DownloadFile directly downloads a file (alternative to pre-signed URL)
 func (s *Service) DownloadFile(ctx context.Context, storageKey string) (io.ReadCloser, error) {
-	object, err := s.client.GetObject(ctx, s.bucketName, storageKey, minio.GetObjectOptions{})
+	object, err := s.store.Get(ctx, storageKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download file: %w", err)
 	}
@@ -281,10 +462,20 @@ func (s *Service) DownloadFile(ctx context.Context, storageKey string) (io.ReadC
 }
 
 // GetFileInfo gets metadata about a file
-func (s *Service) GetFileInfo(ctx context.Context, storageKey string) (minio.ObjectInfo, error) {
-	info, err := s.client.StatObject(ctx, s.bucketName, storageKey, minio.StatObjectOptions{})
+func (s *Service) GetFileInfo(ctx context.Context, storageKey string) (*BlobInfo, error) {
+	info, err := s.store.Stat(ctx, storageKey)
 	if err != nil {
-		return minio.ObjectInfo{}, fmt.Errorf("failed to get file info: %w", err)
+		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 	return info, nil
 }
+
+// VerifySignedURL checks a signed URL issued by a local (filesystem or
+// in-memory) backend. Returns ErrSignedURLsUnsupported for external stores.
+func (s *Service) VerifySignedURL(op, storageKey string, query url.Values) error {
+	verifier, ok := s.store.(SignedURLVerifier)
+	if !ok {
+		return ErrSignedURLsUnsupported
+	}
+	return verifier.VerifySignedURL(op, storageKey, query)
+}
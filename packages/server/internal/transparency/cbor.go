@@ -0,0 +1,271 @@
+package transparency
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+/*
+DETERMINISTIC CBOR:
+The canonical encoding (wire.go) is the subset of CBOR (RFC 8949) needed for
+tree heads and proofs, written the way section 4.2.1 ("core deterministic
+encoding") requires:
+
+- Integers and lengths use the shortest head that fits.
+- Lengths are always definite.
+- Map keys are unsigned integers, each map's keys in ascending order.
+
+Only integers, byte strings, text strings, arrays and maps occur. The decoder
+accepts any well-formed head; wire.go rejects non-canonical input by
+re-encoding what it decoded and comparing bytes.
+*/
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+)
+
+// ErrMalformedCBOR is returned for input that is not the CBOR subset used here
+var ErrMalformedCBOR = errors.New("malformed CBOR")
+
+// cborEncoder appends deterministic CBOR to a buffer
+type cborEncoder struct {
+	buf []byte
+}
+
+// head appends a major type with its argument in the shortest form
+func (e *cborEncoder) head(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, m|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, m|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, m|26), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, m|27), n)
+	}
+}
+
+func (e *cborEncoder) int(v int64) {
+	if v < 0 {
+		e.head(cborNegInt, uint64(-1-v))
+		return
+	}
+	e.head(cborUint, uint64(v))
+}
+
+func (e *cborEncoder) bytes(b []byte) {
+	e.head(cborBytes, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *cborEncoder) text(s string) {
+	e.head(cborText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *cborEncoder) hashes(hashes [][]byte) {
+	e.head(cborArray, uint64(len(hashes)))
+	for _, h := range hashes {
+		e.bytes(h)
+	}
+}
+
+// cborMapWriter builds a map whose entries are added in ascending key order
+type cborMapWriter struct {
+	n    int
+	last int
+	body cborEncoder
+}
+
+func newCBORMap() *cborMapWriter {
+	return &cborMapWriter{last: -1}
+}
+
+// key starts the entry for key; keys must be added in ascending order
+func (m *cborMapWriter) key(key int) *cborEncoder {
+	if key <= m.last {
+		panic(fmt.Sprintf("cbor: map key %d added after %d", key, m.last))
+	}
+	m.last = key
+	m.n++
+	m.body.head(cborUint, uint64(key))
+	return &m.body
+}
+
+// bytes adds a byte string entry, omitted if b is nil
+func (m *cborMapWriter) bytes(key int, b []byte) {
+	if b != nil {
+		m.key(key).bytes(b)
+	}
+}
+
+// text adds a text string entry, omitted if s is empty
+func (m *cborMapWriter) text(key int, s string) {
+	if s != "" {
+		m.key(key).text(s)
+	}
+}
+
+// encode appends the map to e
+func (m *cborMapWriter) encode(e *cborEncoder) {
+	e.head(cborMap, uint64(m.n))
+	e.buf = append(e.buf, m.body.buf...)
+}
+
+// cborDecoder reads CBOR from a buffer
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// head reads a major type and its argument. Indefinite lengths, floats and
+// simple values are rejected.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, fmt.Errorf("%w: unexpected end of input", ErrMalformedCBOR)
+	}
+	major, info := d.data[d.off]>>5, d.data[d.off]&0x1f
+	d.off++
+
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	size := 0
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("%w: unsupported additional information %d", ErrMalformedCBOR, info)
+	}
+	if len(d.data)-d.off < size {
+		return 0, 0, fmt.Errorf("%w: unexpected end of input", ErrMalformedCBOR)
+	}
+	var n uint64
+	for _, b := range d.data[d.off : d.off+size] {
+		n = n<<8 | uint64(b)
+	}
+	d.off += size
+	return major, n, nil
+}
+
+// expect reads a head of the given major type
+func (d *cborDecoder) expect(major byte) (uint64, error) {
+	got, n, err := d.head()
+	if err != nil {
+		return 0, err
+	}
+	if got != major {
+		return 0, fmt.Errorf("%w: major type %d, want %d", ErrMalformedCBOR, got, major)
+	}
+	return n, nil
+}
+
+func (d *cborDecoder) int() (int64, error) {
+	major, n, err := d.head()
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: integer out of range", ErrMalformedCBOR)
+	}
+	switch major {
+	case cborUint:
+		return int64(n), nil
+	case cborNegInt:
+		return -1 - int64(n), nil
+	default:
+		return 0, fmt.Errorf("%w: major type %d, want an integer", ErrMalformedCBOR, major)
+	}
+}
+
+// length reads the head of a string, array or map whose items are at least
+// minItemSize bytes each, bounded by the remaining input
+func (d *cborDecoder) length(major byte, minItemSize int) (int, error) {
+	n, err := d.expect(major)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)-d.off)/uint64(minItemSize) {
+		return 0, fmt.Errorf("%w: length %d exceeds input", ErrMalformedCBOR, n)
+	}
+	return int(n), nil
+}
+
+func (d *cborDecoder) bytes() ([]byte, error) {
+	n, err := d.length(cborBytes, 1)
+	if err != nil {
+		return nil, err
+	}
+	b := append([]byte{}, d.data[d.off:d.off+n]...)
+	d.off += n
+	return b, nil
+}
+
+func (d *cborDecoder) text() (string, error) {
+	n, err := d.length(cborText, 1)
+	if err != nil {
+		return "", err
+	}
+	s := string(d.data[d.off : d.off+n])
+	d.off += n
+	return s, nil
+}
+
+func (d *cborDecoder) hashes() ([][]byte, error) {
+	n, err := d.length(cborArray, 1)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([][]byte, n)
+	for i := range hashes {
+		if hashes[i], err = d.bytes(); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// mapEntries reads a map, calling entry for each key with the decoder
+// positioned at its value
+func (d *cborDecoder) mapEntries(entry func(key uint64) error) error {
+	n, err := d.length(cborMap, 2)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		key, err := d.expect(cborUint)
+		if err != nil {
+			return err
+		}
+		if err := entry(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// end checks that the whole input was consumed
+func (d *cborDecoder) end() error {
+	if d.off != len(d.data) {
+		return fmt.Errorf("%w: %d trailing bytes", ErrMalformedCBOR, len(d.data)-d.off)
+	}
+	return nil
+}
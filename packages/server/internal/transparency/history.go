@@ -0,0 +1,333 @@
+package transparency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+KEY HISTORY:
+key_directory_history keeps every leaf the server ever published for a user,
+keyed by the epoch that introduced it, with everything needed to rebuild the
+leaf exactly (including its timestamp, VRF proof and commitment nonce).
+Inclusion proofs for any epoch use the version in effect at that epoch, and
+a user can walk the whole history to audit every key published for them.
+
+Non-existence proofs show a user's slot is empty at an epoch. The slot is the
+index in use at that epoch: the VRF index from the key's first_epoch on,
+SHA-256(user_id) before.
+*/
+
+// History and absence errors
+var (
+	ErrUserPresent    = errors.New("user has a directory entry at this epoch")
+	ErrUnknownEpoch   = errors.New("epoch not found")
+	ErrVRFUnavailable = errors.New("VRF key not loaded on this instance")
+)
+
+// KeyVersion is one leaf published for a user
+type KeyVersion struct {
+	UserID          uuid.UUID `json:"user_id"`
+	Epoch           int64     `json:"epoch"`
+	UpdateType      string    `json:"update_type"`
+	LeafData        *LeafData `json:"leaf_data"`
+	LeafHash        []byte    `json:"leaf_hash"`
+	UserIDHash      []byte    `json:"user_id_hash"`
+	IndexVersion    int       `json:"index_version"`
+	VRFProof        []byte    `json:"vrf_proof,omitempty"`
+	CommitmentNonce []byte    `json:"commitment_nonce,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// KeyVersionProof is a key version with its inclusion proof at the epoch
+// that introduced it
+type KeyVersionProof struct {
+	KeyVersion
+	Proof *InclusionProof `json:"proof"`
+}
+
+const keyVersionColumns = `user_id, epoch_number, update_type, key_version, identity_key_fingerprint,
+	signed_prekey_fingerprint, identity_key_type, signed_prekey_type, companion_keys,
+	leaf_version, leaf_timestamp, leaf_hash, user_id_hash, index_version, vrf_proof,
+	commitment_nonce, created_at`
+
+// scanKeyVersion reads a key_directory_history row selected with keyVersionColumns
+func scanKeyVersion(row interface{ Scan(...interface{}) error }) (*KeyVersion, error) {
+	v := &KeyVersion{LeafData: &LeafData{}}
+	var signedPreKeyFingerprint, identityKeyType, signedPreKeyType sql.NullString
+	var companionKeysJSON []byte
+	err := row.Scan(&v.UserID, &v.Epoch, &v.UpdateType, &v.LeafData.KeyVersion, &v.LeafData.IdentityKeyFingerprint,
+		&signedPreKeyFingerprint, &identityKeyType, &signedPreKeyType, &companionKeysJSON,
+		&v.LeafData.LeafVersion, &v.LeafData.Timestamp, &v.LeafHash, &v.UserIDHash, &v.IndexVersion, &v.VRFProof,
+		&v.CommitmentNonce, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	v.LeafData.UserID = v.UserID
+	v.LeafData.SignedPreKeyFingerprint = signedPreKeyFingerprint.String
+	v.LeafData.IdentityKeyType = identityKeyType.String
+	v.LeafData.SignedPreKeyType = signedPreKeyType.String
+	if len(companionKeysJSON) > 0 {
+		if err := json.Unmarshal(companionKeysJSON, &v.LeafData.CompanionKeys); err != nil {
+			return nil, fmt.Errorf("failed to decode companion keys: %w", err)
+		}
+	}
+	if v.LeafData.LeafVersion >= LeafVersionPrivate {
+		v.LeafData.UserIDCommitment = ComputeUserIDCommitment(v.UserID, v.CommitmentNonce)
+	}
+	return v, nil
+}
+
+// recordKeyVersion adds the leaf written by an update to the user's history.
+// A second update for the same user in one epoch replaces the first, as it
+// does in the tree.
+func recordKeyVersion(ctx context.Context, tx *sql.Tx, v *KeyVersion) error {
+	var companionKeysJSON []byte
+	if len(v.LeafData.CompanionKeys) > 0 {
+		companionKeysJSON, _ = json.Marshal(v.LeafData.CompanionKeys)
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO key_directory_history (
+			user_id, epoch_number, update_type, key_version, identity_key_fingerprint,
+			signed_prekey_fingerprint, identity_key_type, signed_prekey_type, companion_keys,
+			leaf_version, leaf_timestamp, leaf_hash, user_id_hash, index_version, vrf_proof,
+			commitment_nonce
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (user_id, epoch_number) DO UPDATE SET
+			update_type = EXCLUDED.update_type,
+			key_version = EXCLUDED.key_version,
+			identity_key_fingerprint = EXCLUDED.identity_key_fingerprint,
+			signed_prekey_fingerprint = EXCLUDED.signed_prekey_fingerprint,
+			identity_key_type = EXCLUDED.identity_key_type,
+			signed_prekey_type = EXCLUDED.signed_prekey_type,
+			companion_keys = EXCLUDED.companion_keys,
+			leaf_version = EXCLUDED.leaf_version,
+			leaf_timestamp = EXCLUDED.leaf_timestamp,
+			leaf_hash = EXCLUDED.leaf_hash,
+			user_id_hash = EXCLUDED.user_id_hash,
+			index_version = EXCLUDED.index_version,
+			vrf_proof = EXCLUDED.vrf_proof,
+			commitment_nonce = EXCLUDED.commitment_nonce
+	`, v.UserID, v.Epoch, v.UpdateType, v.LeafData.KeyVersion, v.LeafData.IdentityKeyFingerprint,
+		sql.NullString{String: v.LeafData.SignedPreKeyFingerprint, Valid: v.LeafData.SignedPreKeyFingerprint != ""},
+		sql.NullString{String: v.LeafData.IdentityKeyType, Valid: v.LeafData.IdentityKeyType != ""},
+		sql.NullString{String: v.LeafData.SignedPreKeyType, Valid: v.LeafData.SignedPreKeyType != ""},
+		companionKeysJSON, v.LeafData.LeafVersion, v.LeafData.Timestamp, v.LeafHash, v.UserIDHash,
+		v.IndexVersion, v.VRFProof, v.CommitmentNonce)
+	if err != nil {
+		return fmt.Errorf("failed to record key version: %w", err)
+	}
+	return nil
+}
+
+// GetKeyVersionAt returns the user's key version in effect at an epoch, or
+// nil if the user had no entry then
+func (s *Service) GetKeyVersionAt(ctx context.Context, userID uuid.UUID, epoch int64) (*KeyVersion, error) {
+	v, err := scanKeyVersion(s.db.QueryRowContext(ctx, `
+		SELECT `+keyVersionColumns+`
+		FROM key_directory_history
+		WHERE user_id = $1 AND epoch_number <= $2
+		ORDER BY epoch_number DESC
+		LIMIT 1
+	`, userID, epoch))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key version: %w", err)
+	}
+	return v, nil
+}
+
+// GetKeyHistory returns the user's key versions from fromEpoch on, oldest
+// first, each with an inclusion proof at the epoch that introduced it
+func (s *Service) GetKeyHistory(ctx context.Context, userID uuid.UUID, fromEpoch int64, limit int) ([]KeyVersionProof, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyVersionColumns+`
+		FROM key_directory_history
+		WHERE user_id = $1 AND epoch_number >= $2
+		ORDER BY epoch_number ASC
+		LIMIT $3
+	`, userID, fromEpoch, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key history: %w", err)
+	}
+	var versions []*KeyVersion
+	for rows.Next() {
+		v, err := scanKeyVersion(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan key version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key history: %w", err)
+	}
+
+	rebuilds := maxRebuildsPerRequest
+	history := make([]KeyVersionProof, 0, len(versions))
+	for _, v := range versions {
+		proof, err := s.keyVersionProof(ctx, v, v.Epoch, &rebuilds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prove key version at epoch %d: %w", v.Epoch, err)
+		}
+		history = append(history, KeyVersionProof{KeyVersion: *v, Proof: proof})
+	}
+	return history, nil
+}
+
+// keyVersionProof builds the inclusion proof of a key version at an epoch
+// where it is in effect. rebuilds is passed to readEpochTree.
+func (s *Service) keyVersionProof(ctx context.Context, v *KeyVersion, epoch int64, rebuilds *int) (*InclusionProof, error) {
+	tree, err := s.readEpochTree(ctx, epoch, rebuilds)
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		return nil, ErrUnknownEpoch
+	}
+	if !tree.cumulative && v.Epoch != epoch {
+		return nil, ErrLegacyEpoch
+	}
+
+	siblingPath, err := tree.siblingPath(ctx, s.db, v.UserIDHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var rootHash []byte
+	err = s.db.QueryRowContext(ctx, `
+		SELECT root_hash FROM transparency_epochs WHERE epoch_number = $1
+	`, epoch).Scan(&rootHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root hash: %w", err)
+	}
+
+	return &InclusionProof{
+		EpochNumber:     epoch,
+		LeafHash:        v.LeafHash,
+		LeafData:        v.LeafData,
+		SiblingPath:     siblingPath,
+		PathBits:        v.UserIDHash,
+		RootHash:        rootHash,
+		VRFProof:        v.VRFProof,
+		CommitmentNonce: v.CommitmentNonce,
+	}, nil
+}
+
+// GetNonExistenceProof proves the user had no directory entry at an epoch
+// (the current epoch if 0)
+func (s *Service) GetNonExistenceProof(ctx context.Context, userID uuid.UUID, epochNum int64) (*NonExistenceProof, error) {
+	if epochNum == 0 {
+		s.stateMu.RLock()
+		epochNum = s.currentEpoch
+		s.stateMu.RUnlock()
+	}
+
+	tree, err := s.readEpochTree(ctx, epochNum, nil)
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		return nil, ErrUnknownEpoch
+	}
+	if !tree.cumulative {
+		return nil, ErrLegacyEpoch
+	}
+
+	// The history is authoritative: an entry still waiting to be re-indexed
+	// is present even though its VRF slot is empty
+	version, err := s.GetKeyVersionAt(ctx, userID, epochNum)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil {
+		return nil, ErrUserPresent
+	}
+
+	pathBits, vrfProof, err := s.indexAtEpoch(ctx, userID, epochNum)
+	if err != nil {
+		return nil, err
+	}
+
+	leafHash, err := tree.nodeHash(ctx, s.db, TreeDepth, PathPrefixAtDepth(pathBits, TreeDepth))
+	if err != nil {
+		return nil, err
+	}
+	if !bytesEqual(leafHash, GetDefaultHash(TreeDepth)) {
+		return nil, ErrUserPresent
+	}
+
+	siblingPath, err := tree.siblingPath(ctx, s.db, pathBits)
+	if err != nil {
+		return nil, err
+	}
+
+	var rootHash []byte
+	err = s.db.QueryRowContext(ctx, `
+		SELECT root_hash FROM transparency_epochs WHERE epoch_number = $1
+	`, epochNum).Scan(&rootHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root hash: %w", err)
+	}
+
+	return &NonExistenceProof{
+		EpochNumber: epochNum,
+		UserIDHash:  pathBits,
+		SiblingPath: siblingPath,
+		RootHash:    rootHash,
+		VRFProof:    vrfProof,
+	}, nil
+}
+
+// indexAtEpoch returns the user's slot under the index derivation in use at an epoch
+func (s *Service) indexAtEpoch(ctx context.Context, userID uuid.UUID, epoch int64) (pathBits, vrfProof []byte, err error) {
+	key, err := s.GetVRFKey(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil || key.FirstEpoch == nil || epoch < *key.FirstEpoch {
+		return ComputeUserPath(userID), nil, nil
+	}
+	if s.vrfKey == nil {
+		return nil, nil, ErrVRFUnavailable
+	}
+	pathBits, vrfProof = ComputeUserIndex(s.vrfKey, userID)
+	return pathBits, vrfProof, nil
+}
+
+// VerifyUserNonExistenceProof checks that a non-existence proof covers the
+// user's slot. Pass the VRF public key for epochs from its first_epoch on,
+// nil before.
+func VerifyUserNonExistenceProof(vrfPublicKey []byte, userID uuid.UUID, proof *NonExistenceProof) error {
+	if proof == nil {
+		return fmt.Errorf("missing non-existence proof")
+	}
+
+	if len(vrfPublicKey) > 0 {
+		if err := VerifyUserIndex(vrfPublicKey, userID, proof.UserIDHash, proof.VRFProof); err != nil {
+			return err
+		}
+	} else if !bytesEqual(proof.UserIDHash, ComputeUserPath(userID)) {
+		return fmt.Errorf("proof path is not the index of user %s", userID)
+	}
+
+	if !VerifyNonExistenceProof(proof) {
+		return fmt.Errorf("non-existence proof does not lead to root")
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package transparency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+/*
+PRIVATE INDEX:
+With a VRF key configured (TRANSPARENCY_VRF_KEY), each user's tree index is
+ComputeUserIndex(vrfKey, user_id) and every new leaf is a LeafVersionPrivate
+leaf committing to the user ID with a fresh random nonce. The directory entry
+keeps the VRF proof and the nonce so inclusion proofs can carry both.
+
+The VRF key is registered in transparency_vrf_keys. Every instance that
+signs epochs must use that key; an instance with a different key, or with no
+key once the directory is VRF-indexed, stays read-only so indexes from two
+derivations are never mixed. Read-only instances only need the key to serve
+non-existence proofs, and use it only if it matches the registered one.
+
+Entries written before the VRF key was configured are re-indexed by queueing
+a 'key_reindexed' update for each, which moves the user to its VRF index and
+rewrites the leaf as a private leaf on the next batch. The legacy slot is
+public (anyone can hash a user ID), so the audit log records its clearing as
+a separate index_cleared entry with no link to the new slot.
+*/
+
+// UpdateTypeReindexed moves an existing entry to its VRF index
+const UpdateTypeReindexed = "key_reindexed"
+
+// ChangeTypeIndexCleared is the audit log entry for a slot emptied when an
+// entry moved to its VRF index. It carries no user commitment.
+const ChangeTypeIndexCleared = "index_cleared"
+
+// VRFSuite names the VRF used for tree indexes
+const VRFSuite = "ECVRF-EDWARDS25519-SHA512-TAI"
+
+// VRF key registration errors
+var (
+	ErrVRFKeyMismatch = errors.New("VRF key does not match the registered key")
+	ErrVRFKeyRequired = errors.New("directory uses VRF indexes but no VRF key is configured")
+	ErrVRFKeyIsSigner = errors.New("VRF key must not be the tree head signing key")
+)
+
+// VRFKey is a registered VRF public key
+type VRFKey struct {
+	Fingerprint string
+	PublicKey   []byte
+	Suite       string
+	FirstEpoch  *int64 // first epoch indexed with this key, nil until one is built
+}
+
+// loadVRFKey loads the VRF key. A signing instance registers it; with no key
+// configured its new leaves keep the SHA-256 index, unless a VRF key was
+// registered before.
+func (s *Service) loadVRFKey(ctx context.Context, signing bool) error {
+	key, err := NewVRFPrivateKeyFromEnv()
+	if err != nil {
+		registered, lookupErr := s.GetVRFKey(ctx)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if registered != nil && signing {
+			return ErrVRFKeyRequired
+		}
+		if registered == nil {
+			log.Printf("[Transparency] Warning: No VRF key configured (%v); users are indexed by SHA-256(user_id)", err)
+		}
+		return nil
+	}
+
+	if !signing {
+		registered, err := s.GetVRFKey(ctx)
+		if err != nil {
+			return err
+		}
+		if registered == nil || registered.Fingerprint != key.Fingerprint() {
+			return ErrVRFKeyMismatch
+		}
+		s.vrfKey = key
+		return nil
+	}
+
+	if signerKey, err := s.signer.PublicKeyBytes(); err == nil && bytesEqual(signerKey, key.PublicKey()) {
+		return ErrVRFKeyIsSigner
+	}
+
+	if err := s.registerVRFKey(ctx, key); err != nil {
+		return err
+	}
+	s.vrfKey = key
+	log.Printf("[Transparency] Loaded VRF key: %s", key.Fingerprint())
+
+	queued, err := s.enqueueReindex(ctx)
+	if err != nil {
+		log.Printf("[Transparency] Warning: Failed to queue re-index updates: %v", err)
+	} else if queued > 0 {
+		log.Printf("[Transparency] Queued %d legacy directory entries for re-indexing", queued)
+	}
+	return nil
+}
+
+// registerVRFKey records the VRF key, refusing a key that differs from the
+// one already registered
+func (s *Service) registerVRFKey(ctx context.Context, key *VRFPrivateKey) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE transparency_vrf_keys IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock VRF keys: %w", err)
+	}
+
+	var fingerprint string
+	err = tx.QueryRowContext(ctx, `
+		SELECT fingerprint FROM transparency_vrf_keys WHERE status = 'active'
+	`).Scan(&fingerprint)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO transparency_vrf_keys (fingerprint, public_key, suite, status)
+			VALUES ($1, $2, $3, 'active')
+		`, key.Fingerprint(), key.PublicKey(), VRFSuite)
+		if err != nil {
+			return fmt.Errorf("failed to register VRF key: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to get VRF key: %w", err)
+	case fingerprint != key.Fingerprint():
+		return fmt.Errorf("%w: configured %s, registered %s", ErrVRFKeyMismatch, key.Fingerprint(), fingerprint)
+	}
+
+	return tx.Commit()
+}
+
+// enqueueReindex queues a re-index update for every entry still at its
+// SHA-256 index that has no update pending already
+func (s *Service) enqueueReindex(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO transparency_pending_updates (
+			id, user_id, update_type, identity_key_fingerprint, signed_prekey_fingerprint,
+			key_version, identity_key_type, signed_prekey_type, companion_keys, status
+		)
+		SELECT gen_random_uuid(), e.user_id, $1, e.identity_key_fingerprint, e.signed_prekey_fingerprint,
+		       e.key_version, e.identity_key_type, e.signed_prekey_type, e.companion_keys, 'pending'
+		FROM key_directory_entries e
+		WHERE e.index_version = 0
+		  AND NOT EXISTS (
+			SELECT 1 FROM transparency_pending_updates p
+			WHERE p.user_id = e.user_id AND p.status = 'pending'
+		  )
+	`, UpdateTypeReindexed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue re-index updates: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetVRFKey returns the active VRF public key, or nil if none is registered
+func (s *Service) GetVRFKey(ctx context.Context) (*VRFKey, error) {
+	key := &VRFKey{}
+	var firstEpoch sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT fingerprint, public_key, suite, first_epoch FROM transparency_vrf_keys WHERE status = 'active'
+	`).Scan(&key.Fingerprint, &key.PublicKey, &key.Suite, &firstEpoch)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VRF key: %w", err)
+	}
+	if firstEpoch.Valid {
+		key.FirstEpoch = &firstEpoch.Int64
+	}
+	return key, nil
+}
+
+// userIndex returns the tree index for a user: the VRF output and its proof
+// when a VRF key is loaded, SHA-256(user_id) otherwise
+func (s *Service) userIndex(userID uuid.UUID) (index, proof []byte, indexVersion int) {
+	if s.vrfKey == nil {
+		return ComputeUserPath(userID), nil, IndexVersionSHA256
+	}
+	index, proof = ComputeUserIndex(s.vrfKey, userID)
+	return index, proof, IndexVersionVRF
+}
+
+// VerifyUserInclusionProof checks an inclusion proof for a known user: the
+// index must be that user's, a private leaf's commitment must open to the
+// user ID, and the path must lead to the proof's root. Once the directory has
+// a VRF key, pass it as vrfPublicKey so a proof without a VRF proof (a
+// SHA-256 index) is rejected; nil accepts only SHA-256 indexes.
+func VerifyUserInclusionProof(vrfPublicKey []byte, userID uuid.UUID, proof *InclusionProof) error {
+	if proof == nil || proof.LeafData == nil {
+		return fmt.Errorf("inclusion proof has no leaf data")
+	}
+
+	if len(vrfPublicKey) > 0 {
+		if err := VerifyUserIndex(vrfPublicKey, userID, proof.PathBits, proof.VRFProof); err != nil {
+			return err
+		}
+	} else if !bytesEqual(proof.PathBits, ComputeUserPath(userID)) {
+		return fmt.Errorf("proof path is not the index of user %s", userID)
+	}
+
+	if proof.LeafData.LeafVersion >= LeafVersionPrivate {
+		commitment := ComputeUserIDCommitment(userID, proof.CommitmentNonce)
+		if len(proof.CommitmentNonce) != HashSize || !bytesEqual(commitment, proof.LeafData.UserIDCommitment) {
+			return fmt.Errorf("leaf commitment does not open to user %s", userID)
+		}
+	} else if proof.LeafData.UserID != userID {
+		return fmt.Errorf("leaf belongs to user %s, not %s", proof.LeafData.UserID, userID)
+	}
+
+	if !VerifyInclusionProof(proof) {
+		return fmt.Errorf("inclusion proof does not lead to root")
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package transparency
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+LEADER ELECTION:
+Every replica runs this service, but only one may build epochs: each computes
+MAX(epoch_number)+1 on its own, so two batch processors would race to sign
+different trees for the same epoch.
+
+Instances with a signing key compete for a session-level Postgres advisory
+lock (leaderLockKey). The holder is the leader: it alone drains the update
+queue, builds epochs, syncs the append-only log and compacts the tree. The
+lock lives on one connection held while the instance leads. If that
+connection drops, Postgres releases the lock and another instance takes over
+within leaderRetryInterval; the old leader notices on its next check and
+stops its jobs.
+
+Each epoch transaction also takes a transaction-level advisory lock
+(epochLockKey) and reads the latest epoch under it, so even two instances
+that both believe they lead build epochs one after the other.
+transparency_epochs.epoch_number is UNIQUE as the last line of defence.
+
+Every instance follows new tree heads: the epoch transaction NOTIFYs
+headChannel, delivered on commit, and each instance LISTENs on it, with a poll
+as a backstop for missed notifications.
+*/
+
+// Advisory lock keys
+const (
+	leaderLockKey int64 = 0x6b742d6c65616472 // held by the leader's session
+	epochLockKey  int64 = 0x6b742d6570636873 // held by each epoch transaction
+)
+
+const (
+	// leaderCheckInterval is how often the leader checks its lock connection
+	leaderCheckInterval = 10 * time.Second
+	// leaderRetryInterval is how often a follower tries to become leader
+	leaderRetryInterval = 15 * time.Second
+
+	// headChannel carries the epoch number of each new tree head
+	headChannel = "transparency_epochs"
+	// headPollInterval is the fallback for missed head notifications
+	headPollInterval = 30 * time.Second
+)
+
+// leaderElection competes for leadership until the service stops
+func (s *Service) leaderElection() {
+	for {
+		if conn := s.tryLead(); conn != nil {
+			s.lead(conn)
+		}
+
+		select {
+		case <-s.stopBatch:
+			return
+		case <-time.After(leaderRetryInterval):
+		}
+	}
+}
+
+// tryLead takes the leader lock, returning the connection holding it, or nil
+// if another instance leads
+func (s *Service) tryLead() *sql.Conn {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		log.Printf("[Transparency] Failed to get connection for leader election: %v", err)
+		return nil
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockKey).Scan(&acquired)
+	if err != nil {
+		log.Printf("[Transparency] Failed to try leader lock: %v", err)
+	}
+	if err != nil || !acquired {
+		conn.Close()
+		return nil
+	}
+	return conn
+}
+
+// lead runs the leader's jobs until the lock connection fails or the service
+// stops, then releases the lock
+func (s *Service) lead(conn *sql.Conn) {
+	hostname, _ := os.Hostname()
+	log.Printf("[Transparency] Elected epoch leader (%s)", hostname)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		// Log any epochs that predate the append-only log
+		if err := s.syncLog(ctx); err != nil {
+			log.Printf("[Transparency] Warning: Failed to sync append-only log: %v", err)
+		}
+		if pending, err := s.pendingUpdateCount(ctx); err == nil && pending > 0 {
+			log.Printf("[Transparency] Replaying %d queued key updates", pending)
+		}
+		s.batchProcessor(ctx)
+	}()
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		s.compactionLoop(ctx)
+	}()
+
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+	for held := true; held; {
+		select {
+		case <-s.stopBatch:
+			held = false
+		case <-ticker.C:
+			if err := conn.PingContext(ctx); err != nil {
+				log.Printf("[Transparency] Lost leader lock connection: %v", err)
+				held = false
+			}
+		}
+	}
+
+	// Stop building epochs before another instance can take over
+	cancel()
+	jobs.Wait()
+	releaseLeaderLock(conn)
+	log.Printf("[Transparency] Stepped down as epoch leader")
+}
+
+// releaseLeaderLock unlocks the leader lock and returns the connection to the
+// pool. If the unlock fails the connection is discarded, which also releases it.
+func releaseLeaderLock(conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, leaderLockKey); err != nil {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}
+
+// lockEpochs serializes epoch creation across instances for the rest of the transaction
+func lockEpochs(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, epochLockKey)
+	return err
+}
+
+// notifyHead tells following instances about a new epoch when tx commits
+func notifyHead(ctx context.Context, tx *sql.Tx, epoch int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, headChannel, strconv.FormatInt(epoch, 10))
+	return err
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// followHeads keeps the cached current epoch up to date with heads built by
+// the leader. Notifications need DATABASE_URL for a listener connection;
+// without it the poll alone keeps up.
+func (s *Service) followHeads() {
+	var notifications <-chan *pq.Notification
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("[Transparency] Head listener: %v", err)
+			}
+		})
+		if err := listener.Listen(headChannel); err != nil {
+			log.Printf("[Transparency] Warning: Failed to listen for new heads: %v", err)
+			listener.Close()
+		} else {
+			defer listener.Close()
+			notifications = listener.Notify
+		}
+	}
+
+	ticker := time.NewTicker(headPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// A nil notification follows a reconnect; refresh either way
+		select {
+		case <-notifications:
+		case <-ticker.C:
+		case <-s.stopBatch:
+			return
+		}
+		if err := s.followHead(context.Background()); err != nil {
+			log.Printf("[Transparency] Failed to follow tree head: %v", err)
+		}
+	}
+}
+
+// followHead advances the cached current epoch to the latest signed one
+func (s *Service) followHead(ctx context.Context) error {
+	sth, err := s.GetSignedTreeHead(ctx)
+	if err != nil || sth == nil {
+		return err
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if sth.EpochNumber <= s.currentEpoch {
+		return nil
+	}
+	s.currentEpoch = sth.EpochNumber
+	s.currentRoot = sth.RootHash
+	return nil
+}
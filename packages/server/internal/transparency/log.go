@@ -0,0 +1,561 @@
+package transparency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+/*
+APPEND-ONLY TREE HEAD LOG:
+Every epoch's signed tree head is appended, in epoch order, as a leaf of an
+RFC 6962 Merkle log. After each append the log head (tree size, root,
+timestamp) is signed with the same key as the epochs. A gossiper that sees two
+log heads can demand a consistency proof between them; two heads of the same
+size with different roots, both validly signed, prove equivocation.
+
+Leaf encoding (EncodeLogLeaf):
+  version (1) || TreeHeadData(sth) ||
+  len(signature) (2, big-endian) || signature ||
+  len(fingerprint) (1) || fingerprint
+
+Version 0x01 carries the 56-byte tree head; version 0x02 the 88-byte tree
+head of an epoch that commits to its salt (see salt.go). Canonical tree heads
+(see wire.go) are logged as 0x03 || EncodeTreeHead(sth), signature included.
+
+Signed log head (LogTreeHeadData), domain-separated from epoch STHs:
+  "nochat-kt-log-v1\x00" || tree_size (8) || timestamp (8) || root_hash (32)
+*/
+
+// Log encoding constants
+const (
+	LogLeafVersion          = 1
+	LogLeafSaltVersion      = 2
+	LogLeafCanonicalVersion = 3
+
+	logHeadDomain = "nochat-kt-log-v1\x00"
+
+	// MaxLogEntriesPerRequest caps /api/transparency/log/entries
+	MaxLogEntriesPerRequest = 1000
+)
+
+// LogTreeHead is a signed head of the append-only log
+type LogTreeHead struct {
+	TreeSize              int64     `json:"tree_size"`
+	RootHash              []byte    `json:"root_hash"`
+	Timestamp             time.Time `json:"timestamp"`
+	Signature             []byte    `json:"signature"`
+	SigningKeyFingerprint string    `json:"signing_key_fingerprint"`
+}
+
+// LogEntry is one leaf of the append-only log
+type LogEntry struct {
+	LeafIndex   int64  `json:"leaf_index"`
+	EpochNumber int64  `json:"epoch_number"`
+	LeafData    []byte `json:"leaf_data"`
+	LeafHash    []byte `json:"leaf_hash"`
+}
+
+// LogInclusionProof proves a leaf is in the log at a given size
+type LogInclusionProof struct {
+	LeafIndex int64    `json:"leaf_index"`
+	TreeSize  int64    `json:"tree_size"`
+	LeafHash  []byte   `json:"leaf_hash"`
+	AuditPath [][]byte `json:"audit_path"`
+}
+
+// LogConsistencyProof proves a smaller log is a prefix of a larger one
+type LogConsistencyProof struct {
+	FirstSize  int64    `json:"first_size"`
+	SecondSize int64    `json:"second_size"`
+	Proof      [][]byte `json:"proof"`
+}
+
+// EncodeLogLeaf encodes a signed epoch tree head as a log leaf
+func EncodeLogLeaf(sth *SignedTreeHead) ([]byte, error) {
+	if treeHeadVersion(sth) == TreeHeadVersionCanonical {
+		return append([]byte{LogLeafCanonicalVersion}, EncodeTreeHead(sth)...), nil
+	}
+	if len(sth.Signature) > 0xFFFF || len(sth.SigningKeyFingerprint) > 0xFF {
+		return nil, fmt.Errorf("tree head fields too large to encode")
+	}
+
+	leaf := []byte{LogLeafVersion}
+	if len(sth.SaltCommitment) > 0 {
+		leaf[0] = LogLeafSaltVersion
+	}
+	leaf = append(leaf, TreeHeadData(sth)...)
+	leaf = binary.BigEndian.AppendUint16(leaf, uint16(len(sth.Signature)))
+	leaf = append(leaf, sth.Signature...)
+	leaf = append(leaf, byte(len(sth.SigningKeyFingerprint)))
+	leaf = append(leaf, sth.SigningKeyFingerprint...)
+	return leaf, nil
+}
+
+// DecodeLogLeaf parses a log leaf back into the epoch tree head it commits to
+func DecodeLogLeaf(leaf []byte) (*SignedTreeHead, error) {
+	if len(leaf) > 0 && leaf[0] == LogLeafCanonicalVersion {
+		sth, err := DecodeTreeHead(leaf[1:])
+		if err != nil {
+			return nil, err
+		}
+		if sth.Version != TreeHeadVersionCanonical {
+			return nil, fmt.Errorf("log leaf version %d holds a raw tree head", LogLeafCanonicalVersion)
+		}
+		return sth, nil
+	}
+
+	headSize := 56
+	if len(leaf) > 0 && leaf[0] == LogLeafSaltVersion {
+		headSize += HashSize
+	}
+	if len(leaf) < 1+headSize+2 || (leaf[0] != LogLeafVersion && leaf[0] != LogLeafSaltVersion) {
+		return nil, fmt.Errorf("unsupported or truncated log leaf")
+	}
+
+	sth := &SignedTreeHead{
+		EpochNumber: int64(binary.BigEndian.Uint64(leaf[1:9])),
+		RootHash:    append([]byte(nil), leaf[9:41]...),
+		TreeSize:    int64(binary.BigEndian.Uint64(leaf[41:49])),
+		Timestamp:   time.Unix(int64(binary.BigEndian.Uint64(leaf[49:57])), 0),
+		Version:     TreeHeadVersionRaw,
+	}
+	if leaf[0] == LogLeafSaltVersion {
+		sth.SaltCommitment = append([]byte(nil), leaf[57:89]...)
+	}
+
+	offset := 1 + headSize
+	sigLen := int(binary.BigEndian.Uint16(leaf[offset : offset+2]))
+	offset += 2
+	if len(leaf) < offset+sigLen+1 {
+		return nil, fmt.Errorf("log leaf truncated in signature")
+	}
+	sth.Signature = append([]byte(nil), leaf[offset:offset+sigLen]...)
+	offset += sigLen
+
+	fpLen := int(leaf[offset])
+	offset++
+	if len(leaf) != offset+fpLen {
+		return nil, fmt.Errorf("log leaf has invalid fingerprint length")
+	}
+	sth.SigningKeyFingerprint = string(leaf[offset:])
+	return sth, nil
+}
+
+// LogTreeHeadData serializes the signed fields of a log head
+func LogTreeHeadData(head *LogTreeHead) []byte {
+	data := make([]byte, 0, len(logHeadDomain)+8+8+HashSize)
+	data = append(data, logHeadDomain...)
+	data = binary.BigEndian.AppendUint64(data, uint64(head.TreeSize))
+	data = binary.BigEndian.AppendUint64(data, uint64(head.Timestamp.Unix()))
+	data = append(data, head.RootHash...)
+	return data
+}
+
+// VerifyLogTreeHead checks a log head's signature against a published signing key
+func VerifyLogTreeHead(key *SigningKey, head *LogTreeHead) error {
+	if key.Status == "revoked" {
+		return ErrSigningKeyRevoked
+	}
+	if head.Timestamp.Before(key.ValidFrom.Truncate(time.Second)) ||
+		(key.ValidUntil != nil && head.Timestamp.After(*key.ValidUntil)) {
+		return ErrOutsideKeyValidity
+	}
+	if !verifyMessage(key.PublicKey, key.Algorithm, LogTreeHeadData(head), head.Signature) {
+		return ErrInvalidTreeHeadSignature
+	}
+	return nil
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// logNodeReader reads stored perfect subtree hashes
+func logNodeReader(ctx context.Context, q rowQueryer) LogNodeReader {
+	return func(level int, index int64) ([]byte, error) {
+		var hash []byte
+		err := q.QueryRowContext(ctx, `
+			SELECT node_hash FROM transparency_log_nodes WHERE level = $1 AND node_index = $2
+		`, level, index).Scan(&hash)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("log node (%d, %d) missing", level, index)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read log node: %w", err)
+		}
+		if err := validateLogHash(hash, level, index); err != nil {
+			return nil, err
+		}
+		return hash, nil
+	}
+}
+
+// appendEpochsToLog appends every signed epoch not yet in the log, in epoch
+// order, and signs the new log head. It returns nil if nothing was appended.
+func (s *Service) appendEpochsToLog(ctx context.Context, tx *sql.Tx) (*LogTreeHead, error) {
+	// Appends must be strictly serialized; readers are not blocked
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE transparency_log_entries IN EXCLUSIVE MODE`); err != nil {
+		return nil, fmt.Errorf("failed to lock log: %w", err)
+	}
+
+	var size, lastEpoch int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(MAX(epoch_number), 0) FROM transparency_log_entries
+	`).Scan(&size, &lastEpoch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log size: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT epoch_number, root_hash, tree_size, signature, signing_key_fingerprint, created_at, salt_commitment, head_version
+		FROM transparency_epochs
+		WHERE epoch_number > $1
+		ORDER BY epoch_number ASC
+	`, lastEpoch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unlogged epochs: %w", err)
+	}
+	var pending []SignedTreeHead
+	for rows.Next() {
+		var sth SignedTreeHead
+		if err := rows.Scan(&sth.EpochNumber, &sth.RootHash, &sth.TreeSize, &sth.Signature,
+			&sth.SigningKeyFingerprint, &sth.Timestamp, &sth.SaltCommitment, &sth.Version); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan epoch: %w", err)
+		}
+		pending = append(pending, sth)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read unlogged epochs: %w", err)
+	}
+
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	read := logNodeReader(ctx, tx)
+	for i := range pending {
+		leaf, err := EncodeLogLeaf(&pending[i])
+		if err != nil {
+			return nil, err
+		}
+		leafHash := LogLeafHash(leaf)
+		index := size
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO transparency_log_entries (leaf_index, epoch_number, leaf_data, leaf_hash)
+			VALUES ($1, $2, $3, $4)
+		`, index, pending[i].EpochNumber, leaf, leafHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to append log entry: %w", err)
+		}
+		if err := insertLogNode(ctx, tx, 0, index, leafHash); err != nil {
+			return nil, err
+		}
+
+		// Store each perfect subtree this leaf completes
+		for _, node := range logNodesCompletedBy(index) {
+			level, nodeIndex := int(node[0]), node[1]
+			left, err := read(level-1, 2*nodeIndex)
+			if err != nil {
+				return nil, err
+			}
+			right, err := read(level-1, 2*nodeIndex+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := insertLogNode(ctx, tx, level, nodeIndex, LogNodeHash(left, right)); err != nil {
+				return nil, err
+			}
+		}
+		size++
+	}
+
+	root, err := LogRoot(read, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute log root: %w", err)
+	}
+
+	head := &LogTreeHead{
+		TreeSize:              size,
+		RootHash:              root,
+		Timestamp:             time.Unix(time.Now().Unix(), 0),
+		SigningKeyFingerprint: s.signer.Fingerprint(),
+	}
+	if head.Signature, err = s.signer.SignMessage(ctx, LogTreeHeadData(head)); err != nil {
+		return nil, fmt.Errorf("failed to sign log head: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO transparency_log_heads (tree_size, root_hash, signature, signing_key_fingerprint, signed_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, head.TreeSize, head.RootHash, head.Signature, head.SigningKeyFingerprint, head.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store log head: %w", err)
+	}
+
+	return head, nil
+}
+
+// insertLogNode stores a perfect subtree hash
+func insertLogNode(ctx context.Context, tx *sql.Tx, level int, index int64, hash []byte) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO transparency_log_nodes (level, node_index, node_hash) VALUES ($1, $2, $3)
+	`, level, index, hash)
+	if err != nil {
+		return fmt.Errorf("failed to store log node (%d, %d): %w", level, index, err)
+	}
+	return nil
+}
+
+// syncLog appends epochs created before the log existed (or by a crashed batch)
+func (s *Service) syncLog(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	head, err := s.appendEpochsToLog(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit log sync: %w", err)
+	}
+	if head != nil {
+		log.Printf("[Transparency] Log synced to size %d", head.TreeSize)
+	}
+	return nil
+}
+
+// GetLogTreeHead returns the signed log head at treeSize, or the latest if treeSize is 0
+func (s *Service) GetLogTreeHead(ctx context.Context, treeSize int64) (*LogTreeHead, error) {
+	query := `
+		SELECT tree_size, root_hash, signature, signing_key_fingerprint, signed_at
+		FROM transparency_log_heads
+		ORDER BY tree_size DESC
+		LIMIT 1
+	`
+	args := []interface{}{}
+	if treeSize > 0 {
+		query = `
+			SELECT tree_size, root_hash, signature, signing_key_fingerprint, signed_at
+			FROM transparency_log_heads
+			WHERE tree_size = $1
+		`
+		args = append(args, treeSize)
+	}
+
+	head := &LogTreeHead{}
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&head.TreeSize, &head.RootHash,
+		&head.Signature, &head.SigningKeyFingerprint, &head.Timestamp)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log head: %w", err)
+	}
+	return head, nil
+}
+
+// GetLogEntries returns log leaves in [start, end)
+func (s *Service) GetLogEntries(ctx context.Context, start, end int64) ([]LogEntry, error) {
+	if start < 0 || end <= start {
+		return nil, ErrLogRange
+	}
+	if end-start > MaxLogEntriesPerRequest {
+		end = start + MaxLogEntriesPerRequest
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT leaf_index, epoch_number, leaf_data, leaf_hash
+		FROM transparency_log_entries
+		WHERE leaf_index >= $1 AND leaf_index < $2
+		ORDER BY leaf_index ASC
+	`, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []LogEntry
+	for rows.Next() {
+		var entry LogEntry
+		if err := rows.Scan(&entry.LeafIndex, &entry.EpochNumber, &entry.LeafData, &entry.LeafHash); err != nil {
+			return nil, fmt.Errorf("failed to scan log entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetLogLeafIndex returns the log position of an epoch's tree head, or -1 if it is not logged
+func (s *Service) GetLogLeafIndex(ctx context.Context, epoch int64) (int64, error) {
+	var index int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT leaf_index FROM transparency_log_entries WHERE epoch_number = $1
+	`, epoch).Scan(&index)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get log index: %w", err)
+	}
+	return index, nil
+}
+
+// logSize returns the number of leaves in the log
+func (s *Service) logSize(ctx context.Context) (int64, error) {
+	var size int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM transparency_log_entries`).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to get log size: %w", err)
+	}
+	return size, nil
+}
+
+// GetLogInclusionProof returns the audit path for a leaf in the log of treeSize
+// leaves (the current size if treeSize is 0)
+func (s *Service) GetLogInclusionProof(ctx context.Context, leafIndex, treeSize int64) (*LogInclusionProof, error) {
+	size, err := s.logSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if treeSize == 0 {
+		treeSize = size
+	}
+	if treeSize > size || leafIndex < 0 || leafIndex >= treeSize {
+		return nil, ErrLogRange
+	}
+
+	read := logNodeReader(ctx, s.db)
+	leafHash, err := read(0, leafIndex)
+	if err != nil {
+		return nil, err
+	}
+	path, err := LogInclusionPath(read, leafIndex, treeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build audit path: %w", err)
+	}
+
+	return &LogInclusionProof{
+		LeafIndex: leafIndex,
+		TreeSize:  treeSize,
+		LeafHash:  leafHash,
+		AuditPath: path,
+	}, nil
+}
+
+// GetLogConsistencyProof proves the log at firstSize is a prefix of the log at secondSize
+// (the current size if secondSize is 0)
+func (s *Service) GetLogConsistencyProof(ctx context.Context, firstSize, secondSize int64) (*LogConsistencyProof, error) {
+	size, err := s.logSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if secondSize == 0 {
+		secondSize = size
+	}
+	if secondSize > size || firstSize < 0 || firstSize > secondSize {
+		return nil, ErrLogRange
+	}
+
+	proof, err := LogConsistencyHashes(logNodeReader(ctx, s.db), firstSize, secondSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build consistency proof: %w", err)
+	}
+
+	return &LogConsistencyProof{
+		FirstSize:  firstSize,
+		SecondSize: secondSize,
+		Proof:      proof,
+	}, nil
+}
+
+// LogTreeHeadResponse is a JSON-serializable log head
+type LogTreeHeadResponse struct {
+	TreeSize              int64  `json:"tree_size"`
+	RootHash              string `json:"root_hash"`
+	Timestamp             string `json:"timestamp"`
+	Signature             string `json:"signature"`
+	SigningKeyFingerprint string `json:"signing_key_fingerprint"`
+}
+
+// LogEntryResponse is a JSON-serializable log leaf
+type LogEntryResponse struct {
+	LeafIndex   int64  `json:"leaf_index"`
+	EpochNumber int64  `json:"epoch_number"`
+	LeafData    string `json:"leaf_data"`
+	LeafHash    string `json:"leaf_hash"`
+}
+
+// LogInclusionProofResponse is a JSON-serializable log audit path
+type LogInclusionProofResponse struct {
+	LeafIndex int64    `json:"leaf_index"`
+	TreeSize  int64    `json:"tree_size"`
+	LeafHash  string   `json:"leaf_hash"`
+	AuditPath []string `json:"audit_path"`
+}
+
+// LogConsistencyProofResponse is a JSON-serializable log consistency proof
+type LogConsistencyProofResponse struct {
+	FirstSize  int64    `json:"first_size"`
+	SecondSize int64    `json:"second_size"`
+	Proof      []string `json:"proof"`
+}
+
+// ToResponse converts a LogTreeHead to a JSON-serializable response
+func (h *LogTreeHead) ToResponse() *LogTreeHeadResponse {
+	return &LogTreeHeadResponse{
+		TreeSize:              h.TreeSize,
+		RootHash:              base64.StdEncoding.EncodeToString(h.RootHash),
+		Timestamp:             h.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+		Signature:             base64.StdEncoding.EncodeToString(h.Signature),
+		SigningKeyFingerprint: h.SigningKeyFingerprint,
+	}
+}
+
+// ToResponse converts a LogEntry to a JSON-serializable response
+func (e *LogEntry) ToResponse() *LogEntryResponse {
+	return &LogEntryResponse{
+		LeafIndex:   e.LeafIndex,
+		EpochNumber: e.EpochNumber,
+		LeafData:    base64.StdEncoding.EncodeToString(e.LeafData),
+		LeafHash:    base64.StdEncoding.EncodeToString(e.LeafHash),
+	}
+}
+
+// ToResponse converts a LogInclusionProof to a JSON-serializable response
+func (p *LogInclusionProof) ToResponse() *LogInclusionProofResponse {
+	return &LogInclusionProofResponse{
+		LeafIndex: p.LeafIndex,
+		TreeSize:  p.TreeSize,
+		LeafHash:  base64.StdEncoding.EncodeToString(p.LeafHash),
+		AuditPath: encodeHashes(p.AuditPath),
+	}
+}
+
+// ToResponse converts a LogConsistencyProof to a JSON-serializable response
+func (p *LogConsistencyProof) ToResponse() *LogConsistencyProofResponse {
+	return &LogConsistencyProofResponse{
+		FirstSize:  p.FirstSize,
+		SecondSize: p.SecondSize,
+		Proof:      encodeHashes(p.Proof),
+	}
+}
+
+// encodeHashes base64-encodes a list of hashes
+func encodeHashes(hashes [][]byte) []string {
+	encoded := make([]string, len(hashes))
+	for i, hash := range hashes {
+		encoded[i] = base64.StdEncoding.EncodeToString(hash)
+	}
+	return encoded
+}
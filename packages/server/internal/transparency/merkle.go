@@ -12,7 +12,8 @@ SECURITY OVERVIEW:
 
 TREE STRUCTURE:
 - 256-bit Sparse Merkle Tree (SMT) using SHA-256
-- Path is derived from SHA-256(user_id)
+- Path is the VRF output for user_id (see vrf.go); legacy entries use SHA-256(user_id)
+- Private leaves commit to the user ID instead of containing it
 - Only non-empty nodes are stored (sparse storage)
 - Default hashes for empty subtrees are precomputed
 
@@ -25,9 +26,11 @@ package transparency
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/google/uuid"
@@ -43,10 +46,38 @@ const (
 	// DefaultBatchInterval is how often to process pending updates
 	DefaultBatchInterval = 60 * time.Second
 
+	// DefaultSigningKeyOverlap is how long a rotated signing key stays valid
+	// after its successor is registered, covering instances still signing with it
+	DefaultSigningKeyOverlap = 24 * time.Hour
+
 	// MaxProofSize is the maximum number of sibling hashes in a proof
 	MaxProofSize = TreeDepth
 )
 
+// Leaf hash formats
+const (
+	// LeafVersionLegacy hashes the untagged fields with no length prefixes
+	LeafVersionLegacy = 0
+	// LeafVersionTyped adds key types and companion keys, length-prefixed
+	LeafVersionTyped = 1
+	// LeafVersionPrivate replaces the user ID with UserIDCommitment
+	LeafVersionPrivate = 2
+)
+
+// Domain separators for the typed and private leaf hashes
+const (
+	leafDomainV1 = "nochat-kt-leaf-v1\x00"
+	leafDomainV2 = "nochat-kt-leaf-v2\x00"
+)
+
+// Tree index derivation, recorded per directory entry
+const (
+	// IndexVersionSHA256 places the user at SHA-256(user_id)
+	IndexVersionSHA256 = 0
+	// IndexVersionVRF places the user at the VRF output for user_id
+	IndexVersionVRF = 1
+)
+
 // LeafData represents the data stored at each Merkle tree leaf
 type LeafData struct {
 	UserID                  uuid.UUID `json:"user_id"`
@@ -54,6 +85,20 @@ type LeafData struct {
 	SignedPreKeyFingerprint string    `json:"signed_prekey_fingerprint,omitempty"`
 	KeyVersion              int       `json:"key_version"`
 	Timestamp               int64     `json:"timestamp"` // Unix timestamp
+	// Typed leaves (LeafVersionTyped) only
+	LeafVersion      int       `json:"leaf_version,omitempty"`
+	IdentityKeyType  string    `json:"identity_key_type,omitempty"`
+	SignedPreKeyType string    `json:"signed_prekey_type,omitempty"`
+	CompanionKeys    []LeafKey `json:"companion_keys,omitempty"`
+	// Private leaves (LeafVersionPrivate) only; UserID is not hashed
+	UserIDCommitment []byte `json:"user_id_commitment,omitempty"`
+}
+
+// LeafKey is a companion key committed in a typed leaf
+type LeafKey struct {
+	Role        string `json:"role"` // "companion_identity" or "companion_signed_prekey"
+	KeyType     string `json:"key_type"`
+	Fingerprint string `json:"fingerprint"`
 }
 
 // MerkleNode represents a node in the Sparse Merkle Tree
@@ -76,6 +121,8 @@ type SignedTreeHead struct {
 	Signature             []byte    `json:"signature"`
 	SigningKeyFingerprint string    `json:"signing_key_fingerprint"`
 	Timestamp             time.Time `json:"timestamp"`
+	SaltCommitment        []byte    `json:"salt_commitment,omitempty"` // see salt.go; nil before derived salts
+	Version               int       `json:"version"`                   // signature format, see wire.go; 0 is TreeHeadVersionRaw
 }
 
 // InclusionProof proves a leaf exists in the tree at a specific epoch
@@ -86,6 +133,15 @@ type InclusionProof struct {
 	SiblingPath [][]byte   `json:"sibling_path"` // Path from leaf to root
 	PathBits    []byte     `json:"path_bits"`    // 32 bytes (256 bits) - the path through the tree
 	RootHash    []byte     `json:"root_hash"`
+
+	// Compressed form, used when SiblingPath is empty (see CompressSiblingPath)
+	SiblingBitmap []byte   `json:"sibling_bitmap,omitempty"`
+	Siblings      [][]byte `json:"siblings,omitempty"`
+
+	// Private index: PathBits is the VRF output for the user ID, and
+	// LeafData.UserIDCommitment opens with CommitmentNonce
+	VRFProof        []byte `json:"vrf_proof,omitempty"`
+	CommitmentNonce []byte `json:"commitment_nonce,omitempty"`
 }
 
 // ConsistencyProof proves two epochs are consistent (tree only grew)
@@ -104,6 +160,9 @@ type KeyUpdate struct {
 	SignedPreKeyFingerprint string    `json:"signed_prekey_fingerprint,omitempty"`
 	KeyVersion              int       `json:"key_version"`
 	UpdateType              string    `json:"update_type"` // "key_added", "key_updated", "key_revoked"
+	IdentityKeyType         string    `json:"identity_key_type,omitempty"`
+	SignedPreKeyType        string    `json:"signed_prekey_type,omitempty"`
+	CompanionKeys           []LeafKey `json:"companion_keys,omitempty"`
 }
 
 // SigningKey represents a transparency signing key
@@ -112,9 +171,11 @@ type SigningKey struct {
 	Fingerprint string     `json:"fingerprint"`
 	PublicKey   []byte     `json:"public_key"`
 	Algorithm   string     `json:"algorithm"` // "ed25519" or "p256"
+	Backend     string     `json:"backend"`   // "file", "pkcs11" or "kms"
 	Status      string     `json:"status"`    // "active", "rotated", "revoked"
 	ValidFrom   time.Time  `json:"valid_from"`
 	ValidUntil  *time.Time `json:"valid_until,omitempty"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 }
 
@@ -126,6 +187,13 @@ type KeyDirectoryEntry struct {
 	IdentityKeyFingerprint  string    `json:"identity_key_fingerprint"`
 	SignedPreKeyFingerprint string    `json:"signed_prekey_fingerprint,omitempty"`
 	KeyVersion              int       `json:"key_version"`
+	IdentityKeyType         string    `json:"identity_key_type,omitempty"`
+	SignedPreKeyType        string    `json:"signed_prekey_type,omitempty"`
+	CompanionKeys           []LeafKey `json:"companion_keys,omitempty"`
+	LeafVersion             int       `json:"leaf_version"`
+	IndexVersion            int       `json:"index_version"`
+	VRFProof                []byte    `json:"vrf_proof,omitempty"`
+	CommitmentNonce         []byte    `json:"commitment_nonce,omitempty"`
 	LastEpoch               int64     `json:"last_epoch"`
 	LeafHash                []byte    `json:"leaf_hash"`
 	CreatedAt               time.Time `json:"created_at"`
@@ -137,9 +205,12 @@ type AuditLogEntry struct {
 	ID               uuid.UUID  `json:"id"`
 	EpochNumber      int64      `json:"epoch_number"`
 	ChangeType       string     `json:"change_type"`
-	UserIDCommitment []byte     `json:"user_id_commitment"` // Pseudonymous
+	UserIDCommitment []byte     `json:"user_id_commitment"` // Pseudonymous; empty for index_cleared
 	OldLeafHash      []byte     `json:"old_leaf_hash,omitempty"`
 	NewLeafHash      []byte     `json:"new_leaf_hash,omitempty"`
+	LeafIndex        []byte     `json:"leaf_index,omitempty"`     // Tree slot written
+	OldLeafIndex     []byte     `json:"old_leaf_index,omitempty"` // Legacy only; moves log an index_cleared entry
+	Seq              int64      `json:"seq"`                      // Order within the epoch
 	CreatedAt        time.Time  `json:"created_at"`
 }
 
@@ -189,11 +260,19 @@ func GetDefaultHash(depth int) []byte {
 }
 
 // HashLeaf computes the leaf hash from leaf data
-// Format: SHA256(user_id || identity_fingerprint || prekey_fingerprint || version || timestamp)
+// Legacy format: SHA256(user_id || identity_fingerprint || prekey_fingerprint || version || timestamp)
+// Typed format: see hashLeafTyped
+// Private format: see hashLeafPrivate
 func HashLeaf(data *LeafData) []byte {
 	if data == nil {
 		return GetDefaultHash(TreeDepth)
 	}
+	switch {
+	case data.LeafVersion >= LeafVersionPrivate:
+		return hashLeafPrivate(data)
+	case data.LeafVersion == LeafVersionTyped:
+		return hashLeafTyped(data)
+	}
 
 	h := sha256.New()
 	h.Write(data.UserID[:])
@@ -204,6 +283,65 @@ func HashLeaf(data *LeafData) []byte {
 	return h.Sum(nil)
 }
 
+// hashLeafTyped computes a typed leaf hash. Every variable-length field is
+// prefixed with its 4-byte big-endian length so no two leaves share an encoding:
+// SHA256("nochat-kt-leaf-v1\x00" || user_id || u32 leaf_version ||
+// lp(identity_type) || lp(identity_fp) || lp(prekey_type) || lp(prekey_fp) ||
+// u64 key_version || u64 timestamp || u32 n || n * (lp(role) || lp(type) || lp(fp)))
+func hashLeafTyped(data *LeafData) []byte {
+	h := sha256.New()
+	h.Write([]byte(leafDomainV1))
+	h.Write(data.UserID[:])
+	writeLeafFields(h, data)
+	return h.Sum(nil)
+}
+
+// hashLeafPrivate computes a private leaf hash. It is the typed encoding with
+// its own domain and the length-prefixed user ID commitment in place of the
+// user ID, so the leaf reveals nothing about whose keys it holds:
+// SHA256("nochat-kt-leaf-v2\x00" || lp(commitment) || u32 leaf_version || ...)
+func hashLeafPrivate(data *LeafData) []byte {
+	h := sha256.New()
+	h.Write([]byte(leafDomainV2))
+	writeLengthPrefixed(h, string(data.UserIDCommitment))
+	writeLeafFields(h, data)
+	return h.Sum(nil)
+}
+
+// writeLeafFields writes the fields shared by typed and private leaves
+func writeLeafFields(h io.Writer, data *LeafData) {
+	writeUint32(h, uint32(data.LeafVersion))
+	writeLengthPrefixed(h, data.IdentityKeyType)
+	writeLengthPrefixed(h, data.IdentityKeyFingerprint)
+	writeLengthPrefixed(h, data.SignedPreKeyType)
+	writeLengthPrefixed(h, data.SignedPreKeyFingerprint)
+	writeUint64(h, uint64(data.KeyVersion))
+	writeUint64(h, uint64(data.Timestamp))
+	writeUint32(h, uint32(len(data.CompanionKeys)))
+	for _, key := range data.CompanionKeys {
+		writeLengthPrefixed(h, key.Role)
+		writeLengthPrefixed(h, key.KeyType)
+		writeLengthPrefixed(h, key.Fingerprint)
+	}
+}
+
+func writeUint32(w io.Writer, v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	w.Write(buf[:])
+}
+
+func writeUint64(w io.Writer, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	w.Write(buf[:])
+}
+
+func writeLengthPrefixed(w io.Writer, s string) {
+	writeUint32(w, uint32(len(s)))
+	io.WriteString(w, s)
+}
+
 // HashInternal computes the hash of an internal node
 // Format: SHA256(left_child || right_child)
 func HashInternal(left, right []byte) []byte {
@@ -283,10 +421,74 @@ func GetSiblingPrefix(pathBits []byte, depth int) string {
 	return PathPrefixAtDepth(siblingPath, depth)
 }
 
-// VerifyInclusionProof verifies that a leaf is included in the tree
+// CompressSiblingPath drops siblings equal to the default empty-subtree hash.
+// Bit i of the 32-byte bitmap (MSB first) is set when SiblingPath[i] is kept;
+// kept hashes are returned in index order. Most of a sparse path is default,
+// so a proof shrinks from 8 KB to roughly 32 bytes per populated level.
+func CompressSiblingPath(path [][]byte) (bitmap []byte, siblings [][]byte) {
+	bitmap = make([]byte, TreeDepth/8)
+	for i, hash := range path {
+		if bytesEqual(hash, defaultHashes[i+1]) {
+			continue
+		}
+		bitmap[i/8] |= 1 << (7 - i%8)
+		siblings = append(siblings, hash)
+	}
+	return bitmap, siblings
+}
+
+// DecompressSiblingPath expands a compressed path back to TreeDepth hashes
+func DecompressSiblingPath(bitmap []byte, siblings [][]byte) ([][]byte, error) {
+	if len(bitmap) != TreeDepth/8 {
+		return nil, fmt.Errorf("invalid sibling bitmap size: %d", len(bitmap))
+	}
+
+	path := make([][]byte, TreeDepth)
+	next := 0
+	for i := range path {
+		if GetBit(bitmap, i) == 0 {
+			path[i] = GetDefaultHash(i + 1)
+			continue
+		}
+		if next >= len(siblings) || len(siblings[next]) != HashSize {
+			return nil, fmt.Errorf("sibling bitmap does not match sibling list")
+		}
+		path[i] = siblings[next]
+		next++
+	}
+	if next != len(siblings) {
+		return nil, fmt.Errorf("sibling bitmap does not match sibling list")
+	}
+	return path, nil
+}
+
+// Compress replaces the full sibling path with its compressed form
+func (p *InclusionProof) Compress() {
+	p.SiblingBitmap, p.Siblings = CompressSiblingPath(p.SiblingPath)
+	p.SiblingPath = nil
+}
+
+// fullSiblingPath returns the proof's path, expanding the compressed form if needed
+func fullSiblingPath(path [][]byte, bitmap []byte, siblings [][]byte) ([][]byte, bool) {
+	if len(path) == 0 && bitmap != nil {
+		expanded, err := DecompressSiblingPath(bitmap, siblings)
+		if err != nil {
+			return nil, false
+		}
+		path = expanded
+	}
+	return path, len(path) == TreeDepth
+}
+
+// VerifyInclusionProof verifies that a leaf is included in the tree.
+// Accepts either the full or the compressed sibling path.
 // Returns true if the proof is valid
 func VerifyInclusionProof(proof *InclusionProof) bool {
-	if proof == nil || len(proof.SiblingPath) != TreeDepth {
+	if proof == nil {
+		return false
+	}
+	siblingPath, ok := fullSiblingPath(proof.SiblingPath, proof.SiblingBitmap, proof.Siblings)
+	if !ok {
 		return false
 	}
 
@@ -301,7 +503,7 @@ func VerifyInclusionProof(proof *InclusionProof) bool {
 
 	// Traverse up the tree
 	for depth := TreeDepth - 1; depth >= 0; depth-- {
-		siblingHash := proof.SiblingPath[depth]
+		siblingHash := siblingPath[depth]
 		bit := GetBit(proof.PathBits, depth)
 
 		if bit == 0 {
@@ -345,7 +547,8 @@ func LeafDataFromJSON(data []byte) (*LeafData, error) {
 
 // ComputeUserIDCommitment creates a pseudonymous commitment for audit logs
 // Commitment = SHA256(user_id || epoch_salt)
-// This hides the actual user ID while allowing the same user's updates to be linked
+// This hides the actual user ID while allowing the same user's updates to be linked.
+// Private leaves use it with a random per-leaf nonce that only the proof carries.
 func ComputeUserIDCommitment(userID uuid.UUID, epochSalt []byte) []byte {
 	h := sha256.New()
 	h.Write(userID[:])
@@ -0,0 +1,179 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// populatedLevels are the sibling path indexes the tests fill with
+// non-default hashes, covering both ends and a byte boundary of the bitmap
+var populatedLevels = []int{0, 7, 8, 100, TreeDepth - 1}
+
+// testSiblingPath returns a full path whose siblings are default except at levels
+func testSiblingPath(levels ...int) [][]byte {
+	path := make([][]byte, TreeDepth)
+	for i := range path {
+		path[i] = GetDefaultHash(i + 1)
+	}
+	for _, level := range levels {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("sibling %d", level)))
+		path[level] = hash[:]
+	}
+	return path
+}
+
+// testInclusionProof builds a full-path proof for one leaf, computing the
+// root by hashing up the path
+func testInclusionProof(levels ...int) *InclusionProof {
+	userID := uuid.MustParse("6f1c2a4e-3b5d-4c7e-9f10-2a3b4c5d6e7f")
+	leaf := &LeafData{
+		UserID:                 userID,
+		IdentityKeyFingerprint: "identity",
+		KeyVersion:             1,
+		Timestamp:              1767225600,
+	}
+	proof := &InclusionProof{
+		LeafHash:    HashLeaf(leaf),
+		LeafData:    leaf,
+		SiblingPath: testSiblingPath(levels...),
+		PathBits:    ComputeUserPath(userID),
+	}
+
+	hash := proof.LeafHash
+	for depth := TreeDepth - 1; depth >= 0; depth-- {
+		if GetBit(proof.PathBits, depth) == 0 {
+			hash = HashInternal(hash, proof.SiblingPath[depth])
+		} else {
+			hash = HashInternal(proof.SiblingPath[depth], hash)
+		}
+	}
+	proof.RootHash = hash
+	return proof
+}
+
+func TestCompressSiblingPathRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels []int
+	}{
+		{"all default", nil},
+		{"single level", []int{42}},
+		{"populated levels", populatedLevels},
+	}
+
+	for _, tt := range tests {
+		path := testSiblingPath(tt.levels...)
+		bitmap, siblings := CompressSiblingPath(path)
+
+		if len(bitmap) != TreeDepth/8 {
+			t.Fatalf("%s: bitmap is %d bytes, want %d", tt.name, len(bitmap), TreeDepth/8)
+		}
+		if len(siblings) != len(tt.levels) {
+			t.Fatalf("%s: kept %d siblings, want %d", tt.name, len(siblings), len(tt.levels))
+		}
+		for i, level := range tt.levels {
+			if GetBit(bitmap, level) != 1 {
+				t.Errorf("%s: bitmap bit %d not set", tt.name, level)
+			}
+			if !bytes.Equal(siblings[i], path[level]) {
+				t.Errorf("%s: sibling %d is not the hash at level %d", tt.name, i, level)
+			}
+		}
+		set := 0
+		for i := 0; i < TreeDepth; i++ {
+			set += GetBit(bitmap, i)
+		}
+		if set != len(tt.levels) {
+			t.Errorf("%s: %d bitmap bits set, want %d", tt.name, set, len(tt.levels))
+		}
+
+		expanded, err := DecompressSiblingPath(bitmap, siblings)
+		if err != nil {
+			t.Fatalf("%s: DecompressSiblingPath: %v", tt.name, err)
+		}
+		if !equalHashLists(expanded, path) {
+			t.Errorf("%s: round trip changed the path", tt.name)
+		}
+	}
+}
+
+func TestDecompressSiblingPathErrors(t *testing.T) {
+	bitmap, siblings := CompressSiblingPath(testSiblingPath(populatedLevels...))
+	extra := sha256.Sum256([]byte("extra"))
+
+	tests := []struct {
+		name     string
+		bitmap   []byte
+		siblings [][]byte
+	}{
+		{"nil bitmap", nil, siblings},
+		{"short bitmap", bitmap[:TreeDepth/8-1], siblings},
+		{"long bitmap", append(append([]byte{}, bitmap...), 0), siblings},
+		{"missing sibling", bitmap, siblings[:len(siblings)-1]},
+		{"trailing sibling", bitmap, append(append([][]byte{}, siblings...), extra[:])},
+		{"siblings without bitmap bits", make([]byte, TreeDepth/8), siblings},
+		{"short sibling hash", bitmap, append(append([][]byte{}, siblings[:len(siblings)-1]...), extra[:HashSize-1])},
+	}
+
+	for _, tt := range tests {
+		if _, err := DecompressSiblingPath(tt.bitmap, tt.siblings); err == nil {
+			t.Errorf("%s: DecompressSiblingPath succeeded", tt.name)
+		}
+	}
+}
+
+func TestVerifyCompressedInclusionProof(t *testing.T) {
+	full := testInclusionProof(populatedLevels...)
+	if !VerifyInclusionProof(full) {
+		t.Fatal("full proof rejected")
+	}
+
+	compressed := *full
+	compressed.Compress()
+	if compressed.SiblingPath != nil {
+		t.Fatal("Compress kept the full sibling path")
+	}
+	if !VerifyInclusionProof(&compressed) {
+		t.Fatal("compressed proof rejected")
+	}
+
+	extra := sha256.Sum256([]byte("extra"))
+	flippedBitmap := append([]byte{}, compressed.SiblingBitmap...)
+	flippedBitmap[20] ^= 0x01
+	tamperedSibling := append([][]byte{}, compressed.Siblings...)
+	tamperedSibling[1] = extra[:]
+
+	tests := []struct {
+		name     string
+		bitmap   []byte
+		siblings [][]byte
+	}{
+		{"flipped bitmap bit", flippedBitmap, compressed.Siblings},
+		{"missing sibling", compressed.SiblingBitmap, compressed.Siblings[:len(compressed.Siblings)-1]},
+		{"trailing sibling", compressed.SiblingBitmap, append(append([][]byte{}, compressed.Siblings...), extra[:])},
+		{"short bitmap", compressed.SiblingBitmap[:TreeDepth/8-1], compressed.Siblings},
+		{"empty bitmap", []byte{}, compressed.Siblings},
+		{"tampered sibling", compressed.SiblingBitmap, tamperedSibling},
+		{"all-default bitmap", make([]byte, TreeDepth/8), nil},
+	}
+
+	for _, tt := range tests {
+		proof := compressed
+		proof.SiblingBitmap = tt.bitmap
+		proof.Siblings = tt.siblings
+		if VerifyInclusionProof(&proof) {
+			t.Errorf("%s: compressed proof verified", tt.name)
+		}
+	}
+
+	// A proof with neither form must not verify
+	empty := compressed
+	empty.SiblingBitmap, empty.Siblings = nil, nil
+	if VerifyInclusionProof(&empty) {
+		t.Error("proof without a sibling path verified")
+	}
+}
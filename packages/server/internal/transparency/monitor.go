@@ -0,0 +1,298 @@
+package transparency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+/*
+KEY-CHANGE MONITORING:
+Each client registers the users it talks to. After every epoch, each user
+whose directory entry changed is reported to their subscribers and to
+themselves, so a user can spot a key they never uploaded. The event carries
+the new epoch and a compressed inclusion proof of the new leaf.
+
+The signing instance builds the events after the epoch commits and publishes
+them on Redis (keyChangeChannel); every instance relays them to its own
+connected clients through its KeyChangeNotifier. Without Redis the signing
+instance delivers directly. Pushes are best-effort: clients that were offline
+catch up from GetKeyChangeEvents, which reads key_directory_history.
+*/
+
+// MaxMonitoredUsers caps one user's monitoring subscriptions
+const MaxMonitoredUsers = 1000
+
+// keyChangeChannel is the Redis channel carrying key-change deliveries
+const keyChangeChannel = "transparency:key_changes"
+
+// ErrTooManyMonitoredUsers is returned for a subscription list over MaxMonitoredUsers
+var ErrTooManyMonitoredUsers = errors.New("too many monitored users")
+
+// KeyChangeNotifier delivers key-change events to a user's connected clients
+type KeyChangeNotifier interface {
+	NotifyKeyChange(userID uuid.UUID, event interface{})
+}
+
+// KeyChangeEvent reports a new directory entry for a monitored user
+type KeyChangeEvent struct {
+	Epoch                  int64          `json:"epoch"`
+	UserID                 string         `json:"user_id"`
+	Self                   bool           `json:"self"` // the recipient's own entry changed
+	UpdateType             string         `json:"update_type"`
+	KeyVersion             int            `json:"key_version"`
+	IdentityKeyFingerprint string         `json:"identity_key_fingerprint"`
+	Proof                  *ProofResponse `json:"proof"`
+}
+
+// keyChangeDelivery is one event for one recipient, as published on Redis
+type keyChangeDelivery struct {
+	Recipient uuid.UUID       `json:"recipient"`
+	Event     *KeyChangeEvent `json:"event"`
+}
+
+// SetKeyChangeNotifier sets the notifier for key-change events and, with
+// Redis, starts relaying events published by the signing instance
+func (s *Service) SetKeyChangeNotifier(n KeyChangeNotifier) {
+	s.keyChangeNotifier = n
+	if s.redis == nil || n == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := s.redis.Subscribe(ctx, keyChangeChannel)
+	go func() {
+		<-s.stopBatch
+		cancel()
+		pubsub.Close()
+	}()
+	go func() {
+		for msg := range pubsub.Channel() {
+			var deliveries []keyChangeDelivery
+			if err := json.Unmarshal([]byte(msg.Payload), &deliveries); err != nil {
+				log.Printf("[Transparency] Failed to decode key-change events: %v", err)
+				continue
+			}
+			for _, d := range deliveries {
+				n.NotifyKeyChange(d.Recipient, d.Event)
+			}
+		}
+	}()
+}
+
+// SetMonitoredUsers replaces the users a subscriber monitors. Only users the
+// subscriber shares a conversation or an accepted contact with are kept; the
+// rest are dropped silently, so the stored set never tells a subscriber more
+// than it already knows and can't be used to probe for accounts.
+func (s *Service) SetMonitoredUsers(ctx context.Context, subscriberID uuid.UUID, userIDs []uuid.UUID) error {
+	if len(userIDs) > MaxMonitoredUsers {
+		return ErrTooManyMonitoredUsers
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM transparency_monitor_subscriptions WHERE subscriber_id = $1
+	`, subscriberID); err != nil {
+		return fmt.Errorf("failed to clear subscriptions: %w", err)
+	}
+
+	watched := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id != subscriberID {
+			watched = append(watched, id.String())
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO transparency_monitor_subscriptions (subscriber_id, watched_user_id)
+		SELECT $1, u.id FROM users u
+		WHERE u.id = ANY($2::uuid[])
+		  AND (
+			EXISTS (
+				SELECT 1 FROM participants p1
+				INNER JOIN participants p2 ON p2.conversation_id = p1.conversation_id
+				WHERE p1.user_id = $1 AND p2.user_id = u.id
+			)
+			OR EXISTS (
+				SELECT 1 FROM contacts c
+				WHERE c.status = 'accepted'
+				  AND ((c.user_id = $1 AND c.contact_user_id = u.id)
+				    OR (c.user_id = u.id AND c.contact_user_id = $1))
+			)
+		  )
+		ON CONFLICT DO NOTHING
+	`, subscriberID, pq.Array(watched))
+	if err != nil {
+		return fmt.Errorf("failed to store subscriptions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetMonitoredUsers returns the users a subscriber monitors
+func (s *Service) GetMonitoredUsers(ctx context.Context, subscriberID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT watched_user_id FROM transparency_monitor_subscriptions
+		WHERE subscriber_id = $1
+		ORDER BY created_at ASC
+	`, subscriberID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	userIDs := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// GetKeyChangeEvents returns the key changes of a subscriber's monitored
+// users and of the subscriber itself from fromEpoch on, oldest first
+func (s *Service) GetKeyChangeEvents(ctx context.Context, subscriberID uuid.UUID, fromEpoch int64, limit int) ([]*KeyChangeEvent, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyVersionColumns+`
+		FROM key_directory_history
+		WHERE epoch_number >= $2
+		  AND (user_id = $1 OR user_id IN (
+			SELECT watched_user_id FROM transparency_monitor_subscriptions WHERE subscriber_id = $1
+		  ))
+		ORDER BY epoch_number ASC, user_id
+		LIMIT $3
+	`, subscriberID, fromEpoch, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key changes: %w", err)
+	}
+	var versions []*KeyVersion
+	for rows.Next() {
+		v, err := scanKeyVersion(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan key version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key changes: %w", err)
+	}
+
+	rebuilds := maxRebuildsPerRequest
+	events := make([]*KeyChangeEvent, 0, len(versions))
+	for _, v := range versions {
+		event, err := s.keyChangeEvent(ctx, v, &rebuilds)
+		if err != nil {
+			return nil, err
+		}
+		event.Self = v.UserID == subscriberID
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// keyChangeEvent builds the event for a key version, proven at the epoch
+// that introduced it. rebuilds is passed to readEpochTree.
+func (s *Service) keyChangeEvent(ctx context.Context, v *KeyVersion, rebuilds *int) (*KeyChangeEvent, error) {
+	proof, err := s.keyVersionProof(ctx, v, v.Epoch, rebuilds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prove key change at epoch %d: %w", v.Epoch, err)
+	}
+	proof.Compress()
+
+	return &KeyChangeEvent{
+		Epoch:                  v.Epoch,
+		UserID:                 v.UserID.String(),
+		UpdateType:             v.UpdateType,
+		KeyVersion:             v.LeafData.KeyVersion,
+		IdentityKeyFingerprint: v.LeafData.IdentityKeyFingerprint,
+		Proof:                  proof.ToResponse(),
+	}, nil
+}
+
+// publishKeyChanges sends a key-change event for each user changed in an
+// epoch to their subscribers and to the user. Runs after the epoch commits.
+func (s *Service) publishKeyChanges(epoch int64, userIDs []uuid.UUID) {
+	if s.redis == nil && s.keyChangeNotifier == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	events := make(map[uuid.UUID]*KeyChangeEvent, len(userIDs))
+	for _, userID := range userIDs {
+		v, err := s.GetKeyVersionAt(ctx, userID, epoch)
+		if err != nil || v == nil || v.Epoch != epoch {
+			continue
+		}
+		event, err := s.keyChangeEvent(ctx, v, nil)
+		if err != nil {
+			log.Printf("[Transparency] Failed to build key-change event for epoch %d: %v", epoch, err)
+			continue
+		}
+		events[userID] = event
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	changed := make([]string, 0, len(events))
+	deliveries := make([]keyChangeDelivery, 0, len(events))
+	for userID, event := range events {
+		changed = append(changed, userID.String())
+		self := *event
+		self.Self = true
+		deliveries = append(deliveries, keyChangeDelivery{Recipient: userID, Event: &self})
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT subscriber_id, watched_user_id FROM transparency_monitor_subscriptions
+		WHERE watched_user_id = ANY($1::uuid[])
+	`, pq.Array(changed))
+	if err != nil {
+		log.Printf("[Transparency] Failed to get key-change subscribers: %v", err)
+	} else {
+		for rows.Next() {
+			var subscriberID, watchedID uuid.UUID
+			if err := rows.Scan(&subscriberID, &watchedID); err != nil {
+				log.Printf("[Transparency] Failed to scan key-change subscriber: %v", err)
+				break
+			}
+			deliveries = append(deliveries, keyChangeDelivery{Recipient: subscriberID, Event: events[watchedID]})
+		}
+		rows.Close()
+	}
+
+	if s.redis == nil {
+		for _, d := range deliveries {
+			s.keyChangeNotifier.NotifyKeyChange(d.Recipient, d.Event)
+		}
+		return
+	}
+	payload, err := json.Marshal(deliveries)
+	if err != nil {
+		log.Printf("[Transparency] Failed to encode key-change events: %v", err)
+		return
+	}
+	if err := s.redis.Publish(ctx, keyChangeChannel, payload).Err(); err != nil {
+		log.Printf("[Transparency] Failed to publish key-change events for epoch %d: %v", epoch, err)
+	}
+}
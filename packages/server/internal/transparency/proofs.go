@@ -6,23 +6,51 @@ import (
 	"fmt"
 )
 
-// ProofResponse represents a JSON-serializable inclusion proof
+// ProofResponse represents a JSON-serializable inclusion proof.
+// Exactly one of sibling_path or sibling_bitmap + siblings is set.
 type ProofResponse struct {
-	EpochNumber int64             `json:"epoch_number"`
-	LeafHash    string            `json:"leaf_hash"`
-	LeafData    *LeafDataResponse `json:"leaf_data"`
-	SiblingPath []string          `json:"sibling_path"`
-	PathBits    string            `json:"path_bits"`
-	RootHash    string            `json:"root_hash"`
+	EpochNumber   int64             `json:"epoch_number"`
+	LeafHash      string            `json:"leaf_hash"`
+	LeafData      *LeafDataResponse `json:"leaf_data"`
+	SiblingPath   []string          `json:"sibling_path,omitempty"`
+	SiblingBitmap string            `json:"sibling_bitmap,omitempty"`
+	Siblings      []string          `json:"siblings,omitempty"`
+	PathBits      string            `json:"path_bits"`
+	RootHash      string            `json:"root_hash"`
+	// Set for VRF-indexed entries (see VerifyUserInclusionProof)
+	VRFProof        string `json:"vrf_proof,omitempty"`
+	CommitmentNonce string `json:"commitment_nonce,omitempty"`
 }
 
 // LeafDataResponse is a JSON-serializable version of LeafData
 type LeafDataResponse struct {
-	UserID                  string `json:"user_id"`
-	IdentityKeyFingerprint  string `json:"identity_key_fingerprint"`
-	SignedPreKeyFingerprint string `json:"signed_prekey_fingerprint,omitempty"`
-	KeyVersion              int    `json:"key_version"`
-	Timestamp               int64  `json:"timestamp"`
+	UserID                  string    `json:"user_id"`
+	IdentityKeyFingerprint  string    `json:"identity_key_fingerprint"`
+	SignedPreKeyFingerprint string    `json:"signed_prekey_fingerprint,omitempty"`
+	KeyVersion              int       `json:"key_version"`
+	Timestamp               int64     `json:"timestamp"`
+	LeafVersion             int       `json:"leaf_version,omitempty"`
+	IdentityKeyType         string    `json:"identity_key_type,omitempty"`
+	SignedPreKeyType        string    `json:"signed_prekey_type,omitempty"`
+	CompanionKeys           []LeafKey `json:"companion_keys,omitempty"`
+	UserIDCommitment        string    `json:"user_id_commitment,omitempty"`
+}
+
+// KeyVersionResponse is a JSON-serializable key version with its inclusion proof
+type KeyVersionResponse struct {
+	Epoch        int64          `json:"epoch"`
+	UpdateType   string         `json:"update_type"`
+	KeyVersion   int            `json:"key_version"`
+	IndexVersion int            `json:"index_version"`
+	Proof        *ProofResponse `json:"proof"`
+}
+
+// VRFKeyResponse is the JSON-serializable VRF public key
+type VRFKeyResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+	Suite       string `json:"suite"`
+	FirstEpoch  *int64 `json:"first_epoch,omitempty"`
 }
 
 // ConsistencyProofResponse is a JSON-serializable consistency proof
@@ -42,6 +70,8 @@ type TreeHeadResponse struct {
 	Signature             string `json:"signature"`
 	SigningKeyFingerprint string `json:"signing_key_fingerprint"`
 	Timestamp             string `json:"timestamp"`
+	SaltCommitment        string `json:"salt_commitment,omitempty"`
+	Version               int    `json:"version"` // signature format: 1 raw, 2 canonical (see wire.go)
 }
 
 // SigningKeyResponse is a JSON-serializable signing key
@@ -49,6 +79,7 @@ type SigningKeyResponse struct {
 	Fingerprint string  `json:"fingerprint"`
 	PublicKey   string  `json:"public_key"`
 	Algorithm   string  `json:"algorithm"`
+	Status      string  `json:"status"` // "active" or "rotated"
 	ValidFrom   string  `json:"valid_from"`
 	ValidUntil  *string `json:"valid_until,omitempty"`
 }
@@ -60,14 +91,17 @@ type AuditLogEntryResponse struct {
 	UserIDCommitment string  `json:"user_id_commitment"`
 	OldLeafHash      *string `json:"old_leaf_hash,omitempty"`
 	NewLeafHash      *string `json:"new_leaf_hash,omitempty"`
+	LeafIndex        *string `json:"leaf_index,omitempty"`
+	OldLeafIndex     *string `json:"old_leaf_index,omitempty"`
+	Seq              int64   `json:"seq"`
 	Timestamp        string  `json:"timestamp"`
 }
 
 // ToResponse converts an InclusionProof to a JSON-serializable response
 func (p *InclusionProof) ToResponse() *ProofResponse {
-	siblingPath := make([]string, len(p.SiblingPath))
-	for i, hash := range p.SiblingPath {
-		siblingPath[i] = base64.StdEncoding.EncodeToString(hash)
+	var siblingPath []string
+	if len(p.SiblingPath) > 0 {
+		siblingPath = encodeHashes(p.SiblingPath)
 	}
 
 	var leafDataResp *LeafDataResponse
@@ -78,10 +112,17 @@ func (p *InclusionProof) ToResponse() *ProofResponse {
 			SignedPreKeyFingerprint: p.LeafData.SignedPreKeyFingerprint,
 			KeyVersion:              p.LeafData.KeyVersion,
 			Timestamp:               p.LeafData.Timestamp,
+			LeafVersion:             p.LeafData.LeafVersion,
+			IdentityKeyType:         p.LeafData.IdentityKeyType,
+			SignedPreKeyType:        p.LeafData.SignedPreKeyType,
+			CompanionKeys:           p.LeafData.CompanionKeys,
+		}
+		if p.LeafData.UserIDCommitment != nil {
+			leafDataResp.UserIDCommitment = base64.StdEncoding.EncodeToString(p.LeafData.UserIDCommitment)
 		}
 	}
 
-	return &ProofResponse{
+	resp := &ProofResponse{
 		EpochNumber: p.EpochNumber,
 		LeafHash:    base64.StdEncoding.EncodeToString(p.LeafHash),
 		LeafData:    leafDataResp,
@@ -89,6 +130,36 @@ func (p *InclusionProof) ToResponse() *ProofResponse {
 		PathBits:    base64.StdEncoding.EncodeToString(p.PathBits),
 		RootHash:    base64.StdEncoding.EncodeToString(p.RootHash),
 	}
+	if p.SiblingBitmap != nil {
+		resp.SiblingBitmap = base64.StdEncoding.EncodeToString(p.SiblingBitmap)
+		resp.Siblings = encodeHashes(p.Siblings)
+	}
+	if p.VRFProof != nil {
+		resp.VRFProof = base64.StdEncoding.EncodeToString(p.VRFProof)
+		resp.CommitmentNonce = base64.StdEncoding.EncodeToString(p.CommitmentNonce)
+	}
+	return resp
+}
+
+// ToResponse converts a KeyVersionProof to a JSON-serializable response
+func (v *KeyVersionProof) ToResponse() *KeyVersionResponse {
+	return &KeyVersionResponse{
+		Epoch:        v.Epoch,
+		UpdateType:   v.UpdateType,
+		KeyVersion:   v.LeafData.KeyVersion,
+		IndexVersion: v.IndexVersion,
+		Proof:        v.Proof.ToResponse(),
+	}
+}
+
+// ToResponse converts a VRFKey to a JSON-serializable response
+func (k *VRFKey) ToResponse() *VRFKeyResponse {
+	return &VRFKeyResponse{
+		Fingerprint: k.Fingerprint,
+		PublicKey:   base64.StdEncoding.EncodeToString(k.PublicKey),
+		Suite:       k.Suite,
+		FirstEpoch:  k.FirstEpoch,
+	}
 }
 
 // ToResponse converts a ConsistencyProof to a JSON-serializable response
@@ -109,14 +180,19 @@ func (p *ConsistencyProof) ToResponse() *ConsistencyProofResponse {
 
 // ToResponse converts a SignedTreeHead to a JSON-serializable response
 func (sth *SignedTreeHead) ToResponse() *TreeHeadResponse {
-	return &TreeHeadResponse{
+	resp := &TreeHeadResponse{
 		EpochNumber:           sth.EpochNumber,
 		RootHash:              base64.StdEncoding.EncodeToString(sth.RootHash),
 		TreeSize:              sth.TreeSize,
 		Signature:             base64.StdEncoding.EncodeToString(sth.Signature),
 		SigningKeyFingerprint: sth.SigningKeyFingerprint,
 		Timestamp:             sth.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+		Version:               treeHeadVersion(sth),
 	}
+	if len(sth.SaltCommitment) > 0 {
+		resp.SaltCommitment = base64.StdEncoding.EncodeToString(sth.SaltCommitment)
+	}
+	return resp
 }
 
 // ToResponse converts a SigningKey to a JSON-serializable response
@@ -125,6 +201,7 @@ func (k *SigningKey) ToResponse() *SigningKeyResponse {
 		Fingerprint: k.Fingerprint,
 		PublicKey:   base64.StdEncoding.EncodeToString(k.PublicKey),
 		Algorithm:   k.Algorithm,
+		Status:      k.Status,
 		ValidFrom:   k.ValidFrom.Format("2006-01-02T15:04:05Z07:00"),
 	}
 	if k.ValidUntil != nil {
@@ -140,6 +217,7 @@ func (e *AuditLogEntry) ToResponse() *AuditLogEntryResponse {
 		EpochNumber:      e.EpochNumber,
 		ChangeType:       e.ChangeType,
 		UserIDCommitment: base64.StdEncoding.EncodeToString(e.UserIDCommitment),
+		Seq:              e.Seq,
 		Timestamp:        e.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	}
 	if len(e.OldLeafHash) > 0 {
@@ -150,6 +228,14 @@ func (e *AuditLogEntry) ToResponse() *AuditLogEntryResponse {
 		newHash := base64.StdEncoding.EncodeToString(e.NewLeafHash)
 		resp.NewLeafHash = &newHash
 	}
+	if len(e.LeafIndex) > 0 {
+		leafIndex := base64.StdEncoding.EncodeToString(e.LeafIndex)
+		resp.LeafIndex = &leafIndex
+	}
+	if len(e.OldLeafIndex) > 0 {
+		oldLeafIndex := base64.StdEncoding.EncodeToString(e.OldLeafIndex)
+		resp.OldLeafIndex = &oldLeafIndex
+	}
 	return resp
 }
 
@@ -259,11 +345,29 @@ type NonExistenceProof struct {
 	UserIDHash  []byte   `json:"user_id_hash"`
 	SiblingPath [][]byte `json:"sibling_path"` // Path to empty location
 	RootHash    []byte   `json:"root_hash"`
+
+	// Compressed form, used when SiblingPath is empty (see CompressSiblingPath)
+	SiblingBitmap []byte   `json:"sibling_bitmap,omitempty"`
+	Siblings      [][]byte `json:"siblings,omitempty"`
+
+	// Set when UserIDHash is a VRF index (see VerifyUserNonExistenceProof)
+	VRFProof []byte `json:"vrf_proof,omitempty"`
 }
 
-// VerifyNonExistenceProof verifies that a user is NOT in the tree
+// Compress replaces the full sibling path with its compressed form
+func (p *NonExistenceProof) Compress() {
+	p.SiblingBitmap, p.Siblings = CompressSiblingPath(p.SiblingPath)
+	p.SiblingPath = nil
+}
+
+// VerifyNonExistenceProof verifies that a user is NOT in the tree.
+// Accepts either the full or the compressed sibling path.
 func VerifyNonExistenceProof(proof *NonExistenceProof) bool {
-	if proof == nil || len(proof.SiblingPath) != TreeDepth {
+	if proof == nil {
+		return false
+	}
+	siblingPath, ok := fullSiblingPath(proof.SiblingPath, proof.SiblingBitmap, proof.Siblings)
+	if !ok {
 		return false
 	}
 
@@ -272,7 +376,7 @@ func VerifyNonExistenceProof(proof *NonExistenceProof) bool {
 
 	// Traverse up the tree
 	for depth := TreeDepth - 1; depth >= 0; depth-- {
-		siblingHash := proof.SiblingPath[depth]
+		siblingHash := siblingPath[depth]
 		bit := GetBit(proof.UserIDHash, depth)
 
 		if bit == 0 {
@@ -288,23 +392,31 @@ func VerifyNonExistenceProof(proof *NonExistenceProof) bool {
 
 // NonExistenceProofResponse is a JSON-serializable non-existence proof
 type NonExistenceProofResponse struct {
-	EpochNumber int64    `json:"epoch_number"`
-	UserIDHash  string   `json:"user_id_hash"`
-	SiblingPath []string `json:"sibling_path"`
-	RootHash    string   `json:"root_hash"`
+	EpochNumber   int64    `json:"epoch_number"`
+	UserIDHash    string   `json:"user_id_hash"`
+	SiblingPath   []string `json:"sibling_path,omitempty"`
+	SiblingBitmap string   `json:"sibling_bitmap,omitempty"`
+	Siblings      []string `json:"siblings,omitempty"`
+	RootHash      string   `json:"root_hash"`
+	VRFProof      string   `json:"vrf_proof,omitempty"`
 }
 
 // ToResponse converts a NonExistenceProof to a JSON-serializable response
 func (p *NonExistenceProof) ToResponse() *NonExistenceProofResponse {
-	siblingPath := make([]string, len(p.SiblingPath))
-	for i, hash := range p.SiblingPath {
-		siblingPath[i] = base64.StdEncoding.EncodeToString(hash)
-	}
-
-	return &NonExistenceProofResponse{
+	resp := &NonExistenceProofResponse{
 		EpochNumber: p.EpochNumber,
 		UserIDHash:  base64.StdEncoding.EncodeToString(p.UserIDHash),
-		SiblingPath: siblingPath,
 		RootHash:    base64.StdEncoding.EncodeToString(p.RootHash),
 	}
+	if len(p.SiblingPath) > 0 {
+		resp.SiblingPath = encodeHashes(p.SiblingPath)
+	}
+	if p.SiblingBitmap != nil {
+		resp.SiblingBitmap = base64.StdEncoding.EncodeToString(p.SiblingBitmap)
+		resp.Siblings = encodeHashes(p.Siblings)
+	}
+	if p.VRFProof != nil {
+		resp.VRFProof = base64.StdEncoding.EncodeToString(p.VRFProof)
+	}
+	return resp
 }
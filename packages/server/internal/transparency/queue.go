@@ -0,0 +1,230 @@
+package transparency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+DURABLE UPDATE QUEUE:
+transparency_pending_updates is the source of truth for key changes that have
+not reached the tree yet. A key upload inserts a 'pending' row; the batch
+processor claims due rows with SELECT ... FOR UPDATE SKIP LOCKED inside the
+epoch transaction, so:
+
+  - a crash before commit releases the rows and they are replayed on startup
+  - several instances can drain the queue without applying a row twice
+  - a failing update is retried with exponential backoff and, after
+    MaxUpdateAttempts, parked as 'dead' for an operator to inspect and requeue
+*/
+
+// Queue limits
+const (
+	// MaxBatchSize is the most updates folded into a single epoch
+	MaxBatchSize = 500
+
+	// MaxUpdateAttempts is how many times applyUpdate is tried before dead-lettering
+	MaxUpdateAttempts = 5
+
+	// maxRetryBackoff caps the delay between attempts
+	maxRetryBackoff = time.Hour
+)
+
+// Queue row states
+const (
+	UpdateStatusPending   = "pending"
+	UpdateStatusProcessed = "processed"
+	UpdateStatusDead      = "dead"
+)
+
+// queuedUpdate is a claimed row of the update queue
+type queuedUpdate struct {
+	KeyUpdate
+	ID       uuid.UUID
+	Attempts int
+}
+
+// QueuedUpdate is a queue row as reported to operators
+type QueuedUpdate struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        uuid.UUID  `json:"user_id"`
+	UpdateType    string     `json:"update_type"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeadAt        *time.Time `json:"dead_at,omitempty"`
+}
+
+// enqueueUpdate inserts a pending update
+func (s *Service) enqueueUpdate(ctx context.Context, update KeyUpdate) error {
+	var companionKeysJSON []byte
+	if len(update.CompanionKeys) > 0 {
+		var err error
+		if companionKeysJSON, err = json.Marshal(update.CompanionKeys); err != nil {
+			return fmt.Errorf("failed to encode companion keys: %w", err)
+		}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO transparency_pending_updates (
+			id, user_id, update_type, identity_key_fingerprint, signed_prekey_fingerprint,
+			key_version, identity_key_type, signed_prekey_type, companion_keys, status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'pending')
+	`, uuid.New(), update.UserID, update.UpdateType, update.IdentityKeyFingerprint,
+		sql.NullString{String: update.SignedPreKeyFingerprint, Valid: update.SignedPreKeyFingerprint != ""},
+		update.KeyVersion,
+		sql.NullString{String: update.IdentityKeyType, Valid: update.IdentityKeyType != ""},
+		sql.NullString{String: update.SignedPreKeyType, Valid: update.SignedPreKeyType != ""},
+		companionKeysJSON)
+	if err != nil {
+		return fmt.Errorf("failed to insert queued update: %w", err)
+	}
+	return nil
+}
+
+// claimUpdates locks up to limit due updates in queue order, skipping rows
+// another batch already holds
+func claimUpdates(ctx context.Context, tx *sql.Tx, limit int) ([]queuedUpdate, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, user_id, update_type, identity_key_fingerprint, signed_prekey_fingerprint,
+		       key_version, identity_key_type, signed_prekey_type, companion_keys, attempts
+		FROM transparency_pending_updates
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY created_at ASC
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim updates: %w", err)
+	}
+	defer rows.Close()
+
+	var updates []queuedUpdate
+	for rows.Next() {
+		var u queuedUpdate
+		var signedPreKeyFingerprint, identityKeyType, signedPreKeyType sql.NullString
+		var companionKeysJSON []byte
+
+		err := rows.Scan(&u.ID, &u.UserID, &u.UpdateType, &u.IdentityKeyFingerprint, &signedPreKeyFingerprint,
+			&u.KeyVersion, &identityKeyType, &signedPreKeyType, &companionKeysJSON, &u.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan queued update: %w", err)
+		}
+
+		u.SignedPreKeyFingerprint = signedPreKeyFingerprint.String
+		u.IdentityKeyType = identityKeyType.String
+		u.SignedPreKeyType = signedPreKeyType.String
+		if len(companionKeysJSON) > 0 {
+			if err := json.Unmarshal(companionKeysJSON, &u.CompanionKeys); err != nil {
+				return nil, fmt.Errorf("failed to decode companion keys for update %s: %w", u.ID, err)
+			}
+		}
+		updates = append(updates, u)
+	}
+	return updates, rows.Err()
+}
+
+// recordUpdateFailure schedules a retry, or dead-letters the update once it
+// has used all its attempts
+func recordUpdateFailure(ctx context.Context, tx *sql.Tx, update queuedUpdate, cause error) error {
+	attempts := update.Attempts + 1
+	if attempts >= MaxUpdateAttempts {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE transparency_pending_updates
+			SET status = 'dead', attempts = $2, last_error = $3, dead_at = NOW()
+			WHERE id = $1
+		`, update.ID, attempts, cause.Error())
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		UPDATE transparency_pending_updates
+		SET attempts = $2, last_error = $3, next_attempt_at = $4
+		WHERE id = $1
+	`, update.ID, attempts, cause.Error(), time.Now().Add(retryBackoff(attempts)))
+	return err
+}
+
+// retryBackoff doubles the batch interval per failed attempt, capped at maxRetryBackoff
+func retryBackoff(attempts int) time.Duration {
+	backoff := DefaultBatchInterval
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+// GetQueuedUpdates lists queue rows in a given status, oldest first
+func (s *Service) GetQueuedUpdates(ctx context.Context, status string, limit int) ([]QueuedUpdate, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, update_type, status, attempts, last_error, next_attempt_at, created_at, dead_at
+		FROM transparency_pending_updates
+		WHERE status = $1
+		ORDER BY created_at ASC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queued updates: %w", err)
+	}
+	defer rows.Close()
+
+	var updates []QueuedUpdate
+	for rows.Next() {
+		var u QueuedUpdate
+		var lastError sql.NullString
+		var deadAt sql.NullTime
+
+		err := rows.Scan(&u.ID, &u.UserID, &u.UpdateType, &u.Status, &u.Attempts, &lastError,
+			&u.NextAttemptAt, &u.CreatedAt, &deadAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan queued update: %w", err)
+		}
+		u.LastError = lastError.String
+		if deadAt.Valid {
+			u.DeadAt = &deadAt.Time
+		}
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
+
+// RequeueDeadUpdate returns a dead-lettered update to the queue with a fresh
+// set of attempts. It reports whether a dead update with that ID existed.
+func (s *Service) RequeueDeadUpdate(ctx context.Context, id uuid.UUID) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE transparency_pending_updates
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), dead_at = NULL
+		WHERE id = $1 AND status = 'dead'
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to requeue update: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// pendingUpdateCount returns how many updates are waiting to be applied
+func (s *Service) pendingUpdateCount(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM transparency_pending_updates WHERE status = 'pending'
+	`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending updates: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,507 @@
+package transparency
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+NODE RETENTION:
+merkle_nodes gains a delta for every epoch, so it grows without bound. The
+retention policy keeps the full node sets of the last RetainEpochs epochs,
+plus a snapshot (a rebuilt base) every SnapshotInterval epochs:
+
+  - Snapshots are never pruned. A snapshot is an epoch whose node set is the
+    whole tree, so it needs nothing older to be read.
+  - An epoch is prunable once it is neither a snapshot nor needed by a
+    retained epoch. Retained epochs read from their own base on, so every
+    delta below the oldest retained epoch's base can go.
+  - The compaction job, run by the leader, marks prunable epochs first and
+    deletes their nodes on a later pass, after pruneGracePeriod, so a proof
+    that started before the mark still finds its nodes.
+
+A proof at a pruned epoch loads its snapshot's leaves, replays the audit log
+up to the epoch and rebuilds the tree in memory. The rebuilt root must match
+the signed root. Epochs whose audit log entries lack tree indexes cannot be
+replayed, so they are never pruned. A rebuild that fails anyway returns
+ErrEpochPruned.
+
+The last few rebuilt epochs are cached. Requests that prove many epochs at
+once, key history and key-change catch-up, may rebuild at most
+maxRebuildsPerRequest epochs that aren't cached, and get ErrEpochPruned
+past that, so one request can't tie up the rebuilder.
+*/
+
+// Node retention defaults
+const (
+	// DefaultRetainEpochs is how many recent epochs keep their full node sets
+	DefaultRetainEpochs = 1000
+	// DefaultSnapshotInterval is how many epochs pass between snapshots
+	DefaultSnapshotInterval = 1000
+	// DefaultCompactionInterval is how often the compaction job runs
+	DefaultCompactionInterval = time.Hour
+
+	// pruneGracePeriod is how long a pruned epoch keeps its nodes after being marked
+	pruneGracePeriod = 10 * time.Minute
+	// compactionBatchEpochs bounds the epochs deleted in one transaction
+	compactionBatchEpochs = 100
+
+	// prunedTreeCacheSize is how many rebuilt pruned epochs stay in memory
+	prunedTreeCacheSize = 4
+	// maxRebuildsPerRequest bounds the pruned epochs one key history or
+	// key-change request may rebuild
+	maxRebuildsPerRequest = 2
+)
+
+// Epoch node status (transparency_epochs.node_status)
+const (
+	nodeStatusPresent = "present"
+	nodeStatusPruning = "pruning" // marked; nodes are deleted after pruneGracePeriod
+	nodeStatusPruned  = "pruned"
+)
+
+// ErrEpochPruned is returned when a pruned epoch's tree cannot be rebuilt
+var ErrEpochPruned = errors.New("epoch nodes were pruned and cannot be rebuilt")
+
+// RetentionPolicy controls how long epochs keep their nodes in merkle_nodes
+type RetentionPolicy struct {
+	RetainEpochs       int64         // Epochs kept in full; 0 keeps every epoch
+	SnapshotInterval   int64         // Epochs between snapshots; 0 never takes one after the first base
+	CompactionInterval time.Duration // How often the compaction job runs
+}
+
+// DefaultRetentionPolicy returns the built-in retention policy
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		RetainEpochs:       DefaultRetainEpochs,
+		SnapshotInterval:   DefaultSnapshotInterval,
+		CompactionInterval: DefaultCompactionInterval,
+	}
+}
+
+// RetentionPolicyFromEnv reads the retention policy from TRANSPARENCY_RETAIN_EPOCHS,
+// TRANSPARENCY_SNAPSHOT_INTERVAL and TRANSPARENCY_COMPACTION_INTERVAL,
+// falling back to defaults for anything unset
+func RetentionPolicyFromEnv() (RetentionPolicy, error) {
+	policy := DefaultRetentionPolicy()
+
+	counts := []struct {
+		env string
+		dst *int64
+	}{
+		{"TRANSPARENCY_RETAIN_EPOCHS", &policy.RetainEpochs},
+		{"TRANSPARENCY_SNAPSHOT_INTERVAL", &policy.SnapshotInterval},
+	}
+	for _, c := range counts {
+		v := os.Getenv(c.env)
+		if v == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s: %w", c.env, err)
+		}
+		*c.dst = parsed
+	}
+
+	if v := os.Getenv("TRANSPARENCY_COMPACTION_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid TRANSPARENCY_COMPACTION_INTERVAL: %w", err)
+		}
+		policy.CompactionInterval = parsed
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate checks the policy for inconsistent values
+func (p RetentionPolicy) Validate() error {
+	if p.RetainEpochs < 0 || p.SnapshotInterval < 0 {
+		return fmt.Errorf("retained epochs and snapshot interval must not be negative")
+	}
+	if p.CompactionInterval <= 0 {
+		return fmt.Errorf("compaction interval must be positive")
+	}
+	return nil
+}
+
+// CompactionResult summarises one compaction pass
+type CompactionResult struct {
+	MarkedEpochs int64     `json:"marked_epochs"` // newly marked for pruning
+	PrunedEpochs int64     `json:"pruned_epochs"` // whose nodes were deleted
+	DeletedNodes int64     `json:"deleted_nodes"`
+	DurationMs   int64     `json:"duration_ms"`
+	CompletedAt  time.Time `json:"completed_at"`
+}
+
+// checkSnapshot verifies that a snapshot reproduces the previous epoch's
+// root. If it does not, the snapshot is discarded and the epoch continues
+// from the previous base, so the new root still reflects only this epoch's
+// updates.
+func checkSnapshot(ctx context.Context, tx *sql.Tx, tree, prev *epochTree, rebuiltRoot []byte) (*epochTree, error) {
+	prevRoot, err := prev.root(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prevRoot, rebuiltRoot) {
+		return tree, nil
+	}
+
+	log.Printf("[Transparency] Snapshot for epoch %d does not reproduce the root of epoch %d; keeping base epoch %d",
+		tree.epoch, prev.epoch, prev.base)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM merkle_nodes WHERE epoch = $1`, tree.epoch); err != nil {
+		return nil, fmt.Errorf("failed to discard snapshot: %w", err)
+	}
+	return &epochTree{epoch: tree.epoch, base: prev.base, cumulative: true}, nil
+}
+
+// compactionLoop runs the compaction job on the leader until ctx is done
+func (s *Service) compactionLoop(ctx context.Context) {
+	if s.retention.RetainEpochs == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.retention.CompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.CompactTree(ctx); err != nil {
+				log.Printf("[Transparency] Tree compaction failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CompactTree runs one compaction pass: it deletes the nodes of epochs marked
+// on an earlier pass, then marks the epochs that fell out of retention
+func (s *Service) CompactTree(ctx context.Context) (*CompactionResult, error) {
+	started := time.Now()
+	result := &CompactionResult{}
+
+	for {
+		epochs, nodes, err := deletePrunedNodes(ctx, s.db, started.Add(-pruneGracePeriod))
+		if err != nil {
+			return nil, err
+		}
+		result.PrunedEpochs += epochs
+		result.DeletedNodes += nodes
+		if epochs < compactionBatchEpochs {
+			break
+		}
+	}
+
+	if s.retention.RetainEpochs > 0 {
+		marked, err := markPrunableEpochs(ctx, s.db, s.retention.RetainEpochs)
+		if err != nil {
+			return nil, err
+		}
+		result.MarkedEpochs = marked
+	}
+
+	result.CompletedAt = time.Now()
+	result.DurationMs = result.CompletedAt.Sub(started).Milliseconds()
+
+	s.compactionMu.Lock()
+	s.lastCompaction = result
+	s.compactionMu.Unlock()
+
+	if result.MarkedEpochs > 0 || result.PrunedEpochs > 0 {
+		log.Printf("[Transparency] Compacted tree: marked %d epochs, pruned %d epochs (%d nodes) in %dms",
+			result.MarkedEpochs, result.PrunedEpochs, result.DeletedNodes, result.DurationMs)
+	}
+	return result, nil
+}
+
+// markPrunableEpochs marks the cumulative, non-snapshot epochs below the base
+// of the oldest retained epoch, skipping epochs the audit log cannot rebuild
+func markPrunableEpochs(ctx context.Context, db *sql.DB, retainEpochs int64) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE transparency_epochs e
+		SET node_status = $2, nodes_pruned_at = NOW()
+		WHERE e.node_status = $3
+		  AND e.tree_base_epoch IS NOT NULL
+		  AND e.tree_base_epoch <> e.epoch_number
+		  AND e.epoch_number < (
+			SELECT r.tree_base_epoch FROM transparency_epochs r
+			WHERE r.epoch_number = (SELECT MAX(epoch_number) FROM transparency_epochs) - $1 + 1
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM transparency_audit_log a
+			WHERE a.epoch_number > e.tree_base_epoch AND a.epoch_number <= e.epoch_number
+			  AND a.leaf_index IS NULL
+		  )
+	`, retainEpochs, nodeStatusPruning, nodeStatusPresent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark prunable epochs: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// deletePrunedNodes deletes the nodes of up to compactionBatchEpochs epochs
+// marked before markedBefore, returning the epochs and nodes deleted
+func deletePrunedNodes(ctx context.Context, db *sql.DB, markedBefore time.Time) (int64, int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT epoch_number FROM transparency_epochs
+		WHERE node_status = $1 AND nodes_pruned_at < $2
+		ORDER BY epoch_number ASC
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`, nodeStatusPruning, markedBefore, compactionBatchEpochs)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get marked epochs: %w", err)
+	}
+	var epochs []int64
+	for rows.Next() {
+		var epoch int64
+		if err := rows.Scan(&epoch); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan marked epoch: %w", err)
+		}
+		epochs = append(epochs, epoch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read marked epochs: %w", err)
+	}
+	if len(epochs) == 0 {
+		return 0, 0, nil
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM merkle_nodes WHERE epoch = ANY($1)`, pq.Array(epochs))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete pruned nodes: %w", err)
+	}
+	nodes, _ := res.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE transparency_epochs SET node_status = $1 WHERE epoch_number = ANY($2)
+	`, nodeStatusPruned, pq.Array(epochs)); err != nil {
+		return 0, 0, fmt.Errorf("failed to mark epochs pruned: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit compaction: %w", err)
+	}
+	return int64(len(epochs)), nodes, nil
+}
+
+// readEpochTree returns an epoch's tree for reading proofs, rebuilding it in
+// memory if its nodes were pruned, or nil if the epoch does not exist.
+// rebuilds, if non-nil, is how many rebuilds the calling request may still
+// run; once it reaches zero, epochs missing from the cache fail with
+// ErrEpochPruned.
+func (s *Service) readEpochTree(ctx context.Context, epoch int64, rebuilds *int) (*epochTree, error) {
+	tree, err := getEpochTree(ctx, s.db, epoch)
+	if err != nil || tree == nil || !tree.pruned {
+		return tree, err
+	}
+
+	if cached := s.cachedPrunedTree(epoch); cached != nil {
+		return cached, nil
+	}
+	if rebuilds != nil {
+		if *rebuilds <= 0 {
+			return nil, fmt.Errorf("%w: too many pruned epochs in one request, retry with a smaller limit", ErrEpochPruned)
+		}
+		*rebuilds--
+	}
+
+	// Rebuilds are expensive; run one at a time. Another request may have
+	// rebuilt this epoch while we waited.
+	s.rebuildMu.Lock()
+	defer s.rebuildMu.Unlock()
+	if cached := s.cachedPrunedTree(epoch); cached != nil {
+		return cached, nil
+	}
+
+	started := time.Now()
+	if err := s.rebuildPrunedTree(ctx, tree); err != nil {
+		return nil, err
+	}
+	log.Printf("[Transparency] Rebuilt pruned epoch %d from snapshot %d (%d leaves) in %s",
+		epoch, tree.base, len(tree.leaves), time.Since(started))
+
+	s.cachePrunedTree(tree)
+	return tree, nil
+}
+
+// cachedPrunedTree returns a rebuilt epoch from the cache, marking it most
+// recently used, or nil if it isn't cached
+func (s *Service) cachedPrunedTree(epoch int64) *epochTree {
+	s.prunedMu.Lock()
+	defer s.prunedMu.Unlock()
+	for i, tree := range s.prunedTrees {
+		if tree.epoch == epoch {
+			copy(s.prunedTrees[1:i+1], s.prunedTrees[:i])
+			s.prunedTrees[0] = tree
+			return tree
+		}
+	}
+	return nil
+}
+
+// cachePrunedTree adds a rebuilt epoch to the cache, evicting the least
+// recently used one when full
+func (s *Service) cachePrunedTree(tree *epochTree) {
+	s.prunedMu.Lock()
+	defer s.prunedMu.Unlock()
+	if len(s.prunedTrees) < prunedTreeCacheSize {
+		s.prunedTrees = append(s.prunedTrees, nil)
+	}
+	copy(s.prunedTrees[1:], s.prunedTrees)
+	s.prunedTrees[0] = tree
+}
+
+// rebuildPrunedTree loads a pruned epoch's leaves: its snapshot's leaves with
+// the audit log replayed on top, checked against the epoch's signed root
+func (s *Service) rebuildPrunedTree(ctx context.Context, tree *epochTree) error {
+	leaves := map[[HashSize]byte][]byte{}
+	emptyLeaf := GetDefaultHash(TreeDepth)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT path_prefix, node_hash FROM merkle_nodes
+		WHERE epoch = $1 AND depth = $2
+	`, tree.base, TreeDepth)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot leaves: %w", err)
+	}
+	for rows.Next() {
+		var prefix string
+		var hash []byte
+		if err := rows.Scan(&prefix, &hash); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan snapshot leaf: %w", err)
+		}
+		path, err := hex.DecodeString(prefix)
+		if err != nil || len(path) != HashSize {
+			rows.Close()
+			return fmt.Errorf("invalid snapshot leaf path %q", prefix)
+		}
+		if bytes.Equal(hash, emptyLeaf) {
+			continue
+		}
+		var index [HashSize]byte
+		copy(index[:], path)
+		leaves[index] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read snapshot leaves: %w", err)
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT leaf_index, old_leaf_index, new_leaf_hash FROM transparency_audit_log
+		WHERE epoch_number > $1 AND epoch_number <= $2
+		ORDER BY epoch_number ASC, seq ASC
+	`, tree.base, tree.epoch)
+	if err != nil {
+		return fmt.Errorf("failed to get audit log: %w", err)
+	}
+	for rows.Next() {
+		var leafIndex, oldLeafIndex, newLeafHash []byte
+		if err := rows.Scan(&leafIndex, &oldLeafIndex, &newLeafHash); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan audit log entry: %w", err)
+		}
+		if len(leafIndex) != HashSize {
+			rows.Close()
+			return fmt.Errorf("%w: audit log of epoch %d has entries without a tree index", ErrEpochPruned, tree.epoch)
+		}
+		var index [HashSize]byte
+		if len(oldLeafIndex) == HashSize {
+			copy(index[:], oldLeafIndex)
+			delete(leaves, index)
+		}
+		copy(index[:], leafIndex)
+		if len(newLeafHash) == 0 || bytes.Equal(newLeafHash, emptyLeaf) {
+			delete(leaves, index)
+		} else {
+			leaves[index] = newLeafHash
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	var signedRoot []byte
+	err = s.db.QueryRowContext(ctx, `
+		SELECT root_hash FROM transparency_epochs WHERE epoch_number = $1
+	`, tree.epoch).Scan(&signedRoot)
+	if err != nil {
+		return fmt.Errorf("failed to get root hash: %w", err)
+	}
+
+	sorted := sortedLeaves(leaves)
+	if root := buildSubtree(sorted, 0, func(int, []byte, []byte) {}); !bytes.Equal(root, signedRoot) {
+		return fmt.Errorf("%w: rebuilt root of epoch %d does not match its signed root", ErrEpochPruned, tree.epoch)
+	}
+	tree.leaves = sorted
+	return nil
+}
+
+// TreeStorageStats reports the size of the tree's node storage
+type TreeStorageStats struct {
+	NodeRows         int64             `json:"node_rows"`  // planner estimate
+	NodeBytes        int64             `json:"node_bytes"` // merkle_nodes with its indexes
+	Epochs           int64             `json:"epochs"`
+	PrunedEpochs     int64             `json:"pruned_epochs"` // including those awaiting deletion
+	Snapshots        int64             `json:"snapshots"`
+	LatestSnapshot   int64             `json:"latest_snapshot"`
+	RetainEpochs     int64             `json:"retain_epochs"`
+	SnapshotInterval int64             `json:"snapshot_interval"`
+	LastCompaction   *CompactionResult `json:"last_compaction,omitempty"`
+}
+
+// GetStorageStats returns node storage metrics and the last compaction result
+func (s *Service) GetStorageStats(ctx context.Context) (*TreeStorageStats, error) {
+	stats := &TreeStorageStats{
+		RetainEpochs:     s.retention.RetainEpochs,
+		SnapshotInterval: s.retention.SnapshotInterval,
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT pg_total_relation_size('merkle_nodes'),
+		       GREATEST((SELECT reltuples::bigint FROM pg_class WHERE relname = 'merkle_nodes'), 0)
+	`).Scan(&stats.NodeBytes, &stats.NodeRows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node storage size: %w", err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE node_status <> $1),
+		       COUNT(*) FILTER (WHERE tree_base_epoch = epoch_number),
+		       COALESCE(MAX(epoch_number) FILTER (WHERE tree_base_epoch = epoch_number), 0)
+		FROM transparency_epochs
+	`, nodeStatusPresent).Scan(&stats.Epochs, &stats.PrunedEpochs, &stats.Snapshots, &stats.LatestSnapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get epoch counts: %w", err)
+	}
+
+	s.compactionMu.Lock()
+	stats.LastCompaction = s.lastCompaction
+	s.compactionMu.Unlock()
+	return stats, nil
+}
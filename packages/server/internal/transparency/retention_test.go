@@ -0,0 +1,28 @@
+package transparency
+
+import "testing"
+
+func TestPrunedTreeCache(t *testing.T) {
+	s := &Service{}
+	for epoch := int64(1); epoch <= prunedTreeCacheSize; epoch++ {
+		s.cachePrunedTree(&epochTree{epoch: epoch})
+	}
+
+	// Using epoch 1 makes epoch 2 the least recently used
+	if tree := s.cachedPrunedTree(1); tree == nil || tree.epoch != 1 {
+		t.Fatalf("cachedPrunedTree(1) = %v, want epoch 1", tree)
+	}
+	s.cachePrunedTree(&epochTree{epoch: prunedTreeCacheSize + 1})
+
+	if len(s.prunedTrees) != prunedTreeCacheSize {
+		t.Fatalf("cache holds %d trees, want %d", len(s.prunedTrees), prunedTreeCacheSize)
+	}
+	if s.cachedPrunedTree(2) != nil {
+		t.Error("least recently used epoch 2 was not evicted")
+	}
+	for _, epoch := range []int64{1, 3, prunedTreeCacheSize + 1} {
+		if tree := s.cachedPrunedTree(epoch); tree == nil || tree.epoch != epoch {
+			t.Errorf("cachedPrunedTree(%d) = %v, want epoch %d", epoch, tree, epoch)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+/*
+APPEND-ONLY LOG HASHING (RFC 6962 / RFC 9162 section 2.1):
+  leaf hash  = SHA-256(0x00 || leaf)
+  node hash  = SHA-256(0x01 || left || right)
+  MTH({})    = SHA-256("")
+
+A tree of n leaves splits at k, the largest power of two smaller than n, so
+every left subtree is perfect. Stored nodes are only ever perfect subtrees,
+keyed by (level, index): the node at level L and index i covers leaves
+[i*2^L, (i+1)*2^L). Any subtree hash a proof needs is rebuilt from
+O(log n) stored nodes.
+*/
+
+// Domain separation prefixes from RFC 6962
+const (
+	logLeafPrefix = 0x00
+	logNodePrefix = 0x01
+)
+
+// Log proof verification errors
+var (
+	ErrLogProofInvalid = errors.New("invalid log proof")
+	ErrLogRange        = errors.New("log index out of range")
+)
+
+// LogLeafHash computes the RFC 6962 leaf hash
+func LogLeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{logLeafPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// LogNodeHash computes the RFC 6962 interior node hash
+func LogNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{logNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// EmptyLogRoot is the root hash of a log with no entries
+func EmptyLogRoot() []byte {
+	h := sha256.Sum256(nil)
+	return h[:]
+}
+
+// LogNodeReader returns the stored hash of the perfect subtree at (level, index)
+type LogNodeReader func(level int, index int64) ([]byte, error)
+
+// largestPowerOfTwoBelow returns the largest power of two strictly less than n (n > 1)
+func largestPowerOfTwoBelow(n int64) int64 {
+	return 1 << (bits.Len64(uint64(n-1)) - 1)
+}
+
+// logSubtreeHash computes MTH of leaves [start, end) from stored perfect subtrees
+func logSubtreeHash(read LogNodeReader, start, end int64) ([]byte, error) {
+	n := end - start
+	if n <= 0 {
+		return EmptyLogRoot(), nil
+	}
+	// Perfect, aligned subtrees are stored directly
+	if n&(n-1) == 0 && start%n == 0 {
+		level := bits.TrailingZeros64(uint64(n))
+		return read(level, start>>level)
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	left, err := logSubtreeHash(read, start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := logSubtreeHash(read, start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	return LogNodeHash(left, right), nil
+}
+
+// LogRoot computes the root hash of the first treeSize leaves
+func LogRoot(read LogNodeReader, treeSize int64) ([]byte, error) {
+	return logSubtreeHash(read, 0, treeSize)
+}
+
+// LogInclusionPath computes PATH(m, D[0:n]) from RFC 6962 section 2.1.1
+func LogInclusionPath(read LogNodeReader, leafIndex, treeSize int64) ([][]byte, error) {
+	if leafIndex < 0 || leafIndex >= treeSize {
+		return nil, ErrLogRange
+	}
+	return logPath(read, leafIndex, 0, treeSize)
+}
+
+// logPath computes the audit path for leaf m within leaves [start, end)
+func logPath(read LogNodeReader, m, start, end int64) ([][]byte, error) {
+	n := end - start
+	if n <= 1 {
+		return [][]byte{}, nil
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	if m-start < k {
+		path, err := logPath(read, m, start, start+k)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := logSubtreeHash(read, start+k, end)
+		if err != nil {
+			return nil, err
+		}
+		return append(path, sibling), nil
+	}
+
+	path, err := logPath(read, m, start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := logSubtreeHash(read, start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// LogConsistencyHashes computes PROOF(m, D[0:n]) from RFC 6962 section 2.1.2
+func LogConsistencyHashes(read LogNodeReader, firstSize, secondSize int64) ([][]byte, error) {
+	if firstSize < 0 || firstSize > secondSize {
+		return nil, ErrLogRange
+	}
+	if firstSize == 0 || firstSize == secondSize {
+		return [][]byte{}, nil
+	}
+	return logSubproof(read, firstSize, 0, secondSize, true)
+}
+
+// logSubproof computes SUBPROOF(m, D[start:end], b)
+func logSubproof(read LogNodeReader, m, start, end int64, complete bool) ([][]byte, error) {
+	n := end - start
+	if m == n {
+		if complete {
+			return [][]byte{}, nil
+		}
+		hash, err := logSubtreeHash(read, start, end)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{hash}, nil
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	if m <= k {
+		proof, err := logSubproof(read, m, start, start+k, complete)
+		if err != nil {
+			return nil, err
+		}
+		right, err := logSubtreeHash(read, start+k, end)
+		if err != nil {
+			return nil, err
+		}
+		return append(proof, right), nil
+	}
+
+	proof, err := logSubproof(read, m-k, start+k, end, false)
+	if err != nil {
+		return nil, err
+	}
+	left, err := logSubtreeHash(read, start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, left), nil
+}
+
+// VerifyLogInclusion checks an audit path (RFC 9162 section 2.1.3.2)
+func VerifyLogInclusion(leafHash []byte, leafIndex, treeSize int64, path [][]byte, rootHash []byte) error {
+	if leafIndex < 0 || leafIndex >= treeSize {
+		return ErrLogRange
+	}
+
+	fn, sn := leafIndex, treeSize-1
+	r := leafHash
+	for _, p := range path {
+		if sn == 0 {
+			return ErrLogProofInvalid
+		}
+		if fn&1 == 1 || fn == sn {
+			r = LogNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = LogNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, rootHash) {
+		return ErrLogProofInvalid
+	}
+	return nil
+}
+
+// VerifyLogConsistency checks a consistency proof (RFC 9162 section 2.1.4.2)
+func VerifyLogConsistency(firstSize, secondSize int64, firstRoot, secondRoot []byte, proof [][]byte) error {
+	switch {
+	case firstSize < 0 || firstSize > secondSize:
+		return ErrLogRange
+	case firstSize == 0:
+		// The empty tree is consistent with every tree, but only under its own root
+		if len(proof) != 0 || !bytes.Equal(firstRoot, EmptyLogRoot()) {
+			return ErrLogProofInvalid
+		}
+		if secondSize == 0 && !bytes.Equal(secondRoot, firstRoot) {
+			return ErrLogProofInvalid
+		}
+		return nil
+	case firstSize == secondSize:
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrLogProofInvalid
+		}
+		return nil
+	case len(proof) == 0:
+		return ErrLogProofInvalid
+	}
+
+	// If the first tree is a perfect left subtree, its root is the implicit first proof node
+	if firstSize&(firstSize-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+
+	fn, sn := firstSize-1, secondSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrLogProofInvalid
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = LogNodeHash(c, fr)
+			sr = LogNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = LogNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrLogProofInvalid
+	}
+	return nil
+}
+
+// logNodesCompletedBy returns the perfect subtrees completed by appending leaf
+// index i, as (level, index) pairs from level 1 up
+func logNodesCompletedBy(i int64) [][2]int64 {
+	var nodes [][2]int64
+	for level := int64(1); (i+1)%(1<<level) == 0; level++ {
+		nodes = append(nodes, [2]int64{level, i >> level})
+	}
+	return nodes
+}
+
+// validateLogHash checks a hash read from storage
+func validateLogHash(hash []byte, level int, index int64) error {
+	if len(hash) != HashSize {
+		return fmt.Errorf("log node (%d, %d) has invalid size %d", level, index, len(hash))
+	}
+	return nil
+}
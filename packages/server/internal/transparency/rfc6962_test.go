@@ -0,0 +1,246 @@
+package transparency
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// maxTestLogSize bounds the log sizes the proof tests enumerate
+const maxTestLogSize = 40
+
+// testLogLeaves returns n distinct leaves
+func testLogLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	return leaves
+}
+
+// naiveMTH computes MTH(D[0:n]) straight from RFC 6962 section 2.1
+func naiveMTH(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return EmptyLogRoot()
+	case 1:
+		return LogLeafHash(leaves[0])
+	}
+	k := largestPowerOfTwoBelow(int64(len(leaves)))
+	return LogNodeHash(naiveMTH(leaves[:k]), naiveMTH(leaves[k:]))
+}
+
+// naivePath computes PATH(m, D[0:n]) from RFC 6962 section 2.1.1
+func naivePath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return [][]byte{}
+	}
+	k := int(largestPowerOfTwoBelow(int64(len(leaves))))
+	if m < k {
+		return append(naivePath(m, leaves[:k]), naiveMTH(leaves[k:]))
+	}
+	return append(naivePath(m-k, leaves[k:]), naiveMTH(leaves[:k]))
+}
+
+// naiveProof computes PROOF(m, D[0:n]) from RFC 6962 section 2.1.2
+func naiveProof(m int, leaves [][]byte) [][]byte {
+	if m == 0 || m == len(leaves) {
+		return [][]byte{}
+	}
+	return naiveSubproof(m, leaves, true)
+}
+
+func naiveSubproof(m int, leaves [][]byte, complete bool) [][]byte {
+	if m == len(leaves) {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{naiveMTH(leaves)}
+	}
+	k := int(largestPowerOfTwoBelow(int64(len(leaves))))
+	if m <= k {
+		return append(naiveSubproof(m, leaves[:k], complete), naiveMTH(leaves[k:]))
+	}
+	return append(naiveSubproof(m-k, leaves[k:], false), naiveMTH(leaves[:k]))
+}
+
+// testLogNodeReader stores every perfect, aligned subtree of leaves, as the
+// service does in transparency_log_nodes
+func testLogNodeReader(leaves [][]byte) LogNodeReader {
+	nodes := map[[2]int64][]byte{}
+	for level := 0; 1<<level <= len(leaves); level++ {
+		width := 1 << level
+		for i := 0; (i+1)*width <= len(leaves); i++ {
+			nodes[[2]int64{int64(level), int64(i)}] = naiveMTH(leaves[i*width : (i+1)*width])
+		}
+	}
+	return func(level int, index int64) ([]byte, error) {
+		hash, ok := nodes[[2]int64{int64(level), index}]
+		if !ok {
+			return nil, fmt.Errorf("no stored node (%d, %d)", level, index)
+		}
+		return hash, nil
+	}
+}
+
+func equalHashLists(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// tamperedPaths returns variations of path that must not verify
+func tamperedPaths(path [][]byte) map[string][][]byte {
+	out := map[string][][]byte{
+		"extra node": append(append([][]byte{}, path...), make([]byte, HashSize)),
+	}
+	if len(path) > 0 {
+		out["truncated"] = path[:len(path)-1]
+		for i := range path {
+			flipped := append([][]byte{}, path...)
+			flipped[i] = append([]byte{}, path[i]...)
+			flipped[i][0] ^= 1
+			out[fmt.Sprintf("flipped node %d", i)] = flipped
+		}
+	}
+	return out
+}
+
+func TestLogRoot(t *testing.T) {
+	all := testLogLeaves(maxTestLogSize)
+	read := testLogNodeReader(all)
+
+	for n := 0; n <= maxTestLogSize; n++ {
+		got, err := LogRoot(read, int64(n))
+		if err != nil {
+			t.Fatalf("LogRoot(%d): %v", n, err)
+		}
+		if !bytes.Equal(got, naiveMTH(all[:n])) {
+			t.Fatalf("LogRoot(%d) does not match MTH", n)
+		}
+	}
+}
+
+func TestLogInclusion(t *testing.T) {
+	all := testLogLeaves(maxTestLogSize)
+	read := testLogNodeReader(all)
+
+	for n := 1; n <= maxTestLogSize; n++ {
+		root := naiveMTH(all[:n])
+		for m := 0; m < n; m++ {
+			path, err := LogInclusionPath(read, int64(m), int64(n))
+			if err != nil {
+				t.Fatalf("LogInclusionPath(%d, %d): %v", m, n, err)
+			}
+			if !equalHashLists(path, naivePath(m, all[:n])) {
+				t.Fatalf("LogInclusionPath(%d, %d) does not match PATH", m, n)
+			}
+
+			leaf := LogLeafHash(all[m])
+			if err := VerifyLogInclusion(leaf, int64(m), int64(n), path, root); err != nil {
+				t.Fatalf("VerifyLogInclusion(%d, %d): %v", m, n, err)
+			}
+
+			for name, bad := range tamperedPaths(path) {
+				if err := VerifyLogInclusion(leaf, int64(m), int64(n), bad, root); err == nil {
+					t.Errorf("VerifyLogInclusion(%d, %d) accepted a path with %s", m, n, name)
+				}
+			}
+			if m+1 < n {
+				if err := VerifyLogInclusion(leaf, int64(m+1), int64(n), path, root); err == nil {
+					t.Errorf("VerifyLogInclusion(%d, %d) accepted the path at index %d", m, n, m+1)
+				}
+			}
+			if err := VerifyLogInclusion(LogLeafHash([]byte("other")), int64(m), int64(n), path, root); err == nil {
+				t.Errorf("VerifyLogInclusion(%d, %d) accepted another leaf", m, n)
+			}
+		}
+	}
+
+	if _, err := LogInclusionPath(read, 3, 3); !errors.Is(err, ErrLogRange) {
+		t.Errorf("LogInclusionPath past the end = %v, want ErrLogRange", err)
+	}
+	if err := VerifyLogInclusion(LogLeafHash(all[0]), 0, 0, nil, EmptyLogRoot()); !errors.Is(err, ErrLogRange) {
+		t.Errorf("VerifyLogInclusion in an empty log = %v, want ErrLogRange", err)
+	}
+}
+
+func TestLogConsistency(t *testing.T) {
+	all := testLogLeaves(maxTestLogSize)
+	read := testLogNodeReader(all)
+
+	for n := 0; n <= maxTestLogSize; n++ {
+		secondRoot := naiveMTH(all[:n])
+		for m := 0; m <= n; m++ {
+			firstRoot := naiveMTH(all[:m])
+
+			proof, err := LogConsistencyHashes(read, int64(m), int64(n))
+			if err != nil {
+				t.Fatalf("LogConsistencyHashes(%d, %d): %v", m, n, err)
+			}
+			if !equalHashLists(proof, naiveProof(m, all[:n])) {
+				t.Fatalf("LogConsistencyHashes(%d, %d) does not match PROOF", m, n)
+			}
+
+			if err := VerifyLogConsistency(int64(m), int64(n), firstRoot, secondRoot, proof); err != nil {
+				t.Fatalf("VerifyLogConsistency(%d, %d): %v", m, n, err)
+			}
+
+			for name, bad := range tamperedPaths(proof) {
+				if err := VerifyLogConsistency(int64(m), int64(n), firstRoot, secondRoot, bad); err == nil {
+					t.Errorf("VerifyLogConsistency(%d, %d) accepted a proof with %s", m, n, name)
+				}
+			}
+
+			wrongRoot := append([]byte{}, firstRoot...)
+			wrongRoot[0] ^= 1
+			if err := VerifyLogConsistency(int64(m), int64(n), wrongRoot, secondRoot, proof); err == nil {
+				t.Errorf("VerifyLogConsistency(%d, %d) accepted a wrong first root", m, n)
+			}
+			if m > 0 || n == 0 {
+				wrongRoot = append([]byte{}, secondRoot...)
+				wrongRoot[0] ^= 1
+				if err := VerifyLogConsistency(int64(m), int64(n), firstRoot, wrongRoot, proof); err == nil {
+					t.Errorf("VerifyLogConsistency(%d, %d) accepted a wrong second root", m, n)
+				}
+			}
+		}
+	}
+
+	if _, err := LogConsistencyHashes(read, 5, 4); !errors.Is(err, ErrLogRange) {
+		t.Errorf("LogConsistencyHashes(5, 4) = %v, want ErrLogRange", err)
+	}
+	if err := VerifyLogConsistency(5, 4, nil, nil, nil); !errors.Is(err, ErrLogRange) {
+		t.Errorf("VerifyLogConsistency(5, 4) = %v, want ErrLogRange", err)
+	}
+}
+
+// TestLogConsistencyFromEmpty checks that a consistency proof from the empty
+// log only verifies against the empty log's root
+func TestLogConsistencyFromEmpty(t *testing.T) {
+	leaves := testLogLeaves(5)
+	secondRoot := naiveMTH(leaves)
+
+	if err := VerifyLogConsistency(0, 5, EmptyLogRoot(), secondRoot, nil); err != nil {
+		t.Fatalf("VerifyLogConsistency from the empty root: %v", err)
+	}
+	for name, firstRoot := range map[string][]byte{
+		"nil":        nil,
+		"zero hash":  make([]byte, HashSize),
+		"other root": naiveMTH(leaves[:2]),
+	} {
+		if err := VerifyLogConsistency(0, 5, firstRoot, secondRoot, nil); !errors.Is(err, ErrLogProofInvalid) {
+			t.Errorf("VerifyLogConsistency(0, 5) with %s first root = %v, want ErrLogProofInvalid", name, err)
+		}
+	}
+	if err := VerifyLogConsistency(0, 0, EmptyLogRoot(), secondRoot, nil); !errors.Is(err, ErrLogProofInvalid) {
+		t.Errorf("VerifyLogConsistency(0, 0) with a non-empty second root = %v, want ErrLogProofInvalid", err)
+	}
+}
@@ -0,0 +1,210 @@
+package transparency
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+EPOCH SALTS:
+Audit log entries identify users by ComputeUserIDCommitment(user_id, salt),
+with a fresh salt per epoch so commitments cannot be linked across epochs.
+
+With TRANSPARENCY_SALT_SECRET set, the salt is derived rather than random:
+
+  salt       = HKDF-SHA256(ikm = secret, salt = nil,
+                           info = "nochat-kt-epoch-salt-v1\x00" || epoch (8))
+  commitment = SHA-256("nochat-kt-salt-commit-v1\x00" || epoch (8) || salt)
+
+The commitment is signed in the epoch's tree head (TreeHeadData), so the
+server is bound to the salt when the epoch is published. After the reveal
+delay anyone can fetch the salt, check it against the signed commitment and
+recompute the commitments of user IDs they know. Before that, an audit log
+entry cannot be tested against candidate user IDs.
+
+Epochs signed without a secret have no commitment and use a random salt that
+is never revealed. Once an epoch carries a commitment, a signing instance
+without the secret, or with a different one, stays read-only.
+*/
+
+// Epoch salt domain separators
+const (
+	saltInfoDomain   = "nochat-kt-epoch-salt-v1\x00"
+	saltCommitDomain = "nochat-kt-salt-commit-v1\x00"
+)
+
+// DefaultSaltRevealDelay is how long after its epoch a salt is revealed
+const DefaultSaltRevealDelay = 30 * 24 * time.Hour
+
+// minSaltSecretSize is the minimum length of TRANSPARENCY_SALT_SECRET, decoded
+const minSaltSecretSize = 32
+
+// Epoch salt errors
+var (
+	ErrSaltSecretRequired = errors.New("epochs commit to derived salts but no salt secret is configured")
+	ErrSaltSecretMismatch = errors.New("salt secret does not reproduce the committed epoch salts")
+	ErrNoSaltCommitment   = errors.New("epoch has no salt commitment")
+	ErrSaltNotRevealed    = errors.New("epoch salt not revealed yet")
+	ErrSaltUnavailable    = errors.New("salt secret not loaded on this instance")
+)
+
+// EpochSalt is a revealed epoch salt
+type EpochSalt struct {
+	EpochNumber int64     `json:"epoch_number"`
+	Salt        []byte    `json:"salt"`
+	Commitment  []byte    `json:"commitment"`
+	RevealedAt  time.Time `json:"revealed_at"`
+}
+
+// EpochSaltResponse is a JSON-serializable revealed epoch salt
+type EpochSaltResponse struct {
+	EpochNumber int64  `json:"epoch_number"`
+	Salt        string `json:"salt"`
+	Commitment  string `json:"commitment"`
+	RevealedAt  string `json:"revealed_at"`
+}
+
+// ToResponse converts an EpochSalt to a JSON-serializable response
+func (e *EpochSalt) ToResponse() *EpochSaltResponse {
+	return &EpochSaltResponse{
+		EpochNumber: e.EpochNumber,
+		Salt:        base64.StdEncoding.EncodeToString(e.Salt),
+		Commitment:  base64.StdEncoding.EncodeToString(e.Commitment),
+		RevealedAt:  e.RevealedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+// DeriveEpochSalt derives an epoch's salt from the salt secret
+func DeriveEpochSalt(secret []byte, epoch int64) []byte {
+	info := binary.BigEndian.AppendUint64([]byte(saltInfoDomain), uint64(epoch))
+	salt := make([]byte, HashSize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), salt); err != nil {
+		panic("hkdf: " + err.Error()) // 32 bytes is far below the HKDF-SHA256 limit
+	}
+	return salt
+}
+
+// EpochSaltCommitment returns the commitment to an epoch's salt signed in its tree head
+func EpochSaltCommitment(epoch int64, salt []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(saltCommitDomain))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(epoch)))
+	h.Write(salt)
+	return h.Sum(nil)
+}
+
+// VerifyEpochSalt checks a revealed salt against the commitment in a tree head
+func VerifyEpochSalt(sth *SignedTreeHead, salt []byte) error {
+	if len(sth.SaltCommitment) == 0 {
+		return ErrNoSaltCommitment
+	}
+	if !bytesEqual(EpochSaltCommitment(sth.EpochNumber, salt), sth.SaltCommitment) {
+		return fmt.Errorf("salt does not match the commitment of epoch %d", sth.EpochNumber)
+	}
+	return nil
+}
+
+// loadSaltSecret loads TRANSPARENCY_SALT_SECRET (base64) and checks it against
+// the latest committed epoch. Like the VRF key, a signing instance must have
+// the secret once epochs commit to derived salts.
+func (s *Service) loadSaltSecret(ctx context.Context, signing bool) error {
+	if delay := os.Getenv("TRANSPARENCY_SALT_REVEAL_DELAY"); delay != "" {
+		if d, err := time.ParseDuration(delay); err == nil && d >= 0 {
+			s.saltRevealDelay = d
+		} else {
+			log.Printf("[Transparency] Warning: Invalid TRANSPARENCY_SALT_REVEAL_DELAY %q, using %s", delay, DefaultSaltRevealDelay)
+		}
+	}
+
+	var latest int64
+	var commitment []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT epoch_number, salt_commitment FROM transparency_epochs
+		WHERE salt_commitment IS NOT NULL
+		ORDER BY epoch_number DESC
+		LIMIT 1
+	`).Scan(&latest, &commitment)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get latest salt commitment: %w", err)
+	}
+	committed := err == nil
+
+	encoded := os.Getenv("TRANSPARENCY_SALT_SECRET")
+	if encoded == "" {
+		if committed && signing {
+			return ErrSaltSecretRequired
+		}
+		if !committed {
+			log.Printf("[Transparency] Warning: No TRANSPARENCY_SALT_SECRET configured; epoch salts are random and never revealed")
+		}
+		return nil
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(secret) < minSaltSecretSize {
+		return fmt.Errorf("TRANSPARENCY_SALT_SECRET must be base64 of at least %d bytes", minSaltSecretSize)
+	}
+	if committed && !bytesEqual(EpochSaltCommitment(latest, DeriveEpochSalt(secret, latest)), commitment) {
+		return fmt.Errorf("%w (epoch %d)", ErrSaltSecretMismatch, latest)
+	}
+
+	s.saltSecret = secret
+	log.Printf("[Transparency] Loaded epoch salt secret; salts are revealed after %s", s.saltRevealDelay)
+	return nil
+}
+
+// epochSalt returns the salt for a new epoch and the commitment to sign, which
+// is nil for a random salt
+func (s *Service) epochSalt(epoch int64) (salt, commitment []byte, err error) {
+	if s.saltSecret == nil {
+		salt = make([]byte, HashSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate epoch salt: %w", err)
+		}
+		return salt, nil, nil
+	}
+	salt = DeriveEpochSalt(s.saltSecret, epoch)
+	return salt, EpochSaltCommitment(epoch, salt), nil
+}
+
+// GetEpochSalt reveals an epoch's salt once the reveal delay has passed
+func (s *Service) GetEpochSalt(ctx context.Context, epoch int64) (*EpochSalt, error) {
+	sth, err := s.GetSignedTreeHeadAtEpoch(ctx, epoch)
+	if err != nil {
+		return nil, err
+	}
+	if len(sth.SaltCommitment) == 0 {
+		return nil, ErrNoSaltCommitment
+	}
+
+	revealAt := sth.Timestamp.Add(s.saltRevealDelay)
+	if time.Now().Before(revealAt) {
+		return nil, fmt.Errorf("%w: epoch %d is revealed at %s", ErrSaltNotRevealed, epoch, revealAt.UTC().Format(time.RFC3339))
+	}
+	if s.saltSecret == nil {
+		return nil, ErrSaltUnavailable
+	}
+
+	salt := DeriveEpochSalt(s.saltSecret, epoch)
+	if err := VerifyEpochSalt(sth, salt); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSaltSecretMismatch, err)
+	}
+	return &EpochSalt{
+		EpochNumber: epoch,
+		Salt:        salt,
+		Commitment:  sth.SaltCommitment,
+		RevealedAt:  revealAt,
+	}, nil
+}
@@ -1,30 +1,69 @@
 package transparency
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 )
 
+// Signing key verification errors
+var (
+	ErrUnknownSigningKey        = errors.New("unknown signing key")
+	ErrSigningKeyRevoked        = errors.New("signing key revoked")
+	ErrOutsideKeyValidity       = errors.New("tree head timestamp outside signing key validity")
+	ErrInvalidTreeHeadSignature = errors.New("invalid tree head signature")
+)
+
 // Service provides key transparency operations using a Sparse Merkle Tree
 type Service struct {
 	db     *sql.DB
 	redis  *redis.Client
-	signer *Signer
+	signer TreeHeadSigner
+
+	// Derives private tree indexes; nil keeps SHA-256(user_id) indexes
+	vrfKey *VRFPrivateKey
+
+	// Derives committed epoch salts; nil uses random, unrevealed salts (see salt.go)
+	saltSecret      []byte
+	saltRevealDelay time.Duration
+
+	// Pushes key-change events to connected clients (see monitor.go)
+	keyChangeNotifier KeyChangeNotifier
+
+	// Node retention, and pruned epochs rebuilt in memory, most recently used
+	// first (see retention.go)
+	retention      RetentionPolicy
+	rebuildMu      sync.Mutex
+	prunedMu       sync.Mutex
+	prunedTrees    []*epochTree
+	compactionMu   sync.Mutex
+	lastCompaction *CompactionResult
+
+	// How long a rotated signing key overlaps its successor
+	keyOverlap time.Duration
 
-	// Batch processing
-	batchMu        sync.Mutex
-	pendingUpdates []KeyUpdate
-	batchTicker    *time.Ticker
-	stopBatch      chan struct{}
+	// Tokens that may read the audit log's tree indexes (X-Auditor-Token)
+	auditorTokens [][]byte
+
+	// Batch processing (updates are queued in transparency_pending_updates).
+	// Only the elected leader builds epochs (see leader.go).
+	stopBatch chan struct{}
 
 	// Current state cache
 	currentEpoch int64
@@ -35,35 +74,89 @@ type Service struct {
 // NewService creates a new transparency service
 func NewService(db *sql.DB, redisClient *redis.Client) (*Service, error) {
 	s := &Service{
-		db:        db,
-		redis:     redisClient,
-		stopBatch: make(chan struct{}),
+		db:              db,
+		redis:           redisClient,
+		keyOverlap:      DefaultSigningKeyOverlap,
+		saltRevealDelay: DefaultSaltRevealDelay,
+		stopBatch:       make(chan struct{}),
 	}
 
-	// Try to load signer from environment
-	signer, err := NewSignerFromEnv()
+	if overlap := os.Getenv("TRANSPARENCY_KEY_OVERLAP"); overlap != "" {
+		if d, err := time.ParseDuration(overlap); err == nil && d > 0 {
+			s.keyOverlap = d
+		} else {
+			log.Printf("[Transparency] Warning: Invalid TRANSPARENCY_KEY_OVERLAP %q, using %s", overlap, DefaultSigningKeyOverlap)
+		}
+	}
+
+	// Tree indexes link a user's audit log entries across epochs, so only
+	// configured auditors get them
+	for _, token := range strings.Split(os.Getenv("TRANSPARENCY_AUDITOR_TOKENS"), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			s.auditorTokens = append(s.auditorTokens, []byte(token))
+		}
+	}
+
+	if policy, err := RetentionPolicyFromEnv(); err == nil {
+		s.retention = policy
+	} else {
+		log.Printf("[Transparency] Warning: %v; using default node retention", err)
+		s.retention = DefaultRetentionPolicy()
+	}
+
+	// Try to load signer from the configured backend
+	signer, err := NewTreeHeadSignerFromEnv(context.Background())
 	if err != nil {
 		log.Printf("[Transparency] Warning: No signing key configured: %v", err)
 		log.Printf("[Transparency] Key transparency will operate in read-only mode")
 	} else {
-		s.signer = signer
-		log.Printf("[Transparency] Loaded signing key: %s (%s)", signer.Fingerprint(), signer.Algorithm())
+		log.Printf("[Transparency] Loaded %s signing key: %s (%s)", signer.Backend(), signer.Fingerprint(), signer.Algorithm())
+
+		// Register the key, rotating out any previous one. A revoked key must never
+		// sign, and neither may a key auditors can't look up in the key history.
+		if err := s.registerSigningKey(context.Background(), signer); errors.Is(err, ErrSigningKeyRevoked) {
+			log.Printf("[Transparency] Signing key %s is revoked; operating in read-only mode", signer.Fingerprint())
+		} else if err != nil {
+			log.Printf("[Transparency] Failed to register signing key %s: %v; operating in read-only mode", signer.Fingerprint(), err)
+		} else {
+			s.signer = signer
+		}
+	}
+
+	// Signing instances must all derive tree indexes the same way
+	if err := s.loadVRFKey(context.Background(), s.signer != nil); err != nil {
+		if s.signer != nil {
+			log.Printf("[Transparency] VRF key error: %v; operating in read-only mode", err)
+			s.signer = nil
+		} else {
+			log.Printf("[Transparency] Warning: VRF key not used: %v", err)
+		}
+	}
 
-		// Ensure signing key is registered in database
-		if err := s.registerSigningKey(context.Background()); err != nil {
-			log.Printf("[Transparency] Warning: Failed to register signing key: %v", err)
+	// Signing instances must all derive the committed epoch salts
+	if err := s.loadSaltSecret(context.Background(), s.signer != nil); err != nil {
+		if s.signer != nil {
+			log.Printf("[Transparency] Salt secret error: %v; operating in read-only mode", err)
+			s.signer = nil
+		} else {
+			log.Printf("[Transparency] Warning: Salt secret not used: %v", err)
 		}
 	}
 
+	if err := s.loadWitnesses(context.Background()); err != nil {
+		log.Printf("[Transparency] Warning: Failed to load witnesses: %v", err)
+	}
+
 	// Load current state
 	if err := s.loadCurrentState(context.Background()); err != nil {
 		log.Printf("[Transparency] Warning: Failed to load current state: %v", err)
 	}
 
-	// Start batch processor if we have a signer
+	// Instances with a signer compete to build epochs; all follow new heads
 	if s.signer != nil {
-		go s.batchProcessor()
+		go s.leaderElection()
 	}
+	go s.followHeads()
 
 	return s, nil
 }
@@ -73,21 +166,15 @@ func (s *Service) Close() {
 	if s.stopBatch != nil {
 		close(s.stopBatch)
 	}
-	if s.batchTicker != nil {
-		s.batchTicker.Stop()
-	}
 }
 
-// QueueKeyUpdate adds a key change to the pending batch
+// QueueKeyUpdate persists a key change to the update queue. Instances without
+// a signing key still enqueue; whichever instance signs epochs applies it.
 func (s *Service) QueueKeyUpdate(update KeyUpdate) {
-	if s.signer == nil {
-		log.Printf("[Transparency] Ignoring key update (no signing key configured)")
+	if err := s.enqueueUpdate(context.Background(), update); err != nil {
+		log.Printf("[Transparency] Failed to queue %s for user %s: %v", update.UpdateType, update.UserID, err)
 		return
 	}
-
-	s.batchMu.Lock()
-	defer s.batchMu.Unlock()
-	s.pendingUpdates = append(s.pendingUpdates, update)
 	log.Printf("[Transparency] Queued %s for user %s", update.UpdateType, update.UserID)
 }
 
@@ -95,13 +182,13 @@ func (s *Service) QueueKeyUpdate(update KeyUpdate) {
 func (s *Service) GetSignedTreeHead(ctx context.Context) (*SignedTreeHead, error) {
 	sth := &SignedTreeHead{}
 	err := s.db.QueryRowContext(ctx, `
-		SELECT epoch_number, root_hash, tree_size, signature, signing_key_fingerprint, created_at
+		SELECT epoch_number, root_hash, tree_size, signature, signing_key_fingerprint, created_at, salt_commitment, head_version
 		FROM transparency_epochs
 		WHERE epoch_number > 0
 		ORDER BY epoch_number DESC
 		LIMIT 1
 	`).Scan(&sth.EpochNumber, &sth.RootHash, &sth.TreeSize, &sth.Signature,
-		&sth.SigningKeyFingerprint, &sth.Timestamp)
+		&sth.SigningKeyFingerprint, &sth.Timestamp, &sth.SaltCommitment, &sth.Version)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -117,14 +204,14 @@ func (s *Service) GetSignedTreeHead(ctx context.Context) (*SignedTreeHead, error
 func (s *Service) GetSignedTreeHeadAtEpoch(ctx context.Context, epoch int64) (*SignedTreeHead, error) {
 	sth := &SignedTreeHead{}
 	err := s.db.QueryRowContext(ctx, `
-		SELECT epoch_number, root_hash, tree_size, signature, signing_key_fingerprint, created_at
+		SELECT epoch_number, root_hash, tree_size, signature, signing_key_fingerprint, created_at, salt_commitment, head_version
 		FROM transparency_epochs
 		WHERE epoch_number = $1
 	`, epoch).Scan(&sth.EpochNumber, &sth.RootHash, &sth.TreeSize, &sth.Signature,
-		&sth.SigningKeyFingerprint, &sth.Timestamp)
+		&sth.SigningKeyFingerprint, &sth.Timestamp, &sth.SaltCommitment, &sth.Version)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("epoch not found: %d", epoch)
+		return nil, fmt.Errorf("%w: %d", ErrUnknownEpoch, epoch)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get STH: %w", err)
@@ -133,7 +220,8 @@ func (s *Service) GetSignedTreeHeadAtEpoch(ctx context.Context, epoch int64) (*S
 	return sth, nil
 }
 
-// GetInclusionProof generates an inclusion proof for a user's key at a specific epoch
+// GetInclusionProof generates an inclusion proof for the user's key version
+// in effect at a specific epoch (the current epoch if 0)
 func (s *Service) GetInclusionProof(ctx context.Context, userID uuid.UUID, epochNum int64) (*InclusionProof, error) {
 	// If epoch is 0 or not specified, use current epoch
 	if epochNum == 0 {
@@ -142,89 +230,15 @@ func (s *Service) GetInclusionProof(ctx context.Context, userID uuid.UUID, epoch
 		s.stateMu.RUnlock()
 	}
 
-	// Get the leaf data for this user
-	var entry KeyDirectoryEntry
-	var leafDataJSON []byte
-
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, user_id, user_id_hash, identity_key_fingerprint,
-		       signed_prekey_fingerprint, key_version, last_epoch, leaf_hash
-		FROM key_directory_entries
-		WHERE user_id = $1 AND last_epoch <= $2
-	`, userID, epochNum).Scan(
-		&entry.ID, &entry.UserID, &entry.UserIDHash,
-		&entry.IdentityKeyFingerprint, &entry.SignedPreKeyFingerprint,
-		&entry.KeyVersion, &entry.LastEpoch, &entry.LeafHash,
-	)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found in transparency log")
-	}
+	version, err := s.GetKeyVersionAt(ctx, userID, epochNum)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get directory entry: %w", err)
+		return nil, err
 	}
-
-	// Build the leaf data
-	leafData := &LeafData{
-		UserID:                  entry.UserID,
-		IdentityKeyFingerprint:  entry.IdentityKeyFingerprint,
-		SignedPreKeyFingerprint: entry.SignedPreKeyFingerprint,
-		KeyVersion:              entry.KeyVersion,
-		Timestamp:               entry.UpdatedAt.Unix(),
-	}
-
-	// Get the timestamp from a separate query if needed
-	var updatedAt time.Time
-	s.db.QueryRowContext(ctx, `
-		SELECT updated_at FROM key_directory_entries WHERE id = $1
-	`, entry.ID).Scan(&updatedAt)
-	leafData.Timestamp = updatedAt.Unix()
-
-	// Build sibling path by traversing tree from leaf to root
-	siblingPath := make([][]byte, TreeDepth)
-	pathBits := entry.UserIDHash
-
-	for depth := TreeDepth - 1; depth >= 0; depth-- {
-		// Get the sibling at this depth
-		siblingPrefix := GetSiblingPrefix(pathBits, depth+1)
-
-		var siblingHash []byte
-		err := s.db.QueryRowContext(ctx, `
-			SELECT node_hash FROM merkle_nodes
-			WHERE epoch = $1 AND depth = $2 AND path_prefix = $3
-		`, epochNum, depth+1, siblingPrefix).Scan(&siblingHash)
-
-		if err == sql.ErrNoRows {
-			// Use default hash for empty sibling
-			siblingHash = GetDefaultHash(depth + 1)
-		} else if err != nil {
-			return nil, fmt.Errorf("failed to get sibling at depth %d: %w", depth, err)
-		}
-
-		siblingPath[depth] = siblingHash
-	}
-
-	// Get root hash for this epoch
-	var rootHash []byte
-	err = s.db.QueryRowContext(ctx, `
-		SELECT root_hash FROM transparency_epochs WHERE epoch_number = $1
-	`, epochNum).Scan(&rootHash)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get root hash: %w", err)
+	if version == nil {
+		return nil, fmt.Errorf("user not found in transparency log")
 	}
 
-	// Marshal leaf data for JSON transport
-	leafDataJSON, _ = json.Marshal(leafData)
-	_ = leafDataJSON // Used for verification
-
-	return &InclusionProof{
-		EpochNumber: epochNum,
-		LeafHash:    entry.LeafHash,
-		LeafData:    leafData,
-		SiblingPath: siblingPath,
-		PathBits:    pathBits,
-		RootHash:    rootHash,
-	}, nil
+	return s.keyVersionProof(ctx, version, epochNum, nil)
 }
 
 // GetConsistencyProof proves tree consistency between two epochs
@@ -281,19 +295,36 @@ func (s *Service) GetConsistencyProof(ctx context.Context, fromEpoch, toEpoch in
 	}, nil
 }
 
-// GetAuditLog returns audit log entries starting from a given epoch
-func (s *Service) GetAuditLog(ctx context.Context, fromEpoch int64, limit int) ([]AuditLogEntry, error) {
+// IsAuditor reports whether token is one of TRANSPARENCY_AUDITOR_TOKENS
+func (s *Service) IsAuditor(token string) bool {
+	authorized := false
+	for _, t := range s.auditorTokens {
+		if subtle.ConstantTimeCompare(t, []byte(token)) == 1 {
+			authorized = true
+		}
+	}
+	return authorized
+}
+
+// GetAuditLog returns audit log entries from fromEpoch on, in the order they
+// were applied. afterSeq skips entries of fromEpoch up to that sequence
+// number, so an epoch with more than limit entries can be paged through.
+// Tree indexes are left out unless withIndexes is set: a user's slot stays
+// the same from epoch to epoch, so it would link entries the per-epoch
+// commitments keep apart.
+func (s *Service) GetAuditLog(ctx context.Context, fromEpoch, afterSeq int64, limit int, withIndexes bool) ([]AuditLogEntry, error) {
 	if limit <= 0 || limit > 1000 {
 		limit = 100
 	}
 
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, epoch_number, change_type, user_id_commitment, old_leaf_hash, new_leaf_hash, created_at
+		SELECT id, epoch_number, change_type, user_id_commitment, old_leaf_hash, new_leaf_hash,
+		       leaf_index, old_leaf_index, seq, created_at
 		FROM transparency_audit_log
-		WHERE epoch_number >= $1
-		ORDER BY epoch_number ASC, created_at ASC
-		LIMIT $2
-	`, fromEpoch, limit)
+		WHERE epoch_number > $1 OR (epoch_number = $1 AND seq > $2)
+		ORDER BY epoch_number ASC, seq ASC
+		LIMIT $3
+	`, fromEpoch, afterSeq, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get audit log: %w", err)
 	}
@@ -302,33 +333,55 @@ func (s *Service) GetAuditLog(ctx context.Context, fromEpoch int64, limit int) (
 	var entries []AuditLogEntry
 	for rows.Next() {
 		var entry AuditLogEntry
-		var oldLeafHash, newLeafHash sql.NullString
-
 		err := rows.Scan(&entry.ID, &entry.EpochNumber, &entry.ChangeType,
-			&entry.UserIDCommitment, &oldLeafHash, &newLeafHash, &entry.CreatedAt)
+			&entry.UserIDCommitment, &entry.OldLeafHash, &entry.NewLeafHash,
+			&entry.LeafIndex, &entry.OldLeafIndex, &entry.Seq, &entry.CreatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan audit log entry: %w", err)
 		}
-
-		if oldLeafHash.Valid {
-			entry.OldLeafHash, _ = hex.DecodeString(oldLeafHash.String)
+		if !withIndexes {
+			entry.LeafIndex, entry.OldLeafIndex = nil, nil
 		}
-		if newLeafHash.Valid {
-			entry.NewLeafHash, _ = hex.DecodeString(newLeafHash.String)
-		}
-
 		entries = append(entries, entry)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
 
 	return entries, nil
 }
 
-// GetSigningKeys returns all active signing keys
+// signingKeyColumns is the column list scanned by scanSigningKey
+const signingKeyColumns = `id, key_fingerprint, public_key, algorithm, backend, status,
+	valid_from, valid_until, rotated_at, created_at`
+
+// scanSigningKey scans a transparency_signing_keys row
+func scanSigningKey(row interface{ Scan(...interface{}) error }) (*SigningKey, error) {
+	var key SigningKey
+	var validUntil, rotatedAt sql.NullTime
+
+	err := row.Scan(&key.ID, &key.Fingerprint, &key.PublicKey, &key.Algorithm, &key.Backend,
+		&key.Status, &key.ValidFrom, &validUntil, &rotatedAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if validUntil.Valid {
+		key.ValidUntil = &validUntil.Time
+	}
+	if rotatedAt.Valid {
+		key.RotatedAt = &rotatedAt.Time
+	}
+	return &key, nil
+}
+
+// GetSigningKeys returns the active key and rotated keys that still verify
+// older tree heads. Revoked keys are withheld.
 func (s *Service) GetSigningKeys(ctx context.Context) ([]SigningKey, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, key_fingerprint, public_key, algorithm, status, valid_from, valid_until, created_at
+		SELECT `+signingKeyColumns+`
 		FROM transparency_signing_keys
-		WHERE status = 'active'
+		WHERE status IN ('active', 'rotated')
 		ORDER BY valid_from DESC
 	`)
 	if err != nil {
@@ -338,23 +391,61 @@ func (s *Service) GetSigningKeys(ctx context.Context) ([]SigningKey, error) {
 
 	var keys []SigningKey
 	for rows.Next() {
-		var key SigningKey
-		var validUntil sql.NullTime
-
-		err := rows.Scan(&key.ID, &key.Fingerprint, &key.PublicKey, &key.Algorithm,
-			&key.Status, &key.ValidFrom, &validUntil, &key.CreatedAt)
+		key, err := scanSigningKey(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan signing key: %w", err)
 		}
+		keys = append(keys, *key)
+	}
 
-		if validUntil.Valid {
-			key.ValidUntil = &validUntil.Time
-		}
+	return keys, nil
+}
+
+// GetSigningKey returns a signing key by fingerprint, including revoked keys
+func (s *Service) GetSigningKey(ctx context.Context, fingerprint string) (*SigningKey, error) {
+	key, err := scanSigningKey(s.db.QueryRowContext(ctx, `
+		SELECT `+signingKeyColumns+`
+		FROM transparency_signing_keys
+		WHERE key_fingerprint = $1
+	`, fingerprint))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key: %w", err)
+	}
+	return key, nil
+}
 
-		keys = append(keys, key)
+// VerifySignedTreeHead checks a tree head against the key that signed it.
+// The STH timestamp must fall inside that key's validity window, so tree heads
+// signed before a rotation keep verifying while the retired key can't be
+// used to forge new ones.
+func (s *Service) VerifySignedTreeHead(ctx context.Context, sth *SignedTreeHead) error {
+	key, err := s.GetSigningKey(ctx, sth.SigningKeyFingerprint)
+	if err != nil {
+		return err
 	}
+	if key == nil {
+		return ErrUnknownSigningKey
+	}
+	return VerifyTreeHeadWithKey(key, sth)
+}
 
-	return keys, nil
+// VerifyTreeHeadWithKey checks a tree head's signature and the key's validity window
+func VerifyTreeHeadWithKey(key *SigningKey, sth *SignedTreeHead) error {
+	if key.Status == "revoked" {
+		return ErrSigningKeyRevoked
+	}
+	// valid_from is stored at sub-second precision; STH timestamps are whole seconds
+	if sth.Timestamp.Before(key.ValidFrom.Truncate(time.Second)) ||
+		(key.ValidUntil != nil && sth.Timestamp.After(*key.ValidUntil)) {
+		return ErrOutsideKeyValidity
+	}
+	if !VerifyWithPublicKey(key.PublicKey, key.Algorithm, sth) {
+		return ErrInvalidTreeHeadSignature
+	}
+	return nil
 }
 
 // UpdateClientState updates a client's verified epoch state
@@ -395,111 +486,225 @@ func (s *Service) GetClientState(ctx context.Context, userID uuid.UUID, deviceID
 	return state, nil
 }
 
-// batchProcessor runs in the background and processes pending updates periodically
-func (s *Service) batchProcessor() {
-	s.batchTicker = time.NewTicker(DefaultBatchInterval)
-	defer s.batchTicker.Stop()
+// batchProcessor processes pending updates periodically until ctx is done
+func (s *Service) batchProcessor(ctx context.Context) {
+	// Replay whatever was queued before a restart, crash or change of leader
+	s.drainQueue(ctx)
+
+	ticker := time.NewTicker(DefaultBatchInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-s.batchTicker.C:
-			s.processBatch()
-		case <-s.stopBatch:
+		case <-ticker.C:
+			s.drainQueue(ctx)
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-// processBatch processes all pending updates and creates a new epoch
-func (s *Service) processBatch() {
-	s.batchMu.Lock()
-	updates := s.pendingUpdates
-	s.pendingUpdates = nil
-	s.batchMu.Unlock()
-
-	if len(updates) == 0 {
-		return
+// drainQueue processes batches until the due part of the queue is empty
+func (s *Service) drainQueue(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if claimed := s.processBatch(ctx); claimed < MaxBatchSize {
+			return
+		}
 	}
+}
 
-	ctx := context.Background()
-
+// processBatch claims due updates from the queue, applies them and creates a
+// new epoch. Claimed rows stay locked until the transaction ends, so a crash
+// simply releases them for the next run. It returns the number of updates claimed.
+func (s *Service) processBatch(ctx context.Context) int {
 	// Start transaction
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("[Transparency] Failed to start batch transaction: %v", err)
-		return
+		return 0
 	}
 	defer tx.Rollback()
 
+	// Serialize epoch creation even if two instances believe they lead
+	if err := lockEpochs(ctx, tx); err != nil {
+		log.Printf("[Transparency] Failed to lock epochs: %v", err)
+		return 0
+	}
+
+	updates, err := claimUpdates(ctx, tx, MaxBatchSize)
+	if err != nil {
+		log.Printf("[Transparency] Failed to claim queued updates: %v", err)
+		return 0
+	}
+	if len(updates) == 0 {
+		return 0
+	}
+
 	// Get current epoch
 	var currentEpoch int64
 	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(epoch_number), 0) FROM transparency_epochs`).Scan(&currentEpoch)
 	if err != nil {
 		log.Printf("[Transparency] Failed to get current epoch: %v", err)
-		return
+		return 0
 	}
 
 	newEpoch := currentEpoch + 1
 	now := time.Now()
 	treeSize := int64(0)
 
-	// Generate epoch salt for pseudonymous commitments
-	epochSalt := make([]byte, 32)
-	copy(epochSalt, fmt.Sprintf("epoch-%d-%d", newEpoch, now.Unix()))
+	// The first cumulative epoch starts from a full rebuild of the directory,
+	// and every SnapshotInterval epochs the tree is copied into a new base
+	tree, prev, err := nextEpochTree(ctx, tx, newEpoch, s.retention.SnapshotInterval)
+	if err != nil {
+		log.Printf("[Transparency] Failed to locate epoch tree: %v", err)
+		return 0
+	}
+	switch {
+	case !tree.rebuilds():
+	case prev == nil || !prev.cumulative:
+		rebuilt, err := tree.rebuild(ctx, tx)
+		if err != nil {
+			log.Printf("[Transparency] Failed to rebuild tree for epoch %d: %v", newEpoch, err)
+			return 0
+		}
+		log.Printf("[Transparency] Rebuilt full tree of %d entries as base epoch %d", rebuilt, newEpoch)
+	default:
+		leaves, snapshotRoot, err := tree.snapshot(ctx, tx, prev)
+		if err != nil {
+			log.Printf("[Transparency] Failed to snapshot tree for epoch %d: %v", newEpoch, err)
+			return 0
+		}
+		if tree, err = checkSnapshot(ctx, tx, tree, prev, snapshotRoot); err != nil {
+			log.Printf("[Transparency] Failed to check snapshot for epoch %d: %v", newEpoch, err)
+			return 0
+		}
+		if tree.rebuilds() {
+			log.Printf("[Transparency] Took snapshot of %d leaves as base epoch %d", leaves, newEpoch)
+		}
+	}
 
-	// Apply all updates
+	// Salt for pseudonymous commitments, committed in the tree head if derived
+	epochSalt, saltCommitment, err := s.epochSalt(newEpoch)
+	if err != nil {
+		log.Printf("[Transparency] Failed to derive salt for epoch %d: %v", newEpoch, err)
+		return 0
+	}
+
+	// Apply each update inside a savepoint so one bad update doesn't abort the batch
+	var applied, changedUsers []uuid.UUID
+	var clearedSlots [][]byte
 	for _, update := range updates {
-		if err := s.applyUpdate(ctx, tx, update, newEpoch, now, epochSalt); err != nil {
-			log.Printf("[Transparency] Failed to apply update for user %s: %v", update.UserID, err)
+		clearedSlot, err := s.applyQueuedUpdate(ctx, tx, update, tree, now, epochSalt)
+		if err != nil {
+			log.Printf("[Transparency] Failed to apply update %s for user %s (attempt %d): %v",
+				update.ID, update.UserID, update.Attempts+1, err)
+			if err := recordUpdateFailure(ctx, tx, update, err); err != nil {
+				log.Printf("[Transparency] Failed to record update failure: %v", err)
+				return 0
+			}
 			continue
 		}
-		treeSize++
+		applied = append(applied, update.ID)
+		changedUsers = append(changedUsers, update.UserID)
+		if clearedSlot != nil {
+			clearedSlots = append(clearedSlots, clearedSlot)
+		}
+	}
+
+	if err := recordClearedSlots(ctx, tx, newEpoch, clearedSlots); err != nil {
+		log.Printf("[Transparency] Failed to record cleared slots: %v", err)
+		return 0
+	}
+
+	if len(applied) == 0 {
+		// Nothing to sign; keep the retry bookkeeping but not an unsigned rebuild
+		if _, err := tx.ExecContext(ctx, `DELETE FROM merkle_nodes WHERE epoch = $1`, newEpoch); err != nil {
+			log.Printf("[Transparency] Failed to discard unsigned nodes: %v", err)
+			return 0
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("[Transparency] Failed to commit update failures: %v", err)
+		}
+		return len(updates)
 	}
 
 	// Compute new root hash
-	rootHash, err := s.computeRoot(ctx, tx, newEpoch)
+	rootHash, err := tree.root(ctx, tx)
 	if err != nil {
 		log.Printf("[Transparency] Failed to compute root: %v", err)
-		return
+		return 0
 	}
 
 	// Count total entries
 	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM key_directory_entries`).Scan(&treeSize)
 	if err != nil {
 		log.Printf("[Transparency] Failed to count entries: %v", err)
-		return
+		return 0
 	}
 
 	// Sign the new tree head
-	sth, err := s.signer.CreateSignedTreeHead(newEpoch, rootHash, treeSize)
+	sth, err := SignTreeHead(ctx, s.signer, newEpoch, rootHash, treeSize, saltCommitment)
 	if err != nil {
 		log.Printf("[Transparency] Failed to sign tree head: %v", err)
-		return
+		return 0
 	}
 
-	// Store the signed epoch
+	// Store the signed epoch; created_at is the signed timestamp so the STH verifies when read back
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO transparency_epochs (id, epoch_number, root_hash, tree_size, signature, signing_key_fingerprint)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, uuid.New(), sth.EpochNumber, sth.RootHash, sth.TreeSize, sth.Signature, sth.SigningKeyFingerprint)
+		INSERT INTO transparency_epochs (id, epoch_number, root_hash, tree_size, signature, signing_key_fingerprint, created_at, tree_base_epoch, salt_commitment, head_version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, uuid.New(), sth.EpochNumber, sth.RootHash, sth.TreeSize, sth.Signature, sth.SigningKeyFingerprint, sth.Timestamp, tree.base, sth.SaltCommitment, sth.Version)
+	if isUniqueViolation(err) {
+		log.Printf("[Transparency] Epoch %d was already created by another instance; discarding this batch", newEpoch)
+		return 0
+	}
 	if err != nil {
 		log.Printf("[Transparency] Failed to store epoch: %v", err)
-		return
+		return 0
 	}
 
-	// Mark pending updates as processed
-	for _, update := range updates {
-		tx.ExecContext(ctx, `
-			UPDATE transparency_pending_updates
-			SET processed = true, processed_at = NOW(), processed_epoch = $1
-			WHERE user_id = $2 AND processed = false
-		`, newEpoch, update.UserID)
+	// Non-existence proofs use the VRF index from the first epoch built with it
+	if s.vrfKey != nil {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE transparency_vrf_keys SET first_epoch = $1
+			WHERE fingerprint = $2 AND first_epoch IS NULL
+		`, newEpoch, s.vrfKey.Fingerprint())
+		if err != nil {
+			log.Printf("[Transparency] Failed to record VRF key first epoch: %v", err)
+			return 0
+		}
+	}
+
+	// Commit the new epoch's tree head to the append-only log
+	if _, err := s.appendEpochsToLog(ctx, tx); err != nil {
+		log.Printf("[Transparency] Failed to append epoch %d to log: %v", newEpoch, err)
+		return 0
+	}
+
+	// Mark applied updates as processed
+	_, err = tx.ExecContext(ctx, `
+		UPDATE transparency_pending_updates
+		SET status = 'processed', processed = true, processed_at = NOW(), processed_epoch = $1, last_error = NULL
+		WHERE id = ANY($2)
+	`, newEpoch, pq.Array(applied))
+	if err != nil {
+		log.Printf("[Transparency] Failed to mark updates processed: %v", err)
+		return 0
+	}
+
+	// Followers pick the new head up when the transaction commits
+	if err := notifyHead(ctx, tx, newEpoch); err != nil {
+		log.Printf("[Transparency] Failed to notify new head: %v", err)
+		return 0
 	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		log.Printf("[Transparency] Failed to commit batch: %v", err)
-		return
+		return 0
 	}
 
 	// Update local state
@@ -508,13 +713,40 @@ func (s *Service) processBatch() {
 	s.currentRoot = rootHash
 	s.stateMu.Unlock()
 
-	log.Printf("[Transparency] Created epoch %d with %d updates, root: %s",
-		newEpoch, len(updates), hex.EncodeToString(rootHash[:8]))
+	log.Printf("[Transparency] Created epoch %d with %d updates (%d failed), root: %s",
+		newEpoch, len(applied), len(updates)-len(applied), hex.EncodeToString(rootHash[:8]))
+
+	go s.publishKeyChanges(newEpoch, changedUsers)
+	return len(updates)
 }
 
-// applyUpdate applies a single key update to the tree
-func (s *Service) applyUpdate(ctx context.Context, tx *sql.Tx, update KeyUpdate, epoch int64, timestamp time.Time, epochSalt []byte) error {
-	userIDHash := ComputeUserPath(update.UserID)
+// applyQueuedUpdate applies one update inside a savepoint, rolling back only
+// that update's writes if it fails. Returns the slot the entry moved out of, if any.
+func (s *Service) applyQueuedUpdate(ctx context.Context, tx *sql.Tx, update queuedUpdate, tree *epochTree, timestamp time.Time, epochSalt []byte) ([]byte, error) {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT apply_update`); err != nil {
+		return nil, fmt.Errorf("failed to create savepoint: %w", err)
+	}
+
+	clearedSlot, err := s.applyUpdate(ctx, tx, update.KeyUpdate, tree, timestamp, epochSalt)
+	if err != nil {
+		if _, rbErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT apply_update`); rbErr != nil {
+			return nil, fmt.Errorf("%v (and failed to roll back savepoint: %w)", err, rbErr)
+		}
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT apply_update`); err != nil {
+		return nil, fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return clearedSlot, nil
+}
+
+// applyUpdate applies a single key update to the tree. When the entry moves
+// to a new index (SHA-256 to VRF), the old slot is cleared and returned for
+// recordClearedSlots rather than logged with the new slot.
+func (s *Service) applyUpdate(ctx context.Context, tx *sql.Tx, update KeyUpdate, tree *epochTree, timestamp time.Time, epochSalt []byte) ([]byte, error) {
+	epoch := tree.epoch
+	userIDHash, vrfProof, indexVersion := s.userIndex(update.UserID)
 
 	leafData := &LeafData{
 		UserID:                  update.UserID,
@@ -523,133 +755,141 @@ func (s *Service) applyUpdate(ctx context.Context, tx *sql.Tx, update KeyUpdate,
 		KeyVersion:              update.KeyVersion,
 		Timestamp:               timestamp.Unix(),
 	}
+	// Typed updates get the unambiguous leaf format; untyped ones keep the legacy hash
+	if update.IdentityKeyType != "" {
+		leafData.LeafVersion = LeafVersionTyped
+		leafData.IdentityKeyType = update.IdentityKeyType
+		leafData.SignedPreKeyType = update.SignedPreKeyType
+		leafData.CompanionKeys = update.CompanionKeys
+	}
+
+	// VRF-indexed leaves are private: they commit to the user ID with a fresh
+	// nonce, and the stored leaf data drops the user ID
+	storedLeafData := leafData
+	var commitmentNonce []byte
+	if indexVersion == IndexVersionVRF {
+		commitmentNonce = make([]byte, HashSize)
+		if _, err := rand.Read(commitmentNonce); err != nil {
+			return nil, fmt.Errorf("failed to generate commitment nonce: %w", err)
+		}
+		leafData.LeafVersion = LeafVersionPrivate
+		leafData.IdentityKeyType = update.IdentityKeyType
+		leafData.SignedPreKeyType = update.SignedPreKeyType
+		leafData.CompanionKeys = update.CompanionKeys
+		leafData.UserIDCommitment = ComputeUserIDCommitment(update.UserID, commitmentNonce)
+
+		anonymous := *leafData
+		anonymous.UserID = uuid.Nil
+		storedLeafData = &anonymous
+	}
 
 	leafHash := HashLeaf(leafData)
-	leafDataJSON, _ := json.Marshal(leafData)
+	leafDataJSON, _ := json.Marshal(storedLeafData)
 
-	// Get old leaf hash for audit log
-	var oldLeafHash []byte
+	var companionKeysJSON []byte
+	if len(leafData.CompanionKeys) > 0 {
+		companionKeysJSON, _ = json.Marshal(leafData.CompanionKeys)
+	}
+
+	// Get old leaf hash for audit log, and the old slot in case the index moved
+	var oldLeafHash, oldUserIDHash []byte
 	tx.QueryRowContext(ctx, `
-		SELECT leaf_hash FROM key_directory_entries WHERE user_id = $1
-	`, update.UserID).Scan(&oldLeafHash)
+		SELECT leaf_hash, user_id_hash FROM key_directory_entries WHERE user_id = $1
+	`, update.UserID).Scan(&oldLeafHash, &oldUserIDHash)
 
 	// Upsert key directory entry
 	_, err := tx.ExecContext(ctx, `
 		INSERT INTO key_directory_entries (
 			id, user_id, user_id_hash, identity_key_fingerprint,
-			signed_prekey_fingerprint, key_version, last_epoch, leaf_hash
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			signed_prekey_fingerprint, key_version, last_epoch, leaf_hash,
+			identity_key_type, signed_prekey_type, companion_keys, leaf_version,
+			index_version, vrf_proof, commitment_nonce
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 		ON CONFLICT (user_id) DO UPDATE SET
+			user_id_hash = EXCLUDED.user_id_hash,
 			identity_key_fingerprint = EXCLUDED.identity_key_fingerprint,
 			signed_prekey_fingerprint = EXCLUDED.signed_prekey_fingerprint,
 			key_version = EXCLUDED.key_version,
 			last_epoch = EXCLUDED.last_epoch,
 			leaf_hash = EXCLUDED.leaf_hash,
+			identity_key_type = EXCLUDED.identity_key_type,
+			signed_prekey_type = EXCLUDED.signed_prekey_type,
+			companion_keys = EXCLUDED.companion_keys,
+			leaf_version = EXCLUDED.leaf_version,
+			index_version = EXCLUDED.index_version,
+			vrf_proof = EXCLUDED.vrf_proof,
+			commitment_nonce = EXCLUDED.commitment_nonce,
 			updated_at = NOW()
 	`, uuid.New(), update.UserID, userIDHash, update.IdentityKeyFingerprint,
-		update.SignedPreKeyFingerprint, update.KeyVersion, epoch, leafHash)
+		update.SignedPreKeyFingerprint, update.KeyVersion, epoch, leafHash,
+		sql.NullString{String: leafData.IdentityKeyType, Valid: leafData.IdentityKeyType != ""},
+		sql.NullString{String: leafData.SignedPreKeyType, Valid: leafData.SignedPreKeyType != ""},
+		companionKeysJSON, leafData.LeafVersion, indexVersion, vrfProof, commitmentNonce)
 	if err != nil {
-		return fmt.Errorf("failed to update directory entry: %w", err)
+		return nil, fmt.Errorf("failed to update directory entry: %w", err)
 	}
 
-	// Store leaf node
-	pathPrefix := hex.EncodeToString(userIDHash)
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO merkle_nodes (id, epoch, depth, path_prefix, node_hash, leaf_data, is_leaf)
-		VALUES ($1, $2, $3, $4, $5, $6, true)
-		ON CONFLICT (epoch, depth, path_prefix) DO UPDATE SET
-			node_hash = EXCLUDED.node_hash,
-			leaf_data = EXCLUDED.leaf_data
-	`, uuid.New(), epoch, TreeDepth, pathPrefix, leafHash, leafDataJSON)
-	if err != nil {
-		return fmt.Errorf("failed to store leaf node: %w", err)
-	}
-
-	// Update intermediate nodes up to root
-	currentHash := leafHash
-	for depth := TreeDepth - 1; depth >= 0; depth-- {
-		_ = PathPrefixAtDepth(userIDHash, depth+1) // Current node prefix (used for debugging)
-		siblingPrefix := GetSiblingPrefix(userIDHash, depth+1)
-
-		// Get sibling hash (or default if not exists)
-		var siblingHash []byte
-		err := tx.QueryRowContext(ctx, `
-			SELECT node_hash FROM merkle_nodes
-			WHERE epoch = $1 AND depth = $2 AND path_prefix = $3
-		`, epoch, depth+1, siblingPrefix).Scan(&siblingHash)
-		if err == sql.ErrNoRows {
-			siblingHash = GetDefaultHash(depth + 1)
-		} else if err != nil {
-			return fmt.Errorf("failed to get sibling: %w", err)
-		}
-
-		// Compute parent hash
-		bit := GetBit(userIDHash, depth)
-		var parentHash []byte
-		if bit == 0 {
-			parentHash = HashInternal(currentHash, siblingHash)
-		} else {
-			parentHash = HashInternal(siblingHash, currentHash)
-		}
-
-		// Store parent node
-		parentPrefix := PathPrefixAtDepth(userIDHash, depth)
-		_, err = tx.ExecContext(ctx, `
-			INSERT INTO merkle_nodes (id, epoch, depth, path_prefix, node_hash, is_leaf)
-			VALUES ($1, $2, $3, $4, $5, false)
-			ON CONFLICT (epoch, depth, path_prefix) DO UPDATE SET
-				node_hash = EXCLUDED.node_hash
-		`, uuid.New(), epoch, depth, parentPrefix, parentHash)
-		if err != nil {
-			return fmt.Errorf("failed to store internal node: %w", err)
+	// A re-indexed entry leaves its old slot empty. The old slot is
+	// SHA-256(user_id), which anyone can compute, so neither it nor the leaf
+	// hash stored there may appear next to the new (private) slot.
+	var clearedSlot []byte
+	if oldUserIDHash != nil && !bytesEqual(oldUserIDHash, userIDHash) {
+		clearedSlot = oldUserIDHash
+		oldLeafHash = nil
+		if err := tree.clearLeafPath(ctx, tx, clearedSlot); err != nil {
+			return nil, err
 		}
+	}
 
-		currentHash = parentHash
+	if err := tree.writeLeafPath(ctx, tx, userIDHash, leafHash, leafDataJSON); err != nil {
+		return nil, err
 	}
 
-	// Add audit log entry
-	commitment := ComputeUserIDCommitment(update.UserID, epochSalt)
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO transparency_audit_log (id, epoch_number, change_type, user_id_commitment, old_leaf_hash, new_leaf_hash)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, uuid.New(), epoch, update.UpdateType, commitment, oldLeafHash, leafHash)
+	err = recordKeyVersion(ctx, tx, &KeyVersion{
+		UserID:          update.UserID,
+		Epoch:           epoch,
+		UpdateType:      update.UpdateType,
+		LeafData:        leafData,
+		LeafHash:        leafHash,
+		UserIDHash:      userIDHash,
+		IndexVersion:    indexVersion,
+		VRFProof:        vrfProof,
+		CommitmentNonce: commitmentNonce,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to add audit log entry: %w", err)
+		return nil, err
 	}
 
-	// Queue pending update record
+	// Add audit log entry, with the slot it wrote so auditors can rebuild the tree
+	commitment := ComputeUserIDCommitment(update.UserID, epochSalt)
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO transparency_pending_updates (
-			id, user_id, update_type, identity_key_fingerprint,
-			signed_prekey_fingerprint, key_version
-		) VALUES ($1, $2, $3, $4, $5, $6)
-	`, uuid.New(), update.UserID, update.UpdateType, update.IdentityKeyFingerprint,
-		update.SignedPreKeyFingerprint, update.KeyVersion)
+		INSERT INTO transparency_audit_log (id, epoch_number, change_type, user_id_commitment, old_leaf_hash, new_leaf_hash, leaf_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, uuid.New(), epoch, update.UpdateType, commitment, oldLeafHash, leafHash, userIDHash)
 	if err != nil {
-		// Non-fatal, just log
-		log.Printf("[Transparency] Warning: Failed to queue pending update: %v", err)
+		return nil, fmt.Errorf("failed to add audit log entry: %w", err)
 	}
 
-	return nil
+	return clearedSlot, nil
 }
 
-// computeRoot computes the root hash for an epoch
-func (s *Service) computeRoot(ctx context.Context, tx *sql.Tx, epoch int64) ([]byte, error) {
-	var rootHash []byte
-	err := tx.QueryRowContext(ctx, `
-		SELECT node_hash FROM merkle_nodes
-		WHERE epoch = $1 AND depth = 0 AND path_prefix = ''
-	`, epoch).Scan(&rootHash)
-
-	if err == sql.ErrNoRows {
-		// Empty tree
-		return GetDefaultHash(0), nil
-	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to get root hash: %w", err)
+// recordClearedSlots logs the slots emptied by re-indexed entries of an
+// epoch as separate index_cleared entries with no user commitment. They are
+// written after all of the epoch's updates and in slot order, so neither
+// their position nor their sequence numbers pair a cleared SHA-256 slot with
+// the VRF slot its entry moved to.
+func recordClearedSlots(ctx context.Context, tx *sql.Tx, epoch int64, slots [][]byte) error {
+	sort.Slice(slots, func(i, j int) bool { return bytes.Compare(slots[i], slots[j]) < 0 })
+	for _, slot := range slots {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO transparency_audit_log (id, epoch_number, change_type, leaf_index)
+			VALUES ($1, $2, $3, $4)
+		`, uuid.New(), epoch, ChangeTypeIndexCleared, slot)
+		if err != nil {
+			return fmt.Errorf("failed to add audit log entry: %w", err)
+		}
 	}
-
-	return rootHash, nil
+	return nil
 }
 
 // loadCurrentState loads the current epoch and root from the database
@@ -681,27 +921,74 @@ func (s *Service) loadCurrentState(ctx context.Context) error {
 	return nil
 }
 
-// registerSigningKey ensures the current signing key is in the database
-func (s *Service) registerSigningKey(ctx context.Context) error {
-	if s.signer == nil {
-		return nil
+// registerSigningKey records the signer's key and rotates out any other active
+// key. A re-registered key keeps its original valid_from so tree heads it
+// signed before a restart still fall inside its window. Rotated keys stay
+// valid for keyOverlap past the rotation.
+func (s *Service) registerSigningKey(ctx context.Context, signer TreeHeadSigner) error {
+	signingKey, err := NewSigningKey(signer)
+	if err != nil {
+		return err
 	}
 
-	signingKey, err := s.signer.ToSigningKey()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize concurrent registrations from several instances
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE transparency_signing_keys IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock signing keys: %w", err)
+	}
+
+	var status string
+	err = tx.QueryRowContext(ctx, `
+		SELECT status FROM transparency_signing_keys WHERE key_fingerprint = $1
+	`, signingKey.Fingerprint).Scan(&status)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO transparency_signing_keys (id, key_fingerprint, public_key, algorithm, backend, status, valid_from)
+			VALUES ($1, $2, $3, $4, $5, 'active', $6)
+		`, uuid.New(), signingKey.Fingerprint, signingKey.PublicKey, signingKey.Algorithm,
+			signingKey.Backend, signingKey.ValidFrom)
+		if err != nil {
+			return fmt.Errorf("failed to insert signing key: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to look up signing key: %w", err)
+	case status == "revoked":
+		return ErrSigningKeyRevoked
+	default:
+		// Reactivating a rotated key (e.g. a rollback) reopens its window
+		_, err = tx.ExecContext(ctx, `
+			UPDATE transparency_signing_keys
+			SET status = 'active', backend = $2, valid_until = NULL, rotated_at = NULL
+			WHERE key_fingerprint = $1
+		`, signingKey.Fingerprint, signingKey.Backend)
+		if err != nil {
+			return fmt.Errorf("failed to reactivate signing key: %w", err)
+		}
 	}
 
-	_, err = s.db.ExecContext(ctx, `
-		INSERT INTO transparency_signing_keys (id, key_fingerprint, public_key, algorithm, status, valid_from)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (key_fingerprint) DO UPDATE SET
-			status = 'active',
-			valid_from = EXCLUDED.valid_from
-	`, uuid.New(), signingKey.Fingerprint, signingKey.PublicKey, signingKey.Algorithm,
-		signingKey.Status, signingKey.ValidFrom)
+	result, err := tx.ExecContext(ctx, `
+		UPDATE transparency_signing_keys
+		SET status = 'rotated', rotated_at = NOW(), valid_until = NOW() + $2 * INTERVAL '1 second'
+		WHERE status = 'active' AND key_fingerprint <> $1
+	`, signingKey.Fingerprint, int64(s.keyOverlap/time.Second))
+	if err != nil {
+		return fmt.Errorf("failed to rotate previous signing keys: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit signing key registration: %w", err)
+	}
 
-	return err
+	if rotated, _ := result.RowsAffected(); rotated > 0 {
+		log.Printf("[Transparency] Rotated %d previous signing key(s); valid for another %s", rotated, s.keyOverlap)
+	}
+	return nil
 }
 
 // CurrentEpoch returns the current epoch number
@@ -732,6 +1019,9 @@ type CryptoKeyUpdate struct {
 	SignedPreKeyFingerprint string
 	KeyVersion              int
 	UpdateType              string
+	IdentityKeyType         string
+	SignedPreKeyType        string
+	CompanionKeys           []LeafKey
 }
 
 // QueueCryptoKeyUpdate accepts updates from the crypto package
@@ -742,6 +1032,9 @@ func (s *Service) QueueCryptoKeyUpdate(update CryptoKeyUpdate) {
 		SignedPreKeyFingerprint: update.SignedPreKeyFingerprint,
 		KeyVersion:              update.KeyVersion,
 		UpdateType:              update.UpdateType,
+		IdentityKeyType:         update.IdentityKeyType,
+		SignedPreKeyType:        update.SignedPreKeyType,
+		CompanionKeys:           update.CompanionKeys,
 	})
 }
 
@@ -763,6 +1056,9 @@ type CryptoTransparencyKeyUpdate struct {
 	SignedPreKeyFingerprint string
 	KeyVersion              int
 	UpdateType              string
+	IdentityKeyType         string
+	SignedPreKeyType        string
+	CompanionKeys           []LeafKey
 }
 
 // QueueKeyUpdate implements the crypto.TransparencyQueuer interface
@@ -776,6 +1072,9 @@ func (a *TransparencyAdapter) QueueKeyUpdate(update interface{}) {
 			SignedPreKeyFingerprint: u.SignedPreKeyFingerprint,
 			KeyVersion:              u.KeyVersion,
 			UpdateType:              u.UpdateType,
+			IdentityKeyType:         u.IdentityKeyType,
+			SignedPreKeyType:        u.SignedPreKeyType,
+			CompanionKeys:           u.CompanionKeys,
 		})
 	default:
 		// Try reflection-based approach for any struct with matching fields
@@ -1,6 +1,7 @@
 package transparency
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
@@ -16,12 +17,43 @@ import (
 	"time"
 )
 
-// Signer handles signing and verification of tree heads
+/*
+TREE HEAD SIGNING:
+The log's signing key no longer has to live in process memory. Anything that
+can produce an Ed25519 or P-256 signature over the tree head bytes implements
+TreeHeadSigner:
+
+  file    - PEM key loaded from disk or the environment (Signer)
+  pkcs11  - key held in an HSM or SoftHSM token (PKCS11Signer)
+  kms     - key held in a Vault/OpenBao transit engine (KMSSigner)
+
+All backends emit identical signature encodings (raw 64 bytes for Ed25519,
+ASN.1 DER over SHA-256 for P-256), so clients verify with VerifyWithPublicKey
+regardless of where the key lives. Any crypto.Signer (crypto11, cloud KMS SDKs,
+a test key) can be wrapped with NewSignerFromCryptoSigner.
+*/
+
+// TreeHeadSigner signs serialized tree heads
+type TreeHeadSigner interface {
+	// Algorithm returns "ed25519" or "p256"
+	Algorithm() string
+	// Fingerprint returns the public key fingerprint recorded on each STH
+	Fingerprint() string
+	// PublicKeyBytes returns the raw public key published to clients
+	PublicKeyBytes() ([]byte, error)
+	// Backend names where the private key lives: "file", "pkcs11" or "kms"
+	Backend() string
+	// SignMessage signs the output of TreeHeadData
+	SignMessage(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// Signer handles signing and verification of tree heads with an in-process key
 type Signer struct {
-	privateKey  crypto.PrivateKey
+	signer      crypto.Signer
 	publicKey   crypto.PublicKey
 	algorithm   string
 	fingerprint string
+	backend     string
 }
 
 // NewSigner creates a new Signer from a PEM-encoded private key
@@ -92,13 +124,51 @@ func NewSigner(privateKeyPEM []byte) (*Signer, error) {
 	}
 
 	return &Signer{
-		privateKey:  privateKey,
+		signer:      privateKey.(crypto.Signer),
 		publicKey:   publicKey,
 		algorithm:   algorithm,
 		fingerprint: fingerprint,
+		backend:     "file",
 	}, nil
 }
 
+// NewSignerFromCryptoSigner wraps any Ed25519 or P-256 crypto.Signer, such as
+// a crypto11 HSM key, a cloud KMS client or an in-memory test key
+func NewSignerFromCryptoSigner(cs crypto.Signer, backend string) (*Signer, error) {
+	algorithm, err := publicKeyAlgorithm(cs.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := computePublicKeyFingerprint(cs.Public(), algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute fingerprint: %w", err)
+	}
+
+	return &Signer{
+		signer:      cs,
+		publicKey:   cs.Public(),
+		algorithm:   algorithm,
+		fingerprint: fingerprint,
+		backend:     backend,
+	}, nil
+}
+
+// publicKeyAlgorithm maps a parsed public key to its algorithm name
+func publicKeyAlgorithm(publicKey crypto.PublicKey) (string, error) {
+	switch pk := publicKey.(type) {
+	case ed25519.PublicKey:
+		return "ed25519", nil
+	case *ecdsa.PublicKey:
+		if pk.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported ECDSA curve: only P-256 is supported")
+		}
+		return "p256", nil
+	default:
+		return "", fmt.Errorf("unsupported key type: %T", publicKey)
+	}
+}
+
 // NewSignerFromFile loads a signer from a PEM file
 func NewSignerFromFile(path string) (*Signer, error) {
 	data, err := os.ReadFile(path)
@@ -125,6 +195,22 @@ func NewSignerFromEnv() (*Signer, error) {
 	return NewSigner([]byte(keyData))
 }
 
+// NewTreeHeadSignerFromEnv selects a signing backend with TRANSPARENCY_SIGNER
+// ("file" by default, "pkcs11" or "kms") and loads it from that backend's
+// environment variables
+func NewTreeHeadSignerFromEnv(ctx context.Context) (TreeHeadSigner, error) {
+	switch backend := os.Getenv("TRANSPARENCY_SIGNER"); backend {
+	case "", "file":
+		return NewSignerFromEnv()
+	case "pkcs11":
+		return NewPKCS11SignerFromEnv(ctx)
+	case "kms":
+		return NewKMSSignerFromEnv(ctx)
+	default:
+		return nil, fmt.Errorf("unknown TRANSPARENCY_SIGNER backend: %s", backend)
+	}
+}
+
 // GenerateEd25519Key generates a new Ed25519 key pair and returns PEM-encoded private key
 func GenerateEd25519Key() ([]byte, error) {
 	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
@@ -145,26 +231,46 @@ func GenerateEd25519Key() ([]byte, error) {
 	return pem.EncodeToMemory(block), nil
 }
 
-// Sign signs a tree head and returns the signature
-func (s *Signer) Sign(sth *SignedTreeHead) ([]byte, error) {
-	// Construct the data to sign:
-	// epoch_number (8 bytes) || root_hash (32 bytes) || tree_size (8 bytes) || timestamp (8 bytes)
-	data := make([]byte, 8+32+8+8)
+// TreeHeadData returns the message a tree head's signature covers. Canonical
+// heads (TreeHeadVersionCanonical) sign their domain-separated canonical
+// encoding (see wire.go). Raw heads sign the fixed layout
+// epoch_number (8 bytes) || root_hash (32 bytes) || tree_size (8 bytes) || timestamp (8 bytes)
+// followed, for heads that commit to a derived epoch salt, by salt_commitment (32 bytes)
+func TreeHeadData(sth *SignedTreeHead) []byte {
+	if treeHeadVersion(sth) == TreeHeadVersionCanonical {
+		return treeHeadSigningInput(sth)
+	}
 
+	size := 8 + 32 + 8 + 8
+	if len(sth.SaltCommitment) > 0 {
+		size += 32
+	}
+	data := make([]byte, size)
 	binary.BigEndian.PutUint64(data[0:8], uint64(sth.EpochNumber))
 	copy(data[8:40], sth.RootHash)
 	binary.BigEndian.PutUint64(data[40:48], uint64(sth.TreeSize))
 	binary.BigEndian.PutUint64(data[48:56], uint64(sth.Timestamp.Unix()))
+	if len(sth.SaltCommitment) > 0 {
+		copy(data[56:88], sth.SaltCommitment)
+	}
+	return data
+}
+
+// Sign signs a tree head and returns the signature
+func (s *Signer) Sign(sth *SignedTreeHead) ([]byte, error) {
+	return s.SignMessage(context.Background(), TreeHeadData(sth))
+}
 
+// SignMessage implements TreeHeadSigner
+func (s *Signer) SignMessage(ctx context.Context, message []byte) ([]byte, error) {
 	switch s.algorithm {
 	case "ed25519":
-		privateKey := s.privateKey.(ed25519.PrivateKey)
-		return ed25519.Sign(privateKey, data), nil
+		return s.signer.Sign(rand.Reader, message, crypto.Hash(0))
 
 	case "p256":
-		privateKey := s.privateKey.(*ecdsa.PrivateKey)
-		hash := sha256.Sum256(data)
-		return ecdsa.SignASN1(rand.Reader, privateKey, hash[:])
+		// ecdsa.PrivateKey and HSM/KMS signers return ASN.1 DER for ECDSA
+		hash := sha256.Sum256(message)
+		return s.signer.Sign(rand.Reader, hash[:], crypto.SHA256)
 
 	default:
 		return nil, fmt.Errorf("unsupported algorithm: %s", s.algorithm)
@@ -173,12 +279,7 @@ func (s *Signer) Sign(sth *SignedTreeHead) ([]byte, error) {
 
 // Verify verifies a tree head signature
 func (s *Signer) Verify(sth *SignedTreeHead) bool {
-	// Reconstruct the signed data
-	data := make([]byte, 8+32+8+8)
-	binary.BigEndian.PutUint64(data[0:8], uint64(sth.EpochNumber))
-	copy(data[8:40], sth.RootHash)
-	binary.BigEndian.PutUint64(data[40:48], uint64(sth.TreeSize))
-	binary.BigEndian.PutUint64(data[48:56], uint64(sth.Timestamp.Unix()))
+	data := TreeHeadData(sth)
 
 	switch s.algorithm {
 	case "ed25519":
@@ -197,19 +298,17 @@ func (s *Signer) Verify(sth *SignedTreeHead) bool {
 
 // VerifyWithPublicKey verifies a signature using a raw public key
 func VerifyWithPublicKey(publicKey []byte, algorithm string, sth *SignedTreeHead) bool {
-	// Reconstruct the signed data
-	data := make([]byte, 8+32+8+8)
-	binary.BigEndian.PutUint64(data[0:8], uint64(sth.EpochNumber))
-	copy(data[8:40], sth.RootHash)
-	binary.BigEndian.PutUint64(data[40:48], uint64(sth.TreeSize))
-	binary.BigEndian.PutUint64(data[48:56], uint64(sth.Timestamp.Unix()))
+	return verifyMessage(publicKey, algorithm, TreeHeadData(sth), sth.Signature)
+}
 
+// verifyMessage verifies a signature over data using a raw public key
+func verifyMessage(publicKey []byte, algorithm string, data, signature []byte) bool {
 	switch algorithm {
 	case "ed25519":
 		if len(publicKey) != ed25519.PublicKeySize {
 			return false
 		}
-		return ed25519.Verify(ed25519.PublicKey(publicKey), data, sth.Signature)
+		return ed25519.Verify(ed25519.PublicKey(publicKey), data, signature)
 
 	case "p256":
 		pk, err := x509.ParsePKIXPublicKey(publicKey)
@@ -226,7 +325,7 @@ func VerifyWithPublicKey(publicKey []byte, algorithm string, sth *SignedTreeHead
 			return false
 		}
 		hash := sha256.Sum256(data)
-		return ecdsa.VerifyASN1(ecdsaPK, hash[:], sth.Signature)
+		return ecdsa.VerifyASN1(ecdsaPK, hash[:], signature)
 
 	default:
 		return false
@@ -243,33 +342,49 @@ func (s *Signer) Fingerprint() string {
 	return s.fingerprint
 }
 
+// Backend returns where the private key lives
+func (s *Signer) Backend() string {
+	return s.backend
+}
+
 // PublicKeyBytes returns the public key as bytes
 func (s *Signer) PublicKeyBytes() ([]byte, error) {
-	switch s.algorithm {
+	return marshalPublicKey(s.publicKey, s.algorithm)
+}
+
+// marshalPublicKey encodes a public key the way it is published to clients
+func marshalPublicKey(publicKey crypto.PublicKey, algorithm string) ([]byte, error) {
+	switch algorithm {
 	case "ed25519":
-		pk := s.publicKey.(ed25519.PublicKey)
+		pk := publicKey.(ed25519.PublicKey)
 		return []byte(pk), nil
 
 	case "p256":
-		pk := s.publicKey.(*ecdsa.PublicKey)
+		pk := publicKey.(*ecdsa.PublicKey)
 		return elliptic.Marshal(pk.Curve, pk.X, pk.Y), nil
 
 	default:
-		return nil, fmt.Errorf("unsupported algorithm: %s", s.algorithm)
+		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
 	}
 }
 
 // ToSigningKey creates a SigningKey model from this Signer
 func (s *Signer) ToSigningKey() (*SigningKey, error) {
-	publicKeyBytes, err := s.PublicKeyBytes()
+	return NewSigningKey(s)
+}
+
+// NewSigningKey creates a SigningKey model for any tree head signer
+func NewSigningKey(signer TreeHeadSigner) (*SigningKey, error) {
+	publicKeyBytes, err := signer.PublicKeyBytes()
 	if err != nil {
 		return nil, err
 	}
 
 	return &SigningKey{
-		Fingerprint: s.fingerprint,
+		Fingerprint: signer.Fingerprint(),
 		PublicKey:   publicKeyBytes,
-		Algorithm:   s.algorithm,
+		Algorithm:   signer.Algorithm(),
+		Backend:     signer.Backend(),
 		Status:      "active",
 		ValidFrom:   time.Now(),
 	}, nil
@@ -277,19 +392,9 @@ func (s *Signer) ToSigningKey() (*SigningKey, error) {
 
 // computePublicKeyFingerprint computes the fingerprint of a public key
 func computePublicKeyFingerprint(publicKey crypto.PublicKey, algorithm string) (string, error) {
-	var keyBytes []byte
-
-	switch algorithm {
-	case "ed25519":
-		pk := publicKey.(ed25519.PublicKey)
-		keyBytes = []byte(pk)
-
-	case "p256":
-		pk := publicKey.(*ecdsa.PublicKey)
-		keyBytes = elliptic.Marshal(pk.Curve, pk.X, pk.Y)
-
-	default:
-		return "", fmt.Errorf("unsupported algorithm: %s", algorithm)
+	keyBytes, err := marshalPublicKey(publicKey, algorithm)
+	if err != nil {
+		return "", err
 	}
 
 	hash := sha256.Sum256(keyBytes)
@@ -298,15 +403,24 @@ func computePublicKeyFingerprint(publicKey crypto.PublicKey, algorithm string) (
 
 // CreateSignedTreeHead creates and signs a new tree head
 func (s *Signer) CreateSignedTreeHead(epochNumber int64, rootHash []byte, treeSize int64) (*SignedTreeHead, error) {
+	return SignTreeHead(context.Background(), s, epochNumber, rootHash, treeSize, nil)
+}
+
+// SignTreeHead creates a new tree head and signs it with any backend.
+// saltCommitment is nil for an epoch without a derived salt.
+func SignTreeHead(ctx context.Context, signer TreeHeadSigner, epochNumber int64, rootHash []byte, treeSize int64, saltCommitment []byte) (*SignedTreeHead, error) {
 	sth := &SignedTreeHead{
 		EpochNumber:           epochNumber,
 		RootHash:              rootHash,
 		TreeSize:              treeSize,
-		SigningKeyFingerprint: s.fingerprint,
-		Timestamp:             time.Now(),
+		SaltCommitment:        saltCommitment,
+		SigningKeyFingerprint: signer.Fingerprint(),
+		Version:               TreeHeadVersionCanonical,
+		// Truncated to the signed precision so stored timestamps round-trip
+		Timestamp: time.Unix(time.Now().Unix(), 0),
 	}
 
-	signature, err := s.Sign(sth)
+	signature, err := signer.SignMessage(ctx, TreeHeadData(sth))
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign tree head: %w", err)
 	}
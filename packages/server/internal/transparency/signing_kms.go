@@ -0,0 +1,225 @@
+package transparency
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+REMOTE KMS SIGNING:
+Speaks the transit secrets engine API shared by HashiCorp Vault and OpenBao,
+which supports exactly the two STH algorithms (ed25519 and ecdsa-p256) and
+returns ASN.1 signatures for ECDSA. A `vault server -dev` instance is enough
+for local testing:
+
+  vault secrets enable transit
+  vault write -f transit/keys/sth-signing type=ed25519
+
+The signer pins the key version that was latest at startup, so the published
+fingerprint stays stable; rotating the transit key takes effect (and is
+recorded as a signing key rotation) on the next restart.
+*/
+
+// KMSConfig locates a transit signing key
+type KMSConfig struct {
+	Address string // e.g. https://vault.internal:8200
+	Token   string
+	Mount   string // Transit mount path; defaults to "transit"
+	KeyName string
+}
+
+// KMSSigner signs tree heads with a key held in a remote transit engine
+type KMSSigner struct {
+	config      KMSConfig
+	httpClient  *http.Client
+	keyVersion  int
+	publicKey   crypto.PublicKey
+	algorithm   string
+	fingerprint string
+}
+
+// NewKMSSigner fetches the key's latest public version and returns a signer for it
+func NewKMSSigner(ctx context.Context, config KMSConfig) (*KMSSigner, error) {
+	if config.Address == "" || config.KeyName == "" {
+		return nil, fmt.Errorf("KMS address and key name are required")
+	}
+	if config.Mount == "" {
+		config.Mount = "transit"
+	}
+	config.Address = strings.TrimSuffix(config.Address, "/")
+
+	s := &KMSSigner{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := s.loadPublicKey(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewKMSSignerFromEnv loads a KMS signer from TRANSPARENCY_KMS_* variables
+func NewKMSSignerFromEnv(ctx context.Context) (*KMSSigner, error) {
+	return NewKMSSigner(ctx, KMSConfig{
+		Address: os.Getenv("TRANSPARENCY_KMS_ADDR"),
+		Token:   os.Getenv("TRANSPARENCY_KMS_TOKEN"),
+		Mount:   os.Getenv("TRANSPARENCY_KMS_MOUNT"),
+		KeyName: os.Getenv("TRANSPARENCY_KMS_KEY"),
+	})
+}
+
+// transitKeyResponse is the body of GET /v1/{mount}/keys/{name}
+type transitKeyResponse struct {
+	Data struct {
+		Type          string `json:"type"`
+		LatestVersion int    `json:"latest_version"`
+		Keys          map[string]struct {
+			PublicKey string `json:"public_key"`
+		} `json:"keys"`
+	} `json:"data"`
+}
+
+// loadPublicKey reads the latest key version's public key
+func (s *KMSSigner) loadPublicKey(ctx context.Context) error {
+	var resp transitKeyResponse
+	if err := s.do(ctx, http.MethodGet, "keys/"+s.config.KeyName, nil, &resp); err != nil {
+		return fmt.Errorf("failed to read KMS key: %w", err)
+	}
+
+	version, ok := resp.Data.Keys[strconv.Itoa(resp.Data.LatestVersion)]
+	if !ok {
+		return fmt.Errorf("KMS key %s has no public key for version %d", s.config.KeyName, resp.Data.LatestVersion)
+	}
+
+	switch resp.Data.Type {
+	case "ed25519":
+		raw, err := base64.StdEncoding.DecodeString(version.PublicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 public key from KMS")
+		}
+		s.publicKey = ed25519.PublicKey(raw)
+
+	case "ecdsa-p256":
+		block, _ := pem.Decode([]byte(version.PublicKey))
+		if block == nil {
+			return fmt.Errorf("invalid P-256 public key PEM from KMS")
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse KMS public key: %w", err)
+		}
+		s.publicKey = publicKey
+
+	default:
+		return fmt.Errorf("unsupported KMS key type: %s", resp.Data.Type)
+	}
+
+	var err error
+	if s.algorithm, err = publicKeyAlgorithm(s.publicKey); err != nil {
+		return err
+	}
+	if s.fingerprint, err = computePublicKeyFingerprint(s.publicKey, s.algorithm); err != nil {
+		return fmt.Errorf("failed to compute fingerprint: %w", err)
+	}
+	s.keyVersion = resp.Data.LatestVersion
+	return nil
+}
+
+// SignMessage implements TreeHeadSigner
+func (s *KMSSigner) SignMessage(ctx context.Context, message []byte) ([]byte, error) {
+	req := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(message),
+		"key_version": s.keyVersion,
+	}
+	if s.algorithm == "p256" {
+		// The engine hashes the input itself, matching SHA-256 then ECDSA
+		req["hash_algorithm"] = "sha2-256"
+		req["marshaling_algorithm"] = "asn1"
+	}
+
+	var resp struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	if err := s.do(ctx, http.MethodPost, "sign/"+s.config.KeyName, req, &resp); err != nil {
+		return nil, fmt.Errorf("KMS signing failed: %w", err)
+	}
+
+	// Signatures are "vault:v<version>:<base64>"
+	parts := strings.SplitN(resp.Data.Signature, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed KMS signature")
+	}
+	signature, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed KMS signature: %w", err)
+	}
+	return signature, nil
+}
+
+// do sends a transit API request and decodes the JSON response
+func (s *KMSSigner) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/v1/%s/%s", s.config.Address, s.config.Mount, path), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", s.config.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Algorithm returns the signing algorithm
+func (s *KMSSigner) Algorithm() string {
+	return s.algorithm
+}
+
+// Fingerprint returns the key fingerprint
+func (s *KMSSigner) Fingerprint() string {
+	return s.fingerprint
+}
+
+// Backend returns where the private key lives
+func (s *KMSSigner) Backend() string {
+	return "kms"
+}
+
+// PublicKeyBytes returns the public key as bytes
+func (s *KMSSigner) PublicKeyBytes() ([]byte, error) {
+	return marshalPublicKey(s.publicKey, s.algorithm)
+}
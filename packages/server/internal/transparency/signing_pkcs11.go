@@ -0,0 +1,169 @@
+package transparency
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/*
+PKCS#11 SIGNING:
+The private key never leaves the token. Signing shells out to OpenSC's
+pkcs11-tool, which loads the vendor module (an HSM driver, or libsofthsm2.so
+for local testing), so the server binary needs no cgo PKCS#11 bindings.
+
+The PIN is handed to pkcs11-tool through its environment ("--pin env:NAME")
+rather than argv, so it never appears in the process list.
+
+Local setup with SoftHSM:
+  softhsm2-util --init-token --free --label nochat-kt --pin 1234 --so-pin 5678
+  pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --token-label nochat-kt \
+    --login --pin 1234 --keypairgen --key-type EC:prime256v1 --label sth-signing
+*/
+
+// pkcs11PinEnv is the variable pkcs11-tool reads the PIN from
+const pkcs11PinEnv = "NOCHAT_PKCS11_PIN"
+
+// PKCS11Config locates a signing key on a PKCS#11 token
+type PKCS11Config struct {
+	ModulePath string // Path to the PKCS#11 module (.so)
+	TokenLabel string
+	KeyLabel   string // CKA_LABEL of the private and public key objects
+	PIN        string
+	ToolPath   string // pkcs11-tool binary; defaults to "pkcs11-tool" on PATH
+}
+
+// PKCS11Signer signs tree heads with a key held in a PKCS#11 token
+type PKCS11Signer struct {
+	config      PKCS11Config
+	publicKey   crypto.PublicKey
+	algorithm   string
+	fingerprint string
+}
+
+// NewPKCS11Signer reads the key's public half from the token and returns a signer for it
+func NewPKCS11Signer(ctx context.Context, config PKCS11Config) (*PKCS11Signer, error) {
+	if config.ModulePath == "" || config.KeyLabel == "" {
+		return nil, fmt.Errorf("PKCS#11 module path and key label are required")
+	}
+	if config.ToolPath == "" {
+		config.ToolPath = "pkcs11-tool"
+	}
+
+	s := &PKCS11Signer{config: config}
+
+	der, err := s.run(ctx, nil, false, "--read-object", "--type", "pubkey", "--label", config.KeyLabel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key from token: %w", err)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token public key: %w", err)
+	}
+
+	if s.algorithm, err = publicKeyAlgorithm(publicKey); err != nil {
+		return nil, err
+	}
+	if s.fingerprint, err = computePublicKeyFingerprint(publicKey, s.algorithm); err != nil {
+		return nil, fmt.Errorf("failed to compute fingerprint: %w", err)
+	}
+	s.publicKey = publicKey
+
+	return s, nil
+}
+
+// NewPKCS11SignerFromEnv loads a PKCS#11 signer from TRANSPARENCY_PKCS11_* variables
+func NewPKCS11SignerFromEnv(ctx context.Context) (*PKCS11Signer, error) {
+	return NewPKCS11Signer(ctx, PKCS11Config{
+		ModulePath: os.Getenv("TRANSPARENCY_PKCS11_MODULE"),
+		TokenLabel: os.Getenv("TRANSPARENCY_PKCS11_TOKEN"),
+		KeyLabel:   os.Getenv("TRANSPARENCY_PKCS11_KEY_LABEL"),
+		PIN:        os.Getenv("TRANSPARENCY_PKCS11_PIN"),
+		ToolPath:   os.Getenv("TRANSPARENCY_PKCS11_TOOL"),
+	})
+}
+
+// SignMessage implements TreeHeadSigner
+func (s *PKCS11Signer) SignMessage(ctx context.Context, message []byte) ([]byte, error) {
+	switch s.algorithm {
+	case "ed25519":
+		// CKM_EDDSA signs the full message
+		return s.run(ctx, message, true, "--sign", "--mechanism", "EDDSA", "--label", s.config.KeyLabel)
+
+	case "p256":
+		// CKM_ECDSA signs a precomputed digest; request DER to match the file signer
+		hash := sha256.Sum256(message)
+		return s.run(ctx, hash[:], true, "--sign", "--mechanism", "ECDSA",
+			"--signature-format", "openssl", "--label", s.config.KeyLabel)
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", s.algorithm)
+	}
+}
+
+// run invokes pkcs11-tool with the given input, returning the contents of its output file
+func (s *PKCS11Signer) run(ctx context.Context, input []byte, login bool, args ...string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "nochat-pkcs11-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "out")
+	argv := []string{"--module", s.config.ModulePath, "--output-file", outputPath}
+	if s.config.TokenLabel != "" {
+		argv = append(argv, "--token-label", s.config.TokenLabel)
+	}
+	if login {
+		argv = append(argv, "--login", "--pin", "env:"+pkcs11PinEnv)
+	}
+	if input != nil {
+		inputPath := filepath.Join(dir, "in")
+		if err := os.WriteFile(inputPath, input, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write signing input: %w", err)
+		}
+		argv = append(argv, "--input-file", inputPath)
+	}
+	argv = append(argv, args...)
+
+	cmd := exec.CommandContext(ctx, s.config.ToolPath, argv...)
+	cmd.Env = append(os.Environ(), pkcs11PinEnv+"="+s.config.PIN)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pkcs11-tool failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	output, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pkcs11-tool output: %w", err)
+	}
+	return output, nil
+}
+
+// Algorithm returns the signing algorithm
+func (s *PKCS11Signer) Algorithm() string {
+	return s.algorithm
+}
+
+// Fingerprint returns the key fingerprint
+func (s *PKCS11Signer) Fingerprint() string {
+	return s.fingerprint
+}
+
+// Backend returns where the private key lives
+func (s *PKCS11Signer) Backend() string {
+	return "pkcs11"
+}
+
+// PublicKeyBytes returns the public key as bytes
+func (s *PKCS11Signer) PublicKeyBytes() ([]byte, error) {
+	return marshalPublicKey(s.publicKey, s.algorithm)
+}
@@ -0,0 +1,182 @@
+package transparency
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testCryptoSigners returns one in-memory key per supported algorithm
+func testCryptoSigners(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"ed25519": edKey, "p256": p256Key}
+}
+
+// signHeadAt signs a canonical tree head with an explicit timestamp
+func signHeadAt(t *testing.T, signer TreeHeadSigner, epoch int64, ts time.Time) *SignedTreeHead {
+	t.Helper()
+	sth := &SignedTreeHead{
+		EpochNumber:           epoch,
+		RootHash:              make([]byte, HashSize),
+		TreeSize:              epoch,
+		SigningKeyFingerprint: signer.Fingerprint(),
+		Version:               TreeHeadVersionCanonical,
+		Timestamp:             time.Unix(ts.Unix(), 0),
+	}
+	sth.RootHash[0] = byte(epoch)
+
+	signature, err := signer.SignMessage(context.Background(), TreeHeadData(sth))
+	if err != nil {
+		t.Fatalf("SignMessage: %v", err)
+	}
+	sth.Signature = signature
+	return sth
+}
+
+func TestNewSignerFromCryptoSigner(t *testing.T) {
+	for algorithm, cs := range testCryptoSigners(t) {
+		t.Run(algorithm, func(t *testing.T) {
+			signer, err := NewSignerFromCryptoSigner(cs, "kms")
+			if err != nil {
+				t.Fatalf("NewSignerFromCryptoSigner: %v", err)
+			}
+			if signer.Algorithm() != algorithm || signer.Backend() != "kms" {
+				t.Fatalf("signer = %s/%s, want %s/kms", signer.Algorithm(), signer.Backend(), algorithm)
+			}
+
+			key, err := NewSigningKey(signer)
+			if err != nil {
+				t.Fatalf("NewSigningKey: %v", err)
+			}
+			if key.Fingerprint != signer.Fingerprint() {
+				t.Fatalf("signing key fingerprint %s, signer %s", key.Fingerprint, signer.Fingerprint())
+			}
+
+			sth, err := SignTreeHead(context.Background(), signer, 7, make([]byte, HashSize), 7, nil)
+			if err != nil {
+				t.Fatalf("SignTreeHead: %v", err)
+			}
+			if !signer.Verify(sth) {
+				t.Fatal("signer rejected its own tree head")
+			}
+			if err := VerifyTreeHeadWithKey(key, sth); err != nil {
+				t.Fatalf("VerifyTreeHeadWithKey: %v", err)
+			}
+
+			sth.TreeSize++
+			if VerifyWithPublicKey(key.PublicKey, key.Algorithm, sth) {
+				t.Fatal("tampered tree head verified")
+			}
+		})
+	}
+}
+
+// TestNewSignerFromCryptoSignerMatchesPEM checks that a wrapped key and the
+// same key loaded from PEM publish the same fingerprint and public key
+func TestNewSignerFromCryptoSignerMatchesPEM(t *testing.T) {
+	pemKey, err := GenerateEd25519Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPEM, err := NewSigner(pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := NewSignerFromCryptoSigner(fromPEM.signer, "pkcs11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.Fingerprint() != fromPEM.Fingerprint() {
+		t.Fatalf("fingerprint %s, want %s", wrapped.Fingerprint(), fromPEM.Fingerprint())
+	}
+
+	sth := signHeadAt(t, wrapped, 1, time.Now())
+	if !fromPEM.Verify(sth) {
+		t.Fatal("PEM signer rejected a head signed through the wrapper")
+	}
+}
+
+func TestNewSignerFromCryptoSignerUnsupported(t *testing.T) {
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSignerFromCryptoSigner(p384Key, "kms"); err == nil {
+		t.Fatal("P-384 key accepted")
+	}
+}
+
+// TestVerifyTreeHeadRotationWindow checks that heads verify only inside their
+// key's validity window, so an old key keeps verifying the heads it signed
+// before rotation but can't sign new ones after its overlap ends
+func TestVerifyTreeHeadRotationWindow(t *testing.T) {
+	signers := testCryptoSigners(t)
+	oldSigner, err := NewSignerFromCryptoSigner(signers["ed25519"], "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSigner, err := NewSignerFromCryptoSigner(signers["p256"], "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-48 * time.Hour)
+	rotatedAt := time.Now().Add(-2 * time.Hour)
+	overlapEnd := rotatedAt.Add(time.Hour)
+
+	oldKey, err := NewSigningKey(oldSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey.ValidFrom = start
+	oldKey.ValidUntil = &overlapEnd
+	oldKey.Status = "rotated"
+
+	newKey, err := NewSigningKey(newSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey.ValidFrom = rotatedAt
+
+	tests := []struct {
+		name string
+		key  *SigningKey
+		sth  *SignedTreeHead
+		want error
+	}{
+		{"old key before rotation", oldKey, signHeadAt(t, oldSigner, 1, start.Add(time.Hour)), nil},
+		{"old key during overlap", oldKey, signHeadAt(t, oldSigner, 2, rotatedAt.Add(30*time.Minute)), nil},
+		{"old key after overlap", oldKey, signHeadAt(t, oldSigner, 3, overlapEnd.Add(time.Minute)), ErrOutsideKeyValidity},
+		{"old key before valid_from", oldKey, signHeadAt(t, oldSigner, 4, start.Add(-time.Minute)), ErrOutsideKeyValidity},
+		{"new key after rotation", newKey, signHeadAt(t, newSigner, 5, rotatedAt.Add(time.Minute)), nil},
+		{"new key now", newKey, signHeadAt(t, newSigner, 6, time.Now()), nil},
+		{"new key before rotation", newKey, signHeadAt(t, newSigner, 7, rotatedAt.Add(-time.Minute)), ErrOutsideKeyValidity},
+		{"head signed by the other key", newKey, signHeadAt(t, oldSigner, 8, time.Now()), ErrInvalidTreeHeadSignature},
+	}
+
+	for _, tt := range tests {
+		if err := VerifyTreeHeadWithKey(tt.key, tt.sth); !errors.Is(err, tt.want) {
+			t.Errorf("%s: VerifyTreeHeadWithKey = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	revoked := *oldKey
+	revoked.Status = "revoked"
+	if err := VerifyTreeHeadWithKey(&revoked, signHeadAt(t, oldSigner, 9, start.Add(time.Hour))); !errors.Is(err, ErrSigningKeyRevoked) {
+		t.Errorf("revoked key: VerifyTreeHeadWithKey = %v, want ErrSigningKeyRevoked", err)
+	}
+}
@@ -0,0 +1,408 @@
+package transparency
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+/*
+CUMULATIVE EPOCH TREES:
+Each epoch's tree holds every directory entry, not just that epoch's updates.
+merkle_nodes stores a node only in the epoch where it changed; the node at
+(depth, prefix) as of epoch E is the row with the largest epoch in
+[tree_base_epoch, E]. The base epoch holds a full node set (the first one
+rebuilt from key_directory_entries), so nothing before it is ever read.
+
+Epochs signed before trees were cumulative have no tree_base_epoch. Their
+trees contain only that epoch's updates, so their nodes are read at exactly
+that epoch and only the leaves written in it can be proven.
+
+Every SnapshotInterval epochs the tree is copied into a new base (a snapshot),
+so the epochs between snapshots can be pruned (see retention.go). A pruned
+epoch's tree is rebuilt in memory from its base and the audit log.
+*/
+
+// ErrLegacyEpoch is returned for a proof that a pre-cumulative epoch cannot support
+var ErrLegacyEpoch = errors.New("epoch predates cumulative trees; only keys introduced in it can be proven")
+
+// epochTree locates an epoch's nodes in merkle_nodes
+type epochTree struct {
+	epoch int64
+	base  int64 // nodes are read from epochs [base, epoch]
+	// cumulative is false for legacy epochs, whose tree holds only their own updates
+	cumulative bool
+	// pruned epochs have no nodes of their own; they are read from leaves,
+	// rebuilt in memory and sorted by path
+	pruned bool
+	leaves []treeLeaf
+}
+
+// getEpochTree returns where an epoch's nodes live, or nil if the epoch does not exist
+func getEpochTree(ctx context.Context, q rowQueryer, epoch int64) (*epochTree, error) {
+	var base sql.NullInt64
+	var nodeStatus string
+	err := q.QueryRowContext(ctx, `
+		SELECT tree_base_epoch, node_status FROM transparency_epochs WHERE epoch_number = $1
+	`, epoch).Scan(&base, &nodeStatus)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get epoch tree: %w", err)
+	}
+	if !base.Valid {
+		return &epochTree{epoch: epoch, base: epoch}, nil
+	}
+	return &epochTree{epoch: epoch, base: base.Int64, cumulative: true, pruned: nodeStatus != nodeStatusPresent}, nil
+}
+
+// nextEpochTree returns the tree for a new epoch and the previous epoch's
+// tree (nil if there is none). The new tree shares the previous epoch's base,
+// or starts a new base (and needs a rebuild) when the previous epoch is legacy,
+// there is none, or snapshotInterval epochs have passed since the last base.
+func nextEpochTree(ctx context.Context, tx *sql.Tx, newEpoch, snapshotInterval int64) (tree, prev *epochTree, err error) {
+	prev, err = getEpochTree(ctx, tx, newEpoch-1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if prev == nil || !prev.cumulative || (snapshotInterval > 0 && newEpoch-prev.base >= snapshotInterval) {
+		return &epochTree{epoch: newEpoch, base: newEpoch, cumulative: true}, prev, nil
+	}
+	return &epochTree{epoch: newEpoch, base: prev.base, cumulative: true}, prev, nil
+}
+
+// rebuilds reports whether the tree is a new base that needs a full rebuild
+func (t *epochTree) rebuilds() bool {
+	return t.cumulative && t.base == t.epoch
+}
+
+// nodeHash returns the hash of the node at (depth, prefix) in the tree,
+// or the default hash if that subtree is empty
+func (t *epochTree) nodeHash(ctx context.Context, q rowQueryer, depth int, prefix string) ([]byte, error) {
+	if t.pruned {
+		return t.memNodeHash(depth, prefix)
+	}
+
+	var hash []byte
+	err := q.QueryRowContext(ctx, `
+		SELECT node_hash FROM merkle_nodes
+		WHERE depth = $1 AND path_prefix = $2 AND epoch BETWEEN $3 AND $4
+		ORDER BY epoch DESC
+		LIMIT 1
+	`, depth, prefix, t.base, t.epoch).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return GetDefaultHash(depth), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+	return hash, nil
+}
+
+// writeLeafPath stores a leaf in the tree's epoch and recomputes its
+// ancestors. A nil leafDataJSON with the default leaf hash clears the slot.
+func (t *epochTree) writeLeafPath(ctx context.Context, tx *sql.Tx, pathBits, leafHash, leafDataJSON []byte) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO merkle_nodes (id, epoch, depth, path_prefix, node_hash, leaf_data, is_leaf)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (epoch, depth, path_prefix) DO UPDATE SET
+			node_hash = EXCLUDED.node_hash,
+			leaf_data = EXCLUDED.leaf_data,
+			is_leaf = EXCLUDED.is_leaf
+	`, uuid.New(), t.epoch, TreeDepth, hex.EncodeToString(pathBits), leafHash, leafDataJSON, leafDataJSON != nil)
+	if err != nil {
+		return fmt.Errorf("failed to store leaf node: %w", err)
+	}
+
+	// Update intermediate nodes up to root
+	currentHash := leafHash
+	for depth := TreeDepth - 1; depth >= 0; depth-- {
+		siblingHash, err := t.nodeHash(ctx, tx, depth+1, GetSiblingPrefix(pathBits, depth+1))
+		if err != nil {
+			return fmt.Errorf("failed to get sibling: %w", err)
+		}
+
+		var parentHash []byte
+		if GetBit(pathBits, depth) == 0 {
+			parentHash = HashInternal(currentHash, siblingHash)
+		} else {
+			parentHash = HashInternal(siblingHash, currentHash)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO merkle_nodes (id, epoch, depth, path_prefix, node_hash, is_leaf)
+			VALUES ($1, $2, $3, $4, $5, false)
+			ON CONFLICT (epoch, depth, path_prefix) DO UPDATE SET
+				node_hash = EXCLUDED.node_hash
+		`, uuid.New(), t.epoch, depth, PathPrefixAtDepth(pathBits, depth), parentHash)
+		if err != nil {
+			return fmt.Errorf("failed to store internal node: %w", err)
+		}
+
+		currentHash = parentHash
+	}
+	return nil
+}
+
+// clearLeafPath empties a slot, e.g. when an entry moves to a new index
+func (t *epochTree) clearLeafPath(ctx context.Context, tx *sql.Tx, pathBits []byte) error {
+	return t.writeLeafPath(ctx, tx, pathBits, GetDefaultHash(TreeDepth), nil)
+}
+
+// root returns the tree's root hash
+func (t *epochTree) root(ctx context.Context, q rowQueryer) ([]byte, error) {
+	return t.nodeHash(ctx, q, 0, "")
+}
+
+// siblingPath loads all TreeDepth siblings of a path in a single query.
+// siblingPath[i] is the sibling at depth i+1; missing nodes are empty subtrees.
+func (t *epochTree) siblingPath(ctx context.Context, db *sql.DB, pathBits []byte) ([][]byte, error) {
+	if t.pruned {
+		return t.memSiblingPath(pathBits)
+	}
+
+	depths := make([]int64, TreeDepth)
+	prefixes := make([]string, TreeDepth)
+	for i := range depths {
+		depths[i] = int64(i + 1)
+		prefixes[i] = GetSiblingPrefix(pathBits, i+1)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT ON (n.depth) n.depth, n.node_hash
+		FROM merkle_nodes n
+		JOIN unnest($3::int[], $4::text[]) AS p(depth, path_prefix)
+		  ON n.depth = p.depth AND n.path_prefix = p.path_prefix
+		WHERE n.epoch BETWEEN $1 AND $2
+		ORDER BY n.depth, n.epoch DESC
+	`, t.base, t.epoch, pq.Array(depths), pq.Array(prefixes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sibling path: %w", err)
+	}
+	defer rows.Close()
+
+	siblingPath := make([][]byte, TreeDepth)
+	for rows.Next() {
+		var depth int
+		var hash []byte
+		if err := rows.Scan(&depth, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan sibling: %w", err)
+		}
+		if depth < 1 || depth > TreeDepth {
+			continue
+		}
+		siblingPath[depth-1] = hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sibling path: %w", err)
+	}
+
+	for i, hash := range siblingPath {
+		if hash == nil {
+			siblingPath[i] = GetDefaultHash(i + 1)
+		}
+	}
+	return siblingPath, nil
+}
+
+// treeLeaf is a populated slot of the tree
+type treeLeaf struct {
+	path []byte
+	hash []byte
+}
+
+// rebuild writes the full node set for every directory entry into the
+// tree's epoch, making it the base for the epochs that follow
+func (t *epochTree) rebuild(ctx context.Context, tx *sql.Tx) (int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT user_id_hash, leaf_hash FROM key_directory_entries
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory entries: %w", err)
+	}
+	var leaves []treeLeaf
+	for rows.Next() {
+		var leaf treeLeaf
+		if err := rows.Scan(&leaf.path, &leaf.hash); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan directory entry: %w", err)
+		}
+		leaves = append(leaves, leaf)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read directory entries: %w", err)
+	}
+
+	if _, err := t.writeNodes(ctx, tx, leaves); err != nil {
+		return 0, err
+	}
+	return len(leaves), nil
+}
+
+// snapshot writes the full node set of prev's leaves into the tree's epoch,
+// making it the base for the epochs that follow. It returns the number of
+// leaves and the snapshot's root, which must equal prev's root.
+func (t *epochTree) snapshot(ctx context.Context, tx *sql.Tx, prev *epochTree) (int, []byte, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT ON (path_prefix) path_prefix, node_hash
+		FROM merkle_nodes
+		WHERE depth = $1 AND epoch BETWEEN $2 AND $3
+		ORDER BY path_prefix, epoch DESC
+	`, TreeDepth, prev.base, prev.epoch)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read leaves: %w", err)
+	}
+	emptyLeaf := GetDefaultHash(TreeDepth)
+	var leaves []treeLeaf
+	for rows.Next() {
+		var prefix string
+		var leaf treeLeaf
+		if err := rows.Scan(&prefix, &leaf.hash); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan leaf: %w", err)
+		}
+		if leaf.path, err = hex.DecodeString(prefix); err != nil || len(leaf.path) != HashSize {
+			rows.Close()
+			return 0, nil, fmt.Errorf("invalid leaf path %q", prefix)
+		}
+		// Cleared slots hold the empty leaf hash
+		if !bytes.Equal(leaf.hash, emptyLeaf) {
+			leaves = append(leaves, leaf)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("failed to read leaves: %w", err)
+	}
+
+	root, err := t.writeNodes(ctx, tx, leaves)
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(leaves), root, nil
+}
+
+// writeNodes stores every non-empty node of the tree holding the given
+// leaves in the tree's epoch, and returns its root
+func (t *epochTree) writeNodes(ctx context.Context, tx *sql.Tx, leaves []treeLeaf) ([]byte, error) {
+	sort.Slice(leaves, func(i, j int) bool { return bytes.Compare(leaves[i].path, leaves[j].path) < 0 })
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO merkle_nodes (id, epoch, depth, path_prefix, node_hash, is_leaf)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (epoch, depth, path_prefix) DO UPDATE SET
+			node_hash = EXCLUDED.node_hash
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare node insert: %w", err)
+	}
+	defer stmt.Close()
+
+	var writeErr error
+	root := buildSubtree(leaves, 0, func(depth int, path, hash []byte) {
+		if writeErr != nil {
+			return
+		}
+		_, writeErr = stmt.ExecContext(ctx, uuid.New(), t.epoch, depth, PathPrefixAtDepth(path, depth), hash, depth == TreeDepth)
+	})
+	if writeErr != nil {
+		return nil, fmt.Errorf("failed to store rebuilt node: %w", writeErr)
+	}
+	return root, nil
+}
+
+// ComputeTreeRoot returns the root of a sparse tree holding the given leaf
+// hashes, keyed by their 32-byte tree index. Auditors use it to recompute
+// epoch roots from the audit log.
+func ComputeTreeRoot(leaves map[[HashSize]byte][]byte) []byte {
+	return buildSubtree(sortedLeaves(leaves), 0, func(int, []byte, []byte) {})
+}
+
+// sortedLeaves returns leaf hashes keyed by tree index as leaves sorted by path
+func sortedLeaves(leaves map[[HashSize]byte][]byte) []treeLeaf {
+	sorted := make([]treeLeaf, 0, len(leaves))
+	for index, hash := range leaves {
+		path := index
+		sorted = append(sorted, treeLeaf{path: path[:], hash: hash})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].path, sorted[j].path) < 0 })
+	return sorted
+}
+
+// splitLeaves splits sorted leaves that share their first depth bits by the
+// bit at depth
+func splitLeaves(leaves []treeLeaf, depth int) (left, right []treeLeaf) {
+	split := sort.Search(len(leaves), func(i int) bool { return GetBit(leaves[i].path, depth) == 1 })
+	return leaves[:split], leaves[split:]
+}
+
+// memNodeHash is nodeHash for a tree rebuilt in memory
+func (t *epochTree) memNodeHash(depth int, prefix string) ([]byte, error) {
+	if t.leaves == nil {
+		return nil, fmt.Errorf("%w: epoch %d was not rebuilt", ErrEpochPruned, t.epoch)
+	}
+	path, err := hex.DecodeString(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node prefix: %w", err)
+	}
+
+	leaves := t.leaves
+	for d := 0; d < depth; d++ {
+		left, right := splitLeaves(leaves, d)
+		if GetBit(path, d) == 0 {
+			leaves = left
+		} else {
+			leaves = right
+		}
+	}
+	return buildSubtree(leaves, depth, func(int, []byte, []byte) {}), nil
+}
+
+// memSiblingPath is siblingPath for a tree rebuilt in memory
+func (t *epochTree) memSiblingPath(pathBits []byte) ([][]byte, error) {
+	if t.leaves == nil {
+		return nil, fmt.Errorf("%w: epoch %d was not rebuilt", ErrEpochPruned, t.epoch)
+	}
+
+	siblingPath := make([][]byte, TreeDepth)
+	leaves := t.leaves
+	for depth := 0; depth < TreeDepth; depth++ {
+		left, right := splitLeaves(leaves, depth)
+		if GetBit(pathBits, depth) == 0 {
+			siblingPath[depth] = buildSubtree(right, depth+1, func(int, []byte, []byte) {})
+			leaves = left
+		} else {
+			siblingPath[depth] = buildSubtree(left, depth+1, func(int, []byte, []byte) {})
+			leaves = right
+		}
+	}
+	return siblingPath, nil
+}
+
+// buildSubtree computes the root of the subtree at depth holding the sorted
+// leaves, which all share their first depth bits, and emits every non-empty node
+func buildSubtree(leaves []treeLeaf, depth int, emit func(depth int, path, hash []byte)) []byte {
+	if len(leaves) == 0 {
+		return GetDefaultHash(depth)
+	}
+	if depth == TreeDepth {
+		emit(depth, leaves[0].path, leaves[0].hash)
+		return leaves[0].hash
+	}
+
+	leftLeaves, rightLeaves := splitLeaves(leaves, depth)
+	left := buildSubtree(leftLeaves, depth+1, emit)
+	right := buildSubtree(rightLeaves, depth+1, emit)
+	hash := HashInternal(left, right)
+	emit(depth, leaves[0].path, hash)
+	return hash
+}
@@ -0,0 +1,309 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"filippo.io/edwards25519"
+	"github.com/google/uuid"
+)
+
+/*
+VERIFIABLE RANDOM FUNCTION (RFC 9381, ECVRF-EDWARDS25519-SHA512-TAI):
+A user's position in the tree is no longer SHA-256(user_id), which anyone can
+compute for any user ID and then probe the tree with. The server holds a VRF
+key and places each user at
+
+  index = VRF_hash(sk, user_id)[:32]
+
+Only the key holder can compute an index. A client looking up a user it
+already knows receives the VRF proof with the inclusion proof and checks,
+with the VRF public key alone, that the index is the one and only index for
+that user ID.
+
+  Suite:     0x03 (edwards25519, SHA-512, try-and-increment hash to curve)
+  Proof pi:  Gamma (32) || c (16) || s (32) = 80 bytes
+  Output:    beta = SHA-512(0x03 || 0x03 || 8*Gamma || 0x00), 64 bytes
+*/
+
+const (
+	// VRFProofSize is the size of an ECVRF-EDWARDS25519-SHA512-TAI proof
+	VRFProofSize = 80
+
+	// VRFOutputSize is the size of the VRF hash output (beta)
+	VRFOutputSize = 64
+
+	// VRFPublicKeySize is the size of an encoded VRF public key
+	VRFPublicKeySize = 32
+
+	vrfSuite     = 0x03
+	vrfChallenge = 16
+)
+
+// Domain separators from RFC 9381 section 5
+const (
+	vrfEncodeToCurveFront = 0x01
+	vrfChallengeFront     = 0x02
+	vrfProofToHashFront   = 0x03
+	vrfBack               = 0x00
+)
+
+// VRF errors
+var (
+	ErrVRFProofInvalid = errors.New("invalid VRF proof")
+	ErrVRFKeyInvalid   = errors.New("invalid VRF public key")
+)
+
+// VRFPrivateKey computes VRF outputs and proofs for the tree index
+type VRFPrivateKey struct {
+	x         *edwards25519.Scalar
+	nonceSeed []byte // second half of SHA-512(seed), as in Ed25519
+	publicKey []byte
+}
+
+// NewVRFPrivateKey derives a VRF key from a 32-byte seed. Key derivation is
+// the same as Ed25519, so an Ed25519 seed yields the matching public key.
+func NewVRFPrivateKey(seed []byte) (*VRFPrivateKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid VRF seed size: %d", len(seed))
+	}
+	h := sha512.Sum512(seed)
+	x, err := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive VRF scalar: %w", err)
+	}
+	return &VRFPrivateKey{
+		x:         x,
+		nonceSeed: append([]byte(nil), h[32:]...),
+		publicKey: new(edwards25519.Point).ScalarBaseMult(x).Bytes(),
+	}, nil
+}
+
+// NewVRFPrivateKeyFromPEM loads a VRF key from a PKCS#8 Ed25519 private key,
+// the same format GenerateEd25519Key produces
+func NewVRFPrivateKeyFromPEM(privateKeyPEM []byte) (*VRFPrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VRF key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("VRF key must be an Ed25519 key, got %T", key)
+	}
+	return NewVRFPrivateKey(edKey.Seed())
+}
+
+// NewVRFPrivateKeyFromEnv loads the VRF key from TRANSPARENCY_VRF_KEY, which
+// can be either a file path or a PEM-encoded key
+func NewVRFPrivateKeyFromEnv() (*VRFPrivateKey, error) {
+	keyData := os.Getenv("TRANSPARENCY_VRF_KEY")
+	if keyData == "" {
+		return nil, fmt.Errorf("TRANSPARENCY_VRF_KEY environment variable not set")
+	}
+
+	if _, err := os.Stat(keyData); err == nil {
+		data, err := os.ReadFile(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read VRF key file: %w", err)
+		}
+		return NewVRFPrivateKeyFromPEM(data)
+	}
+	return NewVRFPrivateKeyFromPEM([]byte(keyData))
+}
+
+// PublicKey returns the encoded VRF public key
+func (k *VRFPrivateKey) PublicKey() []byte {
+	return append([]byte(nil), k.publicKey...)
+}
+
+// Fingerprint returns the fingerprint of the VRF public key
+func (k *VRFPrivateKey) Fingerprint() string {
+	return ComputeKeyFingerprint(k.publicKey)
+}
+
+// Prove computes the VRF proof for alpha (RFC 9381 section 5.1)
+func (k *VRFPrivateKey) Prove(alpha []byte) []byte {
+	h, err := vrfEncodeToCurve(k.publicKey, alpha)
+	if err != nil {
+		// Only reachable if all 256 candidates fail to decode, probability 2^-256
+		panic(err)
+	}
+	hString := h.Bytes()
+	gamma := new(edwards25519.Point).ScalarMult(k.x, h)
+
+	// Deterministic nonce (RFC 9381 section 5.4.2.2)
+	nonceHash := sha512.New()
+	nonceHash.Write(k.nonceSeed)
+	nonceHash.Write(hString)
+	nonce, _ := edwards25519.NewScalar().SetUniformBytes(nonceHash.Sum(nil))
+
+	u := new(edwards25519.Point).ScalarBaseMult(nonce)
+	v := new(edwards25519.Point).ScalarMult(nonce, h)
+	cBytes := vrfChallengeBytes(k.publicKey, hString, gamma.Bytes(), u.Bytes(), v.Bytes())
+	c := vrfChallengeScalar(cBytes)
+
+	s := edwards25519.NewScalar().MultiplyAdd(c, k.x, nonce)
+
+	pi := make([]byte, 0, VRFProofSize)
+	pi = append(pi, gamma.Bytes()...)
+	pi = append(pi, cBytes...)
+	pi = append(pi, s.Bytes()...)
+	return pi
+}
+
+// Evaluate returns the VRF output and its proof for alpha
+func (k *VRFPrivateKey) Evaluate(alpha []byte) (beta, pi []byte) {
+	pi = k.Prove(alpha)
+	beta, _ = VRFProofToHash(pi)
+	return beta, pi
+}
+
+// VRFProofToHash derives beta from a proof without verifying it (RFC 9381 section 5.2)
+func VRFProofToHash(pi []byte) ([]byte, error) {
+	gamma, _, _, err := vrfDecodeProof(pi)
+	if err != nil {
+		return nil, err
+	}
+	return vrfGammaToHash(gamma), nil
+}
+
+// VRFVerify checks pi against the public key and alpha and returns beta
+// (RFC 9381 section 5.3, with full key validation)
+func VRFVerify(publicKey, alpha, pi []byte) ([]byte, error) {
+	y, err := vrfDecodePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	gamma, cBytes, s, err := vrfDecodeProof(pi)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := vrfEncodeToCurve(publicKey, alpha)
+	if err != nil {
+		return nil, err
+	}
+	c := vrfChallengeScalar(cBytes)
+	negC := edwards25519.NewScalar().Negate(c)
+
+	// U = s*B - c*Y, V = s*H - c*Gamma
+	u := new(edwards25519.Point).VarTimeDoubleScalarBaseMult(negC, y, s)
+	v := new(edwards25519.Point).Add(
+		new(edwards25519.Point).ScalarMult(s, h),
+		new(edwards25519.Point).ScalarMult(negC, gamma),
+	)
+
+	expected := vrfChallengeBytes(publicKey, h.Bytes(), gamma.Bytes(), u.Bytes(), v.Bytes())
+	if !bytesEqual(expected, cBytes) {
+		return nil, ErrVRFProofInvalid
+	}
+	return vrfGammaToHash(gamma), nil
+}
+
+// vrfDecodePublicKey decodes Y and rejects small-order keys, for which a
+// proof would verify for any output
+func vrfDecodePublicKey(publicKey []byte) (*edwards25519.Point, error) {
+	if len(publicKey) != VRFPublicKeySize {
+		return nil, ErrVRFKeyInvalid
+	}
+	y, err := new(edwards25519.Point).SetBytes(publicKey)
+	if err != nil {
+		return nil, ErrVRFKeyInvalid
+	}
+	if new(edwards25519.Point).MultByCofactor(y).Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, ErrVRFKeyInvalid
+	}
+	return y, nil
+}
+
+// vrfDecodeProof splits pi into Gamma, c and s, rejecting s >= q
+func vrfDecodeProof(pi []byte) (*edwards25519.Point, []byte, *edwards25519.Scalar, error) {
+	if len(pi) != VRFProofSize {
+		return nil, nil, nil, ErrVRFProofInvalid
+	}
+	gamma, err := new(edwards25519.Point).SetBytes(pi[:32])
+	if err != nil {
+		return nil, nil, nil, ErrVRFProofInvalid
+	}
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(pi[32+vrfChallenge:])
+	if err != nil {
+		return nil, nil, nil, ErrVRFProofInvalid
+	}
+	return gamma, pi[32 : 32+vrfChallenge], s, nil
+}
+
+// vrfEncodeToCurve hashes alpha to a point by try-and-increment (RFC 9381 section 5.4.1.1)
+func vrfEncodeToCurve(publicKey, alpha []byte) (*edwards25519.Point, error) {
+	for ctr := 0; ctr < 256; ctr++ {
+		h := sha512.New()
+		h.Write([]byte{vrfSuite, vrfEncodeToCurveFront})
+		h.Write(publicKey)
+		h.Write(alpha)
+		h.Write([]byte{byte(ctr), vrfBack})
+		candidate := h.Sum(nil)
+
+		point, err := new(edwards25519.Point).SetBytes(candidate[:32])
+		if err != nil {
+			continue
+		}
+		return point.MultByCofactor(point), nil
+	}
+	return nil, fmt.Errorf("VRF hash to curve failed")
+}
+
+// vrfChallengeBytes computes the truncated challenge (RFC 9381 section 5.4.3)
+func vrfChallengeBytes(points ...[]byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{vrfSuite, vrfChallengeFront})
+	for _, p := range points {
+		h.Write(p)
+	}
+	h.Write([]byte{vrfBack})
+	return h.Sum(nil)[:vrfChallenge]
+}
+
+// vrfChallengeScalar interprets a 16-byte little-endian challenge as a scalar
+func vrfChallengeScalar(c []byte) *edwards25519.Scalar {
+	var wide [32]byte
+	copy(wide[:], c)
+	s, _ := edwards25519.NewScalar().SetCanonicalBytes(wide[:])
+	return s
+}
+
+// vrfGammaToHash computes beta from Gamma
+func vrfGammaToHash(gamma *edwards25519.Point) []byte {
+	h := sha512.New()
+	h.Write([]byte{vrfSuite, vrfProofToHashFront})
+	h.Write(new(edwards25519.Point).MultByCofactor(gamma).Bytes())
+	h.Write([]byte{vrfBack})
+	return h.Sum(nil)
+}
+
+// ComputeUserIndex returns the VRF tree index for a user and the proof a
+// client needs to check it. The index replaces ComputeUserPath.
+func ComputeUserIndex(key *VRFPrivateKey, userID uuid.UUID) (index, proof []byte) {
+	beta, proof := key.Evaluate(userID[:])
+	return beta[:HashSize], proof
+}
+
+// VerifyUserIndex checks that index is the VRF tree index of userID under
+// the given VRF public key
+func VerifyUserIndex(vrfPublicKey []byte, userID uuid.UUID, index, proof []byte) error {
+	beta, err := VRFVerify(vrfPublicKey, userID[:], proof)
+	if err != nil {
+		return err
+	}
+	if !bytesEqual(beta[:HashSize], index) {
+		return fmt.Errorf("%w: index does not match VRF output", ErrVRFProofInvalid)
+	}
+	return nil
+}
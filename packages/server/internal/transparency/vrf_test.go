@@ -0,0 +1,154 @@
+package transparency
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// vrfVector is a known-answer test for the tree index VRF. Fields are hex
+// encoded. When SecretKey is set the proof is recomputed and must match
+// Proof (if given) and Output; Proof is then run through VRFVerify and must
+// be accepted exactly when Valid is set.
+type vrfVector struct {
+	Name      string
+	SecretKey string
+	PublicKey string
+	Alpha     string
+	Proof     string
+	Output    string
+	Valid     bool
+}
+
+// vrfExample16Proof is pi from RFC 9381 appendix B.3, example 16
+const vrfExample16Proof = "8657106690b5526245a92b003bb079ccd1a92130477671f6fc01ad16f26f723f" +
+	"26f8a57ccaed74ee1b190bed1f479d9727d2d0f9b005a6e456a35d4fb0daab12" +
+	"68a1b0db10836d9826a528ca76567805"
+
+// vrfVectors covers RFC 9381 ECVRF-EDWARDS25519-SHA512-TAI examples and the
+// proof and key checks a verifier must make
+var vrfVectors = []vrfVector{
+	{
+		Name:      "RFC 9381 example 16 (empty alpha)",
+		SecretKey: "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60",
+		PublicKey: "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		Alpha:     "",
+		Proof:     vrfExample16Proof,
+		Output: "90cf1df3b703cce59e2a35b925d411164068269d7b2d29f3301c03dd757876ff" +
+			"66b71dda49d2de59d03450451af026798e8f81cd2e333de5cdf4f3e140fdd8ae",
+		Valid: true,
+	},
+	{
+		Name:      "RFC 9381 example 17 (1-byte alpha)",
+		SecretKey: "4ccd089b28ff96da9db6c346ec114e0f5b8a319f35aba624da8cf6ed4fb8a6fb",
+		PublicKey: "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+		Alpha:     "72",
+		Output: "eb4440665d3891d668e7e0fcaf587f1b4bd7fbfe99d0eb2211ccec90496310eb" +
+			"5e33821bc613efb94db5e5b54c70a848a0bef4553a41befc57663b56373a5031",
+		Valid: true,
+	},
+	{
+		Name:      "RFC 9381 example 18 (2-byte alpha)",
+		SecretKey: "c5aa8df43f9f837bedb7442f31dcb7b166d38535076f094b85ce3a2e0b4458f7",
+		PublicKey: "fc51cd8e6218a1a38da47ed00230f0580816ed13ba3303ac5deb911548908025",
+		Alpha:     "af82",
+		Output: "645427e5d00c62a23fb703732fa5d892940935942101e456ecca7bb217c61c45" +
+			"2118fec1219202a0edcf038bb6373241578be7217ba85a2687f7a0310b2df19f",
+		Valid: true,
+	},
+	{
+		Name:      "proof for a different alpha",
+		PublicKey: "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		Alpha:     "72",
+		Proof:     vrfExample16Proof,
+		Valid:     false,
+	},
+	{
+		Name:      "modified challenge",
+		PublicKey: "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		Alpha:     "",
+		Proof: "8657106690b5526245a92b003bb079ccd1a92130477671f6fc01ad16f26f723f" +
+			"27f8a57ccaed74ee1b190bed1f479d9727d2d0f9b005a6e456a35d4fb0daab12" +
+			"68a1b0db10836d9826a528ca76567805",
+		Valid: false,
+	},
+	{
+		Name:      "s not reduced (s = q)",
+		PublicKey: "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		Alpha:     "",
+		Proof: "8657106690b5526245a92b003bb079ccd1a92130477671f6fc01ad16f26f723f" +
+			"26f8a57ccaed74ee1b190bed1f479d97" +
+			"edd3f55c1a631258d69cf7a2def9de1400000000000000000000000000000010",
+		Valid: false,
+	},
+	{
+		Name:      "small-order public key (identity)",
+		PublicKey: "0100000000000000000000000000000000000000000000000000000000000000",
+		Alpha:     "",
+		Proof:     vrfExample16Proof,
+		Valid:     false,
+	},
+	{
+		Name:      "truncated proof",
+		PublicKey: "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		Alpha:     "",
+		Proof:     vrfExample16Proof[:2*(VRFProofSize-1)],
+		Valid:     false,
+	},
+}
+
+// checkVRFVectors runs every vector through the prover and verifier and
+// returns an error for the first mismatch
+func checkVRFVectors() error {
+	for _, v := range vrfVectors {
+		publicKey, err := hex.DecodeString(v.PublicKey)
+		if err != nil {
+			return fmt.Errorf("vector %q: invalid public key hex: %w", v.Name, err)
+		}
+		alpha, err := hex.DecodeString(v.Alpha)
+		if err != nil {
+			return fmt.Errorf("vector %q: invalid alpha hex: %w", v.Name, err)
+		}
+		proof, err := hex.DecodeString(v.Proof)
+		if err != nil {
+			return fmt.Errorf("vector %q: invalid proof hex: %w", v.Name, err)
+		}
+
+		if v.SecretKey != "" {
+			seed, err := hex.DecodeString(v.SecretKey)
+			if err != nil {
+				return fmt.Errorf("vector %q: invalid secret key hex: %w", v.Name, err)
+			}
+			key, err := NewVRFPrivateKey(seed)
+			if err != nil {
+				return fmt.Errorf("vector %q: %w", v.Name, err)
+			}
+			if got := hex.EncodeToString(key.PublicKey()); got != v.PublicKey {
+				return fmt.Errorf("vector %q: public key mismatch: got %s", v.Name, got)
+			}
+			beta, pi := key.Evaluate(alpha)
+			if len(proof) > 0 && !bytesEqual(pi, proof) {
+				return fmt.Errorf("vector %q: proof mismatch: got %x", v.Name, pi)
+			}
+			if got := hex.EncodeToString(beta); got != v.Output {
+				return fmt.Errorf("vector %q: output mismatch: got %s", v.Name, got)
+			}
+			proof = pi
+		}
+
+		beta, err := VRFVerify(publicKey, alpha, proof)
+		if got := err == nil; got != v.Valid {
+			return fmt.Errorf("vector %q: expected valid=%v, got %v (%v)", v.Name, v.Valid, got, err)
+		}
+		if v.Valid && hex.EncodeToString(beta) != v.Output {
+			return fmt.Errorf("vector %q: verified output mismatch: got %x", v.Name, beta)
+		}
+	}
+	return nil
+}
+
+func TestVRFVectors(t *testing.T) {
+	if err := checkVRFVectors(); err != nil {
+		t.Fatal(err)
+	}
+}
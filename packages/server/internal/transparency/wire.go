@@ -0,0 +1,476 @@
+package transparency
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+CANONICAL ENCODING:
+Tree heads and proofs have one canonical binary form, deterministic CBOR (see
+cbor.go), served as application/cbor. Every object is a map whose key 0 is
+the wire version (WireVersion) and key 1 the object type; the remaining keys
+are listed by each Encode function. Optional fields are omitted rather than
+encoded empty, so each value has exactly one encoding, and decoders reject
+anything else: non-shortest heads, unsorted or duplicate keys, unknown keys,
+trailing bytes.
+
+Tree heads from TreeHeadVersionCanonical on are signed over
+
+  "nochat-kt-sth-v2\x00" || EncodeTreeHead(sth without signature)
+
+so the signature covers the wire version, the head version and the signing
+key fingerprint, and cannot be confused with the log head or a cosignature.
+Older heads keep their fixed TreeHeadData layout and carry head version 1 on
+the wire. wire_test.go pins the encodings and a signature.
+*/
+
+// Wire format constants
+const (
+	// WireVersion is key 0 of every canonically encoded object
+	WireVersion = 1
+	// WireContentType is the media type of the canonical encoding
+	WireContentType = "application/cbor"
+
+	treeHeadDomainV2 = "nochat-kt-sth-v2\x00"
+)
+
+// Tree head signature formats, SignedTreeHead.Version
+const (
+	// TreeHeadVersionRaw signs the fixed-layout TreeHeadData
+	TreeHeadVersionRaw = 1
+	// TreeHeadVersionCanonical signs the canonical encoding
+	TreeHeadVersionCanonical = 2
+)
+
+// Wire object types, key 1 of every object
+const (
+	wireTypeTreeHead          = 1
+	wireTypeInclusionProof    = 2
+	wireTypeConsistencyProof  = 3
+	wireTypeNonExistenceProof = 4
+)
+
+// newWireObject starts an object map with its version and type
+func newWireObject(objectType int) *cborMapWriter {
+	m := newCBORMap()
+	m.key(0).int(WireVersion)
+	m.key(1).int(int64(objectType))
+	return m
+}
+
+// finish encodes an object map
+func finish(m *cborMapWriter) []byte {
+	var e cborEncoder
+	m.encode(&e)
+	return e.buf
+}
+
+// decodeWireObject decodes an object of the given type, passing every field
+// after the version and type to field
+func decodeWireObject(data []byte, objectType int, field func(d *cborDecoder, key uint64) error) error {
+	d := &cborDecoder{data: data}
+	var version, gotType int64 = -1, -1
+	err := d.mapEntries(func(key uint64) error {
+		var err error
+		switch {
+		case key == 0:
+			version, err = d.int()
+		case key == 1:
+			gotType, err = d.int()
+		case version != WireVersion:
+			return fmt.Errorf("unsupported wire version %d", version)
+		case gotType != int64(objectType):
+			return fmt.Errorf("object type %d, want %d", gotType, objectType)
+		default:
+			err = field(d, key)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if version != WireVersion || gotType != int64(objectType) {
+		return fmt.Errorf("%w: missing wire version or object type", ErrMalformedCBOR)
+	}
+	return d.end()
+}
+
+// unknownField is the error for a key an object does not define
+func unknownField(key uint64) error {
+	return fmt.Errorf("%w: unknown field %d", ErrMalformedCBOR, key)
+}
+
+// checkCanonical rejects input that decodes but is not the canonical encoding
+func checkCanonical(data, canonical []byte) error {
+	if !bytes.Equal(data, canonical) {
+		return fmt.Errorf("%w: not the canonical encoding", ErrMalformedCBOR)
+	}
+	return nil
+}
+
+// EncodeWire returns the canonical encoding of a tree head or proof
+func EncodeWire(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case *SignedTreeHead:
+		return EncodeTreeHead(v), nil
+	case *InclusionProof:
+		return EncodeInclusionProof(v), nil
+	case *ConsistencyProof:
+		return EncodeConsistencyProof(v), nil
+	case *NonExistenceProof:
+		return EncodeNonExistenceProof(v), nil
+	default:
+		return nil, fmt.Errorf("no canonical encoding for %T", v)
+	}
+}
+
+// treeHeadVersion returns a head's signature format; zero is a raw head
+func treeHeadVersion(sth *SignedTreeHead) int {
+	if sth.Version == 0 {
+		return TreeHeadVersionRaw
+	}
+	return sth.Version
+}
+
+// EncodeTreeHead returns the canonical encoding of a signed tree head:
+//
+//	2 head version, 3 epoch_number, 4 root_hash, 5 tree_size,
+//	6 timestamp (Unix seconds), 7 signing_key_fingerprint,
+//	8 salt_commitment (optional), 9 signature
+func EncodeTreeHead(sth *SignedTreeHead) []byte {
+	return finish(treeHeadMap(sth, true))
+}
+
+func treeHeadMap(sth *SignedTreeHead, withSignature bool) *cborMapWriter {
+	m := newWireObject(wireTypeTreeHead)
+	m.key(2).int(int64(treeHeadVersion(sth)))
+	m.key(3).int(sth.EpochNumber)
+	m.key(4).bytes(sth.RootHash)
+	m.key(5).int(sth.TreeSize)
+	m.key(6).int(sth.Timestamp.Unix())
+	m.key(7).text(sth.SigningKeyFingerprint)
+	m.bytes(8, sth.SaltCommitment)
+	if withSignature {
+		m.key(9).bytes(sth.Signature)
+	}
+	return m
+}
+
+// treeHeadSigningInput is the message signed for a canonical tree head
+func treeHeadSigningInput(sth *SignedTreeHead) []byte {
+	return append([]byte(treeHeadDomainV2), finish(treeHeadMap(sth, false))...)
+}
+
+// DecodeTreeHead parses the canonical encoding of a signed tree head
+func DecodeTreeHead(data []byte) (*SignedTreeHead, error) {
+	sth := &SignedTreeHead{}
+	err := decodeWireObject(data, wireTypeTreeHead, func(d *cborDecoder, key uint64) error {
+		var err error
+		switch key {
+		case 2:
+			var version int64
+			version, err = d.int()
+			sth.Version = int(version)
+		case 3:
+			sth.EpochNumber, err = d.int()
+		case 4:
+			sth.RootHash, err = d.bytes()
+		case 5:
+			sth.TreeSize, err = d.int()
+		case 6:
+			var timestamp int64
+			timestamp, err = d.int()
+			sth.Timestamp = time.Unix(timestamp, 0)
+		case 7:
+			sth.SigningKeyFingerprint, err = d.text()
+		case 8:
+			sth.SaltCommitment, err = d.bytes()
+		case 9:
+			sth.Signature, err = d.bytes()
+		default:
+			return unknownField(key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid tree head: %w", err)
+	}
+	if sth.Version != TreeHeadVersionRaw && sth.Version != TreeHeadVersionCanonical {
+		return nil, fmt.Errorf("invalid tree head: unsupported head version %d", sth.Version)
+	}
+	if len(sth.RootHash) != HashSize || (sth.SaltCommitment != nil && len(sth.SaltCommitment) != HashSize) {
+		return nil, fmt.Errorf("invalid tree head: bad hash length")
+	}
+	if err := checkCanonical(data, EncodeTreeHead(sth)); err != nil {
+		return nil, fmt.Errorf("invalid tree head: %w", err)
+	}
+	return sth, nil
+}
+
+// EncodeInclusionProof returns the canonical encoding of an inclusion proof:
+//
+//	2 epoch_number, 3 leaf_hash, 4 leaf_data (optional), 5 path_bits,
+//	6 root_hash, then either 7 sibling_path or 8 sibling_bitmap and
+//	9 siblings, then 10 vrf_proof and 11 commitment_nonce (optional)
+//
+// leaf_data is a map: 0 user_id (16 bytes, omitted for private leaves),
+// 1 identity_key_fingerprint, 2 signed_prekey_fingerprint, 3 key_version,
+// 4 timestamp, 5 leaf_version, 6 identity_key_type, 7 signed_prekey_type,
+// 8 companion_keys (maps of 0 role, 1 key_type, 2 fingerprint),
+// 9 user_id_commitment. Empty strings, zero leaf versions and empty lists
+// are omitted.
+func EncodeInclusionProof(p *InclusionProof) []byte {
+	m := newWireObject(wireTypeInclusionProof)
+	m.key(2).int(p.EpochNumber)
+	m.key(3).bytes(p.LeafHash)
+	if p.LeafData != nil {
+		encodeLeafData(m.key(4), p.LeafData)
+	}
+	m.key(5).bytes(p.PathBits)
+	m.key(6).bytes(p.RootHash)
+	if p.SiblingBitmap != nil {
+		m.key(8).bytes(p.SiblingBitmap)
+		m.key(9).hashes(p.Siblings)
+	} else {
+		m.key(7).hashes(p.SiblingPath)
+	}
+	m.bytes(10, p.VRFProof)
+	m.bytes(11, p.CommitmentNonce)
+	return finish(m)
+}
+
+func encodeLeafData(e *cborEncoder, data *LeafData) {
+	m := newCBORMap()
+	if data.UserID != uuid.Nil {
+		m.key(0).bytes(data.UserID[:])
+	}
+	m.key(1).text(data.IdentityKeyFingerprint)
+	m.text(2, data.SignedPreKeyFingerprint)
+	m.key(3).int(int64(data.KeyVersion))
+	m.key(4).int(data.Timestamp)
+	if data.LeafVersion != 0 {
+		m.key(5).int(int64(data.LeafVersion))
+	}
+	m.text(6, data.IdentityKeyType)
+	m.text(7, data.SignedPreKeyType)
+	if len(data.CompanionKeys) > 0 {
+		keys := m.key(8)
+		keys.head(cborArray, uint64(len(data.CompanionKeys)))
+		for _, k := range data.CompanionKeys {
+			km := newCBORMap()
+			km.key(0).text(k.Role)
+			km.key(1).text(k.KeyType)
+			km.key(2).text(k.Fingerprint)
+			km.encode(keys)
+		}
+	}
+	m.bytes(9, data.UserIDCommitment)
+	m.encode(e)
+}
+
+// DecodeInclusionProof parses the canonical encoding of an inclusion proof
+func DecodeInclusionProof(data []byte) (*InclusionProof, error) {
+	p := &InclusionProof{}
+	err := decodeWireObject(data, wireTypeInclusionProof, func(d *cborDecoder, key uint64) error {
+		var err error
+		switch key {
+		case 2:
+			p.EpochNumber, err = d.int()
+		case 3:
+			p.LeafHash, err = d.bytes()
+		case 4:
+			p.LeafData, err = decodeLeafData(d)
+		case 5:
+			p.PathBits, err = d.bytes()
+		case 6:
+			p.RootHash, err = d.bytes()
+		case 7:
+			p.SiblingPath, err = d.hashes()
+		case 8:
+			p.SiblingBitmap, err = d.bytes()
+		case 9:
+			p.Siblings, err = d.hashes()
+		case 10:
+			p.VRFProof, err = d.bytes()
+		case 11:
+			p.CommitmentNonce, err = d.bytes()
+		default:
+			return unknownField(key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid inclusion proof: %w", err)
+	}
+	if err := checkCanonical(data, EncodeInclusionProof(p)); err != nil {
+		return nil, fmt.Errorf("invalid inclusion proof: %w", err)
+	}
+	return p, nil
+}
+
+func decodeLeafData(d *cborDecoder) (*LeafData, error) {
+	data := &LeafData{}
+	err := d.mapEntries(func(key uint64) error {
+		var err error
+		var n int64
+		switch key {
+		case 0:
+			var id []byte
+			if id, err = d.bytes(); err == nil {
+				data.UserID, err = uuid.FromBytes(id)
+			}
+		case 1:
+			data.IdentityKeyFingerprint, err = d.text()
+		case 2:
+			data.SignedPreKeyFingerprint, err = d.text()
+		case 3:
+			n, err = d.int()
+			data.KeyVersion = int(n)
+		case 4:
+			data.Timestamp, err = d.int()
+		case 5:
+			n, err = d.int()
+			data.LeafVersion = int(n)
+		case 6:
+			data.IdentityKeyType, err = d.text()
+		case 7:
+			data.SignedPreKeyType, err = d.text()
+		case 8:
+			data.CompanionKeys, err = decodeLeafKeys(d)
+		case 9:
+			data.UserIDCommitment, err = d.bytes()
+		default:
+			return unknownField(key)
+		}
+		return err
+	})
+	return data, err
+}
+
+func decodeLeafKeys(d *cborDecoder) ([]LeafKey, error) {
+	n, err := d.length(cborArray, 1)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]LeafKey, n)
+	for i := range keys {
+		err := d.mapEntries(func(key uint64) error {
+			var err error
+			switch key {
+			case 0:
+				keys[i].Role, err = d.text()
+			case 1:
+				keys[i].KeyType, err = d.text()
+			case 2:
+				keys[i].Fingerprint, err = d.text()
+			default:
+				return unknownField(key)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// EncodeConsistencyProof returns the canonical encoding of a consistency proof:
+//
+//	2 from_epoch, 3 to_epoch, 4 from_root, 5 to_root, 6 proof_hashes
+func EncodeConsistencyProof(p *ConsistencyProof) []byte {
+	m := newWireObject(wireTypeConsistencyProof)
+	m.key(2).int(p.FromEpoch)
+	m.key(3).int(p.ToEpoch)
+	m.key(4).bytes(p.FromRoot)
+	m.key(5).bytes(p.ToRoot)
+	m.key(6).hashes(p.ProofHashes)
+	return finish(m)
+}
+
+// DecodeConsistencyProof parses the canonical encoding of a consistency proof
+func DecodeConsistencyProof(data []byte) (*ConsistencyProof, error) {
+	p := &ConsistencyProof{}
+	err := decodeWireObject(data, wireTypeConsistencyProof, func(d *cborDecoder, key uint64) error {
+		var err error
+		switch key {
+		case 2:
+			p.FromEpoch, err = d.int()
+		case 3:
+			p.ToEpoch, err = d.int()
+		case 4:
+			p.FromRoot, err = d.bytes()
+		case 5:
+			p.ToRoot, err = d.bytes()
+		case 6:
+			p.ProofHashes, err = d.hashes()
+		default:
+			return unknownField(key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid consistency proof: %w", err)
+	}
+	if err := checkCanonical(data, EncodeConsistencyProof(p)); err != nil {
+		return nil, fmt.Errorf("invalid consistency proof: %w", err)
+	}
+	return p, nil
+}
+
+// EncodeNonExistenceProof returns the canonical encoding of a non-existence proof:
+//
+//	2 epoch_number, 3 user_id_hash, 4 root_hash, then either 5 sibling_path
+//	or 6 sibling_bitmap and 7 siblings, then 8 vrf_proof (optional)
+func EncodeNonExistenceProof(p *NonExistenceProof) []byte {
+	m := newWireObject(wireTypeNonExistenceProof)
+	m.key(2).int(p.EpochNumber)
+	m.key(3).bytes(p.UserIDHash)
+	m.key(4).bytes(p.RootHash)
+	if p.SiblingBitmap != nil {
+		m.key(6).bytes(p.SiblingBitmap)
+		m.key(7).hashes(p.Siblings)
+	} else {
+		m.key(5).hashes(p.SiblingPath)
+	}
+	m.bytes(8, p.VRFProof)
+	return finish(m)
+}
+
+// DecodeNonExistenceProof parses the canonical encoding of a non-existence proof
+func DecodeNonExistenceProof(data []byte) (*NonExistenceProof, error) {
+	p := &NonExistenceProof{}
+	err := decodeWireObject(data, wireTypeNonExistenceProof, func(d *cborDecoder, key uint64) error {
+		var err error
+		switch key {
+		case 2:
+			p.EpochNumber, err = d.int()
+		case 3:
+			p.UserIDHash, err = d.bytes()
+		case 4:
+			p.RootHash, err = d.bytes()
+		case 5:
+			p.SiblingPath, err = d.hashes()
+		case 6:
+			p.SiblingBitmap, err = d.bytes()
+		case 7:
+			p.Siblings, err = d.hashes()
+		case 8:
+			p.VRFProof, err = d.bytes()
+		default:
+			return unknownField(key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid non-existence proof: %w", err)
+	}
+	if err := checkCanonical(data, EncodeNonExistenceProof(p)); err != nil {
+		return nil, fmt.Errorf("invalid non-existence proof: %w", err)
+	}
+	return p, nil
+}
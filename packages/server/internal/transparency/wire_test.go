@@ -0,0 +1,278 @@
+package transparency
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// wireVector is a known-answer test for the canonical encoding (wire.go).
+// Encoding is hex. A valid vector's Value must encode to Encoding and
+// Encoding must decode back to it; an invalid vector's Encoding must be
+// rejected by the decoder for Value's type. Tree head vectors also pin
+// the signed message (SigningInput) and the Ed25519 public key the
+// head's signature verifies under.
+type wireVector struct {
+	Name         string
+	Value        interface{} // *SignedTreeHead, *InclusionProof, *ConsistencyProof or *NonExistenceProof
+	Encoding     string
+	Valid        bool
+	SigningInput string
+	PublicKey    string
+}
+
+// wireVectorPublicKey is the RFC 8032 section 7.1 test 1 Ed25519 key, whose
+// seed is 9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60
+const wireVectorPublicKey = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+
+// wireVectorFingerprint is ComputeKeyFingerprint of wireVectorPublicKey
+const wireVectorFingerprint = "21fe31dfa154a261626bf854046fd227"
+
+// hexBytes decodes a hex literal in a vector
+func hexBytes(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("transparency: invalid vector hex: " + err.Error())
+	}
+	return b
+}
+
+// wireVectors covers each object type, both tree head signature formats and
+// encodings a decoder must reject
+var wireVectors = []wireVector{
+	{
+		Name: "canonical tree head with salt commitment",
+		Value: &SignedTreeHead{
+			EpochNumber:           42,
+			RootHash:              hexBytes("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+			TreeSize:              1000,
+			Timestamp:             time.Unix(1700000000, 0),
+			SigningKeyFingerprint: wireVectorFingerprint,
+			SaltCommitment:        hexBytes("202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"),
+			Signature: hexBytes("79f451fbd44dcae19a8823482fc258bc0ca632dfbd31d1e8c6799c345df2a71d" +
+				"ac1b6a2f1595245826ae04aa6f7c11f8e13d76b01a0681dd7bb1ea8b5fb7540a"),
+			Version: TreeHeadVersionCanonical,
+		},
+		Encoding: "aa00010101020203182a045820000102030405060708090a0b0c0d0e0f101112" +
+			"131415161718191a1b1c1d1e1f051903e8061a6553f100077820323166653331" +
+			"6466613135346132363136323662663835343034366664323237085820202122" +
+			"232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f095840" +
+			"79f451fbd44dcae19a8823482fc258bc0ca632dfbd31d1e8c6799c345df2a71d" +
+			"ac1b6a2f1595245826ae04aa6f7c11f8e13d76b01a0681dd7bb1ea8b5fb7540a",
+		Valid: true,
+		SigningInput: "6e6f636861742d6b742d7374682d763200a900010101020203182a0458200001" +
+			"02030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f0519" +
+			"03e8061a6553f100077820323166653331646661313534613236313632366266" +
+			"3835343034366664323237085820202122232425262728292a2b2c2d2e2f3031" +
+			"32333435363738393a3b3c3d3e3f",
+		PublicKey: wireVectorPublicKey,
+	},
+	{
+		Name: "raw tree head",
+		Value: &SignedTreeHead{
+			EpochNumber:           7,
+			RootHash:              hexBytes("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+			TreeSize:              3,
+			Timestamp:             time.Unix(1600000000, 0),
+			SigningKeyFingerprint: wireVectorFingerprint,
+			Signature: hexBytes("abd7872b41cc2d49b848faef488e8c8675f050af051577a196d87ae2ed6f7404" +
+				"52f6a9e0be4c5c78ac0fbebbb0026e4010d02d66d34ad69cdf37e465b2171c01"),
+			Version: TreeHeadVersionRaw,
+		},
+		Encoding: "a90001010102010307045820000102030405060708090a0b0c0d0e0f10111213" +
+			"1415161718191a1b1c1d1e1f0503061a5f5e1000077820323166653331646661" +
+			"3135346132363136323662663835343034366664323237095840abd7872b41cc" +
+			"2d49b848faef488e8c8675f050af051577a196d87ae2ed6f740452f6a9e0be4c" +
+			"5c78ac0fbebbb0026e4010d02d66d34ad69cdf37e465b2171c01",
+		Valid: true,
+		SigningInput: "0000000000000007000102030405060708090a0b0c0d0e0f1011121314151617" +
+			"18191a1b1c1d1e1f0000000000000003000000005f5e1000",
+		PublicKey: wireVectorPublicKey,
+	},
+	{
+		Name: "compressed inclusion proof of a typed leaf",
+		Value: &InclusionProof{
+			EpochNumber: 42,
+			LeafHash:    hexBytes("404142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f"),
+			LeafData: &LeafData{
+				UserID:                 uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"),
+				IdentityKeyFingerprint: "a1b2c3",
+				KeyVersion:             2,
+				Timestamp:              1700000000,
+				LeafVersion:            LeafVersionTyped,
+				IdentityKeyType:        "ed25519",
+				CompanionKeys: []LeafKey{
+					{Role: "companion_identity", KeyType: "mldsa65", Fingerprint: "d4e5f6"},
+				},
+			},
+			PathBits:      hexBytes("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"),
+			RootHash:      hexBytes("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+			SiblingBitmap: hexBytes("8000000000000000000000000000000000000000000000000000000000000001"),
+			Siblings: [][]byte{
+				hexBytes("a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf"),
+				hexBytes("c0c1c2c3c4c5c6c7c8c9cacbcccdcecfd0d1d2d3d4d5d6d7d8d9dadbdcdddedf"),
+			},
+		},
+		Encoding: "a90001010202182a035820404142434445464748494a4b4c4d4e4f5051525354" +
+			"55565758595a5b5c5d5e5f04a700506ba7b8109dad11d180b400c04fd430c801" +
+			"666131623263330302041a6553f10005010667656432353531390881a3007263" +
+			"6f6d70616e696f6e5f6964656e7469747901676d6c6473613635026664346535" +
+			"6636055820808182838485868788898a8b8c8d8e8f909192939495969798999a" +
+			"9b9c9d9e9f065820000102030405060708090a0b0c0d0e0f1011121314151617" +
+			"18191a1b1c1d1e1f085820800000000000000000000000000000000000000000" +
+			"000000000000000000000109825820a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0" +
+			"b1b2b3b4b5b6b7b8b9babbbcbdbebf5820c0c1c2c3c4c5c6c7c8c9cacbcccdce" +
+			"cfd0d1d2d3d4d5d6d7d8d9dadbdcdddedf",
+		Valid: true,
+	},
+	{
+		Name: "consistency proof",
+		Value: &ConsistencyProof{
+			FromEpoch: 41,
+			ToEpoch:   42,
+			FromRoot:  hexBytes("e0e1e2e3e4e5e6e7e8e9eaebecedeeeff0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"),
+			ToRoot:    hexBytes("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+			ProofHashes: [][]byte{
+				hexBytes("a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf"),
+			},
+		},
+		Encoding: "a70001010302182903182a045820e0e1e2e3e4e5e6e7e8e9eaebecedeeeff0f1" +
+			"f2f3f4f5f6f7f8f9fafbfcfdfeff055820000102030405060708090a0b0c0d0e" +
+			"0f101112131415161718191a1b1c1d1e1f06815820a0a1a2a3a4a5a6a7a8a9aa" +
+			"abacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf",
+		Valid: true,
+	},
+	{
+		Name: "compressed non-existence proof",
+		Value: &NonExistenceProof{
+			EpochNumber:   42,
+			UserIDHash:    hexBytes("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"),
+			RootHash:      hexBytes("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+			SiblingBitmap: hexBytes("0000000000000000000000000000000000000000000000000000000000000000"),
+			Siblings:      [][]byte{},
+		},
+		Encoding: "a70001010402182a035820808182838485868788898a8b8c8d8e8f9091929394" +
+			"95969798999a9b9c9d9e9f045820000102030405060708090a0b0c0d0e0f1011" +
+			"12131415161718191a1b1c1d1e1f065820000000000000000000000000000000" +
+			"00000000000000000000000000000000000780",
+		Valid: true,
+	},
+	{
+		Name:  "integer not in shortest form",
+		Value: &ConsistencyProof{},
+		Encoding: "a7000101030219002903182a045820e0e1e2e3e4e5e6e7e8e9eaebecedeeeff0" +
+			"f1f2f3f4f5f6f7f8f9fafbfcfdfeff055820000102030405060708090a0b0c0d" +
+			"0e0f101112131415161718191a1b1c1d1e1f06815820a0a1a2a3a4a5a6a7a8a9" +
+			"aaabacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf",
+	},
+	{
+		Name:  "map keys out of order",
+		Value: &ConsistencyProof{},
+		Encoding: "a70001010303182a021829045820e0e1e2e3e4e5e6e7e8e9eaebecedeeeff0f1" +
+			"f2f3f4f5f6f7f8f9fafbfcfdfeff055820000102030405060708090a0b0c0d0e" +
+			"0f101112131415161718191a1b1c1d1e1f06815820a0a1a2a3a4a5a6a7a8a9aa" +
+			"abacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf",
+	},
+	{
+		Name:  "trailing byte",
+		Value: &ConsistencyProof{},
+		Encoding: "a70001010302182903182a045820e0e1e2e3e4e5e6e7e8e9eaebecedeeeff0f1" +
+			"f2f3f4f5f6f7f8f9fafbfcfdfeff055820000102030405060708090a0b0c0d0e" +
+			"0f101112131415161718191a1b1c1d1e1f06815820a0a1a2a3a4a5a6a7a8a9aa" +
+			"abacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf00",
+	},
+	{
+		Name:  "unknown wire version",
+		Value: &ConsistencyProof{},
+		Encoding: "a70002010302182903182a045820e0e1e2e3e4e5e6e7e8e9eaebecedeeeff0f1" +
+			"f2f3f4f5f6f7f8f9fafbfcfdfeff055820000102030405060708090a0b0c0d0e" +
+			"0f101112131415161718191a1b1c1d1e1f06815820a0a1a2a3a4a5a6a7a8a9aa" +
+			"abacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf",
+	},
+	{
+		Name:  "object of another type",
+		Value: &NonExistenceProof{},
+		Encoding: "a70001010302182903182a045820e0e1e2e3e4e5e6e7e8e9eaebecedeeeff0f1" +
+			"f2f3f4f5f6f7f8f9fafbfcfdfeff055820000102030405060708090a0b0c0d0e" +
+			"0f101112131415161718191a1b1c1d1e1f06815820a0a1a2a3a4a5a6a7a8a9aa" +
+			"abacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf",
+	},
+}
+
+// decodeWire decodes data as an object of like's type
+func decodeWire(like interface{}, data []byte) (interface{}, error) {
+	switch like.(type) {
+	case *SignedTreeHead:
+		return DecodeTreeHead(data)
+	case *InclusionProof:
+		return DecodeInclusionProof(data)
+	case *ConsistencyProof:
+		return DecodeConsistencyProof(data)
+	case *NonExistenceProof:
+		return DecodeNonExistenceProof(data)
+	default:
+		return nil, fmt.Errorf("no canonical encoding for %T", like)
+	}
+}
+
+// checkWireVectors runs wireVectors against the canonical encoding and the
+// tree head signature formats
+func checkWireVectors() error {
+	for _, v := range wireVectors {
+		data, err := hex.DecodeString(v.Encoding)
+		if err != nil {
+			return fmt.Errorf("vector %q: invalid encoding hex: %w", v.Name, err)
+		}
+
+		decoded, err := decodeWire(v.Value, data)
+		if !v.Valid {
+			if err == nil {
+				return fmt.Errorf("vector %q: invalid encoding was accepted", v.Name)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("vector %q: %w", v.Name, err)
+		}
+
+		encoded, err := EncodeWire(v.Value)
+		if err != nil {
+			return fmt.Errorf("vector %q: %w", v.Name, err)
+		}
+		if got := hex.EncodeToString(encoded); got != v.Encoding {
+			return fmt.Errorf("vector %q: encoding mismatch: got %s", v.Name, got)
+		}
+		reencoded, err := EncodeWire(decoded)
+		if err != nil || !bytesEqual(reencoded, encoded) {
+			return fmt.Errorf("vector %q: decoded value does not round-trip", v.Name)
+		}
+
+		sth, ok := v.Value.(*SignedTreeHead)
+		if !ok {
+			continue
+		}
+		if got := hex.EncodeToString(TreeHeadData(sth)); got != v.SigningInput {
+			return fmt.Errorf("vector %q: signing input mismatch: got %s", v.Name, got)
+		}
+		publicKey, err := hex.DecodeString(v.PublicKey)
+		if err != nil {
+			return fmt.Errorf("vector %q: invalid public key hex: %w", v.Name, err)
+		}
+		if ComputeKeyFingerprint(publicKey) != sth.SigningKeyFingerprint {
+			return fmt.Errorf("vector %q: fingerprint does not match the public key", v.Name)
+		}
+		if !VerifyWithPublicKey(publicKey, "ed25519", decoded.(*SignedTreeHead)) {
+			return fmt.Errorf("vector %q: signature does not verify", v.Name)
+		}
+	}
+	return nil
+}
+
+func TestWireVectors(t *testing.T) {
+	if err := checkWireVectors(); err != nil {
+		t.Fatal(err)
+	}
+}
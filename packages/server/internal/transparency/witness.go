@@ -0,0 +1,542 @@
+package transparency
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+/*
+WITNESS COSIGNING AND GOSSIP:
+Witnesses are independent parties, configured by their Ed25519 public keys,
+that verify each tree head (signature and consistency with the last head they
+cosigned) and return a cosignature over it. A client that gets a tree head
+with cosignatures from witnesses it trusts knows those witnesses saw the same
+head, so the server would have to fool them too to show it a forked view.
+
+Clients gossip the heads they observed to POST /api/transparency/checkpoint
+and receive the latest cosigned checkpoint. A validly signed head that
+differs from the one published for its epoch, whether submitted by a client
+or a witness, is proof the server signed two views of the directory; both
+heads are recorded in transparency_forks and served publicly. A validly
+signed head for an epoch the server does not publish (a hidden fork or a
+rolled-back epoch) is recorded the same way, with no published head.
+
+Cosigned message (CosignatureData):
+  "nochat-kt-witness-v1\x00" || cosigned_at (8, Unix seconds) || TreeHeadData(sth)
+*/
+
+const witnessDomain = "nochat-kt-witness-v1\x00"
+
+// Fork sources
+const (
+	ForkSourceClient  = "client"
+	ForkSourceWitness = "witness"
+)
+
+// Witness errors
+var (
+	ErrUnknownWitness      = errors.New("unknown or disabled witness")
+	ErrInvalidCosignature  = errors.New("invalid cosignature")
+	ErrForkDetected        = errors.New("tree head conflicts with the published head for its epoch")
+	ErrCosignatureTooStale = errors.New("cosignature timestamp predates the tree head")
+)
+
+// Witness is a registered cosigner
+type Witness struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	PublicKey   []byte    `json:"public_key"`
+	Status      string    `json:"status"` // "active" or "disabled"
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Cosignature is a witness's signature over a tree head
+type Cosignature struct {
+	EpochNumber        int64     `json:"epoch_number"`
+	WitnessFingerprint string    `json:"witness_fingerprint"`
+	CosignedAt         time.Time `json:"cosigned_at"`
+	Signature          []byte    `json:"signature"`
+}
+
+// Checkpoint is a tree head with the witness cosignatures collected for it
+type Checkpoint struct {
+	TreeHead     *SignedTreeHead `json:"tree_head"`
+	Cosignatures []Cosignature   `json:"cosignatures"`
+}
+
+// Fork is proof of equivocation: two heads for one epoch, both validly
+// signed, or a signed head for an epoch that was never published (Published nil)
+type Fork struct {
+	ID          uuid.UUID       `json:"id"`
+	EpochNumber int64           `json:"epoch_number"`
+	Published   *SignedTreeHead `json:"published"`
+	Conflicting *SignedTreeHead `json:"conflicting"`
+	Source      string          `json:"source"`
+	Reporter    string          `json:"reporter"` // user ID or witness fingerprint
+	DetectedAt  time.Time       `json:"detected_at"`
+}
+
+// CosignatureData serializes what a witness signs for a tree head
+func CosignatureData(sth *SignedTreeHead, cosignedAt time.Time) []byte {
+	data := make([]byte, 0, len(witnessDomain)+8+56)
+	data = append(data, witnessDomain...)
+	data = binary.BigEndian.AppendUint64(data, uint64(cosignedAt.Unix()))
+	data = append(data, TreeHeadData(sth)...)
+	return data
+}
+
+// Cosign signs a tree head as a witness
+func Cosign(witnessKey ed25519.PrivateKey, sth *SignedTreeHead, cosignedAt time.Time) []byte {
+	return ed25519.Sign(witnessKey, CosignatureData(sth, cosignedAt))
+}
+
+// VerifyCosignature checks a witness cosignature over a tree head
+func VerifyCosignature(witness *Witness, sth *SignedTreeHead, c *Cosignature) error {
+	if c.CosignedAt.Unix() < sth.Timestamp.Unix() {
+		return ErrCosignatureTooStale
+	}
+	if !verifyMessage(witness.PublicKey, "ed25519", CosignatureData(sth, c.CosignedAt), c.Signature) {
+		return ErrInvalidCosignature
+	}
+	return nil
+}
+
+// WitnessFingerprint returns the fingerprint of a witness public key, computed
+// like signing key fingerprints
+func WitnessFingerprint(publicKey []byte) string {
+	hash := sha256.Sum256(publicKey)
+	return hex.EncodeToString(hash[:16])
+}
+
+// loadWitnesses registers the witnesses in TRANSPARENCY_WITNESSES, a
+// comma-separated list of name=base64(Ed25519 public key). When it is set,
+// witnesses missing from the list are disabled; their past cosignatures are
+// kept but no longer served.
+func (s *Service) loadWitnesses(ctx context.Context) error {
+	config := strings.TrimSpace(os.Getenv("TRANSPARENCY_WITNESSES"))
+	if config == "" {
+		return nil
+	}
+
+	var fingerprints []string
+	for _, item := range strings.Split(config, ",") {
+		name, keyB64, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid TRANSPARENCY_WITNESSES entry %q", item)
+		}
+		publicKey, err := base64.StdEncoding.DecodeString(keyB64)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid public key for witness %q", name)
+		}
+
+		fingerprint := WitnessFingerprint(publicKey)
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO transparency_witnesses (fingerprint, name, public_key, status)
+			VALUES ($1, $2, $3, 'active')
+			ON CONFLICT (fingerprint) DO UPDATE SET name = EXCLUDED.name, status = 'active'
+		`, fingerprint, name, publicKey)
+		if err != nil {
+			return fmt.Errorf("failed to register witness %q: %w", name, err)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE transparency_witnesses SET status = 'disabled'
+		WHERE status = 'active' AND NOT (fingerprint = ANY($1))
+	`, pq.Array(fingerprints))
+	if err != nil {
+		return fmt.Errorf("failed to disable removed witnesses: %w", err)
+	}
+
+	log.Printf("[Transparency] Registered %d witnesses", len(fingerprints))
+	return nil
+}
+
+// witnessColumns is the column list scanned by scanWitness
+const witnessColumns = `fingerprint, name, public_key, status, created_at`
+
+// scanWitness scans a transparency_witnesses row
+func scanWitness(row interface{ Scan(...interface{}) error }) (*Witness, error) {
+	w := &Witness{}
+	if err := row.Scan(&w.Fingerprint, &w.Name, &w.PublicKey, &w.Status, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// GetWitnesses returns every registered witness, active ones first
+func (s *Service) GetWitnesses(ctx context.Context) ([]Witness, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+witnessColumns+` FROM transparency_witnesses
+		ORDER BY status = 'active' DESC, created_at ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get witnesses: %w", err)
+	}
+	defer rows.Close()
+
+	var witnesses []Witness
+	for rows.Next() {
+		w, err := scanWitness(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan witness: %w", err)
+		}
+		witnesses = append(witnesses, *w)
+	}
+	return witnesses, rows.Err()
+}
+
+// AddCosignature verifies and stores a witness cosignature. The head must be
+// validly signed; if it differs from the published head for its epoch, the
+// fork is recorded and returned with ErrForkDetected.
+func (s *Service) AddCosignature(ctx context.Context, sth *SignedTreeHead, c *Cosignature) (*Fork, error) {
+	witness, err := scanWitness(s.db.QueryRowContext(ctx, `
+		SELECT `+witnessColumns+` FROM transparency_witnesses
+		WHERE fingerprint = $1 AND status = 'active'
+	`, c.WitnessFingerprint))
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownWitness
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get witness: %w", err)
+	}
+
+	if err := VerifyCosignature(witness, sth, c); err != nil {
+		return nil, err
+	}
+
+	fork, err := s.checkTreeHead(ctx, sth, ForkSourceWitness, witness.Fingerprint)
+	if err != nil {
+		return fork, err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO transparency_cosignatures (epoch_number, witness_fingerprint, cosigned_at, signature)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (epoch_number, witness_fingerprint) DO UPDATE SET
+			cosigned_at = EXCLUDED.cosigned_at,
+			signature = EXCLUDED.signature
+	`, sth.EpochNumber, witness.Fingerprint, time.Unix(c.CosignedAt.Unix(), 0), c.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store cosignature: %w", err)
+	}
+	return nil, nil
+}
+
+// SubmitObservedHeads checks tree heads a client observed against the
+// published ones and returns the latest cosigned checkpoint. Any validly
+// signed head that conflicts is recorded as a fork; the forks are returned
+// with ErrForkDetected.
+func (s *Service) SubmitObservedHeads(ctx context.Context, userID uuid.UUID, heads []*SignedTreeHead) (*Checkpoint, []Fork, error) {
+	var forks []Fork
+	for _, sth := range heads {
+		fork, err := s.checkTreeHead(ctx, sth, ForkSourceClient, userID.String())
+		if errors.Is(err, ErrForkDetected) {
+			forks = append(forks, *fork)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	checkpoint, err := s.GetCheckpoint(ctx, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(forks) > 0 {
+		return checkpoint, forks, ErrForkDetected
+	}
+	return checkpoint, nil, nil
+}
+
+// checkTreeHead verifies a head's signature and compares it with the head
+// published for its epoch, recording a fork if they differ or if the epoch
+// was never published
+func (s *Service) checkTreeHead(ctx context.Context, sth *SignedTreeHead, source, reporter string) (*Fork, error) {
+	if err := s.VerifySignedTreeHead(ctx, sth); err != nil {
+		return nil, err
+	}
+
+	published, err := s.GetSignedTreeHeadAtEpoch(ctx, sth.EpochNumber)
+	switch {
+	case errors.Is(err, ErrUnknownEpoch):
+		published = nil
+	case err != nil:
+		return nil, err
+	case bytesEqual(TreeHeadData(published), TreeHeadData(sth)):
+		return nil, nil
+	}
+
+	fork, err := s.recordFork(ctx, published, sth, source, reporter)
+	if err != nil {
+		return nil, err
+	}
+	publishedRoot := "unpublished"
+	if published != nil {
+		publishedRoot = hex.EncodeToString(published.RootHash[:8])
+	}
+	log.Printf("[Transparency] FORK at epoch %d reported by %s %s: published root %s, conflicting root %s",
+		sth.EpochNumber, source, reporter, publishedRoot, hex.EncodeToString(sth.RootHash[:8]))
+	return fork, ErrForkDetected
+}
+
+// recordFork stores the two conflicting heads, once per conflicting head.
+// published is nil when the server never published the conflicting head's epoch.
+func (s *Service) recordFork(ctx context.Context, published, conflicting *SignedTreeHead, source, reporter string) (*Fork, error) {
+	var publishedLeaf []byte
+	if published != nil {
+		var err error
+		if publishedLeaf, err = EncodeLogLeaf(published); err != nil {
+			return nil, err
+		}
+	}
+	conflictingLeaf, err := EncodeLogLeaf(conflicting)
+	if err != nil {
+		return nil, err
+	}
+	conflictingHash := sha256.Sum256(conflictingLeaf)
+
+	fork := &Fork{
+		ID:          uuid.New(),
+		EpochNumber: conflicting.EpochNumber,
+		Published:   published,
+		Conflicting: conflicting,
+		Source:      source,
+		Reporter:    reporter,
+		DetectedAt:  time.Now(),
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO transparency_forks (id, epoch_number, published_head, conflicting_head, conflicting_head_hash, source, reporter, detected_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (epoch_number, conflicting_head_hash) DO NOTHING
+	`, fork.ID, fork.EpochNumber, publishedLeaf, conflictingLeaf, conflictingHash[:], source, reporter, fork.DetectedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record fork: %w", err)
+	}
+	return fork, nil
+}
+
+// GetCheckpoint returns an epoch's tree head with its cosignatures. For epoch
+// 0 it returns the latest epoch any active witness has cosigned, or the latest
+// epoch if none has been.
+func (s *Service) GetCheckpoint(ctx context.Context, epoch int64) (*Checkpoint, error) {
+	if epoch == 0 {
+		err := s.db.QueryRowContext(ctx, `
+			SELECT COALESCE(MAX(c.epoch_number), 0)
+			FROM transparency_cosignatures c
+			JOIN transparency_witnesses w ON w.fingerprint = c.witness_fingerprint
+			WHERE w.status = 'active'
+		`).Scan(&epoch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get latest cosigned epoch: %w", err)
+		}
+	}
+
+	var sth *SignedTreeHead
+	var err error
+	if epoch == 0 {
+		sth, err = s.GetSignedTreeHead(ctx)
+		if err == nil && sth == nil {
+			err = ErrUnknownEpoch
+		}
+	} else {
+		sth, err = s.GetSignedTreeHeadAtEpoch(ctx, epoch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.witness_fingerprint, c.cosigned_at, c.signature
+		FROM transparency_cosignatures c
+		JOIN transparency_witnesses w ON w.fingerprint = c.witness_fingerprint
+		WHERE c.epoch_number = $1 AND w.status = 'active'
+		ORDER BY c.witness_fingerprint
+	`, sth.EpochNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cosignatures: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoint := &Checkpoint{TreeHead: sth, Cosignatures: []Cosignature{}}
+	for rows.Next() {
+		c := Cosignature{EpochNumber: sth.EpochNumber}
+		if err := rows.Scan(&c.WitnessFingerprint, &c.CosignedAt, &c.Signature); err != nil {
+			return nil, fmt.Errorf("failed to scan cosignature: %w", err)
+		}
+		checkpoint.Cosignatures = append(checkpoint.Cosignatures, c)
+	}
+	return checkpoint, rows.Err()
+}
+
+// GetForks returns recorded forks, newest first
+func (s *Service) GetForks(ctx context.Context, limit int) ([]Fork, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, epoch_number, published_head, conflicting_head, source, reporter, detected_at
+		FROM transparency_forks
+		ORDER BY detected_at DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get forks: %w", err)
+	}
+	defer rows.Close()
+
+	var forks []Fork
+	for rows.Next() {
+		var fork Fork
+		var publishedLeaf, conflictingLeaf []byte
+		err := rows.Scan(&fork.ID, &fork.EpochNumber, &publishedLeaf, &conflictingLeaf,
+			&fork.Source, &fork.Reporter, &fork.DetectedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fork: %w", err)
+		}
+		if publishedLeaf != nil {
+			if fork.Published, err = DecodeLogLeaf(publishedLeaf); err != nil {
+				return nil, fmt.Errorf("failed to decode published head: %w", err)
+			}
+		}
+		if fork.Conflicting, err = DecodeLogLeaf(conflictingLeaf); err != nil {
+			return nil, fmt.Errorf("failed to decode conflicting head: %w", err)
+		}
+		forks = append(forks, fork)
+	}
+	return forks, rows.Err()
+}
+
+// WitnessResponse is a JSON-serializable witness
+type WitnessResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Status      string `json:"status"`
+}
+
+// CosignatureResponse is a JSON-serializable cosignature
+type CosignatureResponse struct {
+	WitnessFingerprint string `json:"witness_fingerprint"`
+	CosignedAt         string `json:"cosigned_at"`
+	Signature          string `json:"signature"`
+}
+
+// CheckpointResponse is a JSON-serializable cosigned checkpoint
+type CheckpointResponse struct {
+	TreeHead     *TreeHeadResponse     `json:"tree_head"`
+	Cosignatures []CosignatureResponse `json:"cosignatures"`
+}
+
+// ForkResponse is JSON-serializable fork evidence. Published is null for a
+// signed head of an epoch the server never published.
+type ForkResponse struct {
+	ID          string            `json:"id"`
+	EpochNumber int64             `json:"epoch_number"`
+	Published   *TreeHeadResponse `json:"published"`
+	Conflicting *TreeHeadResponse `json:"conflicting"`
+	Source      string            `json:"source"`
+	Reporter    string            `json:"reporter"`
+	DetectedAt  string            `json:"detected_at"`
+}
+
+// ToResponse converts a Witness to a JSON-serializable response
+func (w *Witness) ToResponse() *WitnessResponse {
+	return &WitnessResponse{
+		Fingerprint: w.Fingerprint,
+		Name:        w.Name,
+		PublicKey:   base64.StdEncoding.EncodeToString(w.PublicKey),
+		Status:      w.Status,
+	}
+}
+
+// ToResponse converts a Checkpoint to a JSON-serializable response
+func (c *Checkpoint) ToResponse() *CheckpointResponse {
+	resp := &CheckpointResponse{
+		TreeHead:     c.TreeHead.ToResponse(),
+		Cosignatures: make([]CosignatureResponse, len(c.Cosignatures)),
+	}
+	for i, cosig := range c.Cosignatures {
+		resp.Cosignatures[i] = CosignatureResponse{
+			WitnessFingerprint: cosig.WitnessFingerprint,
+			CosignedAt:         cosig.CosignedAt.Format("2006-01-02T15:04:05Z07:00"),
+			Signature:          base64.StdEncoding.EncodeToString(cosig.Signature),
+		}
+	}
+	return resp
+}
+
+// ToResponse converts a Fork to a JSON-serializable response
+func (f *Fork) ToResponse() *ForkResponse {
+	resp := &ForkResponse{
+		ID:          f.ID.String(),
+		EpochNumber: f.EpochNumber,
+		Conflicting: f.Conflicting.ToResponse(),
+		Source:      f.Source,
+		Reporter:    f.Reporter,
+		DetectedAt:  f.DetectedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+	if f.Published != nil {
+		resp.Published = f.Published.ToResponse()
+	}
+	return resp
+}
+
+// TreeHeadFromResponse parses a tree head in the form served by
+// /api/transparency/root, e.g. one a client or witness submits back
+func TreeHeadFromResponse(r *TreeHeadResponse) (*SignedTreeHead, error) {
+	rootHash, err := base64.StdEncoding.DecodeString(r.RootHash)
+	if err != nil || len(rootHash) != HashSize {
+		return nil, fmt.Errorf("invalid root_hash")
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	timestamp, err := time.Parse(time.RFC3339, r.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp")
+	}
+	// Heads served before versioning have no version field
+	version := r.Version
+	if version == 0 {
+		version = TreeHeadVersionRaw
+	}
+	if version != TreeHeadVersionRaw && version != TreeHeadVersionCanonical {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	var saltCommitment []byte
+	if r.SaltCommitment != "" {
+		saltCommitment, err = base64.StdEncoding.DecodeString(r.SaltCommitment)
+		if err != nil || len(saltCommitment) != HashSize {
+			return nil, fmt.Errorf("invalid salt_commitment")
+		}
+	}
+	return &SignedTreeHead{
+		EpochNumber:           r.EpochNumber,
+		RootHash:              rootHash,
+		TreeSize:              r.TreeSize,
+		Signature:             signature,
+		SigningKeyFingerprint: r.SigningKeyFingerprint,
+		Timestamp:             timestamp,
+		SaltCommitment:        saltCommitment,
+		Version:               version,
+	}, nil
+}
@@ -0,0 +1,27 @@
+-- Encrypted Attachment Thumbnails
+-- The server cannot render previews of E2EE content, so clients upload an
+-- encrypted thumbnail blob alongside the main attachment. The thumbnail has its
+-- own random key, wrapped with the message key exactly like the file key.
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_size BIGINT;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_encrypted_key BYTEA;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_key_nonce BYTEA;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_key_algorithm VARCHAR(50);
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_checksum_sha256 VARCHAR(64);
+
+-- A thumbnail is either fully described (blob + wrapped key) or absent
+ALTER TABLE attachments ADD CONSTRAINT attachments_thumbnail_complete_check
+CHECK (
+    (thumbnail_key IS NULL AND thumbnail_encrypted_key IS NULL) OR
+    (thumbnail_key IS NOT NULL AND thumbnail_encrypted_key IS NOT NULL AND thumbnail_size IS NOT NULL)
+);
+
+-- Thumbnails are small previews; larger blobs belong in the main attachment
+ALTER TABLE attachments ADD CONSTRAINT attachments_thumbnail_size_check
+CHECK (thumbnail_size IS NULL OR (thumbnail_size > 0 AND thumbnail_size <= 262144));
+
+COMMENT ON COLUMN attachments.thumbnail_size IS 'Size in bytes of the encrypted thumbnail blob (max 256 KiB)';
+COMMENT ON COLUMN attachments.thumbnail_encrypted_key IS 'Thumbnail encryption key encrypted with the message key (independent of the file key)';
+COMMENT ON COLUMN attachments.thumbnail_key_nonce IS 'Nonce/IV used when encrypting the thumbnail key';
+COMMENT ON COLUMN attachments.thumbnail_key_algorithm IS 'Algorithm used: aes-256-gcm or xchacha20-poly1305';
+COMMENT ON COLUMN attachments.thumbnail_checksum_sha256 IS 'SHA-256 hash of the encrypted thumbnail blob';
@@ -0,0 +1,31 @@
+-- Attachment Access Log
+-- Every download grant issued for an attachment (or its thumbnail) is recorded
+-- here so abuse reports can be traced back to the participant who fetched a
+-- blob. Grants are only issued after the caller is verified as a participant
+-- of the conversation the attachment belongs to.
+
+CREATE TABLE IF NOT EXISTS attachment_access_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- Grant ID embedded in the issued URL
+    grant_id UUID NOT NULL UNIQUE,
+    attachment_id UUID NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- The storage key the URL was issued for (file or thumbnail)
+    storage_key VARCHAR(512) NOT NULL,
+    -- What was granted: file download or thumbnail preview
+    access_type VARCHAR(20) NOT NULL CHECK (access_type IN ('file', 'thumbnail')),
+    ip_address INET,
+    user_agent TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachment_access_attachment ON attachment_access_log(attachment_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_attachment_access_user ON attachment_access_log(user_id, created_at DESC);
+
+-- Storage keys are resolved back to attachments on every download request
+CREATE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments(storage_key);
+CREATE INDEX IF NOT EXISTS idx_attachments_thumbnail_key ON attachments(thumbnail_key) WHERE thumbnail_key IS NOT NULL;
+
+COMMENT ON TABLE attachment_access_log IS 'Audit trail of download URLs issued per user for abuse investigations';
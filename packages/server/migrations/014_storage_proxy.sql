@@ -0,0 +1,8 @@
+-- Streaming Proxy Transfers
+-- Record whether a download was granted as a presigned blob-store URL or
+-- streamed through the server's authenticated proxy endpoint.
+
+ALTER TABLE attachment_access_log ADD COLUMN IF NOT EXISTS delivery_mode VARCHAR(20) NOT NULL DEFAULT 'presigned'
+    CHECK (delivery_mode IN ('presigned', 'proxy'));
+
+COMMENT ON COLUMN attachment_access_log.delivery_mode IS 'presigned = direct blob-store URL, proxy = streamed through /api/storage/proxy';
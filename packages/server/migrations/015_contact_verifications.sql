@@ -0,0 +1,31 @@
+-- Contact Key Verification
+-- Tracks which contacts a user has verified by comparing safety numbers.
+-- Verification is bound to the contact's identity key fingerprint at the time
+-- of comparison and is reset automatically when that key is rotated.
+
+CREATE TABLE IF NOT EXISTS contact_key_verifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- The user who performed the verification
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- The contact whose identity key was verified
+    contact_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    verified BOOLEAN NOT NULL DEFAULT false,
+    -- Fingerprint (SHA-256 hex) of the contact's identity key when verified
+    verified_fingerprint VARCHAR(64),
+    verified_at TIMESTAMP WITH TIME ZONE,
+    -- Set when a key change cleared the verified flag
+    reset_at TIMESTAMP WITH TIME ZONE,
+    reset_reason VARCHAR(50),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(user_id, contact_user_id),
+    CHECK (user_id <> contact_user_id),
+    CONSTRAINT contact_key_verifications_fingerprint_check CHECK (NOT verified OR verified_fingerprint IS NOT NULL)
+);
+
+-- Key rotation resets every verification of the rotated user's key
+CREATE INDEX IF NOT EXISTS idx_contact_key_verifications_contact
+ON contact_key_verifications(contact_user_id)
+WHERE verified = true;
+
+COMMENT ON TABLE contact_key_verifications IS 'Per-contact safety number verification state, reset on identity key rotation';
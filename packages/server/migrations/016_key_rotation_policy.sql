@@ -0,0 +1,26 @@
+-- Key Rotation Policy
+-- Supports the background rotation monitor: tracks when an owner was last
+-- told to rotate a key so notices aren't sent on every pass, and indexes the
+-- scans for keys nearing expiry and rotated keys leaving the grace window.
+
+ALTER TABLE signed_prekeys ADD COLUMN IF NOT EXISTS rotation_notified_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE sealed_sender_keys ADD COLUMN IF NOT EXISTS rotation_notified_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_signed_prekeys_active_created
+ON signed_prekeys(created_at)
+WHERE status = 'active';
+
+CREATE INDEX IF NOT EXISTS idx_signed_prekeys_rotated
+ON signed_prekeys(rotated_at)
+WHERE status = 'rotated';
+
+CREATE INDEX IF NOT EXISTS idx_sealed_sender_keys_active_created
+ON sealed_sender_keys(created_at)
+WHERE status = 'active';
+
+CREATE INDEX IF NOT EXISTS idx_sealed_sender_keys_rotated
+ON sealed_sender_keys(rotated_at)
+WHERE status = 'rotated';
+
+COMMENT ON COLUMN signed_prekeys.rotation_notified_at IS 'Last time the owner was notified that this key is due for rotation';
+COMMENT ON COLUMN sealed_sender_keys.rotation_notified_at IS 'Last time the owner was notified that this key is due for rotation';
@@ -0,0 +1,56 @@
+-- Last-Resort Prekeys and Prekey Claim Log
+-- Following PQXDH, each user may publish a signed last-resort Kyber prekey
+-- (optionally paired with an X25519 key for hybrid bundles). It is never
+-- consumed: bundles fall back to it once the one-time prekey pool is empty, so
+-- draining the pool can no longer block new sessions.
+--
+-- Every bundle claim is logged per requester so the target user can see who is
+-- drawing down their prekeys and how unusual the pattern is.
+
+CREATE TABLE IF NOT EXISTS last_resort_prekeys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Key ID for client-side reference
+    key_id INTEGER NOT NULL,
+    -- X25519 public key (32 bytes) - null for Kyber-only last-resort keys
+    ec_public_key BYTEA,
+    -- Kyber1024 public key (1568 bytes)
+    kyber_public_key BYTEA NOT NULL,
+    -- Signature by the identity key over kyber_public_key (or ec||kyber for hybrid)
+    signature BYTEA NOT NULL,
+    key_fingerprint VARCHAR(64) NOT NULL,
+    -- 1 = Kyber-only, 2 = PQXDH hybrid (X25519 + Kyber)
+    hybrid_version INTEGER NOT NULL DEFAULT 1,
+    -- Status: active, replaced. Replaced keys are kept so late initiators still decrypt
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'replaced')),
+    use_count INTEGER NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    replaced_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE(user_id, key_id)
+);
+
+-- At most one active last-resort key per user and key kind
+CREATE UNIQUE INDEX IF NOT EXISTS idx_last_resort_prekeys_active
+ON last_resort_prekeys(user_id, hybrid_version)
+WHERE status = 'active';
+
+CREATE TABLE IF NOT EXISTS prekey_claims (
+    id BIGSERIAL PRIMARY KEY,
+    -- The user whose bundle was fetched
+    target_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- The user who fetched it
+    requester_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Which prekey was handed out: one_time, last_resort, or none
+    prekey_kind VARCHAR(20) NOT NULL CHECK (prekey_kind IN ('one_time', 'last_resort', 'none')),
+    claimed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_prekey_claims_target_time
+ON prekey_claims(target_user_id, claimed_at);
+
+CREATE INDEX IF NOT EXISTS idx_prekey_claims_claimed_at
+ON prekey_claims(claimed_at);
+
+COMMENT ON TABLE last_resort_prekeys IS 'Signed last-resort Kyber/hybrid prekeys, reused when the one-time prekey pool is empty (PQXDH)';
+COMMENT ON TABLE prekey_claims IS 'Per-requester log of prekey bundle claims, used for the exhaustion anomaly score';
@@ -0,0 +1,20 @@
+-- Cipher Suites
+-- Each user advertises the cipher suites (KEM + signature + AEAD + KDF
+-- combinations from the server's registry) their clients support, in
+-- preference order. Bundles carry this list so initiators can negotiate a
+-- suite, and suites past their retirement deadline are filtered out on read.
+
+CREATE TABLE IF NOT EXISTS user_cipher_suites (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Registry suite ID (see internal/crypto/suites.go)
+    suite_id INTEGER NOT NULL CHECK (suite_id BETWEEN 1 AND 65535),
+    -- 0 = most preferred
+    priority INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, suite_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_cipher_suites_priority
+ON user_cipher_suites(user_id, priority);
+
+COMMENT ON TABLE user_cipher_suites IS 'Cipher suites each user advertises in their prekey bundle, in preference order';
@@ -0,0 +1,134 @@
+-- Explicit Key Types and Companion Keys (ML-KEM / ML-DSA dual publish)
+-- The round-3 Kyber1024/Dilithium3 keys and the final FIPS 203/204 ML-KEM and
+-- ML-DSA keys share sizes (1568 and 1952 bytes), so keys can no longer be told
+-- apart by length. Every key row now carries a key_type; existing rows are
+-- tagged with the legacy type their length implied.
+--
+-- During a migration a user publishes a companion identity key and signed
+-- prekey of the new type next to their primary ones. Companion identity keys
+-- are signed by the primary identity key.
+
+-- ============================================================================
+-- Identity keys
+-- ============================================================================
+
+ALTER TABLE identity_keys ADD COLUMN IF NOT EXISTS key_type VARCHAR(20);
+UPDATE identity_keys
+SET key_type = CASE WHEN octet_length(dilithium_public_key) = 65 THEN 'p256' ELSE 'dilithium3' END
+WHERE key_type IS NULL;
+ALTER TABLE identity_keys ALTER COLUMN key_type SET NOT NULL;
+
+ALTER TABLE identity_keys ADD COLUMN IF NOT EXISTS is_companion BOOLEAN NOT NULL DEFAULT FALSE;
+-- Primary identity key's signature over key_type || 0x00 || public key (companions only)
+ALTER TABLE identity_keys ADD COLUMN IF NOT EXISTS companion_signature BYTEA;
+
+-- One active primary and at most one active companion per user
+DROP INDEX IF EXISTS idx_identity_keys_unique_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_identity_keys_unique_active
+ON identity_keys(user_id, is_companion)
+WHERE status = 'active';
+
+-- ============================================================================
+-- Prekeys
+-- ============================================================================
+
+-- For hybrid prekeys key_type names the PQ component; the EC component is always X25519
+ALTER TABLE signed_prekeys ADD COLUMN IF NOT EXISTS key_type VARCHAR(20);
+UPDATE signed_prekeys
+SET key_type = CASE
+    WHEN ec_public_key IS NOT NULL THEN 'kyber1024'
+    WHEN octet_length(kyber_public_key) = 65 THEN 'p256'
+    WHEN octet_length(kyber_public_key) = 32 THEN 'x25519'
+    ELSE 'kyber1024'
+END
+WHERE key_type IS NULL;
+ALTER TABLE signed_prekeys ALTER COLUMN key_type SET NOT NULL;
+
+ALTER TABLE signed_prekeys ADD COLUMN IF NOT EXISTS is_companion BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE one_time_prekeys ADD COLUMN IF NOT EXISTS key_type VARCHAR(20);
+UPDATE one_time_prekeys
+SET key_type = CASE
+    WHEN ec_public_key IS NOT NULL THEN 'kyber1024'
+    WHEN octet_length(kyber_public_key) = 65 THEN 'p256'
+    WHEN octet_length(kyber_public_key) = 32 THEN 'x25519'
+    ELSE 'kyber1024'
+END
+WHERE key_type IS NULL;
+ALTER TABLE one_time_prekeys ALTER COLUMN key_type SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_one_time_prekeys_type
+ON one_time_prekeys(user_id, key_type, hybrid_version)
+WHERE status = 'available';
+
+ALTER TABLE last_resort_prekeys ADD COLUMN IF NOT EXISTS key_type VARCHAR(20) NOT NULL DEFAULT 'kyber1024';
+ALTER TABLE last_resort_prekeys ALTER COLUMN key_type DROP DEFAULT;
+
+-- One active last-resort key per user, kind and key type
+DROP INDEX IF EXISTS idx_last_resort_prekeys_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_last_resort_prekeys_active
+ON last_resort_prekeys(user_id, hybrid_version, key_type)
+WHERE status = 'active';
+
+-- Legacy claims never hand out ML-KEM keys; those are claimed by type
+CREATE OR REPLACE FUNCTION claim_one_time_prekey(
+    target_user_id UUID,
+    claiming_user_id UUID
+) RETURNS TABLE (
+    prekey_id UUID,
+    key_id INTEGER,
+    kyber_public_key BYTEA,
+    ec_public_key BYTEA,
+    hybrid_version INTEGER
+) AS $$
+DECLARE
+    claimed_key RECORD;
+BEGIN
+    -- Atomically select and mark a prekey as used
+    UPDATE one_time_prekeys otp
+    SET status = 'used',
+        used_by = claiming_user_id,
+        used_at = NOW()
+    WHERE otp.id = (
+        SELECT id FROM one_time_prekeys
+        WHERE user_id = target_user_id
+          AND status = 'available'
+          AND key_type IN ('p256', 'x25519', 'kyber1024')
+          AND (expires_at IS NULL OR expires_at > NOW())
+        ORDER BY created_at ASC
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING otp.id, otp.key_id, otp.kyber_public_key, otp.ec_public_key, COALESCE(otp.hybrid_version, 1)
+    INTO claimed_key;
+
+    IF claimed_key IS NULL THEN
+        RETURN;
+    END IF;
+
+    prekey_id := claimed_key.id;
+    key_id := claimed_key.key_id;
+    kyber_public_key := claimed_key.kyber_public_key;
+    ec_public_key := claimed_key.ec_public_key;
+    hybrid_version := claimed_key.hybrid_version;
+    RETURN NEXT;
+END;
+$$ LANGUAGE plpgsql;
+
+-- ============================================================================
+-- Key directory
+-- ============================================================================
+
+ALTER TABLE key_directory_entries ADD COLUMN IF NOT EXISTS identity_key_type VARCHAR(20);
+ALTER TABLE key_directory_entries ADD COLUMN IF NOT EXISTS signed_prekey_type VARCHAR(20);
+-- [{"role": ..., "key_type": ..., "fingerprint": ...}] for companion keys
+ALTER TABLE key_directory_entries ADD COLUMN IF NOT EXISTS companion_keys JSONB;
+-- 0 = legacy leaf hash, 1 = typed, length-prefixed leaf hash
+ALTER TABLE key_directory_entries ADD COLUMN IF NOT EXISTS leaf_version INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN identity_keys.key_type IS 'p256, dilithium3 or ml-dsa-65';
+COMMENT ON COLUMN identity_keys.is_companion IS 'Companion key published alongside the primary during an algorithm migration';
+COMMENT ON COLUMN signed_prekeys.key_type IS 'p256, x25519, kyber1024, ml-kem-768 or ml-kem-1024 (PQ component for hybrid keys)';
+COMMENT ON COLUMN one_time_prekeys.key_type IS 'p256, x25519, kyber1024, ml-kem-768 or ml-kem-1024 (PQ component for hybrid keys)';
+COMMENT ON COLUMN last_resort_prekeys.key_type IS 'kyber1024, ml-kem-768 or ml-kem-1024';
+COMMENT ON COLUMN key_directory_entries.leaf_version IS 'Leaf hash format: 0 = legacy, 1 = typed and length-prefixed';
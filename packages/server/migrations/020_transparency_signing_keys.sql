@@ -0,0 +1,33 @@
+-- Pluggable Transparency Signing Keys and Rotation
+-- The tree head signing key can now live in a file, a PKCS#11 token or a
+-- remote KMS. Each registered key records which backend holds it.
+--
+-- Rotation no longer overwrites the previous key: when a new key is
+-- registered the old one is marked 'rotated' and keeps a validity window that
+-- overlaps its successor's, so tree heads it signed (including any signed by
+-- instances still running with it during a rollout) continue to verify.
+
+ALTER TABLE transparency_signing_keys ADD COLUMN IF NOT EXISTS backend VARCHAR(20) NOT NULL DEFAULT 'file';
+ALTER TABLE transparency_signing_keys ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE transparency_signing_keys DROP CONSTRAINT IF EXISTS transparency_signing_keys_backend_check;
+ALTER TABLE transparency_signing_keys ADD CONSTRAINT transparency_signing_keys_backend_check
+    CHECK (backend IN ('file', 'pkcs11', 'kms'));
+
+-- A key's validity window must not be empty
+ALTER TABLE transparency_signing_keys DROP CONSTRAINT IF EXISTS transparency_signing_keys_validity_check;
+ALTER TABLE transparency_signing_keys ADD CONSTRAINT transparency_signing_keys_validity_check
+    CHECK (valid_until IS NULL OR valid_until > valid_from);
+
+-- Verifiers look keys up by fingerprint and the STH timestamp
+CREATE INDEX IF NOT EXISTS idx_signing_keys_validity
+ON transparency_signing_keys(valid_from, valid_until)
+WHERE status <> 'revoked';
+
+-- Tree heads used to be stored with the transaction's NOW(), which is not the
+-- timestamp that was signed; stored rows are left as-is and new epochs store
+-- the signed timestamp in created_at.
+
+COMMENT ON COLUMN transparency_signing_keys.backend IS 'Where the private key lives: file, pkcs11 or kms';
+COMMENT ON COLUMN transparency_signing_keys.rotated_at IS 'When a successor key was registered';
+COMMENT ON COLUMN transparency_signing_keys.valid_until IS 'End of the window in which tree heads signed by this key are accepted; overlaps the successor key';
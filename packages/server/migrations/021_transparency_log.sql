@@ -0,0 +1,53 @@
+-- Append-only Log of Signed Tree Heads (RFC 6962)
+-- The sparse Merkle map is rebuilt per epoch, so on its own it cannot show
+-- that the server has not equivocated (served different roots to different
+-- clients). Each epoch's signed tree head is appended as a leaf of an
+-- append-only Merkle log; gossipers compare log heads and check RFC 6962
+-- consistency proofs between them.
+
+-- ============================================================================
+-- Log Leaves
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS transparency_log_entries (
+    -- Position in the log (0-based, dense)
+    leaf_index BIGINT PRIMARY KEY CHECK (leaf_index >= 0),
+    -- Epoch whose signed tree head this leaf commits to
+    epoch_number BIGINT NOT NULL UNIQUE REFERENCES transparency_epochs(epoch_number),
+    -- Encoded leaf (EncodeLogLeaf): version || STH signed data || signature || key fingerprint
+    leaf_data BYTEA NOT NULL,
+    -- SHA-256(0x00 || leaf_data)
+    leaf_hash BYTEA NOT NULL CHECK (octet_length(leaf_hash) = 32),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- ============================================================================
+-- Perfect Subtree Hashes
+-- ============================================================================
+
+-- Node (level, index) covers leaves [index * 2^level, (index + 1) * 2^level).
+-- Only complete subtrees are stored; they never change once written.
+CREATE TABLE IF NOT EXISTS transparency_log_nodes (
+    level SMALLINT NOT NULL CHECK (level >= 0 AND level < 64),
+    node_index BIGINT NOT NULL CHECK (node_index >= 0),
+    node_hash BYTEA NOT NULL CHECK (octet_length(node_hash) = 32),
+    PRIMARY KEY (level, node_index)
+);
+
+-- ============================================================================
+-- Signed Log Heads
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS transparency_log_heads (
+    tree_size BIGINT PRIMARY KEY CHECK (tree_size >= 0),
+    root_hash BYTEA NOT NULL CHECK (octet_length(root_hash) = 32),
+    -- Signature over LogTreeHeadData (domain-separated from epoch STHs)
+    signature BYTEA NOT NULL,
+    signing_key_fingerprint VARCHAR(64) NOT NULL,
+    -- The signed timestamp (whole seconds)
+    signed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+COMMENT ON TABLE transparency_log_entries IS 'Append-only RFC 6962 log leaves, one per signed epoch';
+COMMENT ON TABLE transparency_log_nodes IS 'Hashes of complete subtrees of the append-only log';
+COMMENT ON TABLE transparency_log_heads IS 'Signed heads of the append-only log, one per tree size';
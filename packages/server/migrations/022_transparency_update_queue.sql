@@ -0,0 +1,40 @@
+-- Durable Transparency Update Queue
+-- Key updates used to sit in an in-memory slice until the next batch, so a
+-- crash between a key upload and the batch lost the update: the key existed
+-- in identity_keys but never reached the tree. transparency_pending_updates
+-- is now the queue itself. Batches claim due rows with
+-- SELECT ... FOR UPDATE SKIP LOCKED, failed updates are retried with backoff,
+-- and updates that keep failing are dead-lettered.
+
+-- Typed leaf fields, so a replayed update produces the same leaf as the original
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS identity_key_type VARCHAR(20);
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS signed_prekey_type VARCHAR(20);
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS companion_keys JSONB;
+
+-- Queue state
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'pending';
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+ALTER TABLE transparency_pending_updates ADD COLUMN IF NOT EXISTS dead_at TIMESTAMP WITH TIME ZONE;
+
+-- Rows written by the old batch processor were audit records of already-applied updates
+UPDATE transparency_pending_updates SET status = 'processed' WHERE processed = true AND status = 'pending';
+
+ALTER TABLE transparency_pending_updates DROP CONSTRAINT IF EXISTS transparency_pending_updates_status_check;
+ALTER TABLE transparency_pending_updates ADD CONSTRAINT transparency_pending_updates_status_check
+    CHECK (status IN ('pending', 'processed', 'dead'));
+
+-- Claim order for the batch processor
+DROP INDEX IF EXISTS idx_pending_updates_unprocessed;
+CREATE INDEX IF NOT EXISTS idx_pending_updates_due
+ON transparency_pending_updates(created_at)
+WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_pending_updates_dead
+ON transparency_pending_updates(dead_at)
+WHERE status = 'dead';
+
+COMMENT ON COLUMN transparency_pending_updates.status IS 'pending (queued or awaiting retry), processed, or dead (gave up after repeated failures)';
+COMMENT ON COLUMN transparency_pending_updates.attempts IS 'Failed applyUpdate attempts so far';
+COMMENT ON COLUMN transparency_pending_updates.next_attempt_at IS 'Earliest time the update may be claimed again (retry backoff)';
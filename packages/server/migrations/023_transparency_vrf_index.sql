@@ -0,0 +1,63 @@
+-- VRF-based Private Index for the Key Directory
+-- Users used to sit at SHA-256(user_id) in the tree and every leaf carried
+-- the raw user ID, so anyone holding a proof or the leaf data could enumerate
+-- which users are in the directory and probe for others. The tree index is
+-- now the output of an ECVRF-EDWARDS25519-SHA512-TAI (RFC 9381) keyed by the
+-- server, and leaves carry a hiding commitment to the user ID instead.
+--
+-- Existing entries keep their SHA-256 index (index_version 0) until the batch
+-- processor re-indexes them through the update queue as 'key_reindexed'.
+
+-- ============================================================================
+-- VRF Key
+-- ============================================================================
+
+-- Changing the VRF key moves every user, so at most one key is active
+CREATE TABLE IF NOT EXISTS transparency_vrf_keys (
+    fingerprint VARCHAR(64) PRIMARY KEY,
+    public_key BYTEA NOT NULL CHECK (octet_length(public_key) = 32),
+    suite VARCHAR(40) NOT NULL DEFAULT 'ECVRF-EDWARDS25519-SHA512-TAI',
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'retired')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_vrf_keys_one_active
+ON transparency_vrf_keys(status)
+WHERE status = 'active';
+
+-- ============================================================================
+-- Directory Entries
+-- ============================================================================
+
+ALTER TABLE key_directory_entries ADD COLUMN IF NOT EXISTS index_version INTEGER NOT NULL DEFAULT 0;
+-- Proof that user_id_hash is the VRF output for user_id (80 bytes)
+ALTER TABLE key_directory_entries ADD COLUMN IF NOT EXISTS vrf_proof BYTEA;
+-- Opens the leaf's user ID commitment: SHA-256(user_id || nonce)
+ALTER TABLE key_directory_entries ADD COLUMN IF NOT EXISTS commitment_nonce BYTEA;
+
+ALTER TABLE key_directory_entries DROP CONSTRAINT IF EXISTS key_directory_entries_vrf_index_check;
+ALTER TABLE key_directory_entries ADD CONSTRAINT key_directory_entries_vrf_index_check
+    CHECK (index_version = 0 OR (octet_length(vrf_proof) = 80 AND octet_length(commitment_nonce) = 32));
+
+-- Entries still waiting to be re-indexed
+CREATE INDEX IF NOT EXISTS idx_key_directory_legacy_index
+ON key_directory_entries(user_id)
+WHERE index_version = 0;
+
+-- ============================================================================
+-- Re-index Updates
+-- ============================================================================
+
+ALTER TABLE transparency_pending_updates DROP CONSTRAINT IF EXISTS transparency_pending_updates_update_type_check;
+ALTER TABLE transparency_pending_updates ADD CONSTRAINT transparency_pending_updates_update_type_check
+    CHECK (update_type IN ('key_added', 'key_updated', 'key_revoked', 'key_reindexed'));
+
+ALTER TABLE transparency_audit_log DROP CONSTRAINT IF EXISTS transparency_audit_log_change_type_check;
+ALTER TABLE transparency_audit_log ADD CONSTRAINT transparency_audit_log_change_type_check
+    CHECK (change_type IN ('key_added', 'key_updated', 'key_revoked', 'key_reindexed'));
+
+COMMENT ON TABLE transparency_vrf_keys IS 'VRF public keys that derive private tree indexes';
+COMMENT ON COLUMN key_directory_entries.index_version IS 'Tree index derivation: 0 = SHA-256(user_id), 1 = VRF';
+COMMENT ON COLUMN key_directory_entries.leaf_version IS 'Leaf hash format: 0 = legacy, 1 = typed and length-prefixed, 2 = typed with user ID commitment';
+COMMENT ON COLUMN key_directory_entries.vrf_proof IS 'ECVRF proof that user_id_hash is the index for user_id';
+COMMENT ON COLUMN key_directory_entries.commitment_nonce IS 'Random nonce opening the leaf commitment SHA-256(user_id || nonce)';
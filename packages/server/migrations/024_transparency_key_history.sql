@@ -0,0 +1,112 @@
+-- Cumulative Epoch Trees, Key History and Non-existence Proofs
+-- Each epoch's tree used to contain only the leaves updated in that epoch, so
+-- a proof could only be built at the epoch a key was introduced and an empty
+-- slot proved nothing. Epoch trees are now cumulative: a node is stored only
+-- in the epoch where it changes, and the node as of epoch E is its latest row
+-- in [tree_base_epoch, E]. The first cumulative epoch rebuilds the full tree
+-- from key_directory_entries.
+--
+-- key_directory_history records every leaf ever published for a user so
+-- inclusion proofs can be served for any epoch and users can audit their
+-- whole key history.
+
+-- ============================================================================
+-- Cumulative Trees
+-- ============================================================================
+
+-- NULL for epochs signed before trees were cumulative
+ALTER TABLE transparency_epochs ADD COLUMN IF NOT EXISTS tree_base_epoch BIGINT;
+
+ALTER TABLE transparency_epochs DROP CONSTRAINT IF EXISTS transparency_epochs_tree_base_check;
+ALTER TABLE transparency_epochs ADD CONSTRAINT transparency_epochs_tree_base_check
+    CHECK (tree_base_epoch IS NULL OR tree_base_epoch <= epoch_number);
+
+-- Node-as-of-epoch lookups
+CREATE INDEX IF NOT EXISTS idx_merkle_nodes_path_epoch
+ON merkle_nodes(depth, path_prefix, epoch DESC);
+
+-- Non-existence proofs use the VRF index from the first epoch built with it
+ALTER TABLE transparency_vrf_keys ADD COLUMN IF NOT EXISTS first_epoch BIGINT;
+
+-- ============================================================================
+-- Key History
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS key_directory_history (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Epoch that introduced this leaf
+    epoch_number BIGINT NOT NULL,
+    update_type VARCHAR(20) NOT NULL,
+    key_version INTEGER NOT NULL,
+    identity_key_fingerprint VARCHAR(64) NOT NULL,
+    signed_prekey_fingerprint VARCHAR(64),
+    identity_key_type VARCHAR(20),
+    signed_prekey_type VARCHAR(20),
+    companion_keys JSONB,
+    leaf_version INTEGER NOT NULL DEFAULT 0,
+    -- The timestamp hashed into the leaf (Unix seconds)
+    leaf_timestamp BIGINT NOT NULL,
+    leaf_hash BYTEA NOT NULL CHECK (octet_length(leaf_hash) = 32),
+    -- Tree index and how it was derived (see key_directory_entries)
+    user_id_hash BYTEA NOT NULL CHECK (octet_length(user_id_hash) = 32),
+    index_version INTEGER NOT NULL DEFAULT 0,
+    vrf_proof BYTEA,
+    commitment_nonce BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, epoch_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_key_history_epoch ON key_directory_history(epoch_number);
+
+-- Backfill the current entries first: private leaves keep their commitment
+-- nonce only in key_directory_entries
+INSERT INTO key_directory_history (
+    user_id, epoch_number, update_type, key_version, identity_key_fingerprint,
+    signed_prekey_fingerprint, identity_key_type, signed_prekey_type, companion_keys,
+    leaf_version, leaf_timestamp, leaf_hash, user_id_hash, index_version, vrf_proof,
+    commitment_nonce
+)
+SELECT e.user_id, e.last_epoch, COALESCE(a.change_type, 'key_updated'), e.key_version,
+       e.identity_key_fingerprint, e.signed_prekey_fingerprint, e.identity_key_type,
+       e.signed_prekey_type, e.companion_keys, e.leaf_version,
+       (n.leaf_data->>'timestamp')::BIGINT, e.leaf_hash, e.user_id_hash, e.index_version,
+       e.vrf_proof, e.commitment_nonce
+FROM key_directory_entries e
+JOIN merkle_nodes n
+  ON n.epoch = e.last_epoch AND n.depth = 256
+ AND n.path_prefix = encode(e.user_id_hash, 'hex') AND n.leaf_data IS NOT NULL
+LEFT JOIN LATERAL (
+    SELECT change_type FROM transparency_audit_log
+    WHERE epoch_number = e.last_epoch AND new_leaf_hash = e.leaf_hash
+    LIMIT 1
+) a ON true
+ON CONFLICT (user_id, epoch_number) DO NOTHING;
+
+-- Then earlier versions from the leaf data kept in merkle_nodes; legacy and
+-- typed leaves carry the user ID
+INSERT INTO key_directory_history (
+    user_id, epoch_number, update_type, key_version, identity_key_fingerprint,
+    signed_prekey_fingerprint, identity_key_type, signed_prekey_type, companion_keys,
+    leaf_version, leaf_timestamp, leaf_hash, user_id_hash, index_version
+)
+SELECT (n.leaf_data->>'user_id')::UUID, n.epoch, COALESCE(a.change_type, 'key_updated'),
+       (n.leaf_data->>'key_version')::INTEGER, n.leaf_data->>'identity_key_fingerprint',
+       NULLIF(n.leaf_data->>'signed_prekey_fingerprint', ''),
+       NULLIF(n.leaf_data->>'identity_key_type', ''),
+       NULLIF(n.leaf_data->>'signed_prekey_type', ''),
+       n.leaf_data->'companion_keys', COALESCE((n.leaf_data->>'leaf_version')::INTEGER, 0),
+       (n.leaf_data->>'timestamp')::BIGINT, n.node_hash, decode(n.path_prefix, 'hex'), 0
+FROM merkle_nodes n
+LEFT JOIN LATERAL (
+    SELECT change_type FROM transparency_audit_log
+    WHERE epoch_number = n.epoch AND new_leaf_hash = n.node_hash
+    LIMIT 1
+) a ON true
+WHERE n.is_leaf AND n.depth = 256 AND n.leaf_data IS NOT NULL
+  AND COALESCE((n.leaf_data->>'leaf_version')::INTEGER, 0) < 2
+  AND EXISTS (SELECT 1 FROM users u WHERE u.id = (n.leaf_data->>'user_id')::UUID)
+ON CONFLICT (user_id, epoch_number) DO NOTHING;
+
+COMMENT ON COLUMN transparency_epochs.tree_base_epoch IS 'Epoch holding the full node set this epoch builds on; NULL for pre-cumulative epochs';
+COMMENT ON COLUMN transparency_vrf_keys.first_epoch IS 'First epoch whose tree uses this key''s VRF indexes';
+COMMENT ON TABLE key_directory_history IS 'Every leaf published for a user, keyed by the epoch that introduced it';
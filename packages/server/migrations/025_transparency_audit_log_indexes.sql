@@ -0,0 +1,38 @@
+-- Rebuildable Audit Log
+-- Audit log entries did not say which tree slot they changed, so an external
+-- auditor could not recompute epoch roots from them. Each entry now records
+-- the slot it wrote (leaf_index) and, for an entry that moved to a new index,
+-- the slot it cleared (old_leaf_index). seq orders entries within an epoch,
+-- where a later write to the same slot replaces an earlier one, and is the
+-- paging cursor for /api/transparency/audit-log.
+
+ALTER TABLE transparency_audit_log ADD COLUMN IF NOT EXISTS seq BIGSERIAL;
+ALTER TABLE transparency_audit_log ADD COLUMN IF NOT EXISTS leaf_index BYTEA
+    CHECK (leaf_index IS NULL OR length(leaf_index) = 32);
+ALTER TABLE transparency_audit_log ADD COLUMN IF NOT EXISTS old_leaf_index BYTEA
+    CHECK (old_leaf_index IS NULL OR length(old_leaf_index) = 32);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_epoch_seq ON transparency_audit_log(epoch_number, seq);
+
+-- Backfill slots from key history: the version an entry wrote gives its slot,
+-- and the user's previous version gives the slot it left if the index moved
+UPDATE transparency_audit_log a
+SET leaf_index = h.user_id_hash,
+    old_leaf_index = CASE
+        WHEN prev.user_id_hash IS NOT NULL AND prev.user_id_hash <> h.user_id_hash
+        THEN prev.user_id_hash
+    END
+FROM key_directory_history h
+LEFT JOIN LATERAL (
+    SELECT p.user_id_hash FROM key_directory_history p
+    WHERE p.user_id = h.user_id AND p.epoch_number < h.epoch_number
+    ORDER BY p.epoch_number DESC
+    LIMIT 1
+) prev ON true
+WHERE a.leaf_index IS NULL
+  AND h.epoch_number = a.epoch_number
+  AND h.leaf_hash = a.new_leaf_hash;
+
+COMMENT ON COLUMN transparency_audit_log.seq IS 'Order of entries within an epoch and paging cursor';
+COMMENT ON COLUMN transparency_audit_log.leaf_index IS 'Tree slot written by this change';
+COMMENT ON COLUMN transparency_audit_log.old_leaf_index IS 'Tree slot cleared when the entry moved to a new index';
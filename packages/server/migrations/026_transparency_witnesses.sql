@@ -0,0 +1,54 @@
+-- Witness Cosigning and Fork Evidence
+-- Independent witnesses cosign epoch tree heads, and clients gossip the heads
+-- they observed. Two validly signed heads for one epoch are proof the server
+-- showed different views of the directory and are kept as evidence.
+
+-- ============================================================================
+-- Witnesses
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS transparency_witnesses (
+    -- First 16 bytes of SHA-256(public_key), hex
+    fingerprint VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    -- Raw Ed25519 public key
+    public_key BYTEA NOT NULL CHECK (length(public_key) = 32),
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS transparency_cosignatures (
+    epoch_number BIGINT NOT NULL REFERENCES transparency_epochs(epoch_number) ON DELETE CASCADE,
+    witness_fingerprint VARCHAR(64) NOT NULL REFERENCES transparency_witnesses(fingerprint),
+    -- Signed witness timestamp (whole seconds)
+    cosigned_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    signature BYTEA NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (epoch_number, witness_fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS idx_cosignatures_epoch ON transparency_cosignatures(epoch_number DESC);
+
+-- ============================================================================
+-- Fork Evidence
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS transparency_forks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    epoch_number BIGINT NOT NULL,
+    -- Both heads in the log leaf encoding (EncodeLogLeaf), signatures included
+    published_head BYTEA NOT NULL,
+    conflicting_head BYTEA NOT NULL,
+    conflicting_head_hash BYTEA NOT NULL CHECK (length(conflicting_head_hash) = 32),
+    -- Who reported it: 'client' (user ID) or 'witness' (fingerprint)
+    source VARCHAR(20) NOT NULL CHECK (source IN ('client', 'witness')),
+    reporter VARCHAR(64) NOT NULL,
+    detected_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (epoch_number, conflicting_head_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_forks_detected ON transparency_forks(detected_at DESC);
+
+COMMENT ON TABLE transparency_witnesses IS 'Independent witnesses that cosign epoch tree heads';
+COMMENT ON TABLE transparency_cosignatures IS 'Witness cosignatures over epoch tree heads';
+COMMENT ON TABLE transparency_forks IS 'Pairs of validly signed, conflicting tree heads for one epoch';
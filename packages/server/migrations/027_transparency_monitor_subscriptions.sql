@@ -0,0 +1,17 @@
+-- Key-Change Monitoring Subscriptions
+-- Each client registers the users it talks to. After each epoch, the server
+-- pushes a key-change event with an inclusion proof to every subscriber of a
+-- user whose directory entry changed (and to the user themselves).
+
+CREATE TABLE IF NOT EXISTS transparency_monitor_subscriptions (
+    subscriber_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    watched_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (subscriber_id, watched_user_id),
+    CHECK (subscriber_id <> watched_user_id)
+);
+
+-- Fan-out lookup: who monitors the users changed in an epoch
+CREATE INDEX IF NOT EXISTS idx_monitor_subscriptions_watched ON transparency_monitor_subscriptions(watched_user_id);
+
+COMMENT ON TABLE transparency_monitor_subscriptions IS 'Users each client monitors for key directory changes';
@@ -0,0 +1,19 @@
+-- Merkle Node Retention
+-- merkle_nodes gained a delta for every epoch and was never pruned. Epochs
+-- now keep their nodes only while inside the retention window; snapshot
+-- epochs (tree_base_epoch = epoch_number) keep the full tree and are never
+-- pruned. A pruned epoch's tree is rebuilt from its snapshot and the audit log.
+
+ALTER TABLE transparency_epochs ADD COLUMN IF NOT EXISTS node_status VARCHAR(20) NOT NULL DEFAULT 'present';
+ALTER TABLE transparency_epochs ADD COLUMN IF NOT EXISTS nodes_pruned_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE transparency_epochs DROP CONSTRAINT IF EXISTS transparency_epochs_node_status_check;
+ALTER TABLE transparency_epochs ADD CONSTRAINT transparency_epochs_node_status_check
+    CHECK (node_status IN ('present', 'pruning', 'pruned'));
+
+-- Compaction picks up epochs marked on an earlier pass
+CREATE INDEX IF NOT EXISTS idx_epochs_pruning ON transparency_epochs(nodes_pruned_at)
+    WHERE node_status = 'pruning';
+
+COMMENT ON COLUMN transparency_epochs.node_status IS 'present: nodes in merkle_nodes; pruning: marked, nodes deleted on the next compaction; pruned: rebuilt from its snapshot on demand';
+COMMENT ON COLUMN transparency_epochs.nodes_pruned_at IS 'When the epoch was marked for pruning';
@@ -0,0 +1,14 @@
+-- Committed Epoch Salts
+-- Audit log user-ID commitments were salted with "epoch-N-timestamp", which
+-- anyone could guess. Salts are now derived from a server secret with HKDF
+-- and each epoch's tree head signs a hash of its salt, so the salt can be
+-- revealed to auditors after a delay and checked against the signed head.
+-- Epochs signed before this, or without a secret, have no commitment.
+
+ALTER TABLE transparency_epochs ADD COLUMN IF NOT EXISTS salt_commitment BYTEA;
+
+ALTER TABLE transparency_epochs DROP CONSTRAINT IF EXISTS transparency_epochs_salt_commitment_check;
+ALTER TABLE transparency_epochs ADD CONSTRAINT transparency_epochs_salt_commitment_check
+    CHECK (salt_commitment IS NULL OR length(salt_commitment) = 32);
+
+COMMENT ON COLUMN transparency_epochs.salt_commitment IS 'SHA-256 commitment to the epoch salt, signed in the tree head; NULL for random, never-revealed salts';
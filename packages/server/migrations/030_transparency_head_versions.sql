@@ -0,0 +1,13 @@
+-- Tree Head Versions
+-- Tree heads were signed over a fixed 56-byte layout with no version tag,
+-- domain separation or signing key binding. New heads are signed over their
+-- canonical CBOR encoding instead (head_version 2); existing heads keep the
+-- raw layout (head_version 1) and verify as before.
+
+ALTER TABLE transparency_epochs ADD COLUMN IF NOT EXISTS head_version SMALLINT NOT NULL DEFAULT 1;
+
+ALTER TABLE transparency_epochs DROP CONSTRAINT IF EXISTS transparency_epochs_head_version_check;
+ALTER TABLE transparency_epochs ADD CONSTRAINT transparency_epochs_head_version_check
+    CHECK (head_version IN (1, 2));
+
+COMMENT ON COLUMN transparency_epochs.head_version IS 'Signature format: 1 signs the fixed TreeHeadData layout, 2 the domain-separated canonical encoding';
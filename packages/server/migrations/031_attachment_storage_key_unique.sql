@@ -0,0 +1,10 @@
+-- Unique Attachment Storage Keys
+-- Downloads resolve a storage key back to exactly one attachment (and through
+-- it, one conversation), so a blob key may only ever be attached once, either
+-- as a file or as a thumbnail. Replaces the plain lookup indexes from 013.
+
+DROP INDEX IF EXISTS idx_attachments_storage_key;
+DROP INDEX IF EXISTS idx_attachments_thumbnail_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_attachments_storage_key_unique ON attachments(storage_key);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_attachments_thumbnail_key_unique ON attachments(thumbnail_key) WHERE thumbnail_key IS NOT NULL;
@@ -0,0 +1,31 @@
+-- Unlinked Index Moves
+-- Entries re-indexed from SHA-256(user_id) to a VRF index were logged with
+-- both slots (leaf_index and old_leaf_index). The old slot is computable from
+-- the user ID, so the public audit log tied users to their private slots.
+-- Cleared slots are now separate 'index_cleared' entries with no user
+-- commitment, written after the epoch's other entries in slot order, and the
+-- moved entry carries neither the old slot nor the leaf hash stored there.
+
+ALTER TABLE transparency_audit_log DROP CONSTRAINT IF EXISTS transparency_audit_log_change_type_check;
+ALTER TABLE transparency_audit_log ADD CONSTRAINT transparency_audit_log_change_type_check
+    CHECK (change_type IN ('key_added', 'key_updated', 'key_revoked', 'key_reindexed', 'index_cleared'));
+
+ALTER TABLE transparency_audit_log ALTER COLUMN user_id_commitment DROP NOT NULL;
+ALTER TABLE transparency_audit_log DROP CONSTRAINT IF EXISTS transparency_audit_log_commitment_check;
+ALTER TABLE transparency_audit_log ADD CONSTRAINT transparency_audit_log_commitment_check
+    CHECK ((change_type = 'index_cleared') = (user_id_commitment IS NULL));
+
+-- Split existing moves. The new rows get later seq values than every entry of
+-- their epoch, and clearing a legacy slot commutes with writes to VRF slots,
+-- so replaying the log still yields each epoch's signed root.
+INSERT INTO transparency_audit_log (id, epoch_number, change_type, leaf_index, created_at)
+SELECT gen_random_uuid(), a.epoch_number, 'index_cleared', a.old_leaf_index, a.created_at
+FROM transparency_audit_log a
+WHERE a.old_leaf_index IS NOT NULL
+ORDER BY a.epoch_number, a.old_leaf_index;
+
+UPDATE transparency_audit_log
+SET old_leaf_index = NULL, old_leaf_hash = NULL
+WHERE old_leaf_index IS NOT NULL;
+
+COMMENT ON COLUMN transparency_audit_log.old_leaf_index IS 'Unused: cleared slots are logged as separate index_cleared entries';
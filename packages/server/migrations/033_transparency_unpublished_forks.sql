@@ -0,0 +1,8 @@
+-- Unpublished-Epoch Forks
+-- A validly signed head for an epoch the server does not publish (a hidden
+-- fork or a rolled-back epoch) is equivocation evidence too. It is recorded
+-- with no published head.
+
+ALTER TABLE transparency_forks ALTER COLUMN published_head DROP NOT NULL;
+
+COMMENT ON COLUMN transparency_forks.published_head IS 'Head published for the epoch, or NULL if the epoch was never published';
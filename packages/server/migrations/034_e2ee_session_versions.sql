@@ -0,0 +1,13 @@
+-- Session Backup Versions
+-- Session backup writes were compare-and-swap on the chain indexes, so a write
+-- that changed the encrypted state without advancing either index could
+-- silently replace another device's state. Every write now bumps a version
+-- that the CAS compares instead. Deletes keep the row as a tombstone so the
+-- version keeps increasing and a writer holding a pre-delete version cannot
+-- resurrect stale state.
+
+ALTER TABLE e2ee_sessions ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE e2ee_sessions ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+COMMENT ON COLUMN e2ee_sessions.version IS 'Incremented on every write and delete; session backup writes compare against it';
+COMMENT ON COLUMN e2ee_sessions.deleted_at IS 'Set when the backup was deleted; the row is kept so the version never repeats';
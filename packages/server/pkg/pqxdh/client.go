@@ -0,0 +1,162 @@
+package pqxdh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kindlyrobotics/nochat/internal/crypto"
+)
+
+// Client fetches prekey bundles from a nochat server
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the server at baseURL, authenticating with a session token
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// bundleKeyResponse is a key object in a bundle response (byte fields are base64)
+type bundleKeyResponse struct {
+	KeyID       int    `json:"key_id"`
+	KeyType     string `json:"key_type"`
+	PublicKey   []byte `json:"public_key"`
+	ECPublicKey []byte `json:"ec_public_key"`
+	PQPublicKey []byte `json:"pq_public_key"`
+	Signature   []byte `json:"signature"`
+}
+
+// bundleResponse is the body of GET /api/crypto/bundles/{user_id}/sealed
+type bundleResponse struct {
+	UserID           uuid.UUID          `json:"user_id"`
+	IdentityKey      *bundleKeyResponse `json:"identity_key"`
+	SignedPreKey     *bundleKeyResponse `json:"signed_prekey"`
+	OneTimePreKey    *bundleKeyResponse `json:"one_time_prekey"`
+	LastResortPreKey *bundleKeyResponse `json:"last_resort_prekey"`
+	SealedSenderKey  *struct {
+		KyberPublicKey []byte `json:"kyber_public_key"`
+	} `json:"sealed_sender_key"`
+	DeliveryVerifier []byte `json:"delivery_verifier"`
+}
+
+// FetchBundle fetches and verifies a user's hybrid bundle with their sealed
+// sender key. Each call claims one of the user's one-time prekeys.
+func (c *Client) FetchBundle(ctx context.Context, userID uuid.UUID) (*Bundle, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/crypto/bundles/%s/sealed", c.baseURL, userID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to fetch bundle: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var body bundleResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode bundle: %w", err)
+	}
+
+	bundle, err := body.toBundle()
+	if err != nil {
+		return nil, err
+	}
+	if err := bundle.Verify(); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// toBundle converts a bundle response, rejecting non-hybrid bundles
+func (r *bundleResponse) toBundle() (*Bundle, error) {
+	if r.IdentityKey == nil || r.SignedPreKey == nil || r.SignedPreKey.ECPublicKey == nil {
+		return nil, ErrNotHybridBundle
+	}
+
+	identityKeyType, err := crypto.ResolveKeyType(r.IdentityKey.KeyType, r.IdentityKey.PublicKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("identity key: %w", err)
+	}
+
+	signedPreKey, err := r.SignedPreKey.toPreKey()
+	if err != nil {
+		return nil, fmt.Errorf("signed prekey: %w", err)
+	}
+
+	bundle := &Bundle{
+		UserID:           r.UserID,
+		IdentityKeyType:  identityKeyType,
+		IdentityKey:      r.IdentityKey.PublicKey,
+		SignedPreKey:     *signedPreKey,
+		DeliveryVerifier: r.DeliveryVerifier,
+	}
+	if r.OneTimePreKey != nil && r.OneTimePreKey.ECPublicKey != nil {
+		if bundle.OneTimePreKey, err = r.OneTimePreKey.toPreKey(); err != nil {
+			return nil, fmt.Errorf("one-time prekey: %w", err)
+		}
+	}
+	if r.LastResortPreKey != nil && r.LastResortPreKey.ECPublicKey != nil {
+		if bundle.LastResortPreKey, err = r.LastResortPreKey.toPreKey(); err != nil {
+			return nil, fmt.Errorf("last-resort prekey: %w", err)
+		}
+	}
+	if r.SealedSenderKey != nil {
+		bundle.SealedSenderKey = r.SealedSenderKey.KyberPublicKey
+	}
+	return bundle, nil
+}
+
+// toPreKey converts a hybrid prekey response; untagged PQ keys are Kyber1024
+func (k *bundleKeyResponse) toPreKey() (*PreKey, error) {
+	keyType, err := crypto.ResolveKeyType(k.KeyType, k.PQPublicKey, false)
+	if err != nil {
+		return nil, err
+	}
+	return &PreKey{
+		KeyID:       k.KeyID,
+		KeyType:     keyType,
+		ECPublicKey: k.ECPublicKey,
+		PQPublicKey: k.PQPublicKey,
+		Signature:   k.Signature,
+	}, nil
+}
+
+// StartSession fetches a user's bundle, runs the handshake and returns the
+// initiator's session, the initial message, and the bundle for sealing
+func (c *Client) StartSession(ctx context.Context, identity *IdentityKeys, userID uuid.UUID) (*Session, *InitialMessage, *Bundle, error) {
+	bundle, err := c.FetchBundle(ctx, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	result, err := Initiate(identity, bundle)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	session, err := NewSession(result, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return session, result.Message, bundle, nil
+}
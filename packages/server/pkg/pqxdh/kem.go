@@ -0,0 +1,78 @@
+package pqxdh
+
+import (
+	"fmt"
+
+	"github.com/cloudflare/circl/kem"
+	"github.com/cloudflare/circl/kem/kyber/kyber1024"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem1024"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem768"
+	"github.com/kindlyrobotics/nochat/internal/crypto"
+)
+
+// KEM seed sizes
+const (
+	KEMKeySeedSize        = 64 // Key pair derivation seed (Kyber1024 and ML-KEM)
+	EncapsulationSeedSize = 32 // Encapsulation randomness (Kyber1024 and ML-KEM)
+)
+
+// kemScheme returns the circl scheme for a PQ prekey type
+func kemScheme(keyType crypto.KeyType) (kem.Scheme, error) {
+	switch keyType {
+	case crypto.KeyTypeKyber1024:
+		return kyber1024.Scheme(), nil
+	case crypto.KeyTypeMLKEM768:
+		return mlkem768.Scheme(), nil
+	case crypto.KeyTypeMLKEM1024:
+		return mlkem1024.Scheme(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s is not a PQ prekey type", ErrUnsupportedKeyType, keyType)
+	}
+}
+
+// KEMKeyPairFromSeed derives a PQ prekey pair from a 64-byte seed
+func KEMKeyPairFromSeed(keyType crypto.KeyType, seed []byte) (publicKey, privateKey []byte, err error) {
+	scheme, err := kemScheme(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(seed) != scheme.SeedSize() {
+		return nil, nil, fmt.Errorf("invalid %s seed size: expected %d, got %d", keyType, scheme.SeedSize(), len(seed))
+	}
+
+	pk, sk := scheme.DeriveKeyPair(seed)
+	if publicKey, err = pk.MarshalBinary(); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	if privateKey, err = sk.MarshalBinary(); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	return publicKey, privateKey, nil
+}
+
+// encapsulate encapsulates to a PQ prekey with a 32-byte seed
+func encapsulate(keyType crypto.KeyType, publicKey, seed []byte) (ciphertext, sharedSecret []byte, err error) {
+	scheme, err := kemScheme(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pk, err := scheme.UnmarshalBinaryPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s public key: %w", keyType, err)
+	}
+
+	ciphertext, sharedSecret, err = scheme.EncapsulateDeterministically(pk, seed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encapsulate to %s key: %w", keyType, err)
+	}
+	return ciphertext, sharedSecret, nil
+}
+
+// decapsulate recovers the shared secret for a PQ prekey
+func decapsulate(keyType crypto.KeyType, privateKey, ciphertext []byte) ([]byte, error) {
+	if keyType == crypto.KeyTypeKyber1024 {
+		return crypto.Decapsulate(privateKey, ciphertext)
+	}
+	return crypto.DecapsulateMLKEM(keyType, privateKey, ciphertext)
+}
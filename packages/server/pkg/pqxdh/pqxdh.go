@@ -0,0 +1,485 @@
+/*
+Package pqxdh is a Go reference client for the hybrid PQXDH handshake and
+sealed sender envelopes.
+
+It fetches a peer's bundle over the HTTP API, verifies the signed prekey with
+the peer's identity key, encapsulates to the PQ prekeys, derives the shared
+secret with crypto.DeriveKey, and encrypts messages and sealed envelopes.
+Every randomized step has a ...With variant taking explicit secrets, which is
+what vectors.go uses to pin outputs.
+
+SCOPE:
+Sealed envelopes and delivery tokens use the same wire format as
+packages/web/src/crypto and are interoperable. The handshake is not: it is
+this package's own derivation (see DH2 below), a Go client can only complete
+it with another Go client, and its vectors are regression vectors for this
+package rather than a specification of the web client.
+
+HANDSHAKE (initiator A, responder B):
+  - DH1 = X25519(IK_A, SPK_B.ec)
+  - DH3 = X25519(EK_A, SPK_B.ec)
+  - DH4 = X25519(EK_A, OPK_B.ec)       if a one-time or last-resort prekey is used
+  - (CT1, SS1) = Encaps(SPK_B.pq)
+  - (CT2, SS2) = Encaps(OPK_B.pq)      if a one-time or last-resort prekey is used
+  - SK = HKDF-SHA256(0xFF*32 || DH1 || DH3 || [DH4] || SS1 || [SS2],
+    salt = 0x00*32, info = "NoChat PQXDH v1", 32 bytes)
+  - AD = IK_A || IK_B
+
+IK_A is the initiator's X25519 identity exchange key, sent in the initial
+message. Published identity keys (P-256, Dilithium3, ML-DSA-65) are signing
+keys with no X25519 counterpart on the server, so Signal's DH2 = X25519(EK_A,
+IK_B) is omitted. The web client's pqxdh.ts still feeds a DH2 into kdfPQXDH,
+computed from the first 32 bytes of the identity signing key as if it were an
+X25519 key. That is not a key agreement this package can reproduce (the
+initiator and responder halves don't correspond for signing keys), so the
+two SKs differ and no attempt is made to match it. The PQ KEM is chosen by
+the prekey's key_type: round-3 Kyber1024 for "kyber1024", FIPS 203 for
+"ml-kem-768" and "ml-kem-1024".
+
+MESSAGES:
+Given the same SK, the first symmetric chain is seeded like the web client's
+createSessionFromPQXDH and ratchetEncrypt: HKDF(SK, "nochat-ratchet-v1")
+gives root || initiator chain || responder chain, and each message key is
+HKDF(chain, "nochat-chain-v1")[:32] under XChaCha20-Poly1305 with AD. The DH
+ratchet step is out of scope.
+
+See /docs/crypto-inventory.md for the full protocol inventory.
+*/
+package pqxdh
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/kindlyrobotics/nochat/internal/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Protocol labels, shared with packages/web/src/crypto
+const (
+	pqxdhInfo   = "NoChat PQXDH v1"
+	ratchetInfo = "nochat-ratchet-v1"
+	chainInfo   = "nochat-chain-v1"
+
+	paddingByte   = 0xFF
+	paddingLength = 32
+)
+
+var (
+	// ErrUnsupportedKeyType is returned for key types the handshake can't use
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+	// ErrNotHybridBundle is returned for bundles without an X25519 signed prekey
+	ErrNotHybridBundle = errors.New("bundle is not a hybrid PQXDH bundle")
+	// ErrInvalidPreKeySignature is returned when a prekey isn't signed by the identity key
+	ErrInvalidPreKeySignature = errors.New("invalid prekey signature")
+	// ErrMessageOutOfOrder is returned by Session.Decrypt for skipped or replayed messages
+	ErrMessageOutOfOrder = errors.New("message out of order")
+)
+
+// hkdfSalt is the all-zero salt the web client passes to every HKDF call
+var hkdfSalt = make([]byte, 32)
+
+// PreKey is a public hybrid prekey from a bundle
+type PreKey struct {
+	KeyID       int
+	KeyType     crypto.KeyType // PQ component; the EC component is X25519
+	ECPublicKey []byte
+	PQPublicKey []byte
+	Signature   []byte // Signed and last-resort prekeys only
+}
+
+// Bundle is a peer's hybrid prekey bundle
+type Bundle struct {
+	UserID           uuid.UUID
+	IdentityKeyType  crypto.KeyType
+	IdentityKey      []byte
+	SignedPreKey     PreKey
+	OneTimePreKey    *PreKey
+	LastResortPreKey *PreKey // Set when the one-time pool was empty
+	SealedSenderKey  []byte
+	DeliveryVerifier []byte
+}
+
+// IdentityKeys are the local user's identity keys
+type IdentityKeys struct {
+	KeyType            crypto.KeyType // Algorithm of the published (signing) identity key
+	PublicKey          []byte
+	PrivateKey         []byte
+	ExchangePublicKey  []byte // X25519, sent to responders as IK_A
+	ExchangePrivateKey []byte
+}
+
+// Sign signs a message with the identity key
+func (k *IdentityKeys) Sign(message []byte) ([]byte, error) {
+	switch k.KeyType {
+	case crypto.KeyTypeDilithium3:
+		return crypto.Sign(k.PrivateKey, message)
+	case crypto.KeyTypeMLDSA65:
+		return crypto.SignMLDSA65(k.PrivateKey, message)
+	default:
+		return nil, fmt.Errorf("%w: cannot sign with %s identity keys", ErrUnsupportedKeyType, k.KeyType)
+	}
+}
+
+// VerifyPreKey checks a prekey's signature under the bundle's identity key.
+// ML-KEM prekeys are signed over their tagged encoding (crypto.PreKeySignedData).
+func (b *Bundle) VerifyPreKey(prekey *PreKey) error {
+	if len(prekey.ECPublicKey) != crypto.X25519PublicKeySize {
+		return ErrNotHybridBundle
+	}
+	if err := prekey.KeyType.ValidatePreKey(prekey.PQPublicKey); err != nil {
+		return err
+	}
+
+	message := crypto.PreKeySignedData(prekey.KeyType, prekey.ECPublicKey, prekey.PQPublicKey)
+	valid, err := crypto.VerifyTypedSignature(b.IdentityKeyType, b.IdentityKey, message, prekey.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPreKeySignature, err)
+	}
+	if !valid {
+		return ErrInvalidPreKeySignature
+	}
+	return nil
+}
+
+// Verify checks the signed prekey and, if it will be used, the last-resort prekey
+func (b *Bundle) Verify() error {
+	if err := b.VerifyPreKey(&b.SignedPreKey); err != nil {
+		return fmt.Errorf("signed prekey: %w", err)
+	}
+	if opk, lastResort := b.additionalPreKey(); lastResort {
+		if err := b.VerifyPreKey(opk); err != nil {
+			return fmt.Errorf("last-resort prekey: %w", err)
+		}
+	}
+	return nil
+}
+
+// additionalPreKey returns the one-time prekey, falling back to the last-resort prekey
+func (b *Bundle) additionalPreKey() (*PreKey, bool) {
+	if b.OneTimePreKey != nil {
+		return b.OneTimePreKey, false
+	}
+	if b.LastResortPreKey != nil && b.LastResortPreKey.ECPublicKey != nil {
+		return b.LastResortPreKey, true
+	}
+	return nil, false
+}
+
+// InitiatorSecrets is the randomness consumed by one handshake
+type InitiatorSecrets struct {
+	EphemeralPrivateKey []byte // X25519 scalar (EK_A)
+	SignedPreKeySeed    []byte // Encapsulation seed for SPK_B.pq
+	OneTimePreKeySeed   []byte // Encapsulation seed for OPK_B.pq
+}
+
+// NewInitiatorSecrets draws fresh handshake secrets from r
+func NewInitiatorSecrets(r io.Reader) (*InitiatorSecrets, error) {
+	buf := make([]byte, crypto.X25519PrivateKeySize+2*EncapsulationSeedSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("failed to generate handshake secrets: %w", err)
+	}
+	return &InitiatorSecrets{
+		EphemeralPrivateKey: buf[:crypto.X25519PrivateKeySize],
+		SignedPreKeySeed:    buf[crypto.X25519PrivateKeySize : crypto.X25519PrivateKeySize+EncapsulationSeedSize],
+		OneTimePreKeySeed:   buf[crypto.X25519PrivateKeySize+EncapsulationSeedSize:],
+	}, nil
+}
+
+// InitialMessage is what the initiator sends the responder alongside its first ciphertext
+type InitialMessage struct {
+	IdentityKey             []byte `json:"identity_key"`  // IK_A (X25519)
+	EphemeralKey            []byte `json:"ephemeral_key"` // EK_A (X25519)
+	SignedPreKeyID          int    `json:"signed_prekey_id"`
+	SignedPreKeyCiphertext  []byte `json:"signed_prekey_ciphertext"`
+	OneTimePreKeyID         *int   `json:"one_time_prekey_id,omitempty"`
+	OneTimePreKeyCiphertext []byte `json:"one_time_prekey_ciphertext,omitempty"`
+	LastResort              bool   `json:"last_resort,omitempty"` // OneTimePreKeyID names the last-resort prekey
+}
+
+// Result is the outcome of a handshake on either side
+type Result struct {
+	SharedSecret   []byte
+	AssociatedData []byte
+	Message        *InitialMessage // Initiator only
+}
+
+// Initiate runs the initiator side of the handshake against a verified bundle
+func Initiate(identity *IdentityKeys, bundle *Bundle) (*Result, error) {
+	secrets, err := NewInitiatorSecrets(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return InitiateWith(identity, bundle, secrets)
+}
+
+// InitiateWith runs the initiator side with explicit secrets
+func InitiateWith(identity *IdentityKeys, bundle *Bundle, secrets *InitiatorSecrets) (*Result, error) {
+	if err := bundle.Verify(); err != nil {
+		return nil, err
+	}
+
+	spk := &bundle.SignedPreKey
+	dh1, err := crypto.X25519DH(identity.ExchangePrivateKey, spk.ECPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("DH1: %w", err)
+	}
+	dh3, err := crypto.X25519DH(secrets.EphemeralPrivateKey, spk.ECPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("DH3: %w", err)
+	}
+	ct1, ss1, err := encapsulate(spk.KeyType, spk.PQPublicKey, secrets.SignedPreKeySeed)
+	if err != nil {
+		return nil, fmt.Errorf("signed prekey: %w", err)
+	}
+
+	ephemeralPublicKey, err := X25519PublicKey(secrets.EphemeralPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive ephemeral public key: %w", err)
+	}
+
+	msg := &InitialMessage{
+		IdentityKey:            identity.ExchangePublicKey,
+		EphemeralKey:           ephemeralPublicKey,
+		SignedPreKeyID:         spk.KeyID,
+		SignedPreKeyCiphertext: ct1,
+	}
+	dh := [][]byte{dh1, dh3}
+	kem := [][]byte{ss1}
+
+	if opk, lastResort := bundle.additionalPreKey(); opk != nil {
+		dh4, err := crypto.X25519DH(secrets.EphemeralPrivateKey, opk.ECPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("DH4: %w", err)
+		}
+		ct2, ss2, err := encapsulate(opk.KeyType, opk.PQPublicKey, secrets.OneTimePreKeySeed)
+		if err != nil {
+			return nil, fmt.Errorf("one-time prekey: %w", err)
+		}
+
+		keyID := opk.KeyID
+		msg.OneTimePreKeyID = &keyID
+		msg.OneTimePreKeyCiphertext = ct2
+		msg.LastResort = lastResort
+		dh = append(dh, dh4)
+		kem = append(kem, ss2)
+	}
+
+	sharedSecret, err := DeriveSharedSecret(dh, kem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		SharedSecret:   sharedSecret,
+		AssociatedData: concat(identity.ExchangePublicKey, bundle.IdentityKey),
+		Message:        msg,
+	}, nil
+}
+
+// PreKeyPair is the private half of a hybrid prekey
+type PreKeyPair struct {
+	KeyType      crypto.KeyType
+	ECPrivateKey []byte
+	PQPrivateKey []byte
+}
+
+// ResponderKeys are the responder's keys named by an initial message
+type ResponderKeys struct {
+	IdentityPublicKey []byte      // Published identity key (IK_B), bound into AD
+	SignedPreKey      *PreKeyPair // Private half of the signed prekey the message names
+	OneTimePreKey     *PreKeyPair // One-time or last-resort prekey, if the message names one
+}
+
+// Respond runs the responder side of the handshake
+func Respond(keys *ResponderKeys, msg *InitialMessage) (*Result, error) {
+	dh1, err := crypto.X25519DH(keys.SignedPreKey.ECPrivateKey, msg.IdentityKey)
+	if err != nil {
+		return nil, fmt.Errorf("DH1: %w", err)
+	}
+	dh3, err := crypto.X25519DH(keys.SignedPreKey.ECPrivateKey, msg.EphemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("DH3: %w", err)
+	}
+	ss1, err := decapsulate(keys.SignedPreKey.KeyType, keys.SignedPreKey.PQPrivateKey, msg.SignedPreKeyCiphertext)
+	if err != nil {
+		return nil, fmt.Errorf("signed prekey: %w", err)
+	}
+
+	dh := [][]byte{dh1, dh3}
+	kem := [][]byte{ss1}
+
+	if msg.OneTimePreKeyID != nil {
+		if keys.OneTimePreKey == nil {
+			return nil, fmt.Errorf("one-time prekey %d not available", *msg.OneTimePreKeyID)
+		}
+		dh4, err := crypto.X25519DH(keys.OneTimePreKey.ECPrivateKey, msg.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("DH4: %w", err)
+		}
+		ss2, err := decapsulate(keys.OneTimePreKey.KeyType, keys.OneTimePreKey.PQPrivateKey, msg.OneTimePreKeyCiphertext)
+		if err != nil {
+			return nil, fmt.Errorf("one-time prekey: %w", err)
+		}
+		dh = append(dh, dh4)
+		kem = append(kem, ss2)
+	}
+
+	sharedSecret, err := DeriveSharedSecret(dh, kem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		SharedSecret:   sharedSecret,
+		AssociatedData: concat(msg.IdentityKey, keys.IdentityPublicKey),
+	}, nil
+}
+
+// DeriveSharedSecret combines the DH outputs and KEM shared secrets, in
+// order, into the 32-byte PQXDH secret
+func DeriveSharedSecret(dh, kem [][]byte) ([]byte, error) {
+	input := make([]byte, paddingLength)
+	for i := range input {
+		input[i] = paddingByte
+	}
+	for _, secret := range dh {
+		input = append(input, secret...)
+	}
+	for _, secret := range kem {
+		input = append(input, secret...)
+	}
+
+	sharedSecret, err := crypto.DeriveKey(input, hkdfSalt, []byte(pqxdhInfo), 32)
+	for i := range input {
+		input[i] = 0
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive shared secret: %w", err)
+	}
+	return sharedSecret, nil
+}
+
+// Session is the first symmetric chain in each direction after a handshake
+type Session struct {
+	RootKey           []byte
+	SendingChainKey   []byte
+	ReceivingChainKey []byte
+	SendingIndex      uint32
+	ReceivingIndex    uint32
+	AssociatedData    []byte
+}
+
+// NewSession derives the initial chain keys from a handshake result
+func NewSession(result *Result, initiator bool) (*Session, error) {
+	derived, err := crypto.DeriveKey(result.SharedSecret, hkdfSalt, []byte(ratchetInfo), 96)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive session keys: %w", err)
+	}
+
+	session := &Session{
+		RootKey:        derived[:32],
+		AssociatedData: result.AssociatedData,
+	}
+	if initiator {
+		session.SendingChainKey, session.ReceivingChainKey = derived[32:64], derived[64:96]
+	} else {
+		session.SendingChainKey, session.ReceivingChainKey = derived[64:96], derived[32:64]
+	}
+	return session, nil
+}
+
+// Message is an encrypted message on a session chain
+type Message struct {
+	ChainIndex uint32 `json:"chain_index"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// deriveMessageKey splits a chain key into a message key and the next chain key
+func deriveMessageKey(chainKey []byte) (messageKey, nextChainKey []byte, err error) {
+	derived, err := crypto.DeriveKey(chainKey, hkdfSalt, []byte(chainInfo), 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive message key: %w", err)
+	}
+	return derived[:32], derived[32:], nil
+}
+
+// Encrypt encrypts the next message on the sending chain
+func (s *Session) Encrypt(plaintext []byte) (*Message, error) {
+	nonce, err := crypto.GenerateNonce(crypto.XChaCha20NonceSize)
+	if err != nil {
+		return nil, err
+	}
+	return s.EncryptWith(plaintext, nonce)
+}
+
+// EncryptWith encrypts the next message with an explicit 24-byte nonce
+func (s *Session) EncryptWith(plaintext, nonce []byte) (*Message, error) {
+	messageKey, nextChainKey, err := deriveMessageKey(s.SendingChainKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(messageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XChaCha20-Poly1305: %w", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size: expected %d, got %d", aead.NonceSize(), len(nonce))
+	}
+
+	msg := &Message{
+		ChainIndex: s.SendingIndex,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, s.AssociatedData),
+	}
+	s.SendingChainKey = nextChainKey
+	s.SendingIndex++
+	return msg, nil
+}
+
+// Decrypt decrypts the next message on the receiving chain. Messages must
+// arrive in order; skipped-key storage is left to the full clients.
+func (s *Session) Decrypt(msg *Message) ([]byte, error) {
+	if msg.ChainIndex != s.ReceivingIndex {
+		return nil, fmt.Errorf("%w: expected index %d, got %d", ErrMessageOutOfOrder, s.ReceivingIndex, msg.ChainIndex)
+	}
+
+	messageKey, nextChainKey, err := deriveMessageKey(s.ReceivingChainKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := crypto.DecryptXChaCha20(messageKey, msg.Ciphertext, msg.Nonce, s.AssociatedData)
+	if err != nil {
+		return nil, err
+	}
+	s.ReceivingChainKey = nextChainKey
+	s.ReceivingIndex++
+	return plaintext, nil
+}
+
+// basepoint is the X25519 base point, for deriving public keys from scalars
+var basepoint = func() []byte {
+	b := make([]byte, 32)
+	b[0] = 9
+	return b
+}()
+
+// X25519PublicKey derives the public key for an X25519 scalar
+func X25519PublicKey(privateKey []byte) ([]byte, error) {
+	return crypto.X25519DH(privateKey, basepoint)
+}
+
+// concat joins byte slices into a new slice
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
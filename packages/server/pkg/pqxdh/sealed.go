@@ -0,0 +1,228 @@
+package pqxdh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/kindlyrobotics/nochat/internal/crypto"
+)
+
+/*
+SEALED SENDER:
+Matches packages/web/src/crypto/sealed-sender.ts, which differs from the
+server-side crypto.SealedSenderEncrypt sketch in three ways: the envelope key
+is HKDF-SHA256(SS, salt = 0x00*32, info = "sealed-sender-v1") rather than
+SHA-256(SS || label), the inner envelope is JSON padded to a fixed block size
+before encryption, and the web client's sealed sender keys are ML-KEM-1024.
+
+Serialized envelope (base64 on the wire):
+  version (1) || len(kem_ciphertext) (2, big-endian) || kem_ciphertext ||
+  len(nonce) (1) || nonce || AES-256-GCM ciphertext
+
+Delivery token = HKDF-SHA256(SS_session, salt = verifier || "sealed-sender-token-v1",
+info = "delivery-token", 32 bytes). The server only ever stores its SHA-256.
+*/
+
+// Sealed sender constants, shared with packages/web/src/crypto
+const (
+	SealedSenderVersion = 1
+
+	// SealedSenderKeyType is the KEM the web client uses for sealed sender keys
+	SealedSenderKeyType = crypto.KeyTypeMLKEM1024
+
+	sealedSenderInfo   = "sealed-sender-v1"
+	deliveryTokenLabel = "sealed-sender-token-v1"
+	deliveryTokenInfo  = "delivery-token"
+)
+
+// InnerEnvelope is the sender identity and payload hidden inside a sealed envelope.
+// Field order and names match the web client's JSON so encodings are byte-identical.
+type InnerEnvelope struct {
+	SenderID             string `json:"senderId"`
+	SenderIdentityKey    string `json:"senderIdentityKey"` // Base64
+	SenderKeyFingerprint string `json:"senderKeyFingerprint"`
+	MessageContent       []byte `json:"messageContent"` // Ratchet ciphertext, base64 in JSON
+	TrueTimestamp        int64  `json:"trueTimestamp"`  // Unix milliseconds
+	ConversationID       string `json:"conversationId,omitempty"`
+	MessageID            string `json:"messageId,omitempty"`
+}
+
+// marshalInnerEnvelope encodes an inner envelope like JSON.stringify does
+func marshalInnerEnvelope(inner *InnerEnvelope) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(inner); err != nil {
+		return nil, fmt.Errorf("failed to encode inner envelope: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// SealSecrets is the randomness consumed by one sealed envelope
+type SealSecrets struct {
+	EncapsulationSeed []byte    // 32 bytes
+	Nonce             []byte    // 12-byte AES-GCM nonce
+	Padding           io.Reader // Fills block padding
+}
+
+// Seal encrypts an inner envelope to a recipient's sealed sender key
+func Seal(inner *InnerEnvelope, keyType crypto.KeyType, recipientKey []byte) (*crypto.SealedEnvelope, error) {
+	buf := make([]byte, EncapsulationSeedSize+crypto.AESGCMNonceSize)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return nil, fmt.Errorf("failed to generate envelope secrets: %w", err)
+	}
+	return SealWith(inner, keyType, recipientKey, &SealSecrets{
+		EncapsulationSeed: buf[:EncapsulationSeedSize],
+		Nonce:             buf[EncapsulationSeedSize:],
+		Padding:           rand.Reader,
+	})
+}
+
+// SealWith encrypts an inner envelope with explicit secrets
+func SealWith(inner *InnerEnvelope, keyType crypto.KeyType, recipientKey []byte, secrets *SealSecrets) (*crypto.SealedEnvelope, error) {
+	serialized, err := marshalInnerEnvelope(inner)
+	if err != nil {
+		return nil, err
+	}
+	padded, err := padToBlockSize(serialized, secrets.Padding)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, sharedSecret, err := encapsulate(keyType, recipientKey, secrets.EncapsulationSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	envelopeKey, err := crypto.DeriveKey(sharedSecret, hkdfSalt, []byte(sealedSenderInfo), crypto.SymmetricKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive envelope key: %w", err)
+	}
+
+	encrypted, err := crypto.AESGCMEncrypt(envelopeKey, secrets.Nonce, padded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt inner envelope: %w", err)
+	}
+
+	return &crypto.SealedEnvelope{
+		KEMCiphertext:    ciphertext,
+		EncryptedContent: encrypted,
+		Nonce:            secrets.Nonce,
+	}, nil
+}
+
+// Open decrypts a sealed envelope with the recipient's sealed sender private key
+func Open(envelope *crypto.SealedEnvelope, keyType crypto.KeyType, privateKey []byte) (*InnerEnvelope, error) {
+	sharedSecret, err := decapsulate(keyType, privateKey, envelope.KEMCiphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	envelopeKey, err := crypto.DeriveKey(sharedSecret, hkdfSalt, []byte(sealedSenderInfo), crypto.SymmetricKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive envelope key: %w", err)
+	}
+
+	padded, err := crypto.AESGCMDecrypt(envelopeKey, envelope.Nonce, envelope.EncryptedContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt inner envelope: %w", err)
+	}
+
+	serialized, err := crypto.UnpadFromBlockSize(padded)
+	if err != nil {
+		return nil, err
+	}
+
+	var inner InnerEnvelope
+	if err := json.Unmarshal(serialized, &inner); err != nil {
+		return nil, fmt.Errorf("failed to decode inner envelope: %w", err)
+	}
+	return &inner, nil
+}
+
+// MarshalEnvelope serializes a sealed envelope for the sealed_content field
+func MarshalEnvelope(envelope *crypto.SealedEnvelope) (string, error) {
+	if len(envelope.KEMCiphertext) > 0xFFFF || len(envelope.Nonce) > 0xFF {
+		return "", fmt.Errorf("sealed envelope fields too large to serialize")
+	}
+
+	out := []byte{SealedSenderVersion, byte(len(envelope.KEMCiphertext) >> 8), byte(len(envelope.KEMCiphertext))}
+	out = append(out, envelope.KEMCiphertext...)
+	out = append(out, byte(len(envelope.Nonce)))
+	out = append(out, envelope.Nonce...)
+	out = append(out, envelope.EncryptedContent...)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// UnmarshalEnvelope parses a serialized sealed envelope
+func UnmarshalEnvelope(serialized string) (*crypto.SealedEnvelope, error) {
+	data, err := base64.StdEncoding.DecodeString(serialized)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 envelope: %w", err)
+	}
+	if len(data) < 3 {
+		return nil, fmt.Errorf("sealed envelope too short: %d bytes", len(data))
+	}
+	if data[0] != SealedSenderVersion {
+		return nil, fmt.Errorf("unsupported sealed sender version: %d", data[0])
+	}
+
+	offset := 3
+	kemLen := int(data[1])<<8 | int(data[2])
+	if len(data) < offset+kemLen+1 {
+		return nil, fmt.Errorf("sealed envelope truncated in KEM ciphertext")
+	}
+	kemCiphertext := data[offset : offset+kemLen]
+	offset += kemLen
+
+	nonceLen := int(data[offset])
+	offset++
+	if len(data) < offset+nonceLen {
+		return nil, fmt.Errorf("sealed envelope truncated in nonce")
+	}
+	nonce := data[offset : offset+nonceLen]
+	offset += nonceLen
+
+	return &crypto.SealedEnvelope{
+		KEMCiphertext:    kemCiphertext,
+		Nonce:            nonce,
+		EncryptedContent: data[offset:],
+	}, nil
+}
+
+// DeliveryToken computes the sealed sender delivery token for a recipient
+func DeliveryToken(sharedSecret, deliveryVerifier []byte) ([]byte, error) {
+	salt := concat(deliveryVerifier, []byte(deliveryTokenLabel))
+	token, err := crypto.DeriveKey(sharedSecret, salt, []byte(deliveryTokenInfo), 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive delivery token: %w", err)
+	}
+	return token, nil
+}
+
+// padToBlockSize pads like crypto.PadToBlockSize, drawing the fill from r
+func padToBlockSize(data []byte, r io.Reader) ([]byte, error) {
+	blockSize := crypto.PaddingBlockSizes[len(crypto.PaddingBlockSizes)-1]
+	for _, size := range crypto.PaddingBlockSizes {
+		if size >= len(data)+2 {
+			blockSize = size
+			break
+		}
+	}
+	if len(data)+2 > blockSize {
+		return nil, fmt.Errorf("inner envelope too large: %d bytes", len(data))
+	}
+
+	padded := make([]byte, blockSize)
+	copy(padded, data)
+	if _, err := io.ReadFull(r, padded[len(data):blockSize-2]); err != nil {
+		return nil, fmt.Errorf("failed to generate padding: %w", err)
+	}
+	padded[blockSize-2] = byte(len(data) >> 8)
+	padded[blockSize-1] = byte(len(data))
+	return padded, nil
+}
@@ -0,0 +1,533 @@
+package pqxdh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+	"github.com/kindlyrobotics/nochat/internal/crypto"
+)
+
+/*
+REFERENCE VECTORS:
+Known-answer vectors for this package. Inputs are
+hex seeds and scalars; keys are derived from them (X25519 scalars are used
+as-is, KEM key pairs with the scheme's 64-byte DeriveKeyPair seed, the
+responder's ML-DSA-65 identity key from a 32-byte seed). Large outputs are
+pinned by SHA-256 to keep the file readable.
+
+Sealed sender vectors pad with zero bytes so the padded plaintext is
+reproducible; real envelopes use random padding. Kyber1024 vectors use the
+round-3 scheme, which @noble/post-quantum doesn't implement.
+
+Sealed sender and delivery token vectors are interop vectors: they follow
+the web client's formats. KDF and handshake vectors are Go-only regression
+vectors for this package's derivation without DH2 and are out of scope for
+the web client (see SCOPE in the package doc). CheckVectors recomputes
+everything; cmd/pqxdh-vectors dumps the vectors as JSON, grouped the same way.
+*/
+
+// KDFVector pins DeriveSharedSecret for raw DH and KEM outputs
+type KDFVector struct {
+	Name         string   `json:"name"`
+	DH           []string `json:"dh"`
+	KEM          []string `json:"kem"`
+	SharedSecret string   `json:"shared_secret"`
+}
+
+// HandshakeVector pins a full initiator/responder handshake and first message
+type HandshakeVector struct {
+	Name       string         `json:"name"`
+	PreKeyType crypto.KeyType `json:"prekey_type"`
+
+	// Inputs
+	ResponderIdentitySeed          string `json:"responder_identity_seed"` // ML-DSA-65 seed (IK_B)
+	InitiatorExchangeKey           string `json:"initiator_exchange_key"`  // X25519 scalar (IK_A)
+	SignedPreKeyEC                 string `json:"signed_prekey_ec"`        // X25519 scalar
+	SignedPreKeyPQSeed             string `json:"signed_prekey_pq_seed"`
+	OneTimePreKeyEC                string `json:"one_time_prekey_ec"` // Empty for no one-time prekey
+	OneTimePreKeyPQSeed            string `json:"one_time_prekey_pq_seed"`
+	EphemeralKey                   string `json:"ephemeral_key"` // X25519 scalar (EK_A)
+	SignedPreKeyEncapsulationSeed  string `json:"signed_prekey_encapsulation_seed"`
+	OneTimePreKeyEncapsulationSeed string `json:"one_time_prekey_encapsulation_seed"`
+	Plaintext                      string `json:"plaintext"`
+	MessageNonce                   string `json:"message_nonce"`
+
+	// Expected
+	SignedPreKeySignedDataSHA256  string `json:"signed_prekey_signed_data_sha256"`
+	SignedPreKeyCiphertextSHA256  string `json:"signed_prekey_ciphertext_sha256"`
+	OneTimePreKeyCiphertextSHA256 string `json:"one_time_prekey_ciphertext_sha256"`
+	AssociatedDataSHA256          string `json:"associated_data_sha256"`
+	SharedSecret                  string `json:"shared_secret"`
+	RootKey                       string `json:"root_key"`
+	MessageCiphertext             string `json:"message_ciphertext"`
+}
+
+// SealedSenderVector pins a sealed envelope and its serialization
+type SealedSenderVector struct {
+	Name    string         `json:"name"`
+	KeyType crypto.KeyType `json:"key_type"`
+
+	// Inputs
+	RecipientKeySeed  string        `json:"recipient_key_seed"`
+	EncapsulationSeed string        `json:"encapsulation_seed"`
+	Nonce             string        `json:"nonce"`
+	Inner             InnerEnvelope `json:"inner"`
+
+	// Expected
+	InnerEnvelopeJSON      string `json:"inner_envelope_json"`
+	SharedSecret           string `json:"shared_secret"`
+	KEMCiphertextSHA256    string `json:"kem_ciphertext_sha256"`
+	EncryptedContentSHA256 string `json:"encrypted_content_sha256"`
+	SerializedSHA256       string `json:"serialized_sha256"`
+}
+
+// DeliveryTokenVector pins DeliveryToken
+type DeliveryTokenVector struct {
+	SharedSecret     string `json:"shared_secret"`
+	DeliveryVerifier string `json:"delivery_verifier"`
+	Token            string `json:"token"`
+}
+
+// KDFVectors covers the combiner with and without the one-time prekey terms
+var KDFVectors = []KDFVector{
+	{
+		Name: "without one-time prekey",
+		DH: []string{
+			"d481916617293cda028a83abe1cb927117b0de6c272346f48ffddd9c2c418f5f",
+			"fc1d6d890655e490203d8de06da742c1e667bda3a6a98964c44c9023a2a6cc9e",
+		},
+		KEM: []string{
+			"cb3e58a04272d85b891e3d6f93d2f6b4846dc962e3c55d015235a7dcddbabacb",
+		},
+		SharedSecret: "12612ad528b8d120fc3164f7ed1bf2d3eee1ffe205c26cfed25f3cd3b83ef681",
+	},
+	{
+		Name: "with one-time prekey",
+		DH: []string{
+			"d481916617293cda028a83abe1cb927117b0de6c272346f48ffddd9c2c418f5f",
+			"fc1d6d890655e490203d8de06da742c1e667bda3a6a98964c44c9023a2a6cc9e",
+			"3a04cc51bca6fcdc4b2a462b33265f9f787fa5a90e0b111ed476f6def8abc41b",
+		},
+		KEM: []string{
+			"cb3e58a04272d85b891e3d6f93d2f6b4846dc962e3c55d015235a7dcddbabacb",
+			"cb7624959f376a5fa9a642f58d3bd9d2a71ccc31dc48e6a199b029e81dda0e3d",
+		},
+		SharedSecret: "a31867878a706beef124eea926778be3a8a05b582ba78735ad56a291e133789b",
+	},
+}
+
+// HandshakeVectors covers each PQ prekey type
+var HandshakeVectors = []HandshakeVector{
+	{
+		Name:                           "ml-kem-1024 with one-time prekey",
+		PreKeyType:                     crypto.KeyTypeMLKEM1024,
+		ResponderIdentitySeed:          "b3412a8b7e35550cb1e5cd5e97be52a743544644357b8d85e712ff47c247c6ca",
+		InitiatorExchangeKey:           "eb787f27fe78f2a4836ee57e7225610d75bd64eff00f6a2aa9eafd05cc502f66",
+		SignedPreKeyEC:                 "6724ee2bed74f1f4e6146fc9ea1079f2f2f6c1db5d8a93e0c9b6870327b1490d",
+		SignedPreKeyPQSeed:             "91e9f4aa6f518f41a2755c41963537d1f7ea216105383f69c07eb0d7045800bfff5901cc93325fef1dface2529e0fc379497d1e4a256d5fc2d98bd4d749b51df",
+		OneTimePreKeyEC:                "af3f176857dd17a92bb8b07b72f7a729152315f2dc11621e8abd645fadf23b91",
+		OneTimePreKeyPQSeed:            "1a64f294a58d43a767217dfdf1590c26c0792c4e664991b01dd1962c2475708d34f002e6ac25347fbefe16b37fda56231dc61937abdde02cd2e4b1f7f7e56b67",
+		EphemeralKey:                   "fee0df199892bd448f4dcf018beea15fbda8b610e17e752a0970f0878dd52ca2",
+		SignedPreKeyEncapsulationSeed:  "bb7a40c1fc2582d5715624a50b53a5862982c812bd4609d001f5b7086faf8c69",
+		OneTimePreKeyEncapsulationSeed: "af745b5285308f799fbba23ab905ae5f59c4482aa49d8bf97213742e265039c2",
+		Plaintext:                      "68656c6c6f2066726f6d2074686520676f207265666572656e636520636c69656e74",
+		MessageNonce:                   "aa5a1239533695947d631daac057ace10de46786703bcabc",
+		SignedPreKeySignedDataSHA256:   "fa614827d734a7db1758c6f8055088ab09d50209ded2239cba36f9155bc28854",
+		SignedPreKeyCiphertextSHA256:   "80d7a8c7f68c07b81ffdec45e0055b782cc0db5c056413739a74959a50400fa5",
+		OneTimePreKeyCiphertextSHA256:  "eb277e28bc3d9a876df3ec020048c4a9af2a14af72baf73de8d8af9e25fddea3",
+		AssociatedDataSHA256:           "1ae371a8e17bf97a5038bbde13700d2200318d6170c6d8bd2e4d8d2f5214de3f",
+		SharedSecret:                   "5d9742e05bb05b6ba4bf5a041b3fc3fc354efd983c418d72c5bf55f8ed22aeea",
+		RootKey:                        "4642f7f58d9d4e5c9cb5fead6279d9f9fde1055c4a29964de6a7f36cab95b000",
+		MessageCiphertext:              "140ff91ead28d2898764948f58957dc1b2d35dc1bfc9fb881ff567af11b1f859e2b4b15a35bc23641756c218da2da0257f60",
+	},
+	{
+		Name:                          "ml-kem-768 without one-time prekey",
+		PreKeyType:                    crypto.KeyTypeMLKEM768,
+		ResponderIdentitySeed:         "b3674bcb1002137abbe44c95903b2ebe2d5f1eba992a6d78ae1265dbaf5caf25",
+		InitiatorExchangeKey:          "17460a9b70f20a8464dcbcc2756154aa99106fd2828a16516c5a99ea58c04b10",
+		SignedPreKeyEC:                "517886852b3e8ff692df8f4ba16dd396130c0a6048782fa84dacdbf665003fae",
+		SignedPreKeyPQSeed:            "895e4e7aeefad652b52cb17ca7cc3f5a966b562926d7f503960d3308867f1d14f8d766b1fc329ea2861728cef9238a3e80d45591909a38806427433b73e99fe7",
+		EphemeralKey:                  "dd4f1897bdea967d66d3bca60d2d95a69461c1621328b5bbbac3bf35faceaa5b",
+		SignedPreKeyEncapsulationSeed: "1da50abdd2fc6234227b3d1df7e45fd24b1c24e50c9dccc3611d2464b39c09c9",
+		Plaintext:                     "",
+		MessageNonce:                  "a6ac4dc22dac47dde78d0541184ed0b9d751b57f558b3d94",
+		SignedPreKeySignedDataSHA256:  "7d3807efe05cb0266aa9d144cd96135abfd91a9bd00ccbc974ef52d52cfbf382",
+		SignedPreKeyCiphertextSHA256:  "117af6bbe306995d2fd74d29bc9fb35f640b154dda21d333e71286dd955f27a2",
+		AssociatedDataSHA256:          "bc8edbd3fd81a3d5dd1aa180cc62f61d3a94fcf8af38ea098de9251bb7a081e7",
+		SharedSecret:                  "cd5b61a453a648f3d6fe49665c3289e8b0f71d9e16ad82d79a86733a45e5fdb8",
+		RootKey:                       "589132f2bbf41ab368f58e4e0f159b8458df1cd3d241be08a0a3eabb092243a7",
+		MessageCiphertext:             "c60813ae76ab54a6213fb57d1726b1a3",
+	},
+	{
+		Name:                           "kyber1024 (round 3) with one-time prekey",
+		PreKeyType:                     crypto.KeyTypeKyber1024,
+		ResponderIdentitySeed:          "32e9f00230896a07b58adbb3c76427b8e8aa627f32f5e93d74a9dcbcf309b4e4",
+		InitiatorExchangeKey:           "05f26b3570498466542071d32ff3da663eacc6c667255bbaef45f99dab6a4b0e",
+		SignedPreKeyEC:                 "b178aedd4676de78de35340bc3e3d3d2f9778fd4d32d125c595c92d053b2cf83",
+		SignedPreKeyPQSeed:             "74ef8209c74065ae2f210279925d275e3d85c93f7f630a9abe6da486d3c12047075c95c9a0ce943742e2dea74219af0d1b4e0a5b5d89f50b1dfaa5863f9c11c2",
+		OneTimePreKeyEC:                "db262e743fbfda4a1f458790bda0fb08eefbbbeada5ff502df31dec37ebbc18e",
+		OneTimePreKeyPQSeed:            "7f5d19fdcd8fd4162593ebeb1d5408bf3f1d6f47a738a95d05f583ede723a96e14a14220431e479a5eb13457d95c8e42bff7daeb59c4e888effcea62edff6166",
+		EphemeralKey:                   "f9a29160e0c9197e704f1b7b1a5008d8ee987e9bb2414a8c40cde6528b7f9f29",
+		SignedPreKeyEncapsulationSeed:  "1c4b733432874ca4207154ad4e2382529fd479d35d553b06a6a1ad312e7ee64b",
+		OneTimePreKeyEncapsulationSeed: "3fccd60d3d0d96b273c740d059f1ff4d8870458d7afd77a756335662d18ce32d",
+		Plaintext:                      "6b79626572",
+		MessageNonce:                   "81995083ccbba209c8084aae8d31abc7adc6d913b280bbcb",
+		SignedPreKeySignedDataSHA256:   "c094bb3a9e68f599ca8950e490e651765987e7803e0624d7e9698e64792b8a80",
+		SignedPreKeyCiphertextSHA256:   "45fb952f267c14949b6df03a255fb7899c6cd207215709f255a961466c9cdb89",
+		OneTimePreKeyCiphertextSHA256:  "52dd494001f831c03317b456e50c32d89134f17acd57801b2c33a11b9a60e4f3",
+		AssociatedDataSHA256:           "f08590edd6ac237cb00baf2e240d8e61a7d80bf3902ce03e0ed17db014356cd6",
+		SharedSecret:                   "5c5d66617087d0652b44f89477c9e598296fd2dfeb2254b0b9bc0fe3f382f672",
+		RootKey:                        "0520c1254c277122317c230e2059368dc9da12635eb79f1a8b7224611340a3e8",
+		MessageCiphertext:              "b44d35ce7c90a467ba1d1c476a9a95d34559d80176",
+	},
+}
+
+// SealedSenderVectors covers the web client's ML-KEM-1024 sealed sender keys
+var SealedSenderVectors = []SealedSenderVector{
+	{
+		Name:              "ml-kem-1024 direct message",
+		KeyType:           crypto.KeyTypeMLKEM1024,
+		RecipientKeySeed:  "c36cee593f95d64a3af7d87857766036ab723b75caafa02fff7f10cb91847d02bd6082219ec0ddac459c3b7df9e5ac459c73690f7b2e490eb141bcc773b1668b",
+		EncapsulationSeed: "c60bcbbedc304531a867ae0a7310da5c3bfde4fdad3b0db5a7e8db20eab280ba",
+		Nonce:             "9646057e882d83e9ed621e8b",
+		Inner: InnerEnvelope{
+			SenderID:             "6f1c2a4e-3b5d-4c7e-9f10-2a3b4c5d6e7f",
+			SenderIdentityKey:    "c2VuZGVyIGlkZW50aXR5IGtleQ==",
+			SenderKeyFingerprint: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			MessageContent:       []byte("ratchet ciphertext <&>"),
+			TrueTimestamp:        1767225600000,
+		},
+		InnerEnvelopeJSON:      `{"senderId":"6f1c2a4e-3b5d-4c7e-9f10-2a3b4c5d6e7f","senderIdentityKey":"c2VuZGVyIGlkZW50aXR5IGtleQ==","senderKeyFingerprint":"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae","messageContent":"cmF0Y2hldCBjaXBoZXJ0ZXh0IDwmPg==","trueTimestamp":1767225600000}`,
+		SharedSecret:           "5f2b38795e0919738b982063ea523b04d8b13853ff635b4206006d956b1a517f",
+		KEMCiphertextSHA256:    "1a4ed1bb85fd967440cf2cbbdbac2d01799c86a0488fa081f90cf5d6ec81b071",
+		EncryptedContentSHA256: "e5bf672e118979e3ab07522f0392cfa9e69868797ab9e8f312fea29eabe17d3f",
+		SerializedSHA256:       "e641aca8742640b234bed0010109180524ba5d0aac703c1676298072c16339e9",
+	},
+}
+
+// DeliveryTokenVectors pins the sealed sender delivery token
+var DeliveryTokenVectors = []DeliveryTokenVector{
+	{
+		SharedSecret:     "655b1acd40a412c955a678dc8acda93b20c746a7aa74b5d83200f3b10f8c4444",
+		DeliveryVerifier: "eddc796d8742d865d8ca7d10308e2a8fe9355d77097d5bb879668bc4a73edfe0",
+		Token:            "f22ab122929a7d841f38bce08f3937ef292d3893b47ba555636a7fc2806116e3",
+	},
+}
+
+// CheckVectors recomputes every vector and reports the first mismatch
+func CheckVectors() error {
+	for _, v := range KDFVectors {
+		got, err := v.compute()
+		if err != nil {
+			return fmt.Errorf("KDF vector %q: %w", v.Name, err)
+		}
+		if got.SharedSecret != v.SharedSecret {
+			return fmt.Errorf("KDF vector %q: shared secret mismatch", v.Name)
+		}
+	}
+	for _, v := range HandshakeVectors {
+		got, err := v.compute()
+		if err != nil {
+			return fmt.Errorf("handshake vector %q: %w", v.Name, err)
+		}
+		if got != v {
+			return fmt.Errorf("handshake vector %q: output mismatch", v.Name)
+		}
+	}
+	for _, v := range SealedSenderVectors {
+		got, err := v.compute()
+		if err != nil {
+			return fmt.Errorf("sealed sender vector %q: %w", v.Name, err)
+		}
+		if got.InnerEnvelopeJSON != v.InnerEnvelopeJSON || got.SharedSecret != v.SharedSecret ||
+			got.KEMCiphertextSHA256 != v.KEMCiphertextSHA256 || got.EncryptedContentSHA256 != v.EncryptedContentSHA256 ||
+			got.SerializedSHA256 != v.SerializedSHA256 {
+			return fmt.Errorf("sealed sender vector %q: output mismatch", v.Name)
+		}
+	}
+	for i, v := range DeliveryTokenVectors {
+		got, err := v.compute()
+		if err != nil {
+			return fmt.Errorf("delivery token vector %d: %w", i, err)
+		}
+		if got.Token != v.Token {
+			return fmt.Errorf("delivery token vector %d: token mismatch", i)
+		}
+	}
+	return nil
+}
+
+// ComputedVectors returns every vector with its expected fields recomputed
+func ComputedVectors() (kdf []KDFVector, handshake []HandshakeVector, sealed []SealedSenderVector, tokens []DeliveryTokenVector, err error) {
+	for _, v := range KDFVectors {
+		got, err := v.compute()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		kdf = append(kdf, got)
+	}
+	for _, v := range HandshakeVectors {
+		got, err := v.compute()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		handshake = append(handshake, got)
+	}
+	for _, v := range SealedSenderVectors {
+		got, err := v.compute()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		sealed = append(sealed, got)
+	}
+	for _, v := range DeliveryTokenVectors {
+		got, err := v.compute()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		tokens = append(tokens, got)
+	}
+	return kdf, handshake, sealed, tokens, nil
+}
+
+func (v KDFVector) compute() (KDFVector, error) {
+	dh, err := decodeHexList(v.DH)
+	if err != nil {
+		return v, err
+	}
+	kem, err := decodeHexList(v.KEM)
+	if err != nil {
+		return v, err
+	}
+	secret, err := DeriveSharedSecret(dh, kem)
+	if err != nil {
+		return v, err
+	}
+	v.SharedSecret = hex.EncodeToString(secret)
+	return v, nil
+}
+
+func (v HandshakeVector) compute() (HandshakeVector, error) {
+	in, err := decodeHexFields(map[string]string{
+		"identity": v.ResponderIdentitySeed, "ik": v.InitiatorExchangeKey,
+		"spk_ec": v.SignedPreKeyEC, "spk_pq": v.SignedPreKeyPQSeed,
+		"opk_ec": v.OneTimePreKeyEC, "opk_pq": v.OneTimePreKeyPQSeed,
+		"ek": v.EphemeralKey, "spk_seed": v.SignedPreKeyEncapsulationSeed,
+		"opk_seed":  v.OneTimePreKeyEncapsulationSeed,
+		"plaintext": v.Plaintext, "nonce": v.MessageNonce,
+	})
+	if err != nil {
+		return v, err
+	}
+
+	// Responder keys
+	var seed [mldsa65.SeedSize]byte
+	copy(seed[:], in["identity"])
+	identityPub, identityPriv := mldsa65.NewKeyFromSeed(&seed)
+	responderIdentity := identityPub.Bytes()
+
+	spkEC, err := X25519PublicKey(in["spk_ec"])
+	if err != nil {
+		return v, err
+	}
+	spkPQ, spkPQPriv, err := KEMKeyPairFromSeed(v.PreKeyType, in["spk_pq"])
+	if err != nil {
+		return v, err
+	}
+	signedData := crypto.PreKeySignedData(v.PreKeyType, spkEC, spkPQ)
+	signature := make([]byte, crypto.MLDSA65SignatureSize)
+	if err := mldsa65.SignTo(identityPriv, signedData, nil, false, signature); err != nil {
+		return v, err
+	}
+
+	bundle := &Bundle{
+		IdentityKeyType: crypto.KeyTypeMLDSA65,
+		IdentityKey:     responderIdentity,
+		SignedPreKey: PreKey{
+			KeyID: 1, KeyType: v.PreKeyType, ECPublicKey: spkEC, PQPublicKey: spkPQ, Signature: signature,
+		},
+	}
+	responder := &ResponderKeys{
+		IdentityPublicKey: responderIdentity,
+		SignedPreKey:      &PreKeyPair{KeyType: v.PreKeyType, ECPrivateKey: in["spk_ec"], PQPrivateKey: spkPQPriv},
+	}
+	if len(in["opk_ec"]) > 0 {
+		opkEC, err := X25519PublicKey(in["opk_ec"])
+		if err != nil {
+			return v, err
+		}
+		opkPQ, opkPQPriv, err := KEMKeyPairFromSeed(v.PreKeyType, in["opk_pq"])
+		if err != nil {
+			return v, err
+		}
+		bundle.OneTimePreKey = &PreKey{KeyID: 2, KeyType: v.PreKeyType, ECPublicKey: opkEC, PQPublicKey: opkPQ}
+		responder.OneTimePreKey = &PreKeyPair{KeyType: v.PreKeyType, ECPrivateKey: in["opk_ec"], PQPrivateKey: opkPQPriv}
+	}
+
+	// Initiator
+	ikPub, err := X25519PublicKey(in["ik"])
+	if err != nil {
+		return v, err
+	}
+	identity := &IdentityKeys{ExchangePublicKey: ikPub, ExchangePrivateKey: in["ik"]}
+	initiated, err := InitiateWith(identity, bundle, &InitiatorSecrets{
+		EphemeralPrivateKey: in["ek"],
+		SignedPreKeySeed:    in["spk_seed"],
+		OneTimePreKeySeed:   in["opk_seed"],
+	})
+	if err != nil {
+		return v, err
+	}
+
+	responded, err := Respond(responder, initiated.Message)
+	if err != nil {
+		return v, err
+	}
+	if !bytes.Equal(initiated.SharedSecret, responded.SharedSecret) || !bytes.Equal(initiated.AssociatedData, responded.AssociatedData) {
+		return v, fmt.Errorf("initiator and responder disagree")
+	}
+
+	// First message, decrypted by the responder
+	sending, err := NewSession(initiated, true)
+	if err != nil {
+		return v, err
+	}
+	receiving, err := NewSession(responded, false)
+	if err != nil {
+		return v, err
+	}
+	msg, err := sending.EncryptWith(in["plaintext"], in["nonce"])
+	if err != nil {
+		return v, err
+	}
+	plaintext, err := receiving.Decrypt(msg)
+	if err != nil {
+		return v, err
+	}
+	if !bytes.Equal(plaintext, in["plaintext"]) {
+		return v, fmt.Errorf("message round trip failed")
+	}
+
+	v.SignedPreKeySignedDataSHA256 = sha256Hex(signedData)
+	v.SignedPreKeyCiphertextSHA256 = sha256Hex(initiated.Message.SignedPreKeyCiphertext)
+	v.OneTimePreKeyCiphertextSHA256 = ""
+	if initiated.Message.OneTimePreKeyCiphertext != nil {
+		v.OneTimePreKeyCiphertextSHA256 = sha256Hex(initiated.Message.OneTimePreKeyCiphertext)
+	}
+	v.AssociatedDataSHA256 = sha256Hex(initiated.AssociatedData)
+	v.SharedSecret = hex.EncodeToString(initiated.SharedSecret)
+	v.RootKey = hex.EncodeToString(sending.RootKey)
+	v.MessageCiphertext = hex.EncodeToString(msg.Ciphertext)
+	return v, nil
+}
+
+func (v SealedSenderVector) compute() (SealedSenderVector, error) {
+	in, err := decodeHexFields(map[string]string{
+		"recipient": v.RecipientKeySeed, "seed": v.EncapsulationSeed, "nonce": v.Nonce,
+	})
+	if err != nil {
+		return v, err
+	}
+
+	publicKey, privateKey, err := KEMKeyPairFromSeed(v.KeyType, in["recipient"])
+	if err != nil {
+		return v, err
+	}
+
+	serialized, err := marshalInnerEnvelope(&v.Inner)
+	if err != nil {
+		return v, err
+	}
+
+	envelope, err := SealWith(&v.Inner, v.KeyType, publicKey, &SealSecrets{
+		EncapsulationSeed: in["seed"],
+		Nonce:             in["nonce"],
+		Padding:           zeroReader{},
+	})
+	if err != nil {
+		return v, err
+	}
+
+	encoded, err := MarshalEnvelope(envelope)
+	if err != nil {
+		return v, err
+	}
+	decoded, err := UnmarshalEnvelope(encoded)
+	if err != nil {
+		return v, err
+	}
+	opened, err := Open(decoded, v.KeyType, privateKey)
+	if err != nil {
+		return v, err
+	}
+	if reencoded, _ := marshalInnerEnvelope(opened); !bytes.Equal(reencoded, serialized) {
+		return v, fmt.Errorf("inner envelope round trip failed")
+	}
+
+	_, sharedSecret, err := encapsulate(v.KeyType, publicKey, in["seed"])
+	if err != nil {
+		return v, err
+	}
+
+	v.InnerEnvelopeJSON = string(serialized)
+	v.SharedSecret = hex.EncodeToString(sharedSecret)
+	v.KEMCiphertextSHA256 = sha256Hex(envelope.KEMCiphertext)
+	v.EncryptedContentSHA256 = sha256Hex(envelope.EncryptedContent)
+	v.SerializedSHA256 = sha256Hex([]byte(encoded))
+	return v, nil
+}
+
+func (v DeliveryTokenVector) compute() (DeliveryTokenVector, error) {
+	in, err := decodeHexFields(map[string]string{"secret": v.SharedSecret, "verifier": v.DeliveryVerifier})
+	if err != nil {
+		return v, err
+	}
+	token, err := DeliveryToken(in["secret"], in["verifier"])
+	if err != nil {
+		return v, err
+	}
+	v.Token = hex.EncodeToString(token)
+	return v, nil
+}
+
+// zeroReader pads vector envelopes with zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func decodeHexList(values []string) ([][]byte, error) {
+	out := make([][]byte, len(values))
+	for i, s := range values {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex: %w", err)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+func decodeHexFields(fields map[string]string) (map[string][]byte, error) {
+	out := make(map[string][]byte, len(fields))
+	for name, s := range fields {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex in %s: %w", name, err)
+		}
+		out[name] = b
+	}
+	return out, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,37 @@
+package pqxdh
+
+import "testing"
+
+func TestVectors(t *testing.T) {
+	if err := CheckVectors(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestVectorsDetectMismatch checks that the recomputed outputs actually
+// depend on the pinned fields CheckVectors compares
+func TestVectorsDetectMismatch(t *testing.T) {
+	kdf := KDFVectors[0]
+	kdf.KEM = []string{kdf.KEM[0][:62] + "00"}
+	if got, err := kdf.compute(); err != nil || got.SharedSecret == KDFVectors[0].SharedSecret {
+		t.Errorf("KDF vector with a changed KEM secret reproduced the pinned output (err %v)", err)
+	}
+
+	handshake := HandshakeVectors[0]
+	handshake.EphemeralKey = HandshakeVectors[1].EphemeralKey
+	if got, err := handshake.compute(); err != nil || got.SharedSecret == HandshakeVectors[0].SharedSecret {
+		t.Errorf("handshake vector with a changed ephemeral key reproduced the pinned output (err %v)", err)
+	}
+
+	sealed := SealedSenderVectors[0]
+	sealed.Inner.TrueTimestamp++
+	if got, err := sealed.compute(); err != nil || got.SerializedSHA256 == SealedSenderVectors[0].SerializedSHA256 {
+		t.Errorf("sealed sender vector with a changed timestamp reproduced the pinned output (err %v)", err)
+	}
+
+	token := DeliveryTokenVectors[0]
+	token.SharedSecret = token.DeliveryVerifier
+	if got, err := token.compute(); err != nil || got.Token == DeliveryTokenVectors[0].Token {
+		t.Errorf("delivery token vector with a changed secret reproduced the pinned output (err %v)", err)
+	}
+}